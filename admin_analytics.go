@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Dimensions des graphiques SVG, en unités du viewBox ; le navigateur les met à l'échelle
+const (
+	chartWidth       = 720
+	chartHeight      = 220
+	chartMarginLeft  = 36
+	chartMarginRight = 8
+	chartMarginTop   = 10
+	chartMarginBot   = 26
+)
+
+func adminAnalyticsHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdminAuth(w, r) {
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	weeks := analyticsDefaultWeeks
+	if value, err := strconv.Atoi(r.URL.Query().Get("weeks")); err == nil {
+		for _, period := range analyticsPeriods {
+			if value == period {
+				weeks = value
+			}
+		}
+	}
+
+	analytics, err := loadQuoteAnalytics(weeks)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Erreur calcul statistiques (admin)", "err", err)
+		renderAdminErrorPage(w, "Erreur calcul statistiques", err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	var builder strings.Builder
+	writeAdminPageStart(&builder, "Statistiques - Admin Modul-space")
+
+	builder.WriteString(`<div class="card"><h1>Statistiques</h1>`)
+	builder.WriteString(fmt.Sprintf(`<p class="meta">Depuis le %s</p><form method="GET" action="/admin/analytics" class="inline-form"><label>Période <select name="weeks" onchange="this.form.submit()">`, analytics.Since.Format("02/01/2006")))
+	for _, period := range analyticsPeriods {
+		selected := ""
+		if period == weeks {
+			selected = " selected"
+		}
+		builder.WriteString(fmt.Sprintf(`<option value="%d"%s>%d dernières semaines</option>`, period, selected, period))
+	}
+	builder.WriteString(`</select></label> <noscript><button type="submit" class="btn-secondary">Afficher</button></noscript></form> <a href="/admin">← Retour au dashboard</a></div>`)
+
+	builder.WriteString(`<div class="card"><table><thead><tr><th>Demandes de devis</th><th>Taux d'acceptation</th><th>Taux de conversion</th><th>Délai moyen de première réponse</th><th>Sans réponse</th><th>Inscriptions</th></tr></thead><tbody><tr>`)
+	builder.WriteString(fmt.Sprintf(`<td><strong>%d</strong></td>`, analytics.QuotesTotal))
+	builder.WriteString(fmt.Sprintf(`<td><strong>%s</strong><div class="small">%d acceptés sur %d chiffrés • %d refusés</div></td>`,
+		formatPercentage(analytics.AcceptanceRate()), analytics.QuotesAccepted, analytics.QuotesOffered, analytics.QuotesRejected))
+	builder.WriteString(fmt.Sprintf(`<td><strong>%s</strong><div class="small">demandes devenues devis acceptés</div></td>`, formatPercentage(analytics.ConversionRate())))
+	if analytics.Responded > 0 {
+		builder.WriteString(fmt.Sprintf(`<td><strong>%s</strong><div class="small">sur %d devis ayant reçu une réponse</div></td>`, html.EscapeString(formatElapsed(analytics.AvgFirstResponse)), analytics.Responded))
+	} else {
+		builder.WriteString(`<td class="small">Aucune réponse sur la période</td>`)
+	}
+	builder.WriteString(fmt.Sprintf(`<td><strong>%d</strong><div class="small">en attente ou en cours d'étude</div></td>`, analytics.Unanswered))
+	builder.WriteString(fmt.Sprintf(`<td><strong>%d</strong></td>`, analytics.SignupsTotal))
+	builder.WriteString(`</tr></tbody></table></div>`)
+
+	builder.WriteString(`<div class="card"><h2>Demandes de devis par semaine</h2>`)
+	writeWeeklyBarChart(&builder, analytics.QuotesPerWeek, "demandes", "#6161AB")
+	builder.WriteString(`</div>`)
+
+	builder.WriteString(`<div class="card"><h2>Produits les plus demandés</h2>`)
+	writeProductBarChart(&builder, analytics.Products)
+	builder.WriteString(`</div>`)
+
+	builder.WriteString(`<div class="card"><h2>Inscriptions par semaine</h2>`)
+	writeWeeklyBarChart(&builder, analytics.SignupsPerWeek, "inscriptions", "#0f766e")
+	builder.WriteString(`</div>`)
+
+	writeAdminPageEnd(&builder)
+	w.Write([]byte(builder.String()))
+}
+
+// formatPercentage affiche un pourcentage à la française, avec une décimale
+func formatPercentage(value float64) string {
+	return strings.Replace(strconv.FormatFloat(value, 'f', 1, 64), ".", ",", 1) + " %"
+}
+
+// formatElapsed affiche une durée de façon lisible : minutes, heures ou jours
+func formatElapsed(d time.Duration) string {
+	switch {
+	case d < time.Hour:
+		return fmt.Sprintf("%d min", int(d.Round(time.Minute)/time.Minute))
+	case d < 24*time.Hour:
+		d = d.Round(time.Minute)
+		return fmt.Sprintf("%d h %02d", int(d/time.Hour), int(d%time.Hour/time.Minute))
+	default:
+		d = d.Round(time.Hour)
+		return fmt.Sprintf("%d j %d h", int(d/(24*time.Hour)), int(d%(24*time.Hour)/time.Hour))
+	}
+}
+
+// chartScale arrondit le maximum de l'axe vertical à une valeur ronde (1, 2 ou 5 × 10^n)
+func chartScale(highest int) int {
+	if highest < 1 {
+		return 1
+	}
+	step := 1
+	for {
+		for _, factor := range []int{1, 2, 5} {
+			if factor*step >= highest {
+				return factor * step
+			}
+		}
+		step *= 10
+	}
+}
+
+// writeWeeklyBarChart dessine une série hebdomadaire en histogramme SVG, avec une infobulle par barre
+func writeWeeklyBarChart(builder *strings.Builder, series []WeeklyCount, unit, color string) {
+	if len(series) == 0 {
+		builder.WriteString(`<p class="small">Aucune donnée</p>`)
+		return
+	}
+
+	highest := 0
+	for _, week := range series {
+		if week.Count > highest {
+			highest = week.Count
+		}
+	}
+	scale := chartScale(highest)
+	plotWidth := float64(chartWidth - chartMarginLeft - chartMarginRight)
+	plotHeight := float64(chartHeight - chartMarginTop - chartMarginBot)
+	slot := plotWidth / float64(len(series))
+	barWidth := slot * 0.7
+	// Au plus une douzaine d'étiquettes de date sous l'axe
+	labelEvery := (len(series) + 11) / 12
+
+	builder.WriteString(fmt.Sprintf(`<svg viewBox="0 0 %d %d" width="100%%" role="img" aria-label="%s par semaine" style="max-width:%dpx;font-family:Arial,sans-serif;font-size:11px">`,
+		chartWidth, chartHeight, html.EscapeString(unit), chartWidth))
+	ticks := []int{0, scale}
+	if scale%2 == 0 {
+		ticks = append(ticks, scale/2)
+	}
+	for _, tick := range ticks {
+		y := float64(chartMarginTop) + plotHeight - plotHeight*float64(tick)/float64(scale)
+		builder.WriteString(fmt.Sprintf(`<line x1="%d" y1="%.1f" x2="%d" y2="%.1f" stroke="#e5e7eb"/><text x="%d" y="%.1f" text-anchor="end" fill="#6b7280">%d</text>`,
+			chartMarginLeft, y, chartWidth-chartMarginRight, y, chartMarginLeft-6, y+4, tick))
+	}
+	for i, week := range series {
+		x := float64(chartMarginLeft) + slot*float64(i) + (slot-barWidth)/2
+		height := plotHeight * float64(week.Count) / float64(scale)
+		y := float64(chartMarginTop) + plotHeight - height
+		builder.WriteString(fmt.Sprintf(`<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="%s"><title>Semaine du %s : %d %s</title></rect>`,
+			x, y, barWidth, height, color, week.Week.Format("02/01/2006"), week.Count, html.EscapeString(unit)))
+		if i%labelEvery == 0 {
+			builder.WriteString(fmt.Sprintf(`<text x="%.1f" y="%d" text-anchor="middle" fill="#6b7280">%s</text>`,
+				x+barWidth/2, chartHeight-8, week.Week.Format("02/01")))
+		}
+	}
+	builder.WriteString(fmt.Sprintf(`<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="#9ca3af"/></svg>`,
+		chartMarginLeft, chartHeight-chartMarginBot, chartWidth-chartMarginRight, chartHeight-chartMarginBot))
+}
+
+// writeProductBarChart dessine une barre horizontale par produit : demandes en clair, devis acceptés en foncé
+func writeProductBarChart(builder *strings.Builder, products []ProductCount) {
+	if len(products) == 0 {
+		builder.WriteString(`<p class="small">Aucune demande sur la période</p>`)
+		return
+	}
+
+	const labelWidth = 220
+	const rowHeight = 26
+	highest := 0
+	for _, product := range products {
+		if product.Quotes > highest {
+			highest = product.Quotes
+		}
+	}
+	plotWidth := float64(chartWidth - labelWidth - 60)
+	height := rowHeight*len(products) + 24
+
+	builder.WriteString(fmt.Sprintf(`<svg viewBox="0 0 %d %d" width="100%%" role="img" aria-label="Demandes par produit" style="max-width:%dpx;font-family:Arial,sans-serif;font-size:12px">`,
+		chartWidth, height, chartWidth))
+	for i, product := range products {
+		y := rowHeight * i
+		total := plotWidth * float64(product.Quotes) / float64(highest)
+		accepted := plotWidth * float64(product.Accepted) / float64(highest)
+		builder.WriteString(fmt.Sprintf(`<text x="%d" y="%d" text-anchor="end" fill="#1f2937">%s</text>`,
+			labelWidth-8, y+17, html.EscapeString(textExcerpt(product.Produit, 30))))
+		builder.WriteString(fmt.Sprintf(`<rect x="%d" y="%d" width="%.1f" height="18" fill="#c7c7e6"><title>%s : %d demandes, %d acceptés</title></rect>`,
+			labelWidth, y+4, total, html.EscapeString(product.Produit), product.Quotes, product.Accepted))
+		builder.WriteString(fmt.Sprintf(`<rect x="%d" y="%d" width="%.1f" height="18" fill="#6161AB"><title>%s : %d acceptés</title></rect>`,
+			labelWidth, y+4, accepted, html.EscapeString(product.Produit), product.Accepted))
+		builder.WriteString(fmt.Sprintf(`<text x="%.1f" y="%d" fill="#6b7280">%d</text>`, float64(labelWidth)+total+6, y+17, product.Quotes))
+	}
+	legendY := rowHeight*len(products) + 14
+	builder.WriteString(fmt.Sprintf(`<rect x="%d" y="%d" width="10" height="10" fill="#c7c7e6"/><text x="%d" y="%d" fill="#6b7280">Demandes</text>`, labelWidth, legendY-9, labelWidth+14, legendY))
+	builder.WriteString(fmt.Sprintf(`<rect x="%d" y="%d" width="10" height="10" fill="#6161AB"/><text x="%d" y="%d" fill="#6b7280">Devis acceptés</text></svg>`, labelWidth+90, legendY-9, labelWidth+104, legendY))
+}
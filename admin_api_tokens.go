@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// adminAPITokensHandler liste les jetons d'API (GET) et en crée un (POST). Le jeton créé n'est affiché
+// qu'une fois, dans la réponse à la création
+func adminAPITokensHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdminAuth(w, r) {
+		return
+	}
+
+	created := ""
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		name := strings.TrimSpace(r.FormValue("name"))
+		if name == "" || utf8.RuneCountInString(name) > apiTokenNameMaxLength {
+			http.Error(w, fmt.Sprintf("Nom du jeton obligatoire (%d caractères maximum)", apiTokenNameMaxLength), http.StatusBadRequest)
+			return
+		}
+		requested := make(map[string]bool)
+		for _, scope := range r.Form["scope"] {
+			if !isValidAPIScope(scope) {
+				http.Error(w, "Périmètre inconnu : "+scope, http.StatusBadRequest)
+				return
+			}
+			requested[scope] = true
+		}
+		// Les périmètres sont enregistrés dans l'ordre de apiScopes, sans doublon
+		scopes := make([]string, 0, len(requested))
+		for _, scope := range apiScopes {
+			if requested[scope] {
+				scopes = append(scopes, scope)
+			}
+		}
+		if len(scopes) == 0 {
+			http.Error(w, "Choisissez au moins un périmètre", http.StatusBadRequest)
+			return
+		}
+
+		token, err := createAPIToken(name, adminActor(r), scopes)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Erreur création jeton d'API", "err", err)
+			renderAdminErrorPage(w, "Erreur création jeton", err.Error(), http.StatusInternalServerError)
+			return
+		}
+		auditAdminAction(r, AuditActionAPITokenCreate, "api_token:"+token[:len(apiTokenPrefix)+8],
+			auditCreated(map[string]any{"name": name, "scopes": strings.Join(scopes, " ")}))
+		created = token
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	tokens, err := listAPITokens()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Erreur récupération jetons d'API", "err", err)
+		renderAdminErrorPage(w, "Erreur récupération jetons", err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	// La page de création contient le jeton en clair : elle ne doit pas rester dans un cache
+	w.Header().Set("Cache-Control", "no-store")
+	var builder strings.Builder
+	writeAdminPageStart(&builder, "Jetons d'API - Admin Modul-space")
+	builder.WriteString(`<div class="card"><h1>Jetons d'API</h1><p class="meta">Accès à <code>/api/v1</code> avec l'en-tête <code>Authorization: Bearer &lt;jeton&gt;</code>. Les modifications faites avec un jeton sont inscrites au journal d'audit à son nom.</p><a href="/admin">← Retour au dashboard</a></div>`)
+
+	if created != "" {
+		builder.WriteString(`<div class="card"><h2>Nouveau jeton</h2><p><strong>Copiez ce jeton maintenant : il ne sera plus affiché.</strong></p>`)
+		builder.WriteString(fmt.Sprintf(`<p><input type="text" readonly value="%s" size="70" onclick="this.select()"></p></div>`, html.EscapeString(created)))
+	}
+
+	builder.WriteString(`<div class="card"><h2>Créer un jeton</h2><form method="POST" action="/admin/api-tokens">`)
+	builder.WriteString(fmt.Sprintf(`<p><label>Nom <input type="text" name="name" maxlength="%d" required placeholder="Outil de planification"></label></p><p>`, apiTokenNameMaxLength))
+	for _, scope := range apiScopes {
+		builder.WriteString(fmt.Sprintf(`<label style="display:block"><input type="checkbox" name="scope" value="%s"> <code>%s</code> — %s</label>`,
+			scope, scope, html.EscapeString(apiScopeLabels[scope])))
+	}
+	builder.WriteString(`</p><button type="submit" class="btn-secondary">Créer le jeton</button></form></div>`)
+
+	builder.WriteString(`<div class="card"><h2>Jetons</h2><table><thead><tr><th>Nom</th><th>Début du jeton</th><th>Périmètres</th><th>Créé par</th><th>Créé le</th><th>Dernière utilisation</th><th>Action</th></tr></thead><tbody>`)
+	for _, token := range tokens {
+		lastUsed := "Jamais"
+		if token.LastUsedAt != nil {
+			lastUsed = token.LastUsedAt.Format("2006-01-02 15:04")
+		}
+		builder.WriteString(fmt.Sprintf(`<tr><td>%s</td><td><code>%s…</code></td><td>%s</td><td>%s</td><td>%s</td><td>%s</td><td>`,
+			html.EscapeString(token.Name), html.EscapeString(token.Prefix), html.EscapeString(strings.Join(token.Scopes, " ")),
+			html.EscapeString(token.Owner), token.CreatedAt.Format("2006-01-02 15:04"), lastUsed))
+		if token.RevokedAt != nil {
+			builder.WriteString(fmt.Sprintf(`<span class="small">Révoqué le %s</span>`, token.RevokedAt.Format("2006-01-02 15:04")))
+		} else {
+			builder.WriteString(fmt.Sprintf(`<form method="POST" action="/admin/api-tokens/%d/revoke" class="inline-form" onsubmit="return confirm('Révoquer ce jeton ? Les intégrations qui l\'utilisent seront refusées.')"><button type="submit">Révoquer</button></form>`, token.ID))
+		}
+		builder.WriteString(`</td></tr>`)
+	}
+	if len(tokens) == 0 {
+		builder.WriteString(`<tr><td colspan="7" class="small">Aucun jeton</td></tr>`)
+	}
+	builder.WriteString(`</tbody></table></div>`)
+
+	writeAdminPageEnd(&builder)
+	w.Write([]byte(builder.String()))
+}
+
+func adminRevokeAPITokenHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdminAuth(w, r) {
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	tokenID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || tokenID <= 0 {
+		http.Error(w, "ID jeton invalide", http.StatusBadRequest)
+		return
+	}
+	token, err := revokeAPIToken(tokenID)
+	if errors.Is(err, errAPITokenNotFound) {
+		renderAdminErrorPage(w, "Révocation impossible", err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Erreur révocation jeton d'API", "token_id", tokenID, "err", err)
+		renderAdminErrorPage(w, "Erreur révocation jeton", err.Error(), http.StatusInternalServerError)
+		return
+	}
+	auditAdminAction(r, AuditActionAPITokenRevoke, "api_token:"+token.Prefix, map[string]any{"name": token.Name, "owner": token.Owner})
+
+	http.Redirect(w, r, "/admin/api-tokens", http.StatusSeeOther)
+}
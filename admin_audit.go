@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+func adminAuditHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdminAuth(w, r) {
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := parseAdminListQuery(r.URL.Query(), adminAuditSortColumns, "created_at")
+	entries, total, err := listAuditEntries(query)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Erreur récupération journal d'audit", "err", err)
+		renderAdminErrorPage(w, "Erreur récupération journal d'audit", err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var verification *AuditVerification
+	if r.URL.Query().Get("verify") == "1" {
+		if verification, err = verifyAuditChain(); err != nil {
+			slog.ErrorContext(r.Context(), "Erreur vérification journal d'audit", "err", err)
+			renderAdminErrorPage(w, "Erreur vérification journal d'audit", err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	var builder strings.Builder
+	writeAdminPageStart(&builder, "Journal d'audit - Admin Modul-space")
+	builder.WriteString(fmt.Sprintf(`<div class="card"><h1>Journal d'audit</h1><p class="meta">%d entrée(s) • chaque entrée est scellée par l'empreinte de la précédente</p><a href="/admin">← Retour au dashboard</a> • <a href="/admin/audit?verify=1">Vérifier l'intégrité du journal</a></div>`, total))
+
+	if verification != nil {
+		unsealed := ""
+		if verification.Unsealed > 0 {
+			unsealed = fmt.Sprintf(" (%d entrée(s) antérieure(s) au scellement non vérifiable(s))", verification.Unsealed)
+		}
+		if verification.BrokenID == 0 {
+			builder.WriteString(fmt.Sprintf(`<div class="card"><strong>Journal intègre</strong> : %d entrée(s) vérifiée(s)%s.</div>`, verification.Checked, html.EscapeString(unsealed)))
+		} else {
+			builder.WriteString(fmt.Sprintf(`<div class="card"><strong style="color:#b91c1c">Journal altéré à l'entrée #%d</strong> : %s. %d entrée(s) vérifiée(s) avant la rupture%s.</div>`,
+				verification.BrokenID, html.EscapeString(verification.Reason), verification.Checked-1, html.EscapeString(unsealed)))
+		}
+	}
+
+	builder.WriteString(`<div class="card"><form method="GET" action="/admin/audit" style="display:flex;gap:8px;flex-wrap:wrap;align-items:center">`)
+	builder.WriteString(fmt.Sprintf(`<input type="search" name="q" value="%s" placeholder="Auteur, cible, IP ou détail" style="flex:1;min-width:220px">`, html.EscapeString(query.Search)))
+	builder.WriteString(`<select name="action"><option value="">Toutes les actions</option>`)
+	for _, action := range auditActions {
+		selected := ""
+		if action == query.Action {
+			selected = " selected"
+		}
+		builder.WriteString(fmt.Sprintf(`<option value="%s"%s>%s</option>`, html.EscapeString(action), selected, html.EscapeString(auditActionLabel(action))))
+	}
+	builder.WriteString(`</select>`)
+	builder.WriteString(fmt.Sprintf(`<label class="small">Du <input type="date" name="from" value="%s"></label> <label class="small">au <input type="date" name="to" value="%s"></label>`, formatDateInput(query.From), formatDateInput(query.To)))
+	writeAdminPageSizeSelect(&builder, query)
+	builder.WriteString(fmt.Sprintf(`<input type="hidden" name="sort" value="%s"><input type="hidden" name="dir" value="%s">`, html.EscapeString(query.Sort), query.direction()))
+	builder.WriteString(`<button type="submit" class="btn-secondary">Filtrer</button> <a href="/admin/audit">Réinitialiser</a></form></div>`)
+
+	builder.WriteString(`<div class="card"><table><thead><tr>`)
+	adminSortHeader(&builder, query, "/admin/audit", "created_at", "Date (UTC)")
+	adminSortHeader(&builder, query, "/admin/audit", "actor", "Auteur")
+	adminSortHeader(&builder, query, "/admin/audit", "action", "Action")
+	adminSortHeader(&builder, query, "/admin/audit", "target", "Cible")
+	builder.WriteString(`<th>IP</th><th>Détail</th><th>Empreinte</th></tr></thead><tbody>`)
+	for _, entry := range entries {
+		builder.WriteString(`<tr>`)
+		builder.WriteString(fmt.Sprintf(`<td>%s<div class="small">#%d</div></td>`, entry.CreatedAt.Format("2006-01-02 15:04:05"), entry.ID))
+		builder.WriteString(fmt.Sprintf(`<td>%s</td>`, html.EscapeString(entry.Actor)))
+		builder.WriteString(fmt.Sprintf(`<td>%s<div class="small">%s</div></td>`, html.EscapeString(auditActionLabel(entry.Action)), html.EscapeString(entry.Action)))
+		builder.WriteString(`<td>`)
+		writeAuditTarget(&builder, entry.Target)
+		builder.WriteString(`</td>`)
+		builder.WriteString(fmt.Sprintf(`<td>%s</td>`, html.EscapeString(entry.IP)))
+		builder.WriteString(fmt.Sprintf(`<td><pre style="margin:0;white-space:pre-wrap;word-break:break-word;max-width:420px;font-size:12px">%s</pre></td>`, html.EscapeString(indentAuditDetails(entry.Details))))
+		hash := entry.Hash
+		if len(hash) > 12 {
+			hash = hash[:12] + "…"
+		}
+		if hash == "" {
+			hash = "non scellée"
+		}
+		builder.WriteString(fmt.Sprintf(`<td class="small" title="%s">%s</td>`, html.EscapeString(entry.Hash), html.EscapeString(hash)))
+		builder.WriteString(`</tr>`)
+	}
+	if len(entries) == 0 {
+		builder.WriteString(`<tr><td colspan="7" class="small">Aucune entrée</td></tr>`)
+	}
+	builder.WriteString(`</tbody></table>`)
+	writeAdminPagination(&builder, query, "/admin/audit", total)
+	builder.WriteString(`</div>`)
+
+	writeAdminPageEnd(&builder)
+	w.Write([]byte(builder.String()))
+}
+
+// auditTargetPaths associe le type d'une cible ("quote:12") à la page admin de l'objet
+var auditTargetPaths = map[string]string{
+	"quote":   "/admin/quotes/",
+	"order":   "/admin/orders/",
+	"invoice": "/admin/invoices/",
+	"email":   "/admin/emails/",
+}
+
+// writeAuditTarget écrit la cible d'une entrée, avec un lien vers l'objet quand il a une page admin
+func writeAuditTarget(builder *strings.Builder, target string) {
+	kind, id, found := strings.Cut(target, ":")
+	if path, ok := auditTargetPaths[kind]; ok && found {
+		if _, err := strconv.Atoi(id); err == nil {
+			builder.WriteString(fmt.Sprintf(`<a href="%s%s">%s</a>`, path, id, html.EscapeString(target)))
+			return
+		}
+	}
+	builder.WriteString(html.EscapeString(target))
+}
+
+// indentAuditDetails met en forme le JSON des détails ; un détail non JSON est affiché tel quel
+func indentAuditDetails(details string) string {
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, []byte(details), "", "  "); err != nil {
+		return details
+	}
+	return indented.String()
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"html"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// adminBulkHandler applique une action groupée à la sélection d'une liste admin : mise à la corbeille
+// d'utilisateurs ou de devis, changement de statut ou attribution de devis. Le lot est appliqué dans une
+// seule transaction ; la page de résultat détaille ce qui a été fait ou écarté pour chaque élément
+func adminBulkHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdminAuth(w, r) {
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Formulaire invalide", http.StatusBadRequest)
+		return
+	}
+	ids, err := parseBulkIDs(r.PostForm["ids"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(ids) == 0 {
+		http.Error(w, "Sélectionnez au moins un élément", http.StatusBadRequest)
+		return
+	}
+
+	var title string
+	var step bulkStep
+	notified := false
+	deletedAt := time.Now().Truncate(time.Second)
+	kind, action := r.PostFormValue("kind"), r.PostFormValue("action")
+	switch kind + ":" + action {
+	case "users:delete":
+		title = "Mise à la corbeille d'utilisateurs"
+		step = func(tx *sql.Tx, id int, outcome *bulkOutcome) error {
+			user, err := getUserForUpdate(tx, id)
+			if err != nil {
+				return err
+			}
+			if user == nil {
+				return bulkRefusal("utilisateur introuvable ou déjà à la corbeille")
+			}
+			outcome.Label = user.Email
+			quotes, err := softDeleteUserTx(tx, user, deletedAt)
+			if err != nil {
+				return err
+			}
+			outcome.Link = "/admin/trash"
+			outcome.Message = fmt.Sprintf("mis à la corbeille avec %d devis", quotes)
+			outcome.AuditAction = AuditActionUserDelete
+			outcome.AuditDetails = map[string]any{"deleted": userAuditSnapshot(user), "quotes": quotes}
+			return nil
+		}
+
+	case "quotes:delete":
+		title = "Mise à la corbeille de devis"
+		step = bulkQuoteStep(func(tx *sql.Tx, quote *QuoteRecord, outcome *bulkOutcome) error {
+			err := softDeleteQuoteTx(tx, quote.ID, deletedAt)
+			if errors.Is(err, errQuoteHasOrder) {
+				return bulkRefusal(err.Error())
+			}
+			if err != nil {
+				return err
+			}
+			outcome.Link = "/admin/trash"
+			outcome.Message = "mis à la corbeille"
+			outcome.AuditAction = AuditActionQuoteDelete
+			outcome.AuditDetails = auditDeleted(map[string]any{"reference": quote.Reference, "email": quote.Email, "produit": quote.Produit, "status": quote.Status})
+			return nil
+		})
+
+	case "quotes:status":
+		status := r.PostFormValue("status")
+		if !isValidQuoteStatus(status) {
+			http.Error(w, "Statut invalide", http.StatusBadRequest)
+			return
+		}
+		reason := strings.TrimSpace(r.PostFormValue("reason"))
+		if status == QuoteStatusRejected && reason == "" {
+			http.Error(w, "Indiquez le motif du refus, il est envoyé à chaque client", http.StatusBadRequest)
+			return
+		}
+		title = "Changement de statut : " + quoteStatusLabel(status)
+		step = bulkQuoteStep(func(tx *sql.Tx, quote *QuoteRecord, outcome *bulkOutcome) error {
+			if quote.Status == status {
+				return bulkRefusal("déjà au statut « " + quoteStatusLabel(status) + " »")
+			}
+			if status == QuoteStatusSent {
+				var lines int
+				if err := tx.QueryRow(rebindQuery("SELECT COUNT(*) FROM quote_lines WHERE quote_id = ?"), quote.ID).Scan(&lines); err != nil {
+					return err
+				}
+				if lines == 0 {
+					return bulkRefusal("aucune ligne chiffrée : le devis ne peut pas être envoyé")
+				}
+			}
+			sent, err := updateQuoteStatusTx(r.Context(), tx, quote, status, reason)
+			if err != nil {
+				return err
+			}
+			notified = notified || sent
+			outcome.Message = quoteStatusLabel(quote.Status) + " → " + quoteStatusLabel(status)
+			after := map[string]any{"status": status, "rejection_reason": quote.RejectionReason}
+			if status == QuoteStatusRejected {
+				after["rejection_reason"] = reason
+			}
+			outcome.AuditAction = AuditActionQuoteStatus
+			outcome.AuditDetails = auditDiff(map[string]any{"status": quote.Status, "rejection_reason": quote.RejectionReason}, after)
+			return nil
+		})
+
+	case "quotes:assign":
+		staff := strings.TrimSpace(r.PostFormValue("assigned_to"))
+		if staff != "" && !isAdminStaff(staff) {
+			http.Error(w, "Membre de l'équipe inconnu (ADMIN_STAFF)", http.StatusBadRequest)
+			return
+		}
+		title = "Attribution à " + staff
+		if staff == "" {
+			title = "Retrait de l'attribution"
+		}
+		step = bulkQuoteStep(func(tx *sql.Tx, quote *QuoteRecord, outcome *bulkOutcome) error {
+			if quote.AssignedTo == staff {
+				if staff == "" {
+					return bulkRefusal("déjà sans attribution")
+				}
+				return bulkRefusal("déjà assigné à " + staff)
+			}
+			if err := assignQuoteTx(tx, quote.ID, staff); err != nil {
+				return err
+			}
+			outcome.Message = "assigné à " + staff
+			if staff == "" {
+				outcome.Message = "attribution retirée"
+			}
+			outcome.AuditAction = AuditActionQuoteAssign
+			outcome.AuditDetails = auditDiff(map[string]any{"assigned_to": quote.AssignedTo}, map[string]any{"assigned_to": staff})
+			return nil
+		})
+
+	default:
+		http.Error(w, "Action groupée inconnue", http.StatusBadRequest)
+		return
+	}
+
+	outcomes, err := runBulk(ids, step)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Erreur action groupée", "kind", kind, "action", action, "count", len(ids), "err", err)
+	} else {
+		if notified {
+			notifyOutbox()
+		}
+		notifyWebhooks()
+		for _, outcome := range outcomes {
+			if outcome.Done && outcome.AuditAction != "" {
+				outcome.AuditDetails["bulk"] = true
+				auditAdminAction(r, outcome.AuditAction, fmt.Sprintf("%s:%d", strings.TrimSuffix(kind, "s"), outcome.ID), outcome.AuditDetails)
+			}
+		}
+	}
+
+	renderBulkResults(w, title, outcomes, err, adminReturnPath(r.PostFormValue("return")))
+}
+
+// bulkQuoteStep verrouille le devis de l'élément et renseigne son libellé avant d'appliquer apply
+func bulkQuoteStep(apply func(tx *sql.Tx, quote *QuoteRecord, outcome *bulkOutcome) error) bulkStep {
+	return func(tx *sql.Tx, id int, outcome *bulkOutcome) error {
+		quote, err := getQuoteForUpdate(tx, id)
+		if err != nil {
+			return err
+		}
+		if quote == nil {
+			return bulkRefusal("devis introuvable ou déjà à la corbeille")
+		}
+		outcome.Label = strings.TrimSpace(quote.Reference + " " + quote.Prenom + " " + quote.Nom)
+		outcome.Link = fmt.Sprintf("/admin/quotes/%d", quote.ID)
+		return apply(tx, quote, outcome)
+	}
+}
+
+// adminReturnPath n'accepte comme page de retour qu'une page de l'admin
+func adminReturnPath(path string) string {
+	if path == "/admin" || strings.HasPrefix(path, "/admin/") || strings.HasPrefix(path, "/admin?") {
+		return path
+	}
+	return "/admin"
+}
+
+func renderBulkResults(w http.ResponseWriter, title string, outcomes []bulkOutcome, bulkErr error, returnPath string) {
+	done := 0
+	for _, outcome := range outcomes {
+		if outcome.Done {
+			done++
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if bulkErr != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	var builder strings.Builder
+	writeAdminPageStart(&builder, "Action groupée - Admin Modul-space")
+	builder.WriteString(fmt.Sprintf(`<div class="card"><h1>%s</h1><p class="meta">%d élément(s) traité(s) • %d écarté(s)</p>`, html.EscapeString(title), done, len(outcomes)-done))
+	if bulkErr != nil {
+		builder.WriteString(fmt.Sprintf(`<p><strong style="color:#b91c1c">Lot annulé : aucun élément n'a été modifié.</strong></p><p class="small">%s</p>`, html.EscapeString(bulkErr.Error())))
+	}
+	builder.WriteString(fmt.Sprintf(`<a href="%s">← Retour à la liste</a></div>`, html.EscapeString(returnPath)))
+
+	builder.WriteString(`<div class="card"><table><thead><tr><th>Élément</th><th>Résultat</th><th>Détail</th></tr></thead><tbody>`)
+	for _, outcome := range outcomes {
+		label := outcome.Label
+		if label == "" {
+			label = fmt.Sprintf("#%d", outcome.ID)
+		}
+		builder.WriteString(`<tr><td>`)
+		if outcome.Link != "" {
+			builder.WriteString(fmt.Sprintf(`<a href="%s">%s</a>`, outcome.Link, html.EscapeString(label)))
+		} else {
+			builder.WriteString(html.EscapeString(label))
+		}
+		result := "Écarté"
+		if outcome.Done {
+			result = "Fait"
+		}
+		builder.WriteString(fmt.Sprintf(`</td><td>%s</td><td>%s</td></tr>`, result, html.EscapeString(outcome.Message)))
+	}
+	builder.WriteString(`</tbody></table></div>`)
+
+	writeAdminPageEnd(&builder)
+	w.Write([]byte(builder.String()))
+}
+
+// writeAdminBulkForm écrit la barre d'actions groupées d'une liste ; les cases à cocher des lignes
+// y sont rattachées par l'attribut form, la barre pouvant ainsi précéder le tableau
+func writeAdminBulkForm(builder *strings.Builder, kind, returnPath string) {
+	builder.WriteString(fmt.Sprintf(`<form id="bulk-%s" method="POST" action="/admin/bulk" style="display:flex;gap:8px;flex-wrap:wrap;align-items:center;margin:10px 0">`, kind))
+	builder.WriteString(fmt.Sprintf(`<input type="hidden" name="kind" value="%s"><input type="hidden" name="return" value="%s"><input type="hidden" name="bulk" value="1">`, kind, html.EscapeString(returnPath)))
+	builder.WriteString(`<span class="small">Sélection :</span>`)
+	if kind == "quotes" {
+		builder.WriteString(`<select name="status">`)
+		for _, status := range quoteStatuses {
+			builder.WriteString(fmt.Sprintf(`<option value="%s">%s</option>`, status, html.EscapeString(quoteStatusLabel(status))))
+		}
+		builder.WriteString(`</select><input type="text" name="reason" placeholder="Motif si refus (envoyé aux clients)"><button type="submit" name="action" value="status" class="btn-secondary">Changer le statut</button>`)
+		builder.WriteString(`<select name="assigned_to"><option value="">Personne</option>`)
+		for _, staff := range adminStaff() {
+			builder.WriteString(fmt.Sprintf(`<option value="%s">%s</option>`, html.EscapeString(staff), html.EscapeString(staff)))
+		}
+		builder.WriteString(`</select><button type="submit" name="action" value="assign" class="btn-secondary">Assigner</button>`)
+	}
+	builder.WriteString(`<button type="submit" name="action" value="delete" onclick="return confirm('Mettre la sélection à la corbeille ?');">Mettre à la corbeille</button>`)
+	builder.WriteString(fmt.Sprintf(`<button type="submit" name="format" value="csv" formaction="/admin/%s/export" formmethod="GET" class="btn-secondary">Exporter (CSV)</button>`, kind))
+	builder.WriteString(fmt.Sprintf(`<button type="submit" name="format" value="xlsx" formaction="/admin/%s/export" formmethod="GET" class="btn-secondary">Exporter (Excel)</button>`, kind))
+	builder.WriteString(`</form>`)
+}
+
+// writeAdminBulkSelectAll écrit l'en-tête de la colonne de sélection, qui coche ou décoche toute la page
+func writeAdminBulkSelectAll(builder *strings.Builder, kind string) {
+	builder.WriteString(fmt.Sprintf(`<th><input type="checkbox" title="Tout sélectionner" onclick="for (const box of document.querySelectorAll('input[name=ids][form=bulk-%s]')) box.checked = this.checked"></th>`, kind))
+}
+
+func writeAdminBulkCheckbox(builder *strings.Builder, kind string, id int) {
+	builder.WriteString(fmt.Sprintf(`<td><input type="checkbox" name="ids" value="%d" form="bulk-%s"></td>`, id, kind))
+}
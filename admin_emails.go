@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Nombre d'emails affichés sur /admin/emails
+const adminOutboxLimit = 200
+
+func adminEmailsHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdminAuth(w, r) {
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	if _, ok := outboxStatusLabels[status]; !ok {
+		status = ""
+	}
+
+	emails, err := listOutboxEmails(status, adminOutboxLimit)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Erreur récupération outbox (admin)", "err", err)
+		renderAdminErrorPage(w, "Erreur récupération des emails", err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	counts, err := countOutboxByStatus()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Erreur comptage outbox (admin)", "err", err)
+		renderAdminErrorPage(w, "Erreur récupération des emails", err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	var builder strings.Builder
+	writeAdminPageStart(&builder, "Emails - Admin Modul-space")
+
+	builder.WriteString(`<div class="card"><h1>Emails sortants</h1><p class="meta">`)
+	filters := []string{`<a href="/admin/emails">Tous</a>`}
+	for _, candidate := range []string{OutboxStatusPending, OutboxStatusSending, OutboxStatusSent, OutboxStatusDead} {
+		label := fmt.Sprintf("%s (%d)", outboxStatusLabel(candidate), counts[candidate])
+		if candidate == status {
+			filters = append(filters, "<strong>"+html.EscapeString(label)+"</strong>")
+		} else {
+			filters = append(filters, fmt.Sprintf(`<a href="/admin/emails?status=%s">%s</a>`, candidate, html.EscapeString(label)))
+		}
+	}
+	builder.WriteString(strings.Join(filters, " • "))
+	builder.WriteString(`</p><a href="/admin">← Retour au dashboard</a></div>`)
+
+	builder.WriteString(`<div class="card">`)
+	if len(emails) == 0 {
+		builder.WriteString(`<p class="small">Aucun email.</p>`)
+	} else {
+		builder.WriteString(`<table><thead><tr><th>Créé le</th><th>Destinataires</th><th>Objet</th><th>Statut</th><th>Essais</th><th>Dernière erreur</th><th>Action</th></tr></thead><tbody>`)
+		for _, email := range emails {
+			action := ""
+			if email.Status != OutboxStatusSending {
+				action = fmt.Sprintf(`<form method="POST" action="/admin/emails/%d/resend" class="inline-form"><button type="submit" class="btn-secondary">Renvoyer</button></form>`, email.ID)
+			}
+			builder.WriteString(fmt.Sprintf(`<tr><td>%s</td><td>%s</td><td><a href="/admin/emails/%d">%s</a></td><td>%s</td><td>%d</td><td class="small">%s</td><td>%s</td></tr>`,
+				email.CreatedAt.Format("02/01/2006 15:04"), html.EscapeString(strings.Join(email.Recipients, ", ")), email.ID, html.EscapeString(email.Subject),
+				html.EscapeString(outboxStatusLabel(email.Status)), email.Attempts, html.EscapeString(email.LastError), action))
+		}
+		builder.WriteString(`</tbody></table>`)
+	}
+	builder.WriteString(`</div>`)
+
+	writeAdminPageEnd(&builder)
+	w.Write([]byte(builder.String()))
+}
+
+func adminEmailHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdminAuth(w, r) {
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	emailID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || emailID <= 0 {
+		http.Error(w, "ID email invalide", http.StatusBadRequest)
+		return
+	}
+
+	email, err := GetOutboxEmail(emailID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Erreur récupération email (admin)", "email_id", emailID, "err", err)
+		renderAdminErrorPage(w, "Erreur récupération de l'email", err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if email == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	var builder strings.Builder
+	writeAdminPageStart(&builder, fmt.Sprintf("Email #%d - Admin Modul-space", email.ID))
+
+	builder.WriteString(fmt.Sprintf(`<div class="card"><h1>%s</h1><p class="meta">À %s • %s • %d essai(s)</p><a href="/admin/emails">← Retour aux emails</a></div>`,
+		html.EscapeString(email.Subject), html.EscapeString(strings.Join(email.Recipients, ", ")), html.EscapeString(outboxStatusLabel(email.Status)), email.Attempts))
+
+	builder.WriteString(`<div class="card"><table><tbody>`)
+	builder.WriteString(fmt.Sprintf(`<tr><th>Créé le</th><td>%s</td></tr>`, email.CreatedAt.Format("02/01/2006 15:04:05")))
+	if email.SentAt != nil {
+		builder.WriteString(fmt.Sprintf(`<tr><th>Envoyé le</th><td>%s</td></tr>`, email.SentAt.Format("02/01/2006 15:04:05")))
+	} else if email.NextAttemptAt != nil && email.Status == OutboxStatusPending {
+		builder.WriteString(fmt.Sprintf(`<tr><th>Prochain essai</th><td>%s</td></tr>`, email.NextAttemptAt.Format("02/01/2006 15:04:05")))
+	}
+	if email.RequestID != "" {
+		builder.WriteString(fmt.Sprintf(`<tr><th>Requête d'origine</th><td><code>%s</code></td></tr>`, html.EscapeString(email.RequestID)))
+	}
+	if email.LastError != "" {
+		builder.WriteString(fmt.Sprintf(`<tr><th>Dernière erreur</th><td>%s</td></tr>`, html.EscapeString(email.LastError)))
+	}
+	builder.WriteString(`</tbody></table>`)
+	if email.Status != OutboxStatusSending {
+		builder.WriteString(fmt.Sprintf(`<p><form method="POST" action="/admin/emails/%d/resend" class="inline-form"><button type="submit" class="btn-secondary">Renvoyer</button></form></p>`, email.ID))
+	}
+	builder.WriteString(`</div>`)
+
+	builder.WriteString(fmt.Sprintf(`<div class="card"><h2>Message brut</h2><pre style="white-space:pre-wrap;font-size:13px">%s</pre></div>`, html.EscapeString(email.Message)))
+
+	writeAdminPageEnd(&builder)
+	w.Write([]byte(builder.String()))
+}
+
+func adminResendEmailHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdminAuth(w, r) {
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	emailID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || emailID <= 0 {
+		http.Error(w, "ID email invalide", http.StatusBadRequest)
+		return
+	}
+
+	if err := resendOutboxEmail(emailID); err != nil {
+		slog.ErrorContext(r.Context(), "Erreur remise en file email", "email_id", emailID, "err", err)
+		renderAdminErrorPage(w, "Erreur renvoi de l'email", err.Error(), http.StatusInternalServerError)
+		return
+	}
+	auditAdminAction(r, AuditActionEmailResend, fmt.Sprintf("email:%d", emailID), nil)
+
+	http.Redirect(w, r, fmt.Sprintf("/admin/emails/%d", emailID), http.StatusSeeOther)
+}
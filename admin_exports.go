@@ -0,0 +1,588 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// exportKind décide du format d'une colonne exportée, en CSV comme en XLSX
+type exportKind int
+
+const (
+	exportText exportKind = iota
+	exportNumber
+	// exportMoney est un montant en centimes
+	exportMoney
+	exportDate
+	exportBool
+	exportQuoteStatus
+	exportOrderStatus
+)
+
+// exportColumn est une colonne exportable : clé du paramètre columns, en-tête et colonne SQL
+type exportColumn struct {
+	Key   string
+	Label string
+	SQL   string
+	Kind  exportKind
+}
+
+// exportDataset décrit une liste exportable depuis l'admin
+type exportDataset struct {
+	Name        string
+	Title       string
+	Table       string
+	Columns     []exportColumn
+	SortColumns map[string]string
+	Conditions  func(AdminListQuery) sqlConditions
+	// ValidStatus valide le filtre status ; nil si la liste n'a pas de statut
+	ValidStatus func(string) bool
+}
+
+var exportDatasets = map[string]exportDataset{
+	"users": {
+		Name:  "users",
+		Title: "Utilisateurs",
+		Table: "users",
+		Columns: []exportColumn{
+			{"id", "ID", "id", exportNumber},
+			{"email", "Email", "email", exportText},
+			{"nom", "Nom", "nom", exportText},
+			{"prenom", "Prénom", "prenom", exportText},
+			{"email_notifications", "Emails de suivi", "email_notifications", exportBool},
+			{"created_at", "Inscrit le", "created_at", exportDate},
+		},
+		SortColumns: adminUserSortColumns,
+		Conditions:  adminUserConditions,
+	},
+	"quotes": {
+		Name:  "quotes",
+		Title: "Devis",
+		Table: "quotes",
+		Columns: []exportColumn{
+			{"reference", "Référence", "reference", exportText},
+			{"nom", "Nom", "nom", exportText},
+			{"prenom", "Prénom", "prenom", exportText},
+			{"email", "Email", "email", exportText},
+			{"telephone", "Téléphone", "telephone", exportText},
+			{"produit", "Produit", "produit", exportText},
+			{"configuration", "Configuration", "configuration", exportText},
+			{"message", "Message", "message", exportText},
+			{"status", "Statut", "status", exportQuoteStatus},
+			{"rejection_reason", "Motif du refus", "rejection_reason", exportText},
+			{"assigned_to", "Assigné à", "assigned_to", exportText},
+			{"quoted_at", "Devis envoyé le", "quoted_at", exportDate},
+			{"created_at", "Demandé le", "created_at", exportDate},
+		},
+		SortColumns: adminQuoteSortColumns,
+		Conditions:  adminQuoteConditions,
+		ValidStatus: isValidQuoteStatus,
+	},
+	"orders": {
+		Name:  "orders",
+		Title: "Commandes",
+		Table: "orders",
+		Columns: []exportColumn{
+			{"reference", "Référence", "reference", exportText},
+			{"nom", "Nom", "nom", exportText},
+			{"prenom", "Prénom", "prenom", exportText},
+			{"email", "Email", "email", exportText},
+			{"telephone", "Téléphone", "telephone", exportText},
+			{"produit", "Produit", "produit", exportText},
+			{"status", "Statut", "status", exportOrderStatus},
+			{"total_ht", "Total HT", "total_ht_cents", exportMoney},
+			{"total_vat", "TVA", "total_vat_cents", exportMoney},
+			{"total_ttc", "Total TTC", "total_ttc_cents", exportMoney},
+			{"expected_delivery_at", "Livraison prévue", "expected_delivery_at", exportDate},
+			{"delivered_at", "Livrée le", "delivered_at", exportDate},
+			{"created_at", "Commandée le", "created_at", exportDate},
+		},
+		SortColumns: map[string]string{"reference": "reference", "nom": "nom", "status": "status", "total_ttc": "total_ttc_cents", "created_at": "created_at"},
+		Conditions:  adminOrderConditions,
+		ValidStatus: func(status string) bool { _, ok := orderStatusLabels[status]; return ok },
+	},
+}
+
+// selectedColumns renvoie les colonnes demandées (paramètre columns répété), toutes par défaut
+func (d exportDataset) selectedColumns(keys []string) ([]exportColumn, error) {
+	if len(keys) == 0 {
+		return d.Columns, nil
+	}
+	columns := make([]exportColumn, 0, len(keys))
+	for _, key := range keys {
+		found := false
+		for _, column := range d.Columns {
+			if column.Key == key {
+				columns = append(columns, column)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("colonne inconnue: %s", key)
+		}
+	}
+	return columns, nil
+}
+
+// adminExportHandler exporte en CSV ou XLSX une liste admin, avec les filtres et le tri de la liste.
+// Les lignes sont lues et écrites au fil de l'eau ; l'export est consigné dans le journal d'audit
+func adminExportHandler(name string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !requireAdminAuth(w, r) {
+			return
+		}
+
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		dataset := exportDatasets[name]
+		values := r.URL.Query()
+		query := parseAdminListQuery(values, dataset.SortColumns, "created_at")
+		if query.Status != "" && (dataset.ValidStatus == nil || !dataset.ValidStatus(query.Status)) {
+			query.Status = ""
+		}
+		// Export d'une sélection (actions groupées) : seules les lignes cochées, sans les filtres de la liste
+		ids, err := parseBulkIDs(values["ids"])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if values.Get("bulk") == "1" && len(ids) == 0 {
+			http.Error(w, "Sélectionnez au moins un élément", http.StatusBadRequest)
+			return
+		}
+		if len(ids) > 0 {
+			query = AdminListQuery{Sort: query.Sort, Desc: query.Desc}
+		}
+		columns, err := dataset.selectedColumns(values["columns"])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		format := values.Get("format")
+		if format == "" {
+			format = "csv"
+		}
+		if format != "csv" && format != "xlsx" {
+			http.Error(w, "Format d'export inconnu (csv ou xlsx)", http.StatusBadRequest)
+			return
+		}
+
+		// Les exports contiennent des données personnelles : sans trace dans le journal, pas d'export
+		keys := make([]string, 0, len(columns))
+		for _, column := range columns {
+			keys = append(keys, column.Key)
+		}
+		filters := query.values()
+		filters.Del("page")
+		filters.Del("size")
+		details := map[string]any{"format": format, "columns": keys, "filters": filters}
+		if len(ids) > 0 {
+			details["ids"] = ids
+		}
+		if err := recordAudit(r, AuditActionExport, dataset.Name, details); err != nil {
+			slog.ErrorContext(r.Context(), "Erreur journal d'audit (export)", "dataset", dataset.Name, "err", err)
+			renderAdminErrorPage(w, "Export impossible", "Le journal d'audit n'a pas pu être écrit.", http.StatusInternalServerError)
+			return
+		}
+
+		selects := make([]string, 0, len(columns))
+		for _, column := range columns {
+			selects = append(selects, column.SQL)
+		}
+		conditions := dataset.Conditions(query)
+		if len(ids) > 0 {
+			conditions.addIDs("id", ids)
+		}
+		rows, err := db.Query(rebindQuery("SELECT "+strings.Join(selects, ", ")+" FROM "+dataset.Table+conditions.where()+orderByClause(query, dataset.SortColumns)), conditions.args...)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Erreur export", "dataset", dataset.Name, "err", err)
+			renderAdminErrorPage(w, "Erreur export", err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		filename := fmt.Sprintf("%s-%s.%s", dataset.Name, time.Now().Format("20060102-1504"), format)
+		var writer exportWriter
+		if format == "xlsx" {
+			w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+			writer = newXLSXExportWriter(w, dataset.Title, columns)
+		} else {
+			w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+			writer = newCSVExportWriter(w, columns)
+		}
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+		w.Header().Set("Cache-Control", "no-store")
+
+		count := 0
+		err = writer.WriteHeader()
+		for err == nil && rows.Next() {
+			cells := make([]any, len(columns))
+			targets := make([]any, len(columns))
+			for i := range cells {
+				targets[i] = &cells[i]
+			}
+			if err = rows.Scan(targets...); err == nil {
+				err = writer.WriteRow(cells)
+				count++
+			}
+		}
+		if err == nil {
+			err = rows.Err()
+		}
+		if closeErr := writer.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			// Les en-têtes sont déjà partis : le fichier est tronqué, on ne peut que le journaliser
+			slog.ErrorContext(r.Context(), "Erreur export interrompu", "dataset", dataset.Name, "rows", count, "err", err)
+			return
+		}
+		slog.InfoContext(r.Context(), "Export", "dataset", dataset.Name, "format", format, "rows", count, "actor", adminActor(r))
+	}
+}
+
+// exportWriter écrit un export ligne par ligne
+type exportWriter interface {
+	WriteHeader() error
+	WriteRow(cells []any) error
+	Close() error
+}
+
+// exportTime lit une date renvoyée par le driver (time.Time, ou texte sans parseTime)
+func exportTime(value any) (time.Time, bool) {
+	switch v := value.(type) {
+	case time.Time:
+		return v, true
+	case []byte:
+		return exportTime(string(v))
+	case string:
+		for _, layout := range []string{"2006-01-02 15:04:05", time.RFC3339Nano, "2006-01-02"} {
+			if parsed, err := time.ParseInLocation(layout, v, time.Local); err == nil {
+				return parsed, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// exportInt lit un entier renvoyé par le driver
+func exportInt(value any) (int64, bool) {
+	switch v := value.(type) {
+	case int64:
+		return v, true
+	case float64:
+		return int64(v), true
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	case []byte:
+		return exportInt(string(v))
+	case string:
+		parsed, err := strconv.ParseInt(v, 10, 64)
+		return parsed, err == nil
+	}
+	return 0, false
+}
+
+// exportString lit un texte renvoyé par le driver ; NULL devient une cellule vide
+func exportString(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case []byte:
+		return string(v)
+	case string:
+		return v
+	case time.Time:
+		return v.Format("2006-01-02 15:04")
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// exportTextValue renvoie le texte d'une cellule non numérique
+func exportTextValue(column exportColumn, value any) string {
+	switch column.Kind {
+	case exportQuoteStatus:
+		return quoteStatusLabel(exportString(value))
+	case exportOrderStatus:
+		return orderStatusLabel(exportString(value))
+	case exportBool:
+		if number, ok := exportInt(value); ok && number != 0 {
+			return "oui"
+		}
+		if value == nil {
+			return ""
+		}
+		return "non"
+	}
+	return exportString(value)
+}
+
+// csvExportWriter écrit un CSV pour Excel en français : UTF-8 avec BOM, séparateur ";", virgule décimale
+type csvExportWriter struct {
+	out     io.Writer
+	writer  *csv.Writer
+	columns []exportColumn
+	rows    int
+}
+
+func newCSVExportWriter(w io.Writer, columns []exportColumn) *csvExportWriter {
+	writer := csv.NewWriter(w)
+	writer.Comma = ';'
+	writer.UseCRLF = true
+	return &csvExportWriter{out: w, writer: writer, columns: columns}
+}
+
+// WriteHeader écrit le BOM, qui signale l'UTF-8 à Excel, puis la ligne d'en-têtes
+func (c *csvExportWriter) WriteHeader() error {
+	if _, err := io.WriteString(c.out, "\ufeff"); err != nil {
+		return err
+	}
+	labels := make([]string, 0, len(c.columns))
+	for _, column := range c.columns {
+		labels = append(labels, column.Label)
+	}
+	return c.writer.Write(labels)
+}
+
+func (c *csvExportWriter) WriteRow(cells []any) error {
+	record := make([]string, len(cells))
+	for i, value := range cells {
+		column := c.columns[i]
+		switch column.Kind {
+		case exportNumber:
+			record[i] = exportString(value)
+		case exportMoney:
+			if cents, ok := exportInt(value); ok {
+				record[i] = strings.Replace(strconv.FormatFloat(float64(cents)/100, 'f', 2, 64), ".", ",", 1)
+			}
+		case exportDate:
+			if date, ok := exportTime(value); ok {
+				record[i] = date.Format("2006-01-02 15:04")
+			}
+		default:
+			record[i] = csvSafeText(exportTextValue(column, value))
+		}
+	}
+	if err := c.writer.Write(record); err != nil {
+		return err
+	}
+	c.rows++
+	if c.rows%500 == 0 {
+		c.writer.Flush()
+		return c.writer.Error()
+	}
+	return nil
+}
+
+func (c *csvExportWriter) Close() error {
+	c.writer.Flush()
+	return c.writer.Error()
+}
+
+// csvSafeText empêche un tableur d'interpréter comme formule un texte saisi par un client
+func csvSafeText(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+// Styles de cellule définis dans xlsxStyles
+const (
+	xlsxStyleDate   = 1
+	xlsxStyleMoney  = 2
+	xlsxStyleHeader = 3
+)
+
+// xlsxExportWriter écrit un classeur XLSX d'une feuille. Le paquet ZIP est produit au fil de l'eau :
+// les parties fixes d'abord, puis la feuille ligne par ligne, en chaînes inline (sans table partagée)
+type xlsxExportWriter struct {
+	archive *zip.Writer
+	sheet   *bufio.Writer
+	columns []exportColumn
+	title   string
+	row     int
+	err     error
+}
+
+func newXLSXExportWriter(w io.Writer, title string, columns []exportColumn) *xlsxExportWriter {
+	return &xlsxExportWriter{archive: zip.NewWriter(w), columns: columns, title: title}
+}
+
+func (x *xlsxExportWriter) WriteHeader() error {
+	var sheetName strings.Builder
+	xml.EscapeText(&sheetName, []byte(x.title))
+	parts := [][2]string{
+		{"[Content_Types].xml", xlsxContentTypes},
+		{"_rels/.rels", xlsxRootRels},
+		{"xl/workbook.xml", fmt.Sprintf(xlsxWorkbook, sheetName.String())},
+		{"xl/_rels/workbook.xml.rels", xlsxWorkbookRels},
+		{"xl/styles.xml", xlsxStyles},
+	}
+	for _, part := range parts {
+		file, err := x.archive.Create(part[0])
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(file, xml.Header+part[1]); err != nil {
+			return err
+		}
+	}
+
+	file, err := x.archive.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return err
+	}
+	x.sheet = bufio.NewWriter(file)
+	x.sheet.WriteString(xml.Header + `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetViews><sheetView workbookViewId="0"><pane ySplit="1" topLeftCell="A2" activePane="bottomLeft" state="frozen"/></sheetView></sheetViews><sheetData>`)
+
+	x.row = 1
+	x.sheet.WriteString(`<row r="1">`)
+	for i, column := range x.columns {
+		x.inlineString(i, column.Label, xlsxStyleHeader)
+	}
+	x.sheet.WriteString(`</row>`)
+	return x.err
+}
+
+func (x *xlsxExportWriter) WriteRow(cells []any) error {
+	x.row++
+	fmt.Fprintf(x.sheet, `<row r="%d">`, x.row)
+	for i, value := range cells {
+		column := x.columns[i]
+		switch column.Kind {
+		case exportNumber:
+			if number, ok := exportInt(value); ok {
+				x.number(i, strconv.FormatInt(number, 10), 0)
+			}
+		case exportMoney:
+			if cents, ok := exportInt(value); ok {
+				x.number(i, strconv.FormatFloat(float64(cents)/100, 'f', 2, 64), xlsxStyleMoney)
+			}
+		case exportDate:
+			if date, ok := exportTime(value); ok {
+				x.number(i, strconv.FormatFloat(excelSerialDate(date), 'f', 6, 64), xlsxStyleDate)
+			}
+		default:
+			if text := exportTextValue(column, value); text != "" {
+				x.inlineString(i, text, 0)
+			}
+		}
+	}
+	x.sheet.WriteString(`</row>`)
+	return x.err
+}
+
+func (x *xlsxExportWriter) Close() error {
+	if x.sheet != nil {
+		x.sheet.WriteString(`</sheetData></worksheet>`)
+		if err := x.sheet.Flush(); err != nil && x.err == nil {
+			x.err = err
+		}
+	}
+	if err := x.archive.Close(); err != nil && x.err == nil {
+		x.err = err
+	}
+	return x.err
+}
+
+func (x *xlsxExportWriter) cellRef(column int) string {
+	return xlsxColumnName(column) + strconv.Itoa(x.row)
+}
+
+func (x *xlsxExportWriter) number(column int, value string, style int) {
+	if style > 0 {
+		fmt.Fprintf(x.sheet, `<c r="%s" s="%d"><v>%s</v></c>`, x.cellRef(column), style, value)
+		return
+	}
+	fmt.Fprintf(x.sheet, `<c r="%s"><v>%s</v></c>`, x.cellRef(column), value)
+}
+
+func (x *xlsxExportWriter) inlineString(column int, value string, style int) {
+	styleAttribute := ""
+	if style > 0 {
+		styleAttribute = fmt.Sprintf(` s="%d"`, style)
+	}
+	fmt.Fprintf(x.sheet, `<c r="%s"%s t="inlineStr"><is><t xml:space="preserve">`, x.cellRef(column), styleAttribute)
+	if err := xml.EscapeText(x.sheet, []byte(value)); err != nil && x.err == nil {
+		x.err = err
+	}
+	x.sheet.WriteString(`</t></is></c>`)
+}
+
+// xlsxColumnName convertit un index de colonne (0 = A) en lettres Excel : A…Z, AA…
+func xlsxColumnName(index int) string {
+	name := ""
+	for index >= 0 {
+		name = string(rune('A'+index%26)) + name
+		index = index/26 - 1
+	}
+	return name
+}
+
+// excelSerialDate convertit une date en numéro de série Excel (jours depuis le 30/12/1899), heure locale
+func excelSerialDate(value time.Time) float64 {
+	value = value.In(time.Local)
+	wall := time.Date(value.Year(), value.Month(), value.Day(), value.Hour(), value.Minute(), value.Second(), 0, time.UTC)
+	return wall.Sub(time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)).Hours() / 24
+}
+
+const xlsxContentTypes = `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"><Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/><Default Extension="xml" ContentType="application/xml"/><Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/><Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/><Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/></Types>`
+
+const xlsxRootRels = `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/></Relationships>`
+
+const xlsxWorkbook = `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets><sheet name="%s" sheetId="1" r:id="rId1"/></sheets></workbook>`
+
+const xlsxWorkbookRels = `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/><Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/></Relationships>`
+
+// Styles : 0 normal, 1 date, 2 montant en euros, 3 en-tête en gras
+const xlsxStyles = `<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><numFmts count="2"><numFmt numFmtId="164" formatCode="dd/mm/yyyy hh:mm"/><numFmt numFmtId="165" formatCode="#,##0.00 &quot;€&quot;"/></numFmts><fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts><fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills><borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders><cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs><cellXfs count="4"><xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/><xf numFmtId="164" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/><xf numFmtId="165" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/><xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/></cellXfs><cellStyles count="1"><cellStyle name="Normal" xfId="0" builtinId="0"/></cellStyles></styleSheet>`
+
+// writeAdminExportForm écrit le formulaire d'export d'une liste : choix des colonnes et du format.
+// Les filtres de query sont repris tels quels ; withFilters ajoute des champs pour les saisir
+func writeAdminExportForm(builder *strings.Builder, name string, query AdminListQuery, withFilters bool) {
+	dataset := exportDatasets[name]
+	builder.WriteString(fmt.Sprintf(`<details><summary>Exporter (CSV ou Excel)</summary><form method="GET" action="/admin/%s/export" style="margin-top:10px">`, name))
+	if withFilters {
+		builder.WriteString(`<p style="display:flex;gap:8px;flex-wrap:wrap;align-items:center"><input type="search" name="q" placeholder="Nom, email, produit ou référence" style="flex:1;min-width:200px">`)
+		if name == "orders" {
+			builder.WriteString(`<select name="status"><option value="">Tous les statuts</option>`)
+			for _, status := range orderStatuses {
+				builder.WriteString(fmt.Sprintf(`<option value="%s">%s</option>`, status, html.EscapeString(orderStatusLabel(status))))
+			}
+			builder.WriteString(`</select>`)
+		}
+		builder.WriteString(`<label class="small">Du <input type="date" name="from"></label> <label class="small">au <input type="date" name="to"></label></p>`)
+	} else {
+		for key, values := range query.values() {
+			if key == "page" || key == "size" {
+				continue
+			}
+			builder.WriteString(fmt.Sprintf(`<input type="hidden" name="%s" value="%s">`, html.EscapeString(key), html.EscapeString(values[0])))
+		}
+	}
+	builder.WriteString(`<p>`)
+	for _, column := range dataset.Columns {
+		builder.WriteString(fmt.Sprintf(`<label style="margin-right:12px;white-space:nowrap"><input type="checkbox" name="columns" value="%s" checked> %s</label>`, column.Key, html.EscapeString(column.Label)))
+	}
+	builder.WriteString(`</p><select name="format"><option value="csv">CSV (Excel, séparateur ;)</option><option value="xlsx">Excel (.xlsx)</option></select> <button type="submit" class="btn-secondary">Exporter</button>`)
+	builder.WriteString(`<span class="small"> Export journalisé : il contient des données personnelles.</span></form></details>`)
+}
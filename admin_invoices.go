@@ -0,0 +1,355 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// loadAdminInvoice charge la facture de la route /admin/invoices/{id} ou écrit la réponse d'erreur
+func loadAdminInvoice(w http.ResponseWriter, r *http.Request) (*Invoice, bool) {
+	invoiceID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || invoiceID <= 0 {
+		http.Error(w, "ID facture invalide", http.StatusBadRequest)
+		return nil, false
+	}
+
+	invoice, err := GetInvoiceByID(invoiceID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Erreur récupération facture (admin)", "invoice_id", invoiceID, "err", err)
+		renderAdminErrorPage(w, "Erreur récupération facture", err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	if invoice == nil {
+		http.NotFound(w, r)
+		return nil, false
+	}
+	return invoice, true
+}
+
+// writeInvoicePDF génère la facture et l'envoie en téléchargement
+func writeInvoicePDF(w http.ResponseWriter, r *http.Request, invoice *Invoice) {
+	order, err := GetOrderByID(invoice.OrderID)
+	if err != nil || order == nil {
+		slog.ErrorContext(r.Context(), "Erreur récupération commande de la facture", "order_id", invoice.OrderID, "invoice", invoice.Number, "err", err)
+		http.Error(w, "Erreur génération PDF", http.StatusInternalServerError)
+		return
+	}
+
+	lines, err := listInvoiceLines(invoice.ID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Erreur récupération lignes facture", "invoice_id", invoice.ID, "err", err)
+		http.Error(w, "Erreur génération PDF", http.StatusInternalServerError)
+		return
+	}
+
+	var deposits []*Invoice
+	if invoice.Kind == InvoiceKindFinal {
+		invoices, err := listInvoicesForOrder(order.ID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Erreur récupération factures commande", "order_id", order.ID, "err", err)
+			http.Error(w, "Erreur génération PDF", http.StatusInternalServerError)
+			return
+		}
+		for _, other := range invoices {
+			if other.Kind == InvoiceKindDeposit {
+				deposits = append(deposits, other)
+			}
+		}
+	}
+
+	pdf, err := renderInvoicePDF(invoice, order, lines, deposits)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Erreur génération PDF facture", "invoice_id", invoice.ID, "err", err)
+		http.Error(w, "Erreur génération PDF", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="facture-%s.pdf"`, invoice.Number))
+	w.Header().Set("Content-Length", strconv.Itoa(len(pdf)))
+	w.Write(pdf)
+}
+
+// writeOrderInvoicesCard affiche les factures d'une commande et les boutons d'émission sur la page commande
+func writeOrderInvoicesCard(builder *strings.Builder, order *Order, invoices []*Invoice) {
+	builder.WriteString(`<div class="card"><h2>Facturation</h2>`)
+	hasDeposit, hasFinal := false, false
+	if len(invoices) == 0 {
+		builder.WriteString(`<p class="small">Aucune facture émise.</p>`)
+	} else {
+		builder.WriteString(`<table><thead><tr><th>Numéro</th><th>Type</th><th>Émise le</th><th>Échéance</th><th>À payer</th><th>Réglé</th><th>Statut</th></tr></thead><tbody>`)
+		for _, invoice := range invoices {
+			switch invoice.Kind {
+			case InvoiceKindDeposit:
+				hasDeposit = true
+			case InvoiceKindFinal:
+				hasFinal = true
+			}
+			builder.WriteString(fmt.Sprintf(`<tr><td><a href="/admin/invoices/%d">%s</a></td><td>%s</td><td>%s</td><td>%s</td><td>%s</td><td>%s</td><td>%s</td></tr>`,
+				invoice.ID, html.EscapeString(invoice.Number), html.EscapeString(invoiceKindLabel(invoice.Kind)),
+				invoice.IssuedAt.Format("02/01/2006"), invoice.DueAt.Format("02/01/2006"),
+				html.EscapeString(formatEuros(invoice.AmountDueCents)), html.EscapeString(formatEuros(invoice.PaidCents)), html.EscapeString(invoice.PaymentStatusLabel())))
+		}
+		builder.WriteString(`</tbody></table>`)
+	}
+
+	if !hasFinal {
+		builder.WriteString(`<p>`)
+		if !hasDeposit {
+			builder.WriteString(fmt.Sprintf(`<form method="POST" action="/admin/orders/%d/invoices" class="inline-form"><input type="hidden" name="kind" value="%s"><button type="submit" class="btn-secondary">Émettre la facture d'acompte (%d %%)</button></form> `,
+				order.ID, InvoiceKindDeposit, invoiceDepositPercent()))
+		}
+		builder.WriteString(fmt.Sprintf(`<form method="POST" action="/admin/orders/%d/invoices" class="inline-form" onsubmit="return confirm('Émettre la facture de solde ? Elle ne pourra plus être modifiée.')"><input type="hidden" name="kind" value="%s"><button type="submit" class="btn-secondary">Émettre la facture de solde</button></form>`,
+			order.ID, InvoiceKindFinal))
+		builder.WriteString(`</p>`)
+	}
+	builder.WriteString(`</div>`)
+}
+
+func adminIssueInvoiceHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdminAuth(w, r) {
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	order, ok := loadAdminOrder(w, r)
+	if !ok {
+		return
+	}
+
+	invoiceID, err := issueInvoice(order, r.FormValue("kind"))
+	if errors.Is(err, errInvoiceExists) || errors.Is(err, errFinalInvoiceIssued) {
+		renderAdminErrorPage(w, "Émission impossible", err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Erreur émission facture commande", "order_id", order.ID, "err", err)
+		renderAdminErrorPage(w, "Erreur émission facture", err.Error(), http.StatusInternalServerError)
+		return
+	}
+	auditAdminAction(r, AuditActionInvoiceIssue, fmt.Sprintf("order:%d", order.ID), auditCreated(map[string]any{"invoice_id": invoiceID, "kind": r.FormValue("kind")}))
+
+	http.Redirect(w, r, fmt.Sprintf("/admin/invoices/%d", invoiceID), http.StatusSeeOther)
+}
+
+func adminInvoiceHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdminAuth(w, r) {
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	invoice, ok := loadAdminInvoice(w, r)
+	if !ok {
+		return
+	}
+
+	order, err := GetOrderByID(invoice.OrderID)
+	if err != nil || order == nil {
+		slog.ErrorContext(r.Context(), "Erreur récupération commande de la facture", "order_id", invoice.OrderID, "invoice", invoice.Number, "err", err)
+		renderAdminErrorPage(w, "Erreur récupération commande", fmt.Sprintf("commande %d introuvable", invoice.OrderID), http.StatusInternalServerError)
+		return
+	}
+
+	payments, err := listInvoicePayments(invoice.ID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Erreur récupération règlements facture", "invoice_id", invoice.ID, "err", err)
+		renderAdminErrorPage(w, "Erreur récupération règlements", err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	var builder strings.Builder
+	writeAdminPageStart(&builder, fmt.Sprintf("Facture %s - Admin Modul-space", invoice.Number))
+
+	builder.WriteString(fmt.Sprintf(`<div class="card"><h1>%s %s</h1><p class="meta">Commande %s • %s %s • Émise le %s • Échéance %s</p><a href="/admin/receivables">← Retour aux encours</a> • <a href="/admin/orders/%d">Voir la commande</a> • <a href="/admin/invoices/%d/pdf">Télécharger le PDF</a></div>`,
+		html.EscapeString(invoiceKindLabel(invoice.Kind)), html.EscapeString(invoice.Number), html.EscapeString(order.Reference), html.EscapeString(order.Prenom), html.EscapeString(order.Nom),
+		invoice.IssuedAt.Format("02/01/2006"), invoice.DueAt.Format("02/01/2006"), order.ID, invoice.ID))
+
+	builder.WriteString(`<div class="card"><h2>Montants</h2><table><tbody>`)
+	builder.WriteString(fmt.Sprintf(`<tr><td>Total HT</td><td>%s</td></tr><tr><td>TVA</td><td>%s</td></tr><tr class="totals"><td>Total TTC</td><td>%s</td></tr>`,
+		html.EscapeString(formatEuros(invoice.TotalHTCents)), html.EscapeString(formatEuros(invoice.TotalVATCents)), html.EscapeString(formatEuros(invoice.TotalTTCCents))))
+	if invoice.AmountDueCents != invoice.TotalTTCCents {
+		builder.WriteString(fmt.Sprintf(`<tr><td>Acomptes déduits</td><td>-%s</td></tr><tr class="totals"><td>Net à payer</td><td>%s</td></tr>`,
+			html.EscapeString(formatEuros(invoice.TotalTTCCents-invoice.AmountDueCents)), html.EscapeString(formatEuros(invoice.AmountDueCents))))
+	}
+	builder.WriteString(fmt.Sprintf(`<tr><td>Réglé</td><td>%s</td></tr><tr class="totals"><td>Reste à payer</td><td>%s</td></tr>`,
+		html.EscapeString(formatEuros(invoice.PaidCents)), html.EscapeString(formatEuros(invoice.OutstandingCents()))))
+	builder.WriteString(fmt.Sprintf(`</tbody></table><p>Statut : <strong>%s</strong></p></div>`, html.EscapeString(invoice.PaymentStatusLabel())))
+
+	builder.WriteString(`<div class="card"><h2>Règlements</h2>`)
+	if len(payments) == 0 {
+		builder.WriteString(`<p class="small">Aucun règlement enregistré.</p>`)
+	} else {
+		builder.WriteString(`<table><thead><tr><th>Date</th><th>Montant</th><th>Moyen</th><th>Référence</th></tr></thead><tbody>`)
+		for _, payment := range payments {
+			builder.WriteString(fmt.Sprintf(`<tr><td>%s</td><td>%s</td><td>%s</td><td>%s</td></tr>`,
+				payment.PaidAt.Format("02/01/2006"), html.EscapeString(formatEuros(payment.AmountCents)), html.EscapeString(payment.Method), html.EscapeString(payment.Reference)))
+		}
+		builder.WriteString(`</tbody></table>`)
+	}
+	if invoice.OutstandingCents() > 0 {
+		builder.WriteString(fmt.Sprintf(`<h3>Enregistrer un règlement</h3><form method="POST" action="/admin/invoices/%d/payments">`, invoice.ID))
+		builder.WriteString(fmt.Sprintf(`<input type="text" name="amount" placeholder="Montant (€)" value="%s" required> `, html.EscapeString(strings.TrimSuffix(formatEuros(invoice.OutstandingCents()), " €"))))
+		builder.WriteString(`<select name="method">`)
+		for _, method := range paymentMethods {
+			builder.WriteString(fmt.Sprintf(`<option value="%s">%s</option>`, html.EscapeString(method), html.EscapeString(method)))
+		}
+		builder.WriteString(`</select> `)
+		builder.WriteString(fmt.Sprintf(`<input type="date" name="paid_at" value="%s" required> `, time.Now().In(documentLocation).Format("2006-01-02")))
+		builder.WriteString(`<input type="text" name="reference" placeholder="Référence (n° de chèque, virement…)"> <button type="submit" class="btn-secondary">Enregistrer</button></form>`)
+	}
+	builder.WriteString(`</div>`)
+
+	writeAdminPageEnd(&builder)
+	w.Write([]byte(builder.String()))
+}
+
+func adminInvoicePaymentHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdminAuth(w, r) {
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	invoice, ok := loadAdminInvoice(w, r)
+	if !ok {
+		return
+	}
+
+	amount, err := parseEuros(r.FormValue("amount"))
+	if err != nil || amount <= 0 {
+		http.Error(w, "Montant invalide", http.StatusBadRequest)
+		return
+	}
+
+	method := r.FormValue("method")
+	validMethod := false
+	for _, candidate := range paymentMethods {
+		if candidate == method {
+			validMethod = true
+			break
+		}
+	}
+	if !validMethod {
+		http.Error(w, "Moyen de paiement invalide", http.StatusBadRequest)
+		return
+	}
+
+	paidAt, err := time.ParseInLocation("2006-01-02", r.FormValue("paid_at"), documentLocation)
+	if err != nil {
+		http.Error(w, "Date invalide", http.StatusBadRequest)
+		return
+	}
+
+	err = recordInvoicePayment(invoice.ID, amount, method, r.FormValue("reference"), paidAt)
+	if errors.Is(err, errPaymentTooLarge) {
+		renderAdminErrorPage(w, "Règlement refusé", fmt.Sprintf("%s (reste à payer : %s)", err.Error(), formatEuros(invoice.OutstandingCents())), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Erreur enregistrement règlement facture", "invoice_id", invoice.ID, "err", err)
+		renderAdminErrorPage(w, "Erreur enregistrement règlement", err.Error(), http.StatusInternalServerError)
+		return
+	}
+	auditAdminAction(r, AuditActionInvoicePayment, fmt.Sprintf("invoice:%d", invoice.ID),
+		auditCreated(map[string]any{"amount": formatEuros(amount), "method": method, "reference": r.FormValue("reference"), "paid_at": paidAt.Format("2006-01-02")}))
+
+	http.Redirect(w, r, fmt.Sprintf("/admin/invoices/%d", invoice.ID), http.StatusSeeOther)
+}
+
+func adminInvoicePDFHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdminAuth(w, r) {
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	invoice, ok := loadAdminInvoice(w, r)
+	if !ok {
+		return
+	}
+
+	writeInvoicePDF(w, r, invoice)
+}
+
+// adminReceivablesHandler liste les factures non soldées, les échéances dépassées en tête
+func adminReceivablesHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdminAuth(w, r) {
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	invoices, err := listInvoices()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Erreur récupération factures (admin)", "err", err)
+		renderAdminErrorPage(w, "Erreur récupération factures", err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var outstanding, overdue, invoiced, collected int64
+	open := make([]*Invoice, 0)
+	for _, invoice := range invoices {
+		invoiced += invoice.AmountDueCents
+		collected += invoice.PaidCents
+		if invoice.OutstandingCents() <= 0 {
+			continue
+		}
+		outstanding += invoice.OutstandingCents()
+		if invoice.IsOverdue() {
+			overdue += invoice.OutstandingCents()
+		}
+		open = append(open, invoice)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	var builder strings.Builder
+	writeAdminPageStart(&builder, "Encours clients - Admin Modul-space")
+
+	builder.WriteString(fmt.Sprintf(`<div class="card"><h1>Encours clients</h1><p class="meta">Facturé %s • Encaissé %s • Reste à encaisser <strong>%s</strong> • dont échu <strong>%s</strong></p><a href="/admin">← Retour au dashboard</a></div>`,
+		html.EscapeString(formatEuros(invoiced)), html.EscapeString(formatEuros(collected)), html.EscapeString(formatEuros(outstanding)), html.EscapeString(formatEuros(overdue))))
+
+	builder.WriteString(`<div class="card"><h2>Factures non soldées</h2>`)
+	if len(open) == 0 {
+		builder.WriteString(`<p class="small">Aucune facture en attente de règlement.</p>`)
+	} else {
+		builder.WriteString(`<table><thead><tr><th>Numéro</th><th>Type</th><th>Commande</th><th>Échéance</th><th>À payer</th><th>Réglé</th><th>Reste</th><th>Statut</th></tr></thead><tbody>`)
+		for _, invoice := range open {
+			status := html.EscapeString(invoice.PaymentStatusLabel())
+			if invoice.IsOverdue() {
+				status = `<strong style="color:#b91c1c">` + status + `</strong>`
+			}
+			builder.WriteString(fmt.Sprintf(`<tr><td><a href="/admin/invoices/%d">%s</a></td><td>%s</td><td><a href="/admin/orders/%d">Voir</a></td><td>%s</td><td>%s</td><td>%s</td><td>%s</td><td>%s</td></tr>`,
+				invoice.ID, html.EscapeString(invoice.Number), html.EscapeString(invoiceKindLabel(invoice.Kind)), invoice.OrderID, invoice.DueAt.Format("02/01/2006"),
+				html.EscapeString(formatEuros(invoice.AmountDueCents)), html.EscapeString(formatEuros(invoice.PaidCents)), html.EscapeString(formatEuros(invoice.OutstandingCents())), status))
+		}
+		builder.WriteString(`</tbody></table>`)
+	}
+	builder.WriteString(`</div>`)
+
+	writeAdminPageEnd(&builder)
+	w.Write([]byte(builder.String()))
+}
@@ -0,0 +1,483 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Tailles de page proposées sur les listes admin
+var adminPageSizes = []int{25, 50, 100, 200}
+
+const adminDefaultPageSize = 25
+
+// AdminListQuery décrit la page demandée d'une liste admin. Recherche, filtres, tri et pagination
+// sont appliqués en SQL : seule la page affichée est chargée
+type AdminListQuery struct {
+	Page     int
+	PageSize int
+	// Search contient des mots qui doivent tous se retrouver dans l'une des colonnes de recherche
+	Search  string
+	Status  string
+	Produit string
+	// Action filtre le journal d'audit sur un type d'action
+	Action string
+	// From et To bornent la date de création, jours inclus ; zéro si non renseignés
+	From time.Time
+	To   time.Time
+	// Sort est une clé de la liste blanche des colonnes triables de la liste
+	Sort string
+	Desc bool
+}
+
+// parseAdminListQuery lit la requête ; un tri hors de sortColumns est remplacé par defaultSort (décroissant)
+func parseAdminListQuery(values url.Values, sortColumns map[string]string, defaultSort string) AdminListQuery {
+	query := AdminListQuery{
+		Page:     1,
+		PageSize: adminDefaultPageSize,
+		Search:   strings.TrimSpace(values.Get("q")),
+		Status:   values.Get("status"),
+		Produit:  strings.TrimSpace(values.Get("produit")),
+		Action:   values.Get("action"),
+		Sort:     values.Get("sort"),
+		Desc:     values.Get("dir") != "asc",
+	}
+	if page, err := strconv.Atoi(values.Get("page")); err == nil && page > 1 {
+		query.Page = page
+	}
+	if size, err := strconv.Atoi(values.Get("size")); err == nil {
+		for _, allowed := range adminPageSizes {
+			if size == allowed {
+				query.PageSize = size
+			}
+		}
+	}
+	if from, err := time.ParseInLocation("2006-01-02", values.Get("from"), time.Local); err == nil {
+		query.From = from
+	}
+	if to, err := time.ParseInLocation("2006-01-02", values.Get("to"), time.Local); err == nil {
+		query.To = to
+	}
+	if _, ok := sortColumns[query.Sort]; !ok {
+		query.Sort = defaultSort
+	}
+	return query
+}
+
+// Offset renvoie le nombre de lignes à sauter pour atteindre la page
+func (q AdminListQuery) Offset() int {
+	return (q.Page - 1) * q.PageSize
+}
+
+// PageCount renvoie le nombre de pages pour total lignes
+func (q AdminListQuery) PageCount(total int) int {
+	if total <= 0 {
+		return 1
+	}
+	return (total + q.PageSize - 1) / q.PageSize
+}
+
+// direction renvoie le sens du tri tel que passé dans le paramètre dir
+func (q AdminListQuery) direction() string {
+	if q.Desc {
+		return "desc"
+	}
+	return "asc"
+}
+
+// values reconstruit les paramètres de la requête, pour les liens de tri et de pagination
+func (q AdminListQuery) values() url.Values {
+	values := url.Values{}
+	if q.Search != "" {
+		values.Set("q", q.Search)
+	}
+	if q.Status != "" {
+		values.Set("status", q.Status)
+	}
+	if q.Produit != "" {
+		values.Set("produit", q.Produit)
+	}
+	if q.Action != "" {
+		values.Set("action", q.Action)
+	}
+	if !q.From.IsZero() {
+		values.Set("from", q.From.Format("2006-01-02"))
+	}
+	if !q.To.IsZero() {
+		values.Set("to", q.To.Format("2006-01-02"))
+	}
+	values.Set("sort", q.Sort)
+	values.Set("dir", q.direction())
+	if q.PageSize != adminDefaultPageSize {
+		values.Set("size", strconv.Itoa(q.PageSize))
+	}
+	if q.Page > 1 {
+		values.Set("page", strconv.Itoa(q.Page))
+	}
+	return values
+}
+
+// pageURL renvoie le lien vers une autre page de la même recherche
+func (q AdminListQuery) pageURL(path string, page int) string {
+	q.Page = page
+	return path + "?" + q.values().Encode()
+}
+
+// sortURL renvoie le lien qui trie sur key : croissant, ou inversé si la liste est déjà triée sur key
+func (q AdminListQuery) sortURL(path, key string) string {
+	if q.Sort == key {
+		q.Desc = !q.Desc
+	} else {
+		q.Sort = key
+		q.Desc = false
+	}
+	q.Page = 1
+	return path + "?" + q.values().Encode()
+}
+
+// sqlConditions assemble une clause WHERE ; les clauses s'écrivent avec "?" et sont converties
+// en $1, $2… pour PostgreSQL par rebindQuery
+type sqlConditions struct {
+	clauses []string
+	args    []any
+}
+
+func (c *sqlConditions) add(clause string, args ...any) {
+	c.clauses = append(c.clauses, clause)
+	c.args = append(c.args, args...)
+}
+
+// addSearch exige que chaque mot de search apparaisse dans l'une des colonnes (sans tenir compte de la casse)
+func (c *sqlConditions) addSearch(search string, columns ...string) {
+	for _, word := range strings.Fields(strings.ToLower(search)) {
+		pattern := "%" + escapeLike(word) + "%"
+		matches := make([]string, 0, len(columns))
+		for _, column := range columns {
+			matches = append(matches, "LOWER("+column+") LIKE ?")
+			c.args = append(c.args, pattern)
+		}
+		c.clauses = append(c.clauses, "("+strings.Join(matches, " OR ")+")")
+	}
+}
+
+// addDateRange borne column entre from et to, jours inclus
+func (c *sqlConditions) addDateRange(column string, from, to time.Time) {
+	if !from.IsZero() {
+		c.add(column+" >= ?", from)
+	}
+	if !to.IsZero() {
+		c.add(column+" < ?", to.AddDate(0, 0, 1))
+	}
+}
+
+// addIDs restreint column aux identifiants ids (une sélection de lignes)
+func (c *sqlConditions) addIDs(column string, ids []int) {
+	placeholders := make([]string, len(ids))
+	for i, id := range ids {
+		placeholders[i] = "?"
+		c.args = append(c.args, id)
+	}
+	c.clauses = append(c.clauses, column+" IN ("+strings.Join(placeholders, ", ")+")")
+}
+
+func (c *sqlConditions) where() string {
+	if len(c.clauses) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(c.clauses, " AND ")
+}
+
+// escapeLike neutralise les jokers de LIKE dans une saisie ("\" est le caractère d'échappement par défaut
+// de MySQL comme de PostgreSQL)
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(value)
+}
+
+// orderByClause traduit le tri demandé, déjà validé par parseAdminListQuery ; l'id départage les égalités
+func orderByClause(query AdminListQuery, sortColumns map[string]string) string {
+	direction := strings.ToUpper(query.direction())
+	return fmt.Sprintf(" ORDER BY %s %s, id %s", sortColumns[query.Sort], direction, direction)
+}
+
+// adminSortHeader écrit l'en-tête d'une colonne triable, avec la flèche du tri en cours
+func adminSortHeader(builder *strings.Builder, query AdminListQuery, path, key, label string) {
+	arrow := ""
+	if query.Sort == key {
+		arrow = " ▲"
+		if query.Desc {
+			arrow = " ▼"
+		}
+	}
+	builder.WriteString(fmt.Sprintf(`<th><a href="%s">%s%s</a></th>`, html.EscapeString(query.sortURL(path, key)), html.EscapeString(label), arrow))
+}
+
+// writeAdminPagination écrit la navigation entre les pages d'une liste
+func writeAdminPagination(builder *strings.Builder, query AdminListQuery, path string, total int) {
+	pages := query.PageCount(total)
+	builder.WriteString(`<p class="small" style="display:flex;gap:12px;align-items:center;margin-top:12px">`)
+	if query.Page > 1 {
+		builder.WriteString(fmt.Sprintf(`<a href="%s">← Précédent</a>`, html.EscapeString(query.pageURL(path, query.Page-1))))
+	}
+	builder.WriteString(fmt.Sprintf(`<span>Page %d / %d • %d résultat(s)</span>`, query.Page, pages, total))
+	if query.Page < pages {
+		builder.WriteString(fmt.Sprintf(`<a href="%s">Suivant →</a>`, html.EscapeString(query.pageURL(path, query.Page+1))))
+	}
+	builder.WriteString(`</p>`)
+}
+
+// writeAdminPageSizeSelect écrit le sélecteur de taille de page d'un formulaire de recherche
+func writeAdminPageSizeSelect(builder *strings.Builder, query AdminListQuery) {
+	builder.WriteString(`<select name="size">`)
+	for _, size := range adminPageSizes {
+		selected := ""
+		if size == query.PageSize {
+			selected = " selected"
+		}
+		builder.WriteString(fmt.Sprintf(`<option value="%d"%s>%d par page</option>`, size, selected, size))
+	}
+	builder.WriteString(`</select>`)
+}
+
+func formatDateInput(value time.Time) string {
+	if value.IsZero() {
+		return ""
+	}
+	return value.Format("2006-01-02")
+}
+
+// adminUserConditions traduit la recherche et les filtres de la liste des utilisateurs
+func adminUserConditions(query AdminListQuery) sqlConditions {
+	var conditions sqlConditions
+	conditions.add("deleted_at IS NULL")
+	conditions.addSearch(query.Search, "nom", "prenom", "email")
+	conditions.addDateRange("created_at", query.From, query.To)
+	return conditions
+}
+
+// adminQuoteConditions traduit la recherche et les filtres de la liste des devis, hors corbeille
+func adminQuoteConditions(query AdminListQuery) sqlConditions {
+	conditions := adminOrderConditions(query)
+	conditions.add("deleted_at IS NULL")
+	return conditions
+}
+
+// adminOrderConditions traduit les mêmes filtres pour les commandes, qui reprennent les colonnes
+// du devis (nom, email, produit, référence, statut)
+func adminOrderConditions(query AdminListQuery) sqlConditions {
+	var conditions sqlConditions
+	conditions.addSearch(query.Search, "nom", "prenom", "email", "produit", "reference")
+	if query.Status != "" {
+		conditions.add("status = ?", query.Status)
+	}
+	if query.Produit != "" {
+		conditions.add("produit = ?", query.Produit)
+	}
+	conditions.addDateRange("created_at", query.From, query.To)
+	return conditions
+}
+
+// Colonnes triables des listes admin : clé du paramètre sort -> colonne SQL
+var adminUserSortColumns = map[string]string{
+	"id":         "id",
+	"email":      "email",
+	"nom":        "nom",
+	"prenom":     "prenom",
+	"created_at": "created_at",
+}
+
+var adminQuoteSortColumns = map[string]string{
+	"reference":  "reference",
+	"nom":        "nom",
+	"email":      "email",
+	"produit":    "produit",
+	"status":     "status",
+	"assigned":   "assigned_to",
+	"created_at": "created_at",
+}
+
+func adminUsersHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdminAuth(w, r) {
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := parseAdminListQuery(r.URL.Query(), adminUserSortColumns, "created_at")
+	users, total, err := listAdminUsers(query)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Erreur récupération utilisateurs (admin)", "err", err)
+		renderAdminErrorPage(w, "Erreur récupération utilisateurs", err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	var builder strings.Builder
+	writeAdminPageStart(&builder, "Utilisateurs - Admin Modul-space")
+	builder.WriteString(fmt.Sprintf(`<div class="card"><h1>Utilisateurs</h1><p class="meta">%d utilisateur(s)</p><a href="/admin">← Retour au dashboard</a></div>`, total))
+
+	builder.WriteString(`<div class="card"><form method="GET" action="/admin/users" style="display:flex;gap:8px;flex-wrap:wrap;align-items:center">`)
+	builder.WriteString(fmt.Sprintf(`<input type="search" name="q" value="%s" placeholder="Nom, prénom ou email" style="flex:1;min-width:220px">`, html.EscapeString(query.Search)))
+	builder.WriteString(fmt.Sprintf(`<label class="small">Inscrit du <input type="date" name="from" value="%s"></label> <label class="small">au <input type="date" name="to" value="%s"></label>`, formatDateInput(query.From), formatDateInput(query.To)))
+	writeAdminPageSizeSelect(&builder, query)
+	builder.WriteString(fmt.Sprintf(`<input type="hidden" name="sort" value="%s"><input type="hidden" name="dir" value="%s">`, html.EscapeString(query.Sort), query.direction()))
+	builder.WriteString(`<button type="submit" class="btn-secondary">Filtrer</button> <a href="/admin/users">Réinitialiser</a></form></div>`)
+
+	builder.WriteString(`<div class="card">`)
+	writeAdminExportForm(&builder, "users", query, false)
+	writeAdminUsersTable(&builder, users, &query, "/admin/users")
+	writeAdminPagination(&builder, query, "/admin/users", total)
+	builder.WriteString(`</div>`)
+	writeAdminPageEnd(&builder)
+
+	w.Write([]byte(builder.String()))
+}
+
+func adminQuotesHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdminAuth(w, r) {
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := parseAdminListQuery(r.URL.Query(), adminQuoteSortColumns, "created_at")
+	if query.Status != "" && !isValidQuoteStatus(query.Status) {
+		query.Status = ""
+	}
+	quotes, total, err := listAdminQuotes(query)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Erreur récupération devis (admin)", "err", err)
+		renderAdminErrorPage(w, "Erreur récupération devis", err.Error(), http.StatusInternalServerError)
+		return
+	}
+	products, err := listQuoteProducts()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Erreur récupération produits des devis (admin)", "err", err)
+		renderAdminErrorPage(w, "Erreur récupération devis", err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	var builder strings.Builder
+	writeAdminPageStart(&builder, "Devis - Admin Modul-space")
+	builder.WriteString(fmt.Sprintf(`<div class="card"><h1>Demandes de devis</h1><p class="meta">%d devis</p><a href="/admin">← Retour au dashboard</a></div>`, total))
+
+	builder.WriteString(`<div class="card"><form method="GET" action="/admin/quotes" style="display:flex;gap:8px;flex-wrap:wrap;align-items:center">`)
+	builder.WriteString(fmt.Sprintf(`<input type="search" name="q" value="%s" placeholder="Nom, email, produit ou référence" style="flex:1;min-width:220px">`, html.EscapeString(query.Search)))
+	builder.WriteString(`<select name="status"><option value="">Tous les statuts</option>`)
+	for _, status := range quoteStatuses {
+		selected := ""
+		if status == query.Status {
+			selected = " selected"
+		}
+		builder.WriteString(fmt.Sprintf(`<option value="%s"%s>%s</option>`, status, selected, html.EscapeString(quoteStatusLabel(status))))
+	}
+	builder.WriteString(`</select><select name="produit"><option value="">Tous les produits</option>`)
+	for _, product := range products {
+		selected := ""
+		if product == query.Produit {
+			selected = " selected"
+		}
+		builder.WriteString(fmt.Sprintf(`<option value="%s"%s>%s</option>`, html.EscapeString(product), selected, html.EscapeString(product)))
+	}
+	builder.WriteString(`</select>`)
+	builder.WriteString(fmt.Sprintf(`<label class="small">Du <input type="date" name="from" value="%s"></label> <label class="small">au <input type="date" name="to" value="%s"></label>`, formatDateInput(query.From), formatDateInput(query.To)))
+	writeAdminPageSizeSelect(&builder, query)
+	builder.WriteString(fmt.Sprintf(`<input type="hidden" name="sort" value="%s"><input type="hidden" name="dir" value="%s">`, html.EscapeString(query.Sort), query.direction()))
+	builder.WriteString(`<button type="submit" class="btn-secondary">Filtrer</button> <a href="/admin/quotes">Réinitialiser</a></form></div>`)
+
+	builder.WriteString(`<div class="card">`)
+	writeAdminExportForm(&builder, "quotes", query, false)
+	writeAdminQuotesTable(&builder, quotes, &query, "/admin/quotes")
+	writeAdminPagination(&builder, query, "/admin/quotes", total)
+	builder.WriteString(`</div>`)
+	writeAdminPageEnd(&builder)
+
+	w.Write([]byte(builder.String()))
+}
+
+// adminListReturnPath renvoie la page d'une liste avec ses filtres, ou le dashboard pour les tableaux sans query
+func adminListReturnPath(query *AdminListQuery, path string) string {
+	if query == nil {
+		return "/admin"
+	}
+	return path + "?" + query.values().Encode()
+}
+
+// writeAdminUsersTable écrit le tableau des utilisateurs ; avec query, les en-têtes permettent le tri
+func writeAdminUsersTable(builder *strings.Builder, users []AdminUserEntry, query *AdminListQuery, path string) {
+	writeAdminBulkForm(builder, "users", adminListReturnPath(query, path))
+	builder.WriteString(`<table><thead><tr>`)
+	writeAdminBulkSelectAll(builder, "users")
+	for _, column := range [][2]string{{"id", "ID"}, {"email", "Email"}, {"nom", "Nom"}, {"prenom", "Prénom"}, {"created_at", "Créé le"}} {
+		if query != nil {
+			adminSortHeader(builder, *query, path, column[0], column[1])
+		} else {
+			builder.WriteString(`<th>` + html.EscapeString(column[1]) + `</th>`)
+		}
+	}
+	builder.WriteString(`<th>Action</th></tr></thead><tbody>`)
+	for _, user := range users {
+		builder.WriteString(`<tr>`)
+		writeAdminBulkCheckbox(builder, "users", user.ID)
+		builder.WriteString(fmt.Sprintf(`<td>%d</td>`, user.ID))
+		builder.WriteString(fmt.Sprintf(`<td>%s</td>`, html.EscapeString(user.Email)))
+		builder.WriteString(fmt.Sprintf(`<td>%s</td>`, html.EscapeString(user.Nom)))
+		builder.WriteString(fmt.Sprintf(`<td>%s</td>`, html.EscapeString(user.Prenom)))
+		builder.WriteString(fmt.Sprintf(`<td>%s</td>`, html.EscapeString(user.CreatedAt)))
+		builder.WriteString(fmt.Sprintf(`<td><form method="POST" action="/admin/users/%d/impersonate" class="inline-form" onsubmit="return confirm('Voir le site avec le compte de ce client, en lecture seule ? Ce mode est journalisé.');"><button type="submit" class="btn-secondary">Voir comme le client</button></form> `, user.ID))
+		builder.WriteString(fmt.Sprintf(`<form method="POST" action="/admin/delete-user" class="inline-form" onsubmit="return confirm('Mettre cet utilisateur et ses devis à la corbeille ?');"><input type="hidden" name="id" value="%d"><button type="submit">Supprimer</button></form></td>`, user.ID))
+		builder.WriteString(`</tr>`)
+	}
+	if len(users) == 0 {
+		builder.WriteString(`<tr><td colspan="7" class="small">Aucun utilisateur</td></tr>`)
+	}
+	builder.WriteString(`</tbody></table>`)
+}
+
+// writeAdminQuotesTable écrit le tableau des devis ; avec query, les en-têtes permettent le tri
+func writeAdminQuotesTable(builder *strings.Builder, quotes []AdminQuoteEntry, query *AdminListQuery, path string) {
+	writeAdminBulkForm(builder, "quotes", adminListReturnPath(query, path))
+	builder.WriteString(`<table><thead><tr>`)
+	writeAdminBulkSelectAll(builder, "quotes")
+	for _, column := range [][2]string{{"reference", "Référence"}, {"nom", "Client"}, {"email", "Email"}, {"", "Téléphone"}, {"produit", "Produit"}, {"", "Message"}, {"status", "Statut"}, {"assigned", "Assigné à"}, {"created_at", "Créé le"}} {
+		if query != nil && column[0] != "" {
+			adminSortHeader(builder, *query, path, column[0], column[1])
+		} else {
+			builder.WriteString(`<th>` + html.EscapeString(column[1]) + `</th>`)
+		}
+	}
+	builder.WriteString(`</tr></thead><tbody>`)
+	for _, quote := range quotes {
+		builder.WriteString(`<tr>`)
+		writeAdminBulkCheckbox(builder, "quotes", quote.ID)
+		reference := quote.Reference
+		if reference == "" {
+			reference = strconv.Itoa(quote.ID)
+		}
+		builder.WriteString(fmt.Sprintf(`<td><a href="/admin/quotes/%d">%s</a></td>`, quote.ID, html.EscapeString(reference)))
+		builder.WriteString(fmt.Sprintf(`<td>%s</td>`, html.EscapeString(strings.TrimSpace(quote.Prenom+" "+quote.Nom))))
+		builder.WriteString(fmt.Sprintf(`<td>%s</td>`, html.EscapeString(quote.Email)))
+		builder.WriteString(fmt.Sprintf(`<td>%s</td>`, html.EscapeString(quote.Telephone)))
+		builder.WriteString(fmt.Sprintf(`<td>%s</td>`, html.EscapeString(quote.Produit)))
+		builder.WriteString(fmt.Sprintf(`<td>%s</td>`, html.EscapeString(textExcerpt(quote.Message, 80))))
+		builder.WriteString(fmt.Sprintf(`<td>%s</td>`, html.EscapeString(quoteStatusLabel(quote.Status))))
+		builder.WriteString(fmt.Sprintf(`<td>%s</td>`, html.EscapeString(quote.AssignedTo)))
+		builder.WriteString(fmt.Sprintf(`<td>%s</td>`, html.EscapeString(quote.CreatedAt)))
+		builder.WriteString(`</tr>`)
+	}
+	if len(quotes) == 0 {
+		builder.WriteString(`<tr><td colspan="10" class="small">Aucune demande de devis</td></tr>`)
+	}
+	builder.WriteString(`</tbody></table>`)
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// loadAdminOrder charge la commande de la route /admin/orders/{id} ou écrit la réponse d'erreur
+func loadAdminOrder(w http.ResponseWriter, r *http.Request) (*Order, bool) {
+	orderID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || orderID <= 0 {
+		http.Error(w, "ID commande invalide", http.StatusBadRequest)
+		return nil, false
+	}
+
+	order, err := GetOrderByID(orderID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Erreur récupération commande (admin)", "order_id", orderID, "err", err)
+		renderAdminErrorPage(w, "Erreur récupération commande", err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	if order == nil {
+		http.NotFound(w, r)
+		return nil, false
+	}
+	return order, true
+}
+
+func adminConvertQuoteHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdminAuth(w, r) {
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	quote, ok := loadAdminQuote(w, r)
+	if !ok {
+		return
+	}
+
+	orderID, err := convertQuoteToOrder(quote.ID)
+	if errors.Is(err, errQuoteNotAccepted) || errors.Is(err, errQuoteNotPriced) || errors.Is(err, errOrderExists) {
+		renderAdminErrorPage(w, "Conversion impossible", err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Erreur conversion devis en commande", "quote_id", quote.ID, "err", err)
+		renderAdminErrorPage(w, "Erreur conversion en commande", err.Error(), http.StatusInternalServerError)
+		return
+	}
+	auditAdminAction(r, AuditActionQuoteConvert, fmt.Sprintf("quote:%d", quote.ID), auditCreated(map[string]any{"order_id": orderID}))
+
+	http.Redirect(w, r, fmt.Sprintf("/admin/orders/%d", orderID), http.StatusSeeOther)
+}
+
+func adminOrderHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdminAuth(w, r) {
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	order, ok := loadAdminOrder(w, r)
+	if !ok {
+		return
+	}
+
+	lines, err := listOrderLines(order.ID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Erreur récupération lignes commande", "order_id", order.ID, "err", err)
+		renderAdminErrorPage(w, "Erreur récupération lignes de commande", err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	invoices, err := listInvoicesForOrder(order.ID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Erreur récupération factures commande", "order_id", order.ID, "err", err)
+		renderAdminErrorPage(w, "Erreur récupération factures", err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	var builder strings.Builder
+	writeAdminPageStart(&builder, fmt.Sprintf("Commande %s - Admin Modul-space", order.Reference))
+
+	builder.WriteString(fmt.Sprintf(`<div class="card"><h1>Commande %s</h1><p class="meta">%s • %s %s • %s</p><a href="/admin">← Retour au dashboard</a> • <a href="/admin/quotes/%d">Voir le devis</a></div>`,
+		html.EscapeString(order.Reference), html.EscapeString(order.Produit), html.EscapeString(order.Prenom), html.EscapeString(order.Nom), html.EscapeString(order.Email), order.QuoteID))
+
+	builder.WriteString(`<div class="card"><h2>Suivi</h2><table><thead><tr><th>Étape</th><th>Prévu</th><th>Réalisé</th></tr></thead><tbody>`)
+	formatDate := func(t *time.Time) string {
+		if t == nil || t.IsZero() {
+			return "-"
+		}
+		return t.Format("02/01/2006")
+	}
+	builder.WriteString(fmt.Sprintf(`<tr><td>%s</td><td>-</td><td>%s</td></tr>`, orderStatusLabel(OrderStatusManufacturing), formatDate(&order.CreatedAt)))
+	builder.WriteString(fmt.Sprintf(`<tr><td>%s</td><td>%s</td><td>%s</td></tr>`, orderStatusLabel(OrderStatusReady), formatDate(&order.ExpectedReadyAt), formatDate(order.ReadyAt)))
+	builder.WriteString(fmt.Sprintf(`<tr><td>%s</td><td>-</td><td>%s</td></tr>`, orderStatusLabel(OrderStatusShipped), formatDate(order.ShippedAt)))
+	builder.WriteString(fmt.Sprintf(`<tr><td>%s</td><td>%s</td><td>%s</td></tr>`, orderStatusLabel(OrderStatusDelivered), formatDate(&order.ExpectedDeliveryAt), formatDate(order.DeliveredAt)))
+	builder.WriteString(`</tbody></table><p>`)
+	builder.WriteString(fmt.Sprintf(`Statut actuel : <strong>%s</strong> `, html.EscapeString(orderStatusLabel(order.Status))))
+	if next := nextOrderStatus(order.Status); next != "" {
+		builder.WriteString(fmt.Sprintf(`<form method="POST" action="/admin/orders/%d/advance" class="inline-form"><button type="submit" class="btn-secondary">Passer à « %s »</button></form>`, order.ID, html.EscapeString(orderStatusLabel(next))))
+	}
+	builder.WriteString(`</p>`)
+	if order.Status != OrderStatusDelivered {
+		builder.WriteString(fmt.Sprintf(`<form method="POST" action="/admin/orders/%d/delivery-date">Livraison prévue : <input type="date" name="expected_delivery" value="%s"> <button type="submit" class="btn-secondary">Modifier</button></form>`,
+			order.ID, order.ExpectedDeliveryAt.Format("2006-01-02")))
+	}
+	builder.WriteString(`</div>`)
+
+	builder.WriteString(`<div class="card"><h2>Lignes commandées</h2><table><thead><tr><th>Désignation</th><th>Qté</th><th>PU HT</th><th>TVA</th><th>Total HT</th></tr></thead><tbody>`)
+	for _, line := range lines {
+		builder.WriteString(fmt.Sprintf(`<tr><td>%s</td><td>%d</td><td>%s</td><td>%s</td><td>%s</td></tr>`,
+			html.EscapeString(line.Label), line.Quantity, html.EscapeString(formatEuros(line.UnitPriceCents)), html.EscapeString(formatVATRate(line.VATRateBP)), html.EscapeString(formatEuros(line.TotalCents()))))
+	}
+	builder.WriteString(fmt.Sprintf(`<tr class="totals"><td colspan="4">Total HT</td><td>%s</td></tr>`, html.EscapeString(formatEuros(order.TotalHTCents))))
+	builder.WriteString(fmt.Sprintf(`<tr><td colspan="4">TVA</td><td>%s</td></tr>`, html.EscapeString(formatEuros(order.TotalVATCents))))
+	builder.WriteString(fmt.Sprintf(`<tr class="totals"><td colspan="4">Total TTC</td><td>%s</td></tr>`, html.EscapeString(formatEuros(order.TotalTTCCents))))
+	builder.WriteString(`</tbody></table></div>`)
+
+	writeOrderInvoicesCard(&builder, order, invoices)
+
+	writeAdminPageEnd(&builder)
+	w.Write([]byte(builder.String()))
+}
+
+func adminAdvanceOrderHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdminAuth(w, r) {
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	order, ok := loadAdminOrder(w, r)
+	if !ok {
+		return
+	}
+
+	status, err := advanceOrderStatus(order)
+	if errors.Is(err, errOrderStatusChanged) {
+		renderAdminErrorPage(w, "Commande déjà modifiée", err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Erreur avancement commande", "order_id", order.ID, "err", err)
+		renderAdminErrorPage(w, "Erreur mise à jour commande", err.Error(), http.StatusConflict)
+		return
+	}
+	auditAdminAction(r, AuditActionOrderAdvance, fmt.Sprintf("order:%d", order.ID), auditDiff(map[string]any{"status": order.Status}, map[string]any{"status": status}))
+
+	http.Redirect(w, r, fmt.Sprintf("/admin/orders/%d", order.ID), http.StatusSeeOther)
+}
+
+func adminOrderDeliveryDateHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdminAuth(w, r) {
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	order, ok := loadAdminOrder(w, r)
+	if !ok {
+		return
+	}
+
+	expected, err := time.ParseInLocation("2006-01-02", r.FormValue("expected_delivery"), documentLocation)
+	if err != nil {
+		http.Error(w, "Date invalide", http.StatusBadRequest)
+		return
+	}
+
+	if err := updateOrderExpectedDelivery(order.ID, expected); err != nil {
+		slog.ErrorContext(r.Context(), "Erreur mise à jour date de livraison commande", "order_id", order.ID, "err", err)
+		http.Error(w, "Erreur mise à jour commande", http.StatusInternalServerError)
+		return
+	}
+	auditAdminAction(r, AuditActionOrderDelivery, fmt.Sprintf("order:%d", order.ID),
+		auditDiff(map[string]any{"expected_delivery": order.ExpectedDeliveryAt.Format("2006-01-02")}, map[string]any{"expected_delivery": expected.Format("2006-01-02")}))
+
+	http.Redirect(w, r, fmt.Sprintf("/admin/orders/%d", order.ID), http.StatusSeeOther)
+}
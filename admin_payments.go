@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// paymentTargetLink renvoie le lien admin vers le devis ou la facture payé
+func paymentTargetLink(payment *Payment) string {
+	switch payment.TargetKind {
+	case PaymentTargetQuote:
+		return fmt.Sprintf(`<a href="/admin/quotes/%d">Devis #%d</a>`, payment.TargetID, payment.TargetID)
+	case PaymentTargetInvoice:
+		return fmt.Sprintf(`<a href="/admin/invoices/%d">Facture #%d</a>`, payment.TargetID, payment.TargetID)
+	}
+	return html.EscapeString(payment.TargetKind)
+}
+
+func adminPaymentsHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdminAuth(w, r) {
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	payments, err := listPayments()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Erreur récupération paiements (admin)", "err", err)
+		renderAdminErrorPage(w, "Erreur récupération paiements", err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	var builder strings.Builder
+	writeAdminPageStart(&builder, "Paiements en ligne - Admin Modul-space")
+
+	provider := "désactivé"
+	if paymentProvider != nil {
+		provider = paymentProvider.Name()
+	}
+	builder.WriteString(fmt.Sprintf(`<div class="card"><h1>Paiements en ligne</h1><p class="meta">Prestataire : %s • %d paiements</p><a href="/admin">← Retour au dashboard</a></div>`,
+		html.EscapeString(provider), len(payments)))
+
+	builder.WriteString(`<div class="card">`)
+	if len(payments) == 0 {
+		builder.WriteString(`<p class="small">Aucun paiement en ligne.</p>`)
+	} else {
+		builder.WriteString(`<table><thead><tr><th>Date</th><th>Objet</th><th>Montant</th><th>Prestataire</th><th>Session</th><th>Statut</th><th>Action</th></tr></thead><tbody>`)
+		for _, payment := range payments {
+			action := ""
+			if (payment.Status == PaymentStatusSucceeded || payment.Status == PaymentStatusOverpaid) && paymentProvider != nil && paymentProvider.Name() == payment.Provider {
+				action = fmt.Sprintf(`<form method="POST" action="/admin/payments/%d/refund" class="inline-form" onsubmit="return confirm('Rembourser %s ?')"><button type="submit">Rembourser</button></form>`,
+					payment.ID, html.EscapeString(formatEuros(payment.AmountCents)))
+			}
+			builder.WriteString(fmt.Sprintf(`<tr><td>%s</td><td>%s</td><td>%s</td><td>%s</td><td class="small">%s</td><td>%s</td><td>%s</td></tr>`,
+				payment.CreatedAt.Format("02/01/2006 15:04"), paymentTargetLink(payment), html.EscapeString(formatEuros(payment.AmountCents)),
+				html.EscapeString(payment.Provider), html.EscapeString(payment.SessionID), html.EscapeString(paymentStatusLabel(payment.Status)), action))
+		}
+		builder.WriteString(`</tbody></table>`)
+	}
+	builder.WriteString(`</div>`)
+
+	writeAdminPageEnd(&builder)
+	w.Write([]byte(builder.String()))
+}
+
+func adminRefundPaymentHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdminAuth(w, r) {
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	paymentID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || paymentID <= 0 {
+		http.Error(w, "ID paiement invalide", http.StatusBadRequest)
+		return
+	}
+
+	payment, err := GetPaymentByID(paymentID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Erreur récupération paiement", "payment_id", paymentID, "err", err)
+		renderAdminErrorPage(w, "Erreur récupération paiement", err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if payment == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	err = refundPayment(payment)
+	if errors.Is(err, errPaymentNotRefundable) || errors.Is(err, errPaymentsDisabled) || errors.Is(err, errPaymentOrderExists) {
+		renderAdminErrorPage(w, "Remboursement impossible", err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Erreur remboursement paiement", "payment_id", payment.ID, "err", err)
+		renderAdminErrorPage(w, "Erreur remboursement", err.Error(), http.StatusInternalServerError)
+		return
+	}
+	auditAdminAction(r, AuditActionPaymentRefund, fmt.Sprintf("payment:%d", payment.ID), map[string]any{
+		"amount":   formatEuros(payment.AmountCents),
+		"provider": payment.Provider,
+		"for":      fmt.Sprintf("%s:%d", payment.TargetKind, payment.TargetID),
+		"diff":     map[string]auditChange{"status": {From: payment.Status, To: PaymentStatusRefunded}},
+	})
+
+	http.Redirect(w, r, "/admin/payments", http.StatusSeeOther)
+}
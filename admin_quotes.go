@@ -0,0 +1,655 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// quoteIDFromPath lit l'identifiant {id} des routes /admin/quotes/{id}/...
+func quoteIDFromPath(r *http.Request) (int, bool) {
+	quoteID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || quoteID <= 0 {
+		return 0, false
+	}
+	return quoteID, true
+}
+
+// loadAdminQuote charge le devis de la route ou écrit la réponse d'erreur
+func loadAdminQuote(w http.ResponseWriter, r *http.Request) (*QuoteRecord, bool) {
+	quoteID, ok := quoteIDFromPath(r)
+	if !ok {
+		http.Error(w, "ID devis invalide", http.StatusBadRequest)
+		return nil, false
+	}
+
+	quote, err := GetQuoteByID(quoteID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Erreur récupération devis (admin)", "quote_id", quoteID, "err", err)
+		renderAdminErrorPage(w, "Erreur récupération devis", err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	if quote == nil {
+		http.NotFound(w, r)
+		return nil, false
+	}
+	return quote, true
+}
+
+func adminQuoteHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdminAuth(w, r) {
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	quote, ok := loadAdminQuote(w, r)
+	if !ok {
+		return
+	}
+
+	lines, err := listQuoteLines(quote.ID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Erreur récupération lignes devis (admin)", "quote_id", quote.ID, "err", err)
+		renderAdminErrorPage(w, "Erreur récupération lignes du devis", err.Error(), http.StatusInternalServerError)
+		return
+	}
+	totals := computeQuoteTotals(lines)
+
+	order, err := GetOrderByQuoteID(quote.ID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Erreur récupération commande du devis (admin)", "quote_id", quote.ID, "err", err)
+		renderAdminErrorPage(w, "Erreur récupération commande", err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	messages, err := listQuoteMessages(quote.ID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Erreur récupération messages du devis (admin)", "quote_id", quote.ID, "err", err)
+		renderAdminErrorPage(w, "Erreur récupération conversation", err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	attachments, err := listQuoteAttachments(quote.ID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Erreur récupération fichiers du devis (admin)", "quote_id", quote.ID, "err", err)
+		renderAdminErrorPage(w, "Erreur récupération fichiers", err.Error(), http.StatusInternalServerError)
+		return
+	}
+	quoteAttachments := splitAttachments(attachments, messages)
+
+	notes, err := listQuoteNotes(quote.ID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Erreur récupération notes du devis (admin)", "quote_id", quote.ID, "err", err)
+		renderAdminErrorPage(w, "Erreur récupération notes", err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Historique du client : ses autres devis et ses commandes, rattachés au compte ou à l'email
+	customer := &User{ID: quote.UserID, Email: quote.Email}
+	customerQuotes, err := listQuotesForUser(customer)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Erreur récupération historique devis du devis (admin)", "quote_id", quote.ID, "err", err)
+		renderAdminErrorPage(w, "Erreur récupération historique client", err.Error(), http.StatusInternalServerError)
+		return
+	}
+	customerOrders, err := listOrdersForUser(customer)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Erreur récupération historique commandes du devis (admin)", "quote_id", quote.ID, "err", err)
+		renderAdminErrorPage(w, "Erreur récupération historique client", err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	var builder strings.Builder
+	writeAdminPageStart(&builder, fmt.Sprintf("Devis %s - Admin Modul-space", quoteNumber(quote)))
+
+	assignee := "non attribué"
+	if quote.AssignedTo != "" {
+		assignee = "suivi par " + quote.AssignedTo
+	}
+	builder.WriteString(fmt.Sprintf(`<div class="card"><h1>Devis %s</h1><p class="meta">%s • %s • créé le %s • %s</p>`,
+		html.EscapeString(quoteNumber(quote)), html.EscapeString(quote.Produit), html.EscapeString(quoteStatusLabel(quote.Status)), quote.CreatedAt.Format("2006-01-02 15:04"), html.EscapeString(assignee)))
+	builder.WriteString(fmt.Sprintf(`<form method="POST" action="/admin/quotes/%d/assign" class="inline-form"><select name="assigned_to"><option value="">Personne</option>`, quote.ID))
+	for _, staff := range adminStaff() {
+		selected := ""
+		if staff == quote.AssignedTo {
+			selected = " selected"
+		}
+		builder.WriteString(fmt.Sprintf(`<option value="%s"%s>%s</option>`, html.EscapeString(staff), selected, html.EscapeString(staff)))
+	}
+	builder.WriteString(`</select> <button type="submit" class="btn-secondary">Attribuer</button></form> <a href="/admin">← Retour au dashboard</a></div>`)
+
+	builder.WriteString(`<div class="card"><h2>Demande</h2><table><tbody>`)
+	for _, row := range [][2]string{
+		{"Nom", quote.Nom},
+		{"Prénom", quote.Prenom},
+		{"Email", quote.Email},
+		{"Téléphone", quote.Telephone},
+		{"Produit", quote.Produit},
+		{"Configuration", quote.Configuration},
+		{"Message", quote.Message},
+	} {
+		builder.WriteString(fmt.Sprintf(`<tr><th>%s</th><td style="white-space:pre-wrap">%s</td></tr>`, html.EscapeString(row[0]), html.EscapeString(row[1])))
+	}
+	if len(quoteAttachments) > 0 {
+		builder.WriteString(`<tr><th>Fichiers</th><td>`)
+		writeAdminAttachmentLinks(&builder, quoteAttachments)
+		builder.WriteString(`</td></tr>`)
+	}
+	builder.WriteString(`</tbody></table></div>`)
+
+	builder.WriteString(`<div class="card"><h2>Historique du client</h2>`)
+	if quote.UserID == 0 {
+		builder.WriteString(`<p class="small">Demande sans compte client : historique retrouvé par l'adresse email.</p>`)
+	}
+	builder.WriteString(`<table><thead><tr><th>Document</th><th>Produit</th><th>Statut</th><th>Date</th></tr></thead><tbody>`)
+	history := 0
+	for _, other := range customerQuotes {
+		if other.ID == quote.ID {
+			continue
+		}
+		history++
+		builder.WriteString(fmt.Sprintf(`<tr><td><a href="/admin/quotes/%d">Devis %s</a></td><td>%s</td><td>%s</td><td>%s</td></tr>`,
+			other.ID, html.EscapeString(quoteNumber(other)), html.EscapeString(other.Produit), html.EscapeString(quoteStatusLabel(other.Status)), other.CreatedAt.Format("2006-01-02")))
+	}
+	for _, customerOrder := range customerOrders {
+		history++
+		builder.WriteString(fmt.Sprintf(`<tr><td><a href="/admin/orders/%d">Commande %s</a></td><td>%s</td><td>%s</td><td>%s</td></tr>`,
+			customerOrder.ID, html.EscapeString(customerOrder.Reference), html.EscapeString(customerOrder.Produit), html.EscapeString(orderStatusLabel(customerOrder.Status)), customerOrder.CreatedAt.Format("2006-01-02")))
+	}
+	if history == 0 {
+		builder.WriteString(`<tr><td colspan="4" class="small">Première demande de ce client</td></tr>`)
+	}
+	builder.WriteString(`</tbody></table></div>`)
+
+	// Chaque ligne est éditable : ses champs sont rattachés à son formulaire par l'attribut form
+	builder.WriteString(`<div class="card"><h2>Chiffrage</h2><table><thead><tr><th>Désignation</th><th>Qté</th><th>PU HT (€)</th><th>TVA (%)</th><th>Total HT</th><th>Action</th></tr></thead><tbody>`)
+	for _, line := range lines {
+		form := fmt.Sprintf("line-%d", line.ID)
+		builder.WriteString(`<tr>`)
+		builder.WriteString(fmt.Sprintf(`<td><input type="text" name="label" value="%s" required form="%s" style="width:100%%;box-sizing:border-box"></td>`, html.EscapeString(line.Label), form))
+		builder.WriteString(fmt.Sprintf(`<td><input type="number" name="quantity" value="%d" min="1" form="%s" style="width:60px"></td>`, line.Quantity, form))
+		builder.WriteString(fmt.Sprintf(`<td><input type="text" name="unit_price" value="%s" required form="%s" style="width:100px"></td>`, html.EscapeString(formatEuros(line.UnitPriceCents)), form))
+		builder.WriteString(fmt.Sprintf(`<td><input type="text" name="vat_rate" value="%s" form="%s" style="width:60px"></td>`, html.EscapeString(formatVATRate(line.VATRateBP)), form))
+		builder.WriteString(fmt.Sprintf(`<td>%s</td>`, html.EscapeString(formatEuros(line.TotalCents()))))
+		builder.WriteString(fmt.Sprintf(`<td><form id="%s" method="POST" action="/admin/quotes/%d/lines/update" class="inline-form"><input type="hidden" name="line_id" value="%d"><button type="submit" class="btn-secondary">Enregistrer</button></form> `, form, quote.ID, line.ID))
+		builder.WriteString(fmt.Sprintf(`<form method="POST" action="/admin/quotes/%d/lines/delete" class="inline-form" onsubmit="return confirm('Supprimer cette ligne ?');"><input type="hidden" name="line_id" value="%d"><button type="submit">Supprimer</button></form></td>`, quote.ID, line.ID))
+		builder.WriteString(`</tr>`)
+	}
+	if len(lines) == 0 {
+		builder.WriteString(`<tr><td colspan="6" class="small">Aucune ligne chiffrée</td></tr>`)
+	}
+	builder.WriteString(fmt.Sprintf(`<tr class="totals"><td colspan="4">Total HT</td><td colspan="2">%s</td></tr>`, html.EscapeString(formatEuros(totals.TotalHTCents))))
+	for _, vat := range totals.VAT {
+		builder.WriteString(fmt.Sprintf(`<tr><td colspan="4">TVA %s</td><td colspan="2">%s</td></tr>`, html.EscapeString(formatVATRate(vat.RateBP)), html.EscapeString(formatEuros(vat.VATCents))))
+	}
+	builder.WriteString(fmt.Sprintf(`<tr class="totals"><td colspan="4">Total TTC</td><td colspan="2">%s</td></tr>`, html.EscapeString(formatEuros(totals.TotalTTCCents))))
+	builder.WriteString(`</tbody></table>`)
+	builder.WriteString(fmt.Sprintf(`<form method="POST" action="/admin/quotes/%d/lines" style="margin-top:12px;display:flex;gap:8px;flex-wrap:wrap">
+		<input type="text" name="label" placeholder="Désignation" value="%s" required style="flex:1;min-width:220px">
+		<input type="number" name="quantity" value="1" min="1" style="width:70px">
+		<input type="text" name="unit_price" placeholder="PU HT (€)" required style="width:110px">
+		<input type="text" name="vat_rate" value="20" style="width:60px" title="TVA (%%)">
+		<button type="submit" class="btn-secondary">Ajouter la ligne</button></form></div>`, quote.ID, html.EscapeString(quote.Produit)))
+
+	builder.WriteString(`<div class="card"><h2>Actions</h2>`)
+	if len(lines) > 0 {
+		builder.WriteString(fmt.Sprintf(`<form method="POST" action="/admin/quotes/%d/status" class="inline-form" onsubmit="return confirm('Envoyer le devis de %s au client ?');"><input type="hidden" name="status" value="%s"><button type="submit" class="btn-secondary">Envoyer le devis au client</button></form> `,
+			quote.ID, html.EscapeString(formatEuros(totals.TotalTTCCents)), QuoteStatusSent))
+	} else {
+		builder.WriteString(`<span class="small">Ajoutez au moins une ligne pour envoyer le devis.</span> `)
+	}
+	builder.WriteString(fmt.Sprintf(`<form method="POST" action="/admin/quotes/%d/status" class="inline-form" onsubmit="return confirm('Marquer ce devis comme accepté ?');"><input type="hidden" name="status" value="%s"><button type="submit" class="btn-secondary">Marquer accepté</button></form>`, quote.ID, QuoteStatusAccepted))
+	builder.WriteString(fmt.Sprintf(`<form method="POST" action="/admin/quotes/%d/status" style="margin-top:12px;display:flex;gap:8px"><input type="hidden" name="status" value="%s"><input type="text" name="reason" required placeholder="Motif du refus, envoyé au client" style="flex:1"><button type="submit">Refuser</button></form>`, quote.ID, QuoteStatusRejected))
+	builder.WriteString(fmt.Sprintf(`<form method="POST" action="/admin/quotes/%d/status" style="margin-top:12px"><span class="small">Autre statut :</span> <select name="status">`, quote.ID))
+	for _, status := range []string{QuoteStatusPending, QuoteStatusInReview} {
+		selected := ""
+		if status == quote.Status {
+			selected = " selected"
+		}
+		builder.WriteString(fmt.Sprintf(`<option value="%s"%s>%s</option>`, status, selected, html.EscapeString(quoteStatusLabel(status))))
+	}
+	builder.WriteString(`</select> <button type="submit" class="btn-secondary">Mettre à jour</button></form>`)
+	if quote.Status == QuoteStatusRejected && quote.RejectionReason != "" {
+		builder.WriteString(fmt.Sprintf(`<p class="small">Motif du refus : %s</p>`, html.EscapeString(quote.RejectionReason)))
+	}
+	if len(lines) > 0 {
+		builder.WriteString(fmt.Sprintf(`<p><a href="/admin/quotes/%d/pdf">Télécharger le devis PDF</a></p>`, quote.ID))
+	}
+	if order != nil {
+		builder.WriteString(fmt.Sprintf(`<p>Commande <a href="/admin/orders/%d">%s</a> : %s</p>`, order.ID, html.EscapeString(order.Reference), html.EscapeString(orderStatusLabel(order.Status))))
+	} else if quote.Status == QuoteStatusAccepted && len(lines) > 0 {
+		builder.WriteString(fmt.Sprintf(`<form method="POST" action="/admin/quotes/%d/order" style="margin-top:12px"><button type="submit" class="btn-secondary">Convertir en commande</button></form>`, quote.ID))
+	}
+	if quote.UserID != 0 {
+		builder.WriteString(fmt.Sprintf(`<form method="POST" action="/admin/users/%d/impersonate" style="margin-top:12px"><input type="hidden" name="next" value="/mes-devis/%d"><button type="submit" class="btn-secondary">Voir ce devis comme le client</button> <span class="small">lecture seule, journalisé</span></form>`, quote.UserID, quote.ID))
+	}
+	if order == nil {
+		builder.WriteString(fmt.Sprintf(`<form method="POST" action="/admin/quotes/%d/delete" style="margin-top:12px" onsubmit="return confirm('Mettre ce devis à la corbeille ?');"><button type="submit">Mettre à la corbeille</button></form>`, quote.ID))
+	}
+	builder.WriteString(`</div>`)
+
+	builder.WriteString(`<div class="card" id="notes"><h2>Notes internes</h2><p class="small">Visibles de l'équipe uniquement, jamais envoyées au client.</p>`)
+	builder.WriteString(fmt.Sprintf(`<form method="POST" action="/admin/quotes/%d/notes"><textarea name="body" rows="3" required maxlength="%d" style="width:100%%;box-sizing:border-box" placeholder="Note interne"></textarea><p><select name="author">`, quote.ID, quoteNoteMaxLength))
+	for _, staff := range adminStaff() {
+		selected := ""
+		if staff == quote.AssignedTo {
+			selected = " selected"
+		}
+		builder.WriteString(fmt.Sprintf(`<option value="%s"%s>%s</option>`, html.EscapeString(staff), selected, html.EscapeString(staff)))
+	}
+	builder.WriteString(`</select> <button type="submit" class="btn-secondary">Ajouter la note</button></p></form>`)
+	for _, note := range notes {
+		builder.WriteString(fmt.Sprintf(`<div style="background:#fff8e1;padding:10px 14px;border-radius:8px;margin-bottom:8px"><p class="small" style="margin:0 0 4px">%s • %s</p><div style="white-space:pre-wrap">%s</div></div>`,
+			html.EscapeString(note.Author), note.CreatedAt.Format("02/01/2006 15:04"), html.EscapeString(note.Body)))
+	}
+	builder.WriteString(`</div>`)
+
+	builder.WriteString(`<div class="card" id="conversation"><h2>Conversation avec le client</h2>`)
+	if len(messages) == 0 {
+		builder.WriteString(`<p class="small">Aucun message.</p>`)
+	}
+	for _, message := range messages {
+		background := "#f6f6fb"
+		if message.FromStaff() {
+			background = "#e2e3f3"
+		}
+		source := ""
+		if message.Source == QuoteMessageSourceEmail {
+			source = " • par email"
+		}
+		builder.WriteString(fmt.Sprintf(`<div style="background:%s;padding:10px 14px;border-radius:8px;margin-bottom:8px"><p class="small" style="margin:0 0 4px">%s • %s%s</p><div style="white-space:pre-wrap">%s</div>`,
+			background, html.EscapeString(message.AuthorName), message.CreatedAt.Format("02/01/2006 15:04"), source, html.EscapeString(message.Body)))
+		writeAdminAttachmentLinks(&builder, message.Attachments)
+		builder.WriteString(`</div>`)
+	}
+	builder.WriteString(fmt.Sprintf(`<form method="POST" action="/admin/quotes/%d/messages" enctype="multipart/form-data"><textarea name="body" rows="3" maxlength="%d" style="width:100%%;box-sizing:border-box" placeholder="Message au client (envoyé par email)"></textarea><p><input type="file" name="attachments" multiple accept="image/jpeg,image/png,image/gif,image/webp,application/pdf"> <button type="submit" class="btn-secondary">Envoyer au client</button></p></form></div>`,
+		quote.ID, quoteMessageMaxLength))
+
+	writeAdminPageEnd(&builder)
+	w.Write([]byte(builder.String()))
+}
+
+// textExcerpt raccourcit un texte libre sur une seule ligne, pour les tableaux de l'admin
+func textExcerpt(text string, maxRunes int) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if runes := []rune(text); len(runes) > maxRunes {
+		return strings.TrimSpace(string(runes[:maxRunes])) + "…"
+	}
+	return text
+}
+
+// writeAdminAttachmentLinks liste des pièces jointes, servies par /attachments/{id}
+func writeAdminAttachmentLinks(builder *strings.Builder, attachments []Attachment) {
+	for _, attachment := range attachments {
+		builder.WriteString(fmt.Sprintf(`<div><a href="/attachments/%d" target="_blank" rel="noopener">%s</a> <span class="small">%s • %s</span></div>`,
+			attachment.ID, html.EscapeString(attachment.Filename), html.EscapeString(attachment.ContentType), html.EscapeString(attachment.SizeLabel())))
+	}
+}
+
+func adminQuoteLinesHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdminAuth(w, r) {
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	quote, ok := loadAdminQuote(w, r)
+	if !ok {
+		return
+	}
+
+	label := strings.TrimSpace(r.FormValue("label"))
+	quantity, err := strconv.Atoi(r.FormValue("quantity"))
+	if err != nil || quantity <= 0 {
+		http.Error(w, "Quantité invalide", http.StatusBadRequest)
+		return
+	}
+	unitPrice, err := parseEuros(r.FormValue("unit_price"))
+	if err != nil || label == "" {
+		http.Error(w, "Ligne de devis invalide", http.StatusBadRequest)
+		return
+	}
+	vatRate := defaultVATRateBP
+	if value := strings.TrimSpace(r.FormValue("vat_rate")); value != "" {
+		if vatRate, err = parseVATRate(value); err != nil {
+			http.Error(w, "Taux de TVA invalide", http.StatusBadRequest)
+			return
+		}
+	}
+
+	line := QuoteLine{QuoteID: quote.ID, Label: label, Quantity: quantity, UnitPriceCents: unitPrice, VATRateBP: vatRate}
+	if err := addQuoteLine(line); err != nil {
+		slog.ErrorContext(r.Context(), "Erreur ajout ligne devis", "quote_id", quote.ID, "err", err)
+		http.Error(w, "Erreur ajout ligne", http.StatusInternalServerError)
+		return
+	}
+	auditAdminAction(r, AuditActionQuoteLineAdd, fmt.Sprintf("quote:%d", quote.ID), auditCreated(quoteLineAuditSnapshot(line)))
+
+	http.Redirect(w, r, fmt.Sprintf("/admin/quotes/%d", quote.ID), http.StatusSeeOther)
+}
+
+func adminUpdateQuoteLineHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdminAuth(w, r) {
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	quote, ok := loadAdminQuote(w, r)
+	if !ok {
+		return
+	}
+
+	lineID, err := strconv.Atoi(r.FormValue("line_id"))
+	if err != nil || lineID <= 0 {
+		http.Error(w, "ID ligne invalide", http.StatusBadRequest)
+		return
+	}
+	label := strings.TrimSpace(r.FormValue("label"))
+	quantity, err := strconv.Atoi(r.FormValue("quantity"))
+	if err != nil || quantity <= 0 {
+		http.Error(w, "Quantité invalide", http.StatusBadRequest)
+		return
+	}
+	unitPrice, err := parseEuros(r.FormValue("unit_price"))
+	if err != nil || label == "" {
+		http.Error(w, "Ligne de devis invalide", http.StatusBadRequest)
+		return
+	}
+	vatRate := defaultVATRateBP
+	if value := r.FormValue("vat_rate"); value != "" {
+		if vatRate, err = parseVATRate(value); err != nil {
+			http.Error(w, "Taux de TVA invalide", http.StatusBadRequest)
+			return
+		}
+	}
+
+	before, err := findQuoteLine(quote.ID, lineID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Erreur récupération ligne du devis", "line_id", lineID, "quote_id", quote.ID, "err", err)
+		http.Error(w, "Erreur modification ligne", http.StatusInternalServerError)
+		return
+	}
+	if before == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	line := QuoteLine{ID: lineID, QuoteID: quote.ID, Label: label, Quantity: quantity, UnitPriceCents: unitPrice, VATRateBP: vatRate}
+	if err := updateQuoteLine(line); err != nil {
+		slog.ErrorContext(r.Context(), "Erreur modification ligne du devis", "line_id", lineID, "quote_id", quote.ID, "err", err)
+		http.Error(w, "Erreur modification ligne", http.StatusInternalServerError)
+		return
+	}
+	auditAdminAction(r, AuditActionQuoteLineUpdate, fmt.Sprintf("quote:%d", quote.ID), auditDiff(quoteLineAuditSnapshot(*before), quoteLineAuditSnapshot(line)))
+
+	http.Redirect(w, r, fmt.Sprintf("/admin/quotes/%d", quote.ID), http.StatusSeeOther)
+}
+
+func adminDeleteQuoteLineHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdminAuth(w, r) {
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	quote, ok := loadAdminQuote(w, r)
+	if !ok {
+		return
+	}
+
+	lineID, err := strconv.Atoi(r.FormValue("line_id"))
+	if err != nil || lineID <= 0 {
+		http.Error(w, "ID ligne invalide", http.StatusBadRequest)
+		return
+	}
+
+	line, err := findQuoteLine(quote.ID, lineID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Erreur récupération ligne du devis", "line_id", lineID, "quote_id", quote.ID, "err", err)
+		http.Error(w, "Erreur suppression ligne", http.StatusInternalServerError)
+		return
+	}
+	if line == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	if err := deleteQuoteLine(quote.ID, lineID); err != nil {
+		slog.ErrorContext(r.Context(), "Erreur suppression ligne du devis", "line_id", lineID, "quote_id", quote.ID, "err", err)
+		http.Error(w, "Erreur suppression ligne", http.StatusInternalServerError)
+		return
+	}
+	auditAdminAction(r, AuditActionQuoteLineDelete, fmt.Sprintf("quote:%d", quote.ID), auditDeleted(quoteLineAuditSnapshot(*line)))
+
+	http.Redirect(w, r, fmt.Sprintf("/admin/quotes/%d", quote.ID), http.StatusSeeOther)
+}
+
+func adminQuoteStatusHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdminAuth(w, r) {
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	quote, ok := loadAdminQuote(w, r)
+	if !ok {
+		return
+	}
+
+	status := r.FormValue("status")
+	if !isValidQuoteStatus(status) {
+		http.Error(w, "Statut invalide", http.StatusBadRequest)
+		return
+	}
+	reason := strings.TrimSpace(r.FormValue("reason"))
+	if status == QuoteStatusRejected && reason == "" {
+		http.Error(w, "Indiquez le motif du refus, il est envoyé au client", http.StatusBadRequest)
+		return
+	}
+	if status == QuoteStatusSent {
+		lines, err := listQuoteLines(quote.ID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Erreur récupération lignes devis", "quote_id", quote.ID, "err", err)
+			http.Error(w, "Erreur changement statut", http.StatusInternalServerError)
+			return
+		}
+		if len(lines) == 0 {
+			http.Error(w, "Ajoutez au moins une ligne chiffrée avant d'envoyer le devis", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := updateQuoteStatus(r.Context(), quote, status, reason); err != nil {
+		slog.ErrorContext(r.Context(), "Erreur changement statut devis", "quote_id", quote.ID, "err", err)
+		http.Error(w, "Erreur changement statut", http.StatusInternalServerError)
+		return
+	}
+	after := map[string]any{"status": status, "rejection_reason": quote.RejectionReason}
+	if status == QuoteStatusRejected {
+		after["rejection_reason"] = reason
+	}
+	auditAdminAction(r, AuditActionQuoteStatus, fmt.Sprintf("quote:%d", quote.ID), auditDiff(map[string]any{"status": quote.Status, "rejection_reason": quote.RejectionReason}, after))
+
+	http.Redirect(w, r, fmt.Sprintf("/admin/quotes/%d", quote.ID), http.StatusSeeOther)
+}
+
+func adminQuoteAssignHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdminAuth(w, r) {
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	quote, ok := loadAdminQuote(w, r)
+	if !ok {
+		return
+	}
+
+	staff := strings.TrimSpace(r.FormValue("assigned_to"))
+	if staff != "" && !isAdminStaff(staff) {
+		http.Error(w, "Membre de l'équipe inconnu (ADMIN_STAFF)", http.StatusBadRequest)
+		return
+	}
+
+	if err := assignQuote(quote.ID, staff); err != nil {
+		slog.ErrorContext(r.Context(), "Erreur attribution devis", "quote_id", quote.ID, "err", err)
+		http.Error(w, "Erreur attribution du devis", http.StatusInternalServerError)
+		return
+	}
+	auditAdminAction(r, AuditActionQuoteAssign, fmt.Sprintf("quote:%d", quote.ID), auditDiff(map[string]any{"assigned_to": quote.AssignedTo}, map[string]any{"assigned_to": staff}))
+
+	http.Redirect(w, r, fmt.Sprintf("/admin/quotes/%d", quote.ID), http.StatusSeeOther)
+}
+
+func adminQuoteNoteHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdminAuth(w, r) {
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	quote, ok := loadAdminQuote(w, r)
+	if !ok {
+		return
+	}
+
+	body := strings.TrimSpace(r.FormValue("body"))
+	if body == "" || len(body) > quoteNoteMaxLength {
+		http.Error(w, fmt.Sprintf("La note doit contenir entre 1 et %d caractères", quoteNoteMaxLength), http.StatusBadRequest)
+		return
+	}
+	author := r.FormValue("author")
+	if !isAdminStaff(author) {
+		http.Error(w, "Membre de l'équipe inconnu (ADMIN_STAFF)", http.StatusBadRequest)
+		return
+	}
+
+	if err := addQuoteNote(quote.ID, author, body); err != nil {
+		slog.ErrorContext(r.Context(), "Erreur ajout note devis", "quote_id", quote.ID, "err", err)
+		http.Error(w, "Erreur ajout de la note", http.StatusInternalServerError)
+		return
+	}
+	auditAdminAction(r, AuditActionQuoteNote, fmt.Sprintf("quote:%d", quote.ID), auditCreated(map[string]any{"author": author, "body": body}))
+
+	http.Redirect(w, r, fmt.Sprintf("/admin/quotes/%d#notes", quote.ID), http.StatusSeeOther)
+}
+
+func adminQuoteMessageHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdminAuth(w, r) {
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	quote, ok := loadAdminQuote(w, r)
+	if !ok {
+		return
+	}
+
+	if err := parseAttachmentForm(w, r); err != nil {
+		http.Error(w, "Formulaire invalide ou fichiers trop volumineux", http.StatusBadRequest)
+		return
+	}
+	body := strings.TrimSpace(r.FormValue("body"))
+	if len(body) > quoteMessageMaxLength {
+		http.Error(w, fmt.Sprintf("Le message doit contenir au plus %d caractères", quoteMessageMaxLength), http.StatusBadRequest)
+		return
+	}
+	attachments, err := storeUploadedAttachments(r.MultipartForm)
+	if err != nil {
+		if isAttachmentRejection(err) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		slog.ErrorContext(r.Context(), "Erreur stockage pièces jointes devis (admin)", "quote_id", quote.ID, "err", err)
+		http.Error(w, "Erreur enregistrement des fichiers", http.StatusInternalServerError)
+		return
+	}
+	if body == "" && len(attachments) == 0 {
+		http.Error(w, "Écrivez un message ou joignez un fichier", http.StatusBadRequest)
+		return
+	}
+
+	if err := addQuoteMessage(r.Context(), quote, QuoteMessageAuthorStaff, quoteMessageAuthorName(quote, QuoteMessageAuthorStaff), body, QuoteMessageSourceWeb, attachments); err != nil {
+		discardAttachments(attachments)
+		slog.ErrorContext(r.Context(), "Erreur ajout message devis (admin)", "quote_id", quote.ID, "err", err)
+		renderAdminErrorPage(w, "Erreur envoi du message", err.Error(), http.StatusInternalServerError)
+		return
+	}
+	filenames := make([]string, 0, len(attachments))
+	for _, attachment := range attachments {
+		filenames = append(filenames, attachment.Filename)
+	}
+	auditAdminAction(r, AuditActionQuoteMessage, fmt.Sprintf("quote:%d", quote.ID), auditCreated(map[string]any{"body": body, "attachments": filenames}))
+
+	http.Redirect(w, r, fmt.Sprintf("/admin/quotes/%d#conversation", quote.ID), http.StatusSeeOther)
+}
+
+func adminQuotePDFHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdminAuth(w, r) {
+		return
+	}
+
+	quote, ok := loadAdminQuote(w, r)
+	if !ok {
+		return
+	}
+
+	lines, err := listQuoteLines(quote.ID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Erreur récupération lignes devis (admin)", "quote_id", quote.ID, "err", err)
+		http.Error(w, "Erreur récupération devis", http.StatusInternalServerError)
+		return
+	}
+	if len(lines) == 0 {
+		http.Error(w, "Devis non chiffré", http.StatusConflict)
+		return
+	}
+
+	writeQuotePDF(w, quote, lines)
+}
+
+// writeQuotePDF envoie le PDF du devis en téléchargement
+func writeQuotePDF(w http.ResponseWriter, quote *QuoteRecord, lines []QuoteLine) {
+	pdf, err := renderQuotePDF(quote, lines)
+	if err != nil {
+		slog.Error("Erreur génération PDF devis", "quote_id", quote.ID, "err", err)
+		http.Error(w, "Erreur génération PDF", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="devis-%s.pdf"`, quoteNumber(quote)))
+	w.Header().Set("Content-Length", strconv.Itoa(len(pdf)))
+	w.Write(pdf)
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+func adminTrashHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdminAuth(w, r) {
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	users, err := listTrashedUsers()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Erreur récupération corbeille (utilisateurs)", "err", err)
+		renderAdminErrorPage(w, "Erreur récupération corbeille", err.Error(), http.StatusInternalServerError)
+		return
+	}
+	quotes, err := listTrashedQuotes()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Erreur récupération corbeille (devis)", "err", err)
+		renderAdminErrorPage(w, "Erreur récupération corbeille", err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	retention := trashRetention()
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	var builder strings.Builder
+	writeAdminPageStart(&builder, "Corbeille - Admin Modul-space")
+	builder.WriteString(fmt.Sprintf(`<div class="card"><h1>Corbeille</h1><p class="meta">%d utilisateur(s) • %d devis • suppression définitive %d jours après la mise à la corbeille</p><a href="/admin">← Retour au dashboard</a></div>`,
+		len(users), len(quotes), int(retention.Hours()/24)))
+
+	builder.WriteString(`<div class="card"><h2>Utilisateurs</h2><p class="small">Restaurer un compte restaure aussi les devis supprimés avec lui.</p>`)
+	builder.WriteString(`<table><thead><tr><th>Email</th><th>Nom</th><th>Prénom</th><th>Supprimé le</th><th>Purge le</th><th>Action</th></tr></thead><tbody>`)
+	for _, user := range users {
+		builder.WriteString(fmt.Sprintf(`<tr><td>%s</td><td>%s</td><td>%s</td><td>%s</td><td>%s</td><td>`,
+			html.EscapeString(user.Email), html.EscapeString(user.Nom), html.EscapeString(user.Prenom),
+			user.DeletedAt.Format("2006-01-02 15:04"), user.DeletedAt.Add(retention).Format("2006-01-02")))
+		writeAdminRestoreForm(&builder, "user", user.ID)
+		builder.WriteString(`</td></tr>`)
+	}
+	if len(users) == 0 {
+		builder.WriteString(`<tr><td colspan="6" class="small">Aucun utilisateur dans la corbeille</td></tr>`)
+	}
+	builder.WriteString(`</tbody></table></div>`)
+
+	builder.WriteString(`<div class="card"><h2>Devis</h2>`)
+	builder.WriteString(`<table><thead><tr><th>Référence</th><th>Client</th><th>Email</th><th>Produit</th><th>Statut</th><th>Supprimé le</th><th>Purge le</th><th>Action</th></tr></thead><tbody>`)
+	for _, quote := range quotes {
+		builder.WriteString(fmt.Sprintf(`<tr><td>%s</td><td>%s</td><td>%s</td><td>%s</td><td>%s</td><td>%s</td><td>%s</td><td>`,
+			html.EscapeString(quote.Reference), html.EscapeString(quote.Prenom+" "+quote.Nom), html.EscapeString(quote.Email), html.EscapeString(quote.Produit),
+			html.EscapeString(quoteStatusLabel(quote.Status)), quote.DeletedAt.Format("2006-01-02 15:04"), quote.DeletedAt.Add(retention).Format("2006-01-02")))
+		writeAdminRestoreForm(&builder, "quote", quote.ID)
+		builder.WriteString(`</td></tr>`)
+	}
+	if len(quotes) == 0 {
+		builder.WriteString(`<tr><td colspan="8" class="small">Aucun devis dans la corbeille</td></tr>`)
+	}
+	builder.WriteString(`</tbody></table></div>`)
+
+	writeAdminPageEnd(&builder)
+	w.Write([]byte(builder.String()))
+}
+
+func writeAdminRestoreForm(builder *strings.Builder, kind string, id int) {
+	builder.WriteString(fmt.Sprintf(`<form method="POST" action="/admin/trash/restore" class="inline-form"><input type="hidden" name="kind" value="%s"><input type="hidden" name="id" value="%d"><button type="submit" class="btn-secondary">Restaurer</button></form>`, kind, id))
+}
+
+func adminTrashRestoreHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdminAuth(w, r) {
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil || id <= 0 {
+		http.Error(w, "ID invalide", http.StatusBadRequest)
+		return
+	}
+
+	switch r.FormValue("kind") {
+	case "user":
+		quotes, err := restoreUser(id)
+		if err != nil {
+			renderTrashError(w, r, "Restauration impossible", err)
+			return
+		}
+		user, err := GetUserByID(id)
+		if err != nil || user == nil {
+			slog.ErrorContext(r.Context(), "Erreur relecture utilisateur restauré", "user_id", id, "err", err)
+			user = &User{ID: id}
+		}
+		auditAdminAction(r, AuditActionUserRestore, fmt.Sprintf("user:%d", id), map[string]any{"restored": userAuditSnapshot(user), "quotes": quotes})
+		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+	case "quote":
+		if err := restoreQuote(id); err != nil {
+			renderTrashError(w, r, "Restauration impossible", err)
+			return
+		}
+		auditAdminAction(r, AuditActionQuoteRestore, fmt.Sprintf("quote:%d", id), nil)
+		http.Redirect(w, r, fmt.Sprintf("/admin/quotes/%d", id), http.StatusSeeOther)
+	default:
+		http.Error(w, "Type d'élément invalide", http.StatusBadRequest)
+	}
+}
+
+func adminDeleteQuoteHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdminAuth(w, r) {
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	quote, ok := loadAdminQuote(w, r)
+	if !ok {
+		return
+	}
+
+	if err := softDeleteQuote(quote.ID); err != nil {
+		renderTrashError(w, r, "Suppression impossible", err)
+		return
+	}
+	auditAdminAction(r, AuditActionQuoteDelete, fmt.Sprintf("quote:%d", quote.ID),
+		auditDeleted(map[string]any{"reference": quote.Reference, "email": quote.Email, "produit": quote.Produit, "status": quote.Status}))
+
+	http.Redirect(w, r, "/admin/trash", http.StatusSeeOther)
+}
+
+// renderTrashError affiche un refus métier (409) ou une erreur technique (500)
+func renderTrashError(w http.ResponseWriter, r *http.Request, title string, err error) {
+	if errors.Is(err, errQuoteHasOrder) || errors.Is(err, errTrashOwnerDeleted) || errors.Is(err, errNotInTrash) {
+		renderAdminErrorPage(w, title, err.Error(), http.StatusConflict)
+		return
+	}
+	slog.ErrorContext(r.Context(), "Erreur corbeille", "action", title, "err", err)
+	renderAdminErrorPage(w, title, err.Error(), http.StatusInternalServerError)
+}
@@ -0,0 +1,442 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Nombre de livraisons affichées sur la page d'un abonnement
+const adminWebhookDeliveriesLimit = 100
+
+// Longueurs des colonnes name et url de webhook_subscriptions
+const (
+	webhookNameMaxLength = 100
+	webhookURLMaxLength  = 500
+)
+
+// adminWebhooksHandler liste les abonnements webhook (GET) et en crée un (POST)
+func adminWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdminAuth(w, r) {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		name := strings.TrimSpace(r.FormValue("name"))
+		if name == "" || utf8.RuneCountInString(name) > webhookNameMaxLength {
+			http.Error(w, fmt.Sprintf("Nom obligatoire (%d caractères maximum)", webhookNameMaxLength), http.StatusBadRequest)
+			return
+		}
+		target := strings.TrimSpace(r.FormValue("url"))
+		if len(target) > webhookURLMaxLength {
+			http.Error(w, fmt.Sprintf("URL trop longue (%d caractères maximum)", webhookURLMaxLength), http.StatusBadRequest)
+			return
+		}
+		if err := validateWebhookURL(target); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		format := r.FormValue("format")
+		if _, ok := webhookFormatLabels[format]; !ok {
+			http.Error(w, "Format inconnu : "+format, http.StatusBadRequest)
+			return
+		}
+		requested := make(map[string]bool)
+		for _, event := range r.Form["event"] {
+			if !isValidWebhookEvent(event) {
+				http.Error(w, "Événement inconnu : "+event, http.StatusBadRequest)
+				return
+			}
+			requested[event] = true
+		}
+		// Les événements sont enregistrés dans l'ordre de webhookEvents, sans doublon
+		events := make([]string, 0, len(requested))
+		for _, event := range webhookEvents {
+			if requested[event] {
+				events = append(events, event)
+			}
+		}
+		if len(events) == 0 {
+			http.Error(w, "Choisissez au moins un événement", http.StatusBadRequest)
+			return
+		}
+
+		subscriptionID, err := createWebhookSubscription(name, target, events, format, adminActor(r))
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Erreur création abonnement webhook", "err", err)
+			renderAdminErrorPage(w, "Erreur création du webhook", err.Error(), http.StatusInternalServerError)
+			return
+		}
+		auditAdminAction(r, AuditActionWebhookCreate, fmt.Sprintf("webhook:%d", subscriptionID),
+			auditCreated(map[string]any{"name": name, "url": target, "events": strings.Join(events, " "), "format": format}))
+
+		http.Redirect(w, r, fmt.Sprintf("/admin/webhooks/%d", subscriptionID), http.StatusSeeOther)
+		return
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	subscriptions, err := listWebhookSubscriptions()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Erreur récupération abonnements webhook", "err", err)
+		renderAdminErrorPage(w, "Erreur récupération des webhooks", err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	var builder strings.Builder
+	writeAdminPageStart(&builder, "Webhooks - Admin Modul-space")
+	builder.WriteString(fmt.Sprintf(`<div class="card"><h1>Webhooks</h1><p class="meta">Chaque événement est envoyé en POST aux abonnements actifs, signé dans l'en-tête <code>%s</code> (HMAC-SHA256 de <code>&lt;t&gt;.&lt;corps&gt;</code> avec le secret de l'abonnement). Les échecs sont relancés avec un délai croissant.</p><a href="/admin">← Retour au dashboard</a></div>`,
+		webhookSignatureHeader))
+
+	builder.WriteString(`<div class="card"><h2>Abonnements</h2><table><thead><tr><th>Nom</th><th>URL</th><th>Événements</th><th>Format</th><th>État</th><th>Créé par</th></tr></thead><tbody>`)
+	for _, subscription := range subscriptions {
+		state := "Actif"
+		if !subscription.Active {
+			state = "En pause"
+		}
+		builder.WriteString(fmt.Sprintf(`<tr><td><a href="/admin/webhooks/%d">%s</a></td><td class="small">%s</td><td class="small">%s</td><td>%s</td><td>%s</td><td>%s</td></tr>`,
+			subscription.ID, html.EscapeString(subscription.Name), html.EscapeString(subscription.URL), html.EscapeString(strings.Join(subscription.Events, " ")),
+			html.EscapeString(subscription.Format), state, html.EscapeString(subscription.CreatedBy)))
+	}
+	if len(subscriptions) == 0 {
+		builder.WriteString(`<tr><td colspan="6" class="small">Aucun abonnement</td></tr>`)
+	}
+	builder.WriteString(`</tbody></table></div>`)
+
+	builder.WriteString(fmt.Sprintf(`<div class="card"><h2>Nouvel abonnement</h2><form method="POST" action="/admin/webhooks"><p><label>Nom <input type="text" name="name" maxlength="%d" required placeholder="CRM"></label></p>`, webhookNameMaxLength))
+	placeholder := "https://crm.example.com/hooks/modul-space"
+	if webhookTestReceiverEnabled() {
+		placeholder = webhookTestReceiverURL()
+	}
+	builder.WriteString(fmt.Sprintf(`<p><label>URL <input type="url" name="url" maxlength="%d" size="60" required placeholder="%s"></label></p><p>`, webhookURLMaxLength, html.EscapeString(placeholder)))
+	for _, event := range webhookEvents {
+		builder.WriteString(fmt.Sprintf(`<label style="display:block"><input type="checkbox" name="event" value="%s" checked> <code>%s</code> — %s</label>`,
+			event, event, html.EscapeString(webhookEventLabel(event))))
+	}
+	builder.WriteString(`</p><p><label>Format <select name="format">`)
+	for _, format := range []string{WebhookFormatJSON, WebhookFormatDiscord} {
+		builder.WriteString(fmt.Sprintf(`<option value="%s">%s</option>`, format, html.EscapeString(webhookFormatLabels[format])))
+	}
+	builder.WriteString(`</select></label></p><button type="submit" class="btn-secondary">Créer l'abonnement</button></form></div>`)
+
+	if webhookTestReceiverEnabled() {
+		writeWebhookTestReceiver(&builder)
+	}
+
+	writeAdminPageEnd(&builder)
+	w.Write([]byte(builder.String()))
+}
+
+// writeWebhookTestReceiver affiche les derniers appels reçus par le récepteur de test
+func writeWebhookTestReceiver(builder *strings.Builder) {
+	builder.WriteString(fmt.Sprintf(`<div class="card"><h2>Récepteur de test</h2><p class="meta">Abonnez <code>%s</code> pour recevoir les webhooks sur ce serveur. Ajoutez <code>?status=500</code> à l'URL pour simuler un destinataire en panne.</p>`,
+		html.EscapeString(webhookTestReceiverURL())))
+	received := webhookTestReceived()
+	if len(received) == 0 {
+		builder.WriteString(`<p class="small">Aucun appel reçu depuis le démarrage.</p></div>`)
+		return
+	}
+	builder.WriteString(`<table><thead><tr><th>Reçu le</th><th>Événement</th><th>Livraison</th><th>Signature</th><th>Réponse</th><th>Corps</th></tr></thead><tbody>`)
+	for _, call := range received {
+		signature := "Valide"
+		if call.Verified != "" {
+			signature = "Refusée : " + call.Verified
+		}
+		builder.WriteString(fmt.Sprintf(`<tr><td>%s</td><td><code>%s</code></td><td><a href="/admin/webhook-deliveries/%s">#%s</a></td><td>%s</td><td>%d</td><td><pre style="white-space:pre-wrap;font-size:12px;margin:0">%s</pre></td></tr>`,
+			call.ReceivedAt.Format("02/01/2006 15:04:05"), html.EscapeString(call.Event), html.EscapeString(call.DeliveryID), html.EscapeString(call.DeliveryID),
+			html.EscapeString(signature), call.Status, html.EscapeString(call.Body)))
+	}
+	builder.WriteString(`</tbody></table></div>`)
+}
+
+// adminWebhookHandler affiche un abonnement, son secret et son journal de livraisons
+func adminWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdminAuth(w, r) {
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	subscription, ok := loadAdminWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	if _, ok := webhookDeliveryStatusLabels[status]; !ok {
+		status = ""
+	}
+	deliveries, err := listWebhookDeliveries(subscription.ID, status, adminWebhookDeliveriesLimit)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Erreur récupération livraisons webhook", "subscription_id", subscription.ID, "err", err)
+		renderAdminErrorPage(w, "Erreur récupération des livraisons", err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	// La page contient le secret de signature
+	w.Header().Set("Cache-Control", "no-store")
+	var builder strings.Builder
+	writeAdminPageStart(&builder, fmt.Sprintf("Webhook %s - Admin Modul-space", subscription.Name))
+
+	state := "Actif"
+	toggle := "Mettre en pause"
+	if !subscription.Active {
+		state = "En pause : les livraisons attendent la réactivation"
+		toggle = "Réactiver"
+	}
+	builder.WriteString(fmt.Sprintf(`<div class="card"><h1>%s</h1><p class="meta">%s • %s • %s</p><a href="/admin/webhooks">← Retour aux webhooks</a></div>`,
+		html.EscapeString(subscription.Name), html.EscapeString(subscription.URL), html.EscapeString(webhookFormatLabels[subscription.Format]), state))
+
+	builder.WriteString(`<div class="card"><table><tbody>`)
+	builder.WriteString(fmt.Sprintf(`<tr><th>Événements</th><td>%s</td></tr>`, html.EscapeString(strings.Join(subscription.Events, " "))))
+	builder.WriteString(fmt.Sprintf(`<tr><th>Secret de signature</th><td><input type="text" readonly value="%s" size="60" onclick="this.select()"></td></tr>`, html.EscapeString(subscription.Secret)))
+	builder.WriteString(fmt.Sprintf(`<tr><th>Créé</th><td>%s par %s</td></tr>`, subscription.CreatedAt.Format("02/01/2006 15:04"), html.EscapeString(subscription.CreatedBy)))
+	builder.WriteString(`</tbody></table><p>`)
+	builder.WriteString(fmt.Sprintf(`<form method="POST" action="/admin/webhooks/%d/test" class="inline-form"><button type="submit" class="btn-secondary">Envoyer un test</button></form> `, subscription.ID))
+	builder.WriteString(fmt.Sprintf(`<form method="POST" action="/admin/webhooks/%d/toggle" class="inline-form"><button type="submit" class="btn-secondary">%s</button></form> `, subscription.ID, toggle))
+	builder.WriteString(fmt.Sprintf(`<form method="POST" action="/admin/webhooks/%d/delete" class="inline-form" onsubmit="return confirm('Supprimer cet abonnement et son journal de livraisons ?')"><button type="submit">Supprimer</button></form>`, subscription.ID))
+	builder.WriteString(`</p></div>`)
+
+	builder.WriteString(`<div class="card"><h2>Livraisons</h2><p class="meta">`)
+	filters := []string{fmt.Sprintf(`<a href="/admin/webhooks/%d">Toutes</a>`, subscription.ID)}
+	for _, candidate := range []string{WebhookDeliveryPending, WebhookDeliverySending, WebhookDeliveryDelivered, WebhookDeliveryDead} {
+		label := webhookDeliveryStatusLabel(candidate)
+		if candidate == status {
+			filters = append(filters, "<strong>"+html.EscapeString(label)+"</strong>")
+		} else {
+			filters = append(filters, fmt.Sprintf(`<a href="/admin/webhooks/%d?status=%s">%s</a>`, subscription.ID, candidate, html.EscapeString(label)))
+		}
+	}
+	builder.WriteString(strings.Join(filters, " • "))
+	builder.WriteString(`</p>`)
+	if len(deliveries) == 0 {
+		builder.WriteString(`<p class="small">Aucune livraison.</p>`)
+	} else {
+		builder.WriteString(`<table><thead><tr><th>Créée le</th><th>Événement</th><th>Statut</th><th>Essais</th><th>Réponse</th><th>Dernière erreur</th><th>Action</th></tr></thead><tbody>`)
+		for _, delivery := range deliveries {
+			builder.WriteString(fmt.Sprintf(`<tr><td><a href="/admin/webhook-deliveries/%d">%s</a></td><td><code>%s</code></td><td>%s</td><td>%d</td><td>%s</td><td class="small">%s</td><td>%s</td></tr>`,
+				delivery.ID, delivery.CreatedAt.Format("02/01/2006 15:04"), html.EscapeString(delivery.Event), html.EscapeString(webhookDeliveryStatusLabel(delivery.Status)),
+				delivery.Attempts, webhookResponseStatus(delivery), html.EscapeString(delivery.LastError), webhookRedeliverButton(delivery)))
+		}
+		builder.WriteString(`</tbody></table>`)
+	}
+	builder.WriteString(`</div>`)
+
+	writeAdminPageEnd(&builder)
+	w.Write([]byte(builder.String()))
+}
+
+func webhookResponseStatus(delivery *WebhookDelivery) string {
+	if delivery.ResponseStatus == 0 {
+		return "—"
+	}
+	return strconv.Itoa(delivery.ResponseStatus)
+}
+
+func webhookRedeliverButton(delivery *WebhookDelivery) string {
+	if delivery.Status == WebhookDeliverySending {
+		return ""
+	}
+	return fmt.Sprintf(`<form method="POST" action="/admin/webhook-deliveries/%d/redeliver" class="inline-form"><button type="submit" class="btn-secondary">Renvoyer</button></form>`, delivery.ID)
+}
+
+// loadAdminWebhook lit l'abonnement de l'URL ; il a déjà répondu si ok est faux
+func loadAdminWebhook(w http.ResponseWriter, r *http.Request) (*WebhookSubscription, bool) {
+	subscriptionID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || subscriptionID <= 0 {
+		http.Error(w, "ID webhook invalide", http.StatusBadRequest)
+		return nil, false
+	}
+	subscription, err := GetWebhookSubscription(subscriptionID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Erreur récupération abonnement webhook", "subscription_id", subscriptionID, "err", err)
+		renderAdminErrorPage(w, "Erreur récupération du webhook", err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	if subscription == nil {
+		http.NotFound(w, r)
+		return nil, false
+	}
+	return subscription, true
+}
+
+// adminToggleWebhookHandler met en pause ou réactive un abonnement
+func adminToggleWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdminAuth(w, r) {
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	subscription, ok := loadAdminWebhook(w, r)
+	if !ok {
+		return
+	}
+	if err := setWebhookSubscriptionActive(subscription.ID, !subscription.Active); err != nil {
+		slog.ErrorContext(r.Context(), "Erreur activation webhook", "subscription_id", subscription.ID, "err", err)
+		renderAdminErrorPage(w, "Erreur modification du webhook", err.Error(), http.StatusInternalServerError)
+		return
+	}
+	auditAdminAction(r, AuditActionWebhookUpdate, fmt.Sprintf("webhook:%d", subscription.ID),
+		auditDiff(map[string]any{"active": subscription.Active}, map[string]any{"active": !subscription.Active}))
+
+	http.Redirect(w, r, fmt.Sprintf("/admin/webhooks/%d", subscription.ID), http.StatusSeeOther)
+}
+
+func adminDeleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdminAuth(w, r) {
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	subscription, ok := loadAdminWebhook(w, r)
+	if !ok {
+		return
+	}
+	err := deleteWebhookSubscription(subscription.ID)
+	if errors.Is(err, errWebhookSubscriptionNotFound) {
+		renderAdminErrorPage(w, "Suppression impossible", err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Erreur suppression webhook", "subscription_id", subscription.ID, "err", err)
+		renderAdminErrorPage(w, "Erreur suppression du webhook", err.Error(), http.StatusInternalServerError)
+		return
+	}
+	auditAdminAction(r, AuditActionWebhookDelete, fmt.Sprintf("webhook:%d", subscription.ID),
+		auditDeleted(map[string]any{"name": subscription.Name, "url": subscription.URL, "events": strings.Join(subscription.Events, " ")}))
+
+	http.Redirect(w, r, "/admin/webhooks", http.StatusSeeOther)
+}
+
+// adminTestWebhookHandler envoie un événement ping à l'abonnement
+func adminTestWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdminAuth(w, r) {
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	subscription, ok := loadAdminWebhook(w, r)
+	if !ok {
+		return
+	}
+	if err := enqueueWebhookPing(subscription, adminActor(r)); err != nil {
+		slog.ErrorContext(r.Context(), "Erreur test webhook", "subscription_id", subscription.ID, "err", err)
+		renderAdminErrorPage(w, "Erreur envoi du test", err.Error(), http.StatusInternalServerError)
+		return
+	}
+	auditAdminAction(r, AuditActionWebhookTest, fmt.Sprintf("webhook:%d", subscription.ID), nil)
+
+	http.Redirect(w, r, fmt.Sprintf("/admin/webhooks/%d", subscription.ID), http.StatusSeeOther)
+}
+
+// adminWebhookDeliveryHandler affiche le corps envoyé et la dernière réponse du destinataire
+func adminWebhookDeliveryHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdminAuth(w, r) {
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	deliveryID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || deliveryID <= 0 {
+		http.Error(w, "ID livraison invalide", http.StatusBadRequest)
+		return
+	}
+	delivery, err := GetWebhookDelivery(deliveryID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Erreur récupération livraison webhook", "delivery_id", deliveryID, "err", err)
+		renderAdminErrorPage(w, "Erreur récupération de la livraison", err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if delivery == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	var builder strings.Builder
+	writeAdminPageStart(&builder, fmt.Sprintf("Livraison webhook #%d - Admin Modul-space", delivery.ID))
+
+	builder.WriteString(fmt.Sprintf(`<div class="card"><h1>Livraison #%d</h1><p class="meta"><code>%s</code> • %s • %d essai(s)</p><a href="/admin/webhooks/%d">← Retour à l'abonnement</a></div>`,
+		delivery.ID, html.EscapeString(delivery.Event), html.EscapeString(webhookDeliveryStatusLabel(delivery.Status)), delivery.Attempts, delivery.SubscriptionID))
+
+	builder.WriteString(`<div class="card"><table><tbody>`)
+	builder.WriteString(fmt.Sprintf(`<tr><th>Événement</th><td><code>%s</code></td></tr>`, html.EscapeString(delivery.EventID)))
+	builder.WriteString(fmt.Sprintf(`<tr><th>Créée le</th><td>%s</td></tr>`, delivery.CreatedAt.Format("02/01/2006 15:04:05")))
+	if delivery.DeliveredAt != nil {
+		builder.WriteString(fmt.Sprintf(`<tr><th>Livrée le</th><td>%s</td></tr>`, delivery.DeliveredAt.Format("02/01/2006 15:04:05")))
+	} else if delivery.NextAttemptAt != nil && delivery.Status == WebhookDeliveryPending {
+		builder.WriteString(fmt.Sprintf(`<tr><th>Prochain essai</th><td>%s</td></tr>`, delivery.NextAttemptAt.Format("02/01/2006 15:04:05")))
+	}
+	builder.WriteString(fmt.Sprintf(`<tr><th>Réponse</th><td>%s</td></tr>`, webhookResponseStatus(delivery)))
+	if delivery.LastError != "" {
+		builder.WriteString(fmt.Sprintf(`<tr><th>Dernière erreur</th><td>%s</td></tr>`, html.EscapeString(delivery.LastError)))
+	}
+	builder.WriteString(`</tbody></table>`)
+	if button := webhookRedeliverButton(delivery); button != "" {
+		builder.WriteString(`<p>` + button + `</p>`)
+	}
+	builder.WriteString(`</div>`)
+
+	builder.WriteString(fmt.Sprintf(`<div class="card"><h2>Corps envoyé</h2><pre style="white-space:pre-wrap;font-size:13px">%s</pre></div>`, html.EscapeString(delivery.Payload)))
+	if delivery.ResponseBody != "" {
+		builder.WriteString(fmt.Sprintf(`<div class="card"><h2>Réponse du destinataire</h2><pre style="white-space:pre-wrap;font-size:13px">%s</pre></div>`, html.EscapeString(delivery.ResponseBody)))
+	}
+
+	writeAdminPageEnd(&builder)
+	w.Write([]byte(builder.String()))
+}
+
+func adminRedeliverWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdminAuth(w, r) {
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	deliveryID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || deliveryID <= 0 {
+		http.Error(w, "ID livraison invalide", http.StatusBadRequest)
+		return
+	}
+
+	if err := redeliverWebhook(deliveryID); err != nil {
+		slog.ErrorContext(r.Context(), "Erreur remise en file webhook", "delivery_id", deliveryID, "err", err)
+		renderAdminErrorPage(w, "Erreur renvoi du webhook", err.Error(), http.StatusInternalServerError)
+		return
+	}
+	auditAdminAction(r, AuditActionWebhookRedeliver, fmt.Sprintf("webhook_delivery:%d", deliveryID), nil)
+
+	http.Redirect(w, r, fmt.Sprintf("/admin/webhook-deliveries/%d", deliveryID), http.StatusSeeOther)
+}
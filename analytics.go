@@ -0,0 +1,186 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Périodes proposées sur la page statistiques, en semaines
+var analyticsPeriods = []int{12, 26, 52}
+
+const analyticsDefaultWeeks = 12
+
+// analyticsTopProducts borne le classement des produits les plus demandés
+const analyticsTopProducts = 10
+
+// WeeklyCount compte les créations d'une semaine, du lundi au dimanche
+type WeeklyCount struct {
+	Week  time.Time
+	Count int
+}
+
+// ProductCount compte les demandes de devis d'un produit sur la période
+type ProductCount struct {
+	Produit  string
+	Quotes   int
+	Accepted int
+}
+
+// QuoteAnalytics rassemble les indicateurs de la page statistiques, calculés en SQL sur la période
+type QuoteAnalytics struct {
+	Since time.Time
+	Weeks int
+	// QuotesPerWeek et SignupsPerWeek contiennent toutes les semaines de la période, vides comprises
+	QuotesPerWeek  []WeeklyCount
+	SignupsPerWeek []WeeklyCount
+	QuotesTotal    int
+	// QuotesOffered compte les devis chiffrés et envoyés au client (ou acceptés directement)
+	QuotesOffered  int
+	QuotesAccepted int
+	QuotesRejected int
+	// Responded compte les devis ayant reçu une première réponse de l'atelier
+	Responded        int
+	AvgFirstResponse time.Duration
+	Unanswered       int
+	Products         []ProductCount
+	SignupsTotal     int
+}
+
+// AcceptanceRate est la part des devis chiffrés acceptés par le client, en pourcentage
+func (a *QuoteAnalytics) AcceptanceRate() float64 {
+	return percentage(a.QuotesAccepted, a.QuotesOffered)
+}
+
+// ConversionRate est la part des demandes de la période devenues des devis acceptés, en pourcentage
+func (a *QuoteAnalytics) ConversionRate() float64 {
+	return percentage(a.QuotesAccepted, a.QuotesTotal)
+}
+
+func percentage(part, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(part) * 100 / float64(total)
+}
+
+// analyticsWeekStart renvoie le lundi 0 h de la semaine de t
+func analyticsWeekStart(t time.Time) time.Time {
+	offset := (int(t.Weekday()) + 6) % 7
+	return time.Date(t.Year(), t.Month(), t.Day()-offset, 0, 0, 0, 0, t.Location())
+}
+
+// weekStartExpression tronque une colonne de date au lundi de sa semaine, dans le dialecte courant
+func weekStartExpression(column string) string {
+	if dbDriver == "postgres" {
+		return fmt.Sprintf("date_trunc('week', %s)", column)
+	}
+	return fmt.Sprintf("DATE_SUB(DATE(%s), INTERVAL WEEKDAY(%s) DAY)", column, column)
+}
+
+// loadQuoteAnalytics calcule les indicateurs des weeks dernières semaines, semaine en cours comprise
+func loadQuoteAnalytics(weeks int) (*QuoteAnalytics, error) {
+	if db == nil {
+		return nil, fmt.Errorf("base de données non configurée")
+	}
+
+	since := analyticsWeekStart(time.Now()).AddDate(0, 0, -7*(weeks-1))
+	analytics := &QuoteAnalytics{Since: since, Weeks: weeks}
+
+	var err error
+	if analytics.QuotesPerWeek, err = countPerWeek("quotes", since, weeks); err != nil {
+		return nil, fmt.Errorf("erreur statistiques devis par semaine: %v", err)
+	}
+	if analytics.SignupsPerWeek, err = countPerWeek("users", since, weeks); err != nil {
+		return nil, fmt.Errorf("erreur statistiques inscriptions: %v", err)
+	}
+	for _, week := range analytics.SignupsPerWeek {
+		analytics.SignupsTotal += week.Count
+	}
+
+	err = db.QueryRow(
+		rebindQuery(`SELECT COUNT(*),
+			COALESCE(SUM(CASE WHEN quoted_at IS NOT NULL OR status = ? THEN 1 ELSE 0 END), 0),
+			COALESCE(SUM(CASE WHEN status = ? THEN 1 ELSE 0 END), 0),
+			COALESCE(SUM(CASE WHEN status = ? THEN 1 ELSE 0 END), 0),
+			COALESCE(SUM(CASE WHEN first_response_at IS NULL AND status IN (?, ?) THEN 1 ELSE 0 END), 0)
+			FROM quotes WHERE created_at >= ? AND deleted_at IS NULL`),
+		QuoteStatusAccepted, QuoteStatusAccepted, QuoteStatusRejected, QuoteStatusPending, QuoteStatusInReview, since,
+	).Scan(&analytics.QuotesTotal, &analytics.QuotesOffered, &analytics.QuotesAccepted, &analytics.QuotesRejected, &analytics.Unanswered)
+	if err != nil {
+		return nil, fmt.Errorf("erreur statistiques devis: %v", err)
+	}
+
+	var avgSeconds float64
+	err = db.QueryRow(
+		func() string {
+			if dbDriver == "postgres" {
+				return "SELECT COUNT(*), COALESCE(AVG(EXTRACT(EPOCH FROM (first_response_at - created_at))), 0) FROM quotes WHERE created_at >= $1 AND deleted_at IS NULL AND first_response_at IS NOT NULL"
+			}
+			return "SELECT COUNT(*), COALESCE(AVG(TIMESTAMPDIFF(SECOND, created_at, first_response_at)), 0) FROM quotes WHERE created_at >= ? AND deleted_at IS NULL AND first_response_at IS NOT NULL"
+		}(),
+		since,
+	).Scan(&analytics.Responded, &avgSeconds)
+	if err != nil {
+		return nil, fmt.Errorf("erreur statistiques délai de réponse: %v", err)
+	}
+	analytics.AvgFirstResponse = time.Duration(avgSeconds * float64(time.Second))
+
+	rows, err := db.Query(
+		rebindQuery(fmt.Sprintf(`SELECT produit, COUNT(*), COALESCE(SUM(CASE WHEN status = ? THEN 1 ELSE 0 END), 0)
+			FROM quotes WHERE created_at >= ? AND deleted_at IS NULL GROUP BY produit ORDER BY COUNT(*) DESC, produit LIMIT %d`, analyticsTopProducts)),
+		QuoteStatusAccepted, since,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("erreur statistiques produits: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var product ProductCount
+		if err := rows.Scan(&product.Produit, &product.Quotes, &product.Accepted); err != nil {
+			return nil, err
+		}
+		analytics.Products = append(analytics.Products, product)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return analytics, nil
+}
+
+// countPerWeek compte les lignes de table, hors corbeille, créées chaque semaine depuis since ;
+// les semaines sans création sont présentes avec un compte nul
+func countPerWeek(table string, since time.Time, weeks int) ([]WeeklyCount, error) {
+	week := weekStartExpression("created_at")
+	rows, err := db.Query(
+		rebindQuery(fmt.Sprintf("SELECT %s AS week_start, COUNT(*) FROM %s WHERE created_at >= ? AND deleted_at IS NULL GROUP BY week_start ORDER BY week_start", week, table)),
+		since,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var start sql.NullTime
+		var count int
+		if err := rows.Scan(&start, &count); err != nil {
+			return nil, err
+		}
+		if start.Valid {
+			counts[start.Time.Format("2006-01-02")] += count
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	series := make([]WeeklyCount, weeks)
+	for i := range series {
+		start := since.AddDate(0, 0, 7*i)
+		series[i] = WeeklyCount{Week: start, Count: counts[start.Format("2006-01-02")]}
+	}
+	return series, nil
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Taille de page des listes de l'API (paramètre limit)
+const (
+	apiDefaultLimit = 50
+	apiMaxLimit     = 200
+)
+
+// apiMaxBodyBytes borne le corps des requêtes de modification
+const apiMaxBodyBytes = 64 << 10
+
+// Codes d'erreur de l'API, stables : les intégrations s'appuient dessus plutôt que sur les messages
+const (
+	APIErrorUnauthorized     = "unauthorized"
+	APIErrorForbidden        = "forbidden"
+	APIErrorNotFound         = "not_found"
+	APIErrorMethodNotAllowed = "method_not_allowed"
+	APIErrorInvalidRequest   = "invalid_request"
+	APIErrorConflict         = "conflict"
+	APIErrorInternal         = "internal_error"
+)
+
+// APIError est le contenu de l'enveloppe d'erreur {"error": {...}} renvoyée par toutes les routes de l'API
+type APIError struct {
+	Code    string `json:"code" enum:"api_error_code"`
+	Message string `json:"message"`
+	// Field désigne le champ du corps en cause, pour les erreurs invalid_request
+	Field string `json:"field,omitempty" doc:"Champ du corps en cause"`
+}
+
+type APIErrorEnvelope struct {
+	Error APIError `json:"error"`
+}
+
+// APIPage est une page d'une liste ; NextCursor, à repasser dans le paramètre cursor, est absent
+// sur la dernière page
+type APIPage[T any] struct {
+	Data       []T    `json:"data"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+func writeAPIJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		slog.Error("Erreur écriture réponse API", "err", err)
+	}
+}
+
+func writeAPIError(w http.ResponseWriter, status int, code, message string) {
+	writeAPIJSON(w, status, APIErrorEnvelope{Error: APIError{Code: code, Message: message}})
+}
+
+// writeAPIInternalError journalise l'erreur et renvoie un message générique : le détail reste côté serveur,
+// retrouvable dans les journaux avec l'identifiant de requête cité dans le message
+func writeAPIInternalError(w http.ResponseWriter, r *http.Request, operation string, err error) {
+	slog.ErrorContext(r.Context(), "Erreur API", "operation", operation, "err", err)
+	message := "Erreur interne, réessayez plus tard"
+	if id := requestIDFromContext(r.Context()); id != "" {
+		message += " (requête " + id + ")"
+	}
+	writeAPIError(w, http.StatusInternalServerError, APIErrorInternal, message)
+}
+
+func writeAPIMethodNotAllowed(w http.ResponseWriter, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	writeAPIError(w, http.StatusMethodNotAllowed, APIErrorMethodNotAllowed, "Méthode non autorisée sur cette ressource")
+}
+
+// apiNotFoundHandler répond aux routes inconnues de /api/v1 avec l'enveloppe d'erreur
+func apiNotFoundHandler(w http.ResponseWriter, r *http.Request) {
+	writeAPIError(w, http.StatusNotFound, APIErrorNotFound, "Route inconnue : "+r.URL.Path)
+}
+
+// apiAuth authentifie la requête par son jeton (Authorization: Bearer msk_…) et vérifie qu'il a le périmètre scope
+func apiAuth(w http.ResponseWriter, r *http.Request, scope string) (*APIToken, bool) {
+	value, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || strings.TrimSpace(value) == "" {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="Modul-space API"`)
+		writeAPIError(w, http.StatusUnauthorized, APIErrorUnauthorized, "Jeton d'API manquant (en-tête Authorization: Bearer)")
+		return nil, false
+	}
+
+	token, err := authenticateAPIToken(strings.TrimSpace(value))
+	if err != nil {
+		writeAPIInternalError(w, r, "authentification", err)
+		return nil, false
+	}
+	if token == nil {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="Modul-space API", error="invalid_token"`)
+		writeAPIError(w, http.StatusUnauthorized, APIErrorUnauthorized, "Jeton d'API invalide ou révoqué")
+		return nil, false
+	}
+	if !token.HasScope(scope) {
+		writeAPIError(w, http.StatusForbidden, APIErrorForbidden, fmt.Sprintf("Ce jeton n'a pas le périmètre %s", scope))
+		return nil, false
+	}
+	return token, true
+}
+
+// auditAPIAction journalise une modification faite par l'API, au nom du jeton
+func auditAPIAction(r *http.Request, token *APIToken, action, target string, details any) {
+	encoded, err := json.Marshal(details)
+	if err == nil {
+		err = appendAuditEntry(token.actor(), action, target, clientIP(r), string(encoded))
+	}
+	if err != nil {
+		slog.WarnContext(r.Context(), "Journal d'audit non écrit", "action", action, "target", target, "err", err)
+	}
+}
+
+// APICursor repère la position d'une liste : les listes sont triées par identifiant décroissant et la page
+// suivante reprend après le dernier identifiant reçu. Le curseur est opaque pour les intégrations
+type APICursor struct {
+	AfterID int
+}
+
+func (c APICursor) encode() string {
+	return base64.RawURLEncoding.EncodeToString([]byte("id:" + strconv.Itoa(c.AfterID)))
+}
+
+func decodeAPICursor(value string) (APICursor, error) {
+	if value == "" {
+		return APICursor{}, nil
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return APICursor{}, errors.New("curseur invalide")
+	}
+	id, err := strconv.Atoi(strings.TrimPrefix(string(decoded), "id:"))
+	if err != nil || id <= 0 || !strings.HasPrefix(string(decoded), "id:") {
+		return APICursor{}, errors.New("curseur invalide")
+	}
+	return APICursor{AfterID: id}, nil
+}
+
+// APIListQuery rassemble les paramètres communs des listes : limit, cursor et le filtre status
+type APIListQuery struct {
+	Limit  int
+	Cursor APICursor
+	Status string
+}
+
+// parseAPIListQuery lit limit, cursor et status ; validStatus est nil pour les listes sans statut
+func parseAPIListQuery(r *http.Request, validStatus func(string) bool) (APIListQuery, error) {
+	values := r.URL.Query()
+	query := APIListQuery{Limit: apiDefaultLimit}
+	if limit := values.Get("limit"); limit != "" {
+		parsed, err := strconv.Atoi(limit)
+		if err != nil || parsed < 1 || parsed > apiMaxLimit {
+			return query, fmt.Errorf("limit doit être compris entre 1 et %d", apiMaxLimit)
+		}
+		query.Limit = parsed
+	}
+	cursor, err := decodeAPICursor(values.Get("cursor"))
+	if err != nil {
+		return query, err
+	}
+	query.Cursor = cursor
+	if status := values.Get("status"); status != "" {
+		if validStatus == nil || !validStatus(status) {
+			return query, fmt.Errorf("statut inconnu: %s", status)
+		}
+		query.Status = status
+	}
+	return query, nil
+}
+
+// conditions traduit la requête en clause WHERE ; la liste est lue avec une ligne de plus que limit
+// pour savoir s'il existe une page suivante
+func (q APIListQuery) conditions(statusColumn string) sqlConditions {
+	var conditions sqlConditions
+	if q.Cursor.AfterID > 0 {
+		conditions.add("id < ?", q.Cursor.AfterID)
+	}
+	if q.Status != "" {
+		conditions.add(statusColumn+" = ?", q.Status)
+	}
+	return conditions
+}
+
+func (q APIListQuery) orderAndLimit() string {
+	return fmt.Sprintf(" ORDER BY id DESC LIMIT %d", q.Limit+1)
+}
+
+// newAPIPage coupe la ligne de trop lue par la requête et en déduit le curseur de la page suivante
+func newAPIPage[T any](items []T, limit int, id func(T) int) APIPage[T] {
+	page := APIPage[T]{Data: items}
+	if len(items) > limit {
+		page.Data = items[:limit]
+		page.NextCursor = APICursor{AfterID: id(items[limit-1])}.encode()
+	}
+	return page
+}
+
+// decodeAPIBody lit le corps JSON d'une requête dans target ; les champs inconnus sont refusés
+// pour qu'une faute de frappe ne passe pas inaperçue
+func decodeAPIBody(w http.ResponseWriter, r *http.Request, target any) bool {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, apiMaxBodyBytes))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(target); err != nil {
+		writeAPIError(w, http.StatusBadRequest, APIErrorInvalidRequest, "Corps JSON invalide : "+err.Error())
+		return false
+	}
+	if err := decoder.Decode(&struct{}{}); err != io.EOF {
+		writeAPIError(w, http.StatusBadRequest, APIErrorInvalidRequest, "Corps JSON invalide : un seul objet attendu")
+		return false
+	}
+	return true
+}
+
+// apiPathID lit l'identifiant numérique {id} du chemin
+func apiPathID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id <= 0 {
+		writeAPIError(w, http.StatusNotFound, APIErrorNotFound, "Identifiant invalide")
+		return 0, false
+	}
+	return id, true
+}
+
+func writeAPIInvalidField(w http.ResponseWriter, field, message string) {
+	writeAPIJSON(w, http.StatusBadRequest, APIErrorEnvelope{Error: APIError{Code: APIErrorInvalidRequest, Message: message, Field: field}})
+}
+
+// apiRoute est une route JSON du serveur : la même table enregistre les handlers (registerAPIRoutes)
+// et décrit la spécification OpenAPI (openAPISpec)
+type apiRoute struct {
+	Pattern string
+	// Tag regroupe les routes dans la spécification
+	Tag     string
+	Handler http.HandlerFunc
+	// Legacy marque les routes du site (/api/quote, /api/user) : leurs erreurs restent en texte brut
+	Legacy     bool
+	Operations []apiOperation
+}
+
+// apiOperation décrit une méthode d'une route ; Request et Response sont des valeurs des types Go
+// du corps, nil s'il n'y en a pas
+type apiOperation struct {
+	Method      string
+	ID          string
+	Summary     string
+	Description string
+	// Scope est le périmètre de jeton exigé ; Session marque les routes authentifiées par le cookie du site
+	Scope    string
+	Session  bool
+	Query    []OpenAPIParameter
+	Request  any
+	Response any
+	// Status est le statut de succès, 200 par défaut
+	Status int
+	// Errors complète les erreurs déduites de la route ; une valeur nil prend le format d'erreur de la route
+	Errors map[int]any
+}
+
+func apiRoutes() []apiRoute {
+	return []apiRoute{
+		{Pattern: "/api/quote", Tag: "site", Handler: quoteHandler, Legacy: true, Operations: []apiOperation{{
+			Method: http.MethodPost, ID: "createQuote", Summary: "Demander un devis",
+			Description: "Route du formulaire de devis du site. Le site l'envoie en multipart/form-data avec ses pièces jointes (champ attachments) ; " +
+				"un corps JSON est vérifié contre le schéma Quote.",
+			Session: true, Request: Quote{}, Response: QuoteResponse{},
+			Errors: map[int]any{http.StatusBadRequest: nil, http.StatusUnauthorized: QuoteResponse{}, http.StatusInternalServerError: nil},
+		}}},
+		{Pattern: "/api/user", Tag: "site", Handler: userHandler, Legacy: true, Operations: []apiOperation{{
+			Method: http.MethodGet, ID: "getSessionUser", Summary: "Compte connecté sur le site",
+			Description: "Renvoie loggedIn false sans session.", Response: UserSession{},
+		}}},
+		{Pattern: "/api/v1/openapi.json", Tag: "openapi", Handler: openAPIHandler, Operations: []apiOperation{{
+			Method: http.MethodGet, ID: "getOpenAPISpec", Summary: "Spécification OpenAPI de l'API (ce document)",
+		}}},
+		{Pattern: "/api/v1/quotes", Tag: "quotes", Handler: apiQuotesHandler, Operations: []apiOperation{{
+			Method: http.MethodGet, ID: "listQuotes", Summary: "Lister les devis", Scope: APIScopeQuotesRead,
+			Query: apiListParameters("quote_status"), Response: APIPage[APIQuote]{},
+		}}},
+		{Pattern: "/api/v1/quotes/{id}", Tag: "quotes", Handler: apiQuoteHandler, Operations: []apiOperation{{
+			Method: http.MethodGet, ID: "getQuote", Summary: "Lire un devis", Scope: APIScopeQuotesRead, Response: APIQuote{},
+		}, {
+			Method: http.MethodPatch, ID: "updateQuote", Summary: "Changer le statut ou l'attribution d'un devis", Scope: APIScopeQuotesWrite,
+			Description: "Un refus exige rejection_reason ; un devis sans ligne chiffrée ne peut pas être envoyé (409).",
+			Request:     APIQuoteUpdate{}, Response: APIQuote{}, Errors: map[int]any{http.StatusConflict: nil},
+		}}},
+		{Pattern: "/api/v1/orders", Tag: "orders", Handler: apiOrdersHandler, Operations: []apiOperation{{
+			Method: http.MethodGet, ID: "listOrders", Summary: "Lister les commandes", Scope: APIScopeOrdersRead,
+			Query: apiListParameters("order_status"), Response: APIPage[APIOrder]{},
+		}}},
+		{Pattern: "/api/v1/orders/{id}", Tag: "orders", Handler: apiOrderHandler, Operations: []apiOperation{{
+			Method: http.MethodGet, ID: "getOrder", Summary: "Lire une commande", Scope: APIScopeOrdersRead, Response: APIOrder{},
+		}, {
+			Method: http.MethodPatch, ID: "updateOrder", Summary: "Faire avancer une commande ou changer sa date de livraison", Scope: APIScopeOrdersWrite,
+			Description: "La commande n'avance que d'une étape à la fois (409 sinon).",
+			Request:     APIOrderUpdate{}, Response: APIOrder{}, Errors: map[int]any{http.StatusConflict: nil},
+		}}},
+		{Pattern: "/api/v1/products", Tag: "products", Handler: apiProductsHandler, Operations: []apiOperation{{
+			Method: http.MethodGet, ID: "listProducts", Summary: "Lister le catalogue", Scope: APIScopeProductsRead, Response: APIPage[APIProduct]{},
+		}}},
+		{Pattern: "/api/v1/products/{slug}", Tag: "products", Handler: apiProductHandler, Operations: []apiOperation{{
+			Method: http.MethodGet, ID: "getProduct", Summary: "Lire un produit", Scope: APIScopeProductsRead, Response: APIProduct{},
+		}}},
+		{Pattern: "/api/v1/users", Tag: "users", Handler: apiUsersHandler, Operations: []apiOperation{{
+			Method: http.MethodGet, ID: "listUsers", Summary: "Lister les comptes clients", Scope: APIScopeUsersRead,
+			Query: apiListParameters(""), Response: APIPage[APIUser]{},
+		}}},
+		{Pattern: "/api/v1/users/{id}", Tag: "users", Handler: apiUserHandler, Operations: []apiOperation{{
+			Method: http.MethodGet, ID: "getUser", Summary: "Lire un compte client", Scope: APIScopeUsersRead, Response: APIUser{},
+		}}},
+	}
+}
+
+// registerAPIRoutes enregistre les routes JSON, chacune derrière la validation de son corps par la spécification
+func registerAPIRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("/api/v1/", apiNotFoundHandler)
+	for _, route := range apiRoutes() {
+		mux.HandleFunc(route.Pattern, validateAPIRequest(route, route.Handler))
+	}
+}
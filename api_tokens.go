@@ -0,0 +1,184 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// apiTokenPrefix rend les jetons reconnaissables (journaux, détecteurs de secrets)
+const apiTokenPrefix = "msk_"
+
+// apiTokenNameMaxLength borne le nom d'un jeton, repris dans l'auteur des entrées du journal d'audit
+const apiTokenNameMaxLength = 60
+
+// Périmètres (scopes) qu'un jeton peut recevoir
+const (
+	APIScopeQuotesRead   = "quotes:read"
+	APIScopeQuotesWrite  = "quotes:write"
+	APIScopeOrdersRead   = "orders:read"
+	APIScopeOrdersWrite  = "orders:write"
+	APIScopeProductsRead = "products:read"
+	APIScopeUsersRead    = "users:read"
+)
+
+var apiScopes = []string{
+	APIScopeQuotesRead, APIScopeQuotesWrite, APIScopeOrdersRead, APIScopeOrdersWrite, APIScopeProductsRead, APIScopeUsersRead,
+}
+
+var apiScopeLabels = map[string]string{
+	APIScopeQuotesRead:   "Lire les devis",
+	APIScopeQuotesWrite:  "Modifier les devis (statut, attribution)",
+	APIScopeOrdersRead:   "Lire les commandes",
+	APIScopeOrdersWrite:  "Modifier les commandes (avancement, livraison)",
+	APIScopeProductsRead: "Lire le catalogue",
+	APIScopeUsersRead:    "Lire les comptes clients",
+}
+
+var errAPITokenNotFound = errors.New("jeton introuvable ou déjà révoqué")
+
+func isValidAPIScope(scope string) bool {
+	_, ok := apiScopeLabels[scope]
+	return ok
+}
+
+// APIToken est un jeton d'API personnel. Seule son empreinte SHA-256 est conservée : le jeton en clair
+// n'est montré qu'une fois, à sa création
+type APIToken struct {
+	ID int
+	// Name décrit l'usage du jeton ("outil de planification") ; Owner est l'admin qui l'a créé
+	Name  string
+	Owner string
+	// Prefix est le début du jeton, affiché pour le reconnaître
+	Prefix     string
+	Scopes     []string
+	LastUsedAt *time.Time
+	RevokedAt  *time.Time
+	CreatedAt  time.Time
+}
+
+func (t *APIToken) HasScope(scope string) bool {
+	for _, candidate := range t.Scopes {
+		if candidate == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// actor est l'auteur des entrées du journal d'audit écrites avec ce jeton
+func (t *APIToken) actor() string {
+	return fmt.Sprintf("api#%d:%s", t.ID, t.Name)
+}
+
+func hashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// createAPIToken crée un jeton et le renvoie en clair, pour la seule fois
+func createAPIToken(name, owner string, scopes []string) (string, error) {
+	if db == nil {
+		return "", fmt.Errorf("base de données non configurée")
+	}
+
+	token := apiTokenPrefix + randomHex(24)
+	prefix := token[:len(apiTokenPrefix)+8]
+	_, err := db.Exec(
+		rebindQuery("INSERT INTO api_tokens (name, owner, prefix, token_hash, scopes, created_at) VALUES (?, ?, ?, ?, ?, ?)"),
+		name, owner, prefix, hashAPIToken(token), strings.Join(scopes, " "), time.Now(),
+	)
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+const apiTokenColumns = "id, name, owner, prefix, scopes, last_used_at, revoked_at, created_at"
+
+func scanAPIToken(scanner interface{ Scan(...any) error }) (*APIToken, error) {
+	token := &APIToken{}
+	var scopes string
+	var lastUsedAt, revokedAt sql.NullTime
+	if err := scanner.Scan(&token.ID, &token.Name, &token.Owner, &token.Prefix, &scopes, &lastUsedAt, &revokedAt, &token.CreatedAt); err != nil {
+		return nil, err
+	}
+	token.Scopes = strings.Fields(scopes)
+	if lastUsedAt.Valid {
+		token.LastUsedAt = &lastUsedAt.Time
+	}
+	if revokedAt.Valid {
+		token.RevokedAt = &revokedAt.Time
+	}
+	return token, nil
+}
+
+// listAPITokens renvoie tous les jetons, révoqués compris, les plus récents d'abord
+func listAPITokens() ([]*APIToken, error) {
+	if db == nil {
+		return nil, fmt.Errorf("base de données non configurée")
+	}
+
+	rows, err := db.Query("SELECT " + apiTokenColumns + " FROM api_tokens ORDER BY created_at DESC, id DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := make([]*APIToken, 0)
+	for rows.Next() {
+		token, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
+
+// revokeAPIToken révoque un jeton : il est refusé dès la requête suivante
+func revokeAPIToken(tokenID int) (*APIToken, error) {
+	if db == nil {
+		return nil, fmt.Errorf("base de données non configurée")
+	}
+
+	result, err := db.Exec(rebindQuery("UPDATE api_tokens SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL"), time.Now(), tokenID)
+	if err != nil {
+		return nil, err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return nil, err
+	} else if affected == 0 {
+		return nil, errAPITokenNotFound
+	}
+	return scanAPIToken(db.QueryRow(rebindQuery("SELECT "+apiTokenColumns+" FROM api_tokens WHERE id = ?"), tokenID))
+}
+
+// authenticateAPIToken retrouve un jeton actif à partir de sa valeur en clair, nil s'il est inconnu ou révoqué.
+// La date de dernière utilisation est mise à jour au plus une fois par minute
+func authenticateAPIToken(value string) (*APIToken, error) {
+	if db == nil {
+		return nil, fmt.Errorf("base de données non configurée")
+	}
+	if !strings.HasPrefix(value, apiTokenPrefix) {
+		return nil, nil
+	}
+
+	token, err := scanAPIToken(db.QueryRow(rebindQuery("SELECT "+apiTokenColumns+" FROM api_tokens WHERE token_hash = ? AND revoked_at IS NULL"), hashAPIToken(value)))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if _, err := db.Exec(rebindQuery("UPDATE api_tokens SET last_used_at = ? WHERE id = ? AND (last_used_at IS NULL OR last_used_at < ?)"), now, token.ID, now.Add(-time.Minute)); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
@@ -0,0 +1,592 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// APIQuote est une demande de devis telle qu'exposée par l'API
+type APIQuote struct {
+	ID        int    `json:"id"`
+	Reference string `json:"reference" doc:"Référence légale DEV-AAAA-NNNN"`
+	// UserID est le compte client du devis, null pour une demande antérieure au rattachement des comptes
+	UserID          *int       `json:"user_id"`
+	Nom             string     `json:"nom"`
+	Prenom          string     `json:"prenom"`
+	Email           string     `json:"email"`
+	Telephone       string     `json:"telephone"`
+	Produit         string     `json:"produit"`
+	Configuration   string     `json:"configuration"`
+	Message         string     `json:"message"`
+	Status          string     `json:"status" enum:"quote_status"`
+	RejectionReason string     `json:"rejection_reason"`
+	AssignedTo      string     `json:"assigned_to" doc:"Membre de l'équipe chargé du devis, vide si non attribué"`
+	QuotedAt        *time.Time `json:"quoted_at" doc:"Date d'envoi du devis chiffré"`
+	CreatedAt       time.Time  `json:"created_at"`
+}
+
+// APIQuoteUpdate est le corps de PATCH /api/v1/quotes/{id} ; un champ absent reste inchangé
+type APIQuoteUpdate struct {
+	Status *string `json:"status" enum:"quote_status"`
+	// RejectionReason est requis avec status "rejected" : il est envoyé au client
+	RejectionReason *string `json:"rejection_reason" doc:"Motif envoyé au client, obligatoire avec status rejected"`
+	// AssignedTo est un membre de ADMIN_STAFF, ou "" pour retirer l'attribution
+	AssignedTo *string `json:"assigned_to" doc:"Membre de l'équipe (ADMIN_STAFF), vide pour retirer l'attribution"`
+}
+
+// APIOrder est une commande telle qu'exposée par l'API ; les montants sont en centimes
+type APIOrder struct {
+	ID                 int        `json:"id"`
+	Reference          string     `json:"reference"`
+	QuoteID            int        `json:"quote_id"`
+	UserID             *int       `json:"user_id"`
+	Nom                string     `json:"nom"`
+	Prenom             string     `json:"prenom"`
+	Email              string     `json:"email"`
+	Telephone          string     `json:"telephone"`
+	Produit            string     `json:"produit"`
+	Status             string     `json:"status" enum:"order_status"`
+	TotalHTCents       int64      `json:"total_ht_cents"`
+	TotalVATCents      int64      `json:"total_vat_cents"`
+	TotalTTCCents      int64      `json:"total_ttc_cents"`
+	ExpectedReadyAt    time.Time  `json:"expected_ready_at"`
+	ExpectedDeliveryAt time.Time  `json:"expected_delivery_at"`
+	ReadyAt            *time.Time `json:"ready_at"`
+	ShippedAt          *time.Time `json:"shipped_at"`
+	DeliveredAt        *time.Time `json:"delivered_at"`
+	CreatedAt          time.Time  `json:"created_at"`
+}
+
+// APIOrderUpdate est le corps de PATCH /api/v1/orders/{id} ; un champ absent reste inchangé
+type APIOrderUpdate struct {
+	// Status ne peut être que l'étape qui suit l'étape actuelle
+	Status *string `json:"status" enum:"order_status" doc:"Étape suivante de la commande"`
+	// ExpectedDeliveryAt est une date AAAA-MM-JJ
+	ExpectedDeliveryAt *string `json:"expected_delivery_at" format:"date"`
+}
+
+// APIProduct est un meuble du catalogue
+type APIProduct struct {
+	Slug             string   `json:"slug"`
+	Name             string   `json:"name"`
+	Page             string   `json:"page" doc:"Page de la fiche produit sur le site"`
+	Configurations   []string `json:"configurations"`
+	LeadTimeMinWeeks int      `json:"lead_time_min_weeks" doc:"Délai de fabrication minimal, en semaines"`
+	LeadTimeMaxWeeks int      `json:"lead_time_max_weeks"`
+}
+
+// APIUser est un compte client, sans son mot de passe
+type APIUser struct {
+	ID                 int       `json:"id"`
+	Email              string    `json:"email"`
+	Nom                string    `json:"nom"`
+	Prenom             string    `json:"prenom"`
+	EmailNotifications bool      `json:"email_notifications"`
+	CreatedAt          time.Time `json:"created_at"`
+}
+
+func optionalID(id int) *int {
+	if id == 0 {
+		return nil
+	}
+	return &id
+}
+
+func newAPIQuote(quote *QuoteRecord) APIQuote {
+	return APIQuote{
+		ID: quote.ID, Reference: quote.Reference, UserID: optionalID(quote.UserID),
+		Nom: quote.Nom, Prenom: quote.Prenom, Email: quote.Email, Telephone: quote.Telephone,
+		Produit: quote.Produit, Configuration: quote.Configuration, Message: quote.Message,
+		Status: quote.Status, RejectionReason: quote.RejectionReason, AssignedTo: quote.AssignedTo,
+		QuotedAt: quote.QuotedAt, CreatedAt: quote.CreatedAt,
+	}
+}
+
+func newAPIOrder(order *Order) APIOrder {
+	return APIOrder{
+		ID: order.ID, Reference: order.Reference, QuoteID: order.QuoteID, UserID: optionalID(order.UserID),
+		Nom: order.Nom, Prenom: order.Prenom, Email: order.Email, Telephone: order.Telephone, Produit: order.Produit,
+		Status: order.Status, TotalHTCents: order.TotalHTCents, TotalVATCents: order.TotalVATCents, TotalTTCCents: order.TotalTTCCents,
+		ExpectedReadyAt: order.ExpectedReadyAt, ExpectedDeliveryAt: order.ExpectedDeliveryAt,
+		ReadyAt: order.ReadyAt, ShippedAt: order.ShippedAt, DeliveredAt: order.DeliveredAt, CreatedAt: order.CreatedAt,
+	}
+}
+
+func newAPIProduct(product Product) APIProduct {
+	configurations := product.Configurations
+	if configurations == nil {
+		configurations = []string{}
+	}
+	minWeeks, maxWeeks := leadTimeWeeks(product.Name)
+	return APIProduct{
+		Slug: product.Slug, Name: product.Name, Page: product.Page, Configurations: configurations,
+		LeadTimeMinWeeks: minWeeks, LeadTimeMaxWeeks: maxWeeks,
+	}
+}
+
+// listAPIQuotes lit une page de devis hors corbeille, les plus récents d'abord
+func listAPIQuotes(query APIListQuery) ([]APIQuote, error) {
+	if db == nil {
+		return nil, fmt.Errorf("base de données non configurée")
+	}
+
+	conditions := query.conditions("status")
+	conditions.add("deleted_at IS NULL")
+	rows, err := db.Query(rebindQuery("SELECT "+quoteRecordColumns+" FROM quotes"+conditions.where()+query.orderAndLimit()), conditions.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	quotes := make([]APIQuote, 0)
+	for rows.Next() {
+		quote, err := scanQuoteRecord(rows)
+		if err != nil {
+			return nil, err
+		}
+		quotes = append(quotes, newAPIQuote(quote))
+	}
+	return quotes, rows.Err()
+}
+
+// listAPIOrders lit une page de commandes, les plus récentes d'abord
+func listAPIOrders(query APIListQuery) ([]APIOrder, error) {
+	conditions := query.conditions("status")
+	orders, err := queryOrders(rebindQuery("SELECT "+orderColumns+" FROM orders"+conditions.where()+query.orderAndLimit()), conditions.args...)
+	if err != nil {
+		return nil, err
+	}
+	page := make([]APIOrder, 0, len(orders))
+	for _, order := range orders {
+		page = append(page, newAPIOrder(order))
+	}
+	return page, nil
+}
+
+const apiUserColumns = "id, email, nom, prenom, email_notifications, created_at"
+
+func scanAPIUser(scanner interface{ Scan(...any) error }) (APIUser, error) {
+	var user APIUser
+	var nom, prenom sql.NullString
+	var createdAt sql.NullTime
+	if err := scanner.Scan(&user.ID, &user.Email, &nom, &prenom, &user.EmailNotifications, &createdAt); err != nil {
+		return user, err
+	}
+	user.Nom = nom.String
+	user.Prenom = prenom.String
+	user.CreatedAt = createdAt.Time
+	return user, nil
+}
+
+// listAPIUsers lit une page de comptes clients hors corbeille, les plus récents d'abord
+func listAPIUsers(query APIListQuery) ([]APIUser, error) {
+	if db == nil {
+		return nil, fmt.Errorf("base de données non configurée")
+	}
+
+	conditions := query.conditions("")
+	conditions.add("deleted_at IS NULL")
+	rows, err := db.Query(rebindQuery("SELECT "+apiUserColumns+" FROM users"+conditions.where()+query.orderAndLimit()), conditions.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := make([]APIUser, 0)
+	for rows.Next() {
+		user, err := scanAPIUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+// getAPIUser lit un compte client hors corbeille, nil s'il n'existe pas
+func getAPIUser(userID int) (*APIUser, error) {
+	if db == nil {
+		return nil, fmt.Errorf("base de données non configurée")
+	}
+
+	user, err := scanAPIUser(db.QueryRow(rebindQuery("SELECT "+apiUserColumns+" FROM users WHERE id = ? AND deleted_at IS NULL"), userID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// GET /api/v1/quotes?status=&limit=&cursor=
+func apiQuotesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeAPIMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+	if _, ok := apiAuth(w, r, APIScopeQuotesRead); !ok {
+		return
+	}
+
+	query, err := parseAPIListQuery(r, isValidQuoteStatus)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, APIErrorInvalidRequest, err.Error())
+		return
+	}
+	quotes, err := listAPIQuotes(query)
+	if err != nil {
+		writeAPIInternalError(w, r, "liste des devis", err)
+		return
+	}
+	writeAPIJSON(w, http.StatusOK, newAPIPage(quotes, query.Limit, func(quote APIQuote) int { return quote.ID }))
+}
+
+// GET et PATCH /api/v1/quotes/{id}
+func apiQuoteHandler(w http.ResponseWriter, r *http.Request) {
+	scope := APIScopeQuotesRead
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPatch:
+		scope = APIScopeQuotesWrite
+	default:
+		writeAPIMethodNotAllowed(w, http.MethodGet, http.MethodPatch)
+		return
+	}
+	token, ok := apiAuth(w, r, scope)
+	if !ok {
+		return
+	}
+
+	quoteID, ok := apiPathID(w, r)
+	if !ok {
+		return
+	}
+	quote, err := GetQuoteByID(quoteID)
+	if err != nil {
+		writeAPIInternalError(w, r, fmt.Sprintf("devis %d", quoteID), err)
+		return
+	}
+	if quote == nil {
+		writeAPIError(w, http.StatusNotFound, APIErrorNotFound, "Devis introuvable")
+		return
+	}
+
+	if r.Method == http.MethodPatch {
+		var update APIQuoteUpdate
+		if !decodeAPIBody(w, r, &update) {
+			return
+		}
+		if !applyAPIQuoteUpdate(w, r, token, quote, update) {
+			return
+		}
+		if quote, err = GetQuoteByID(quoteID); err != nil || quote == nil {
+			writeAPIInternalError(w, r, fmt.Sprintf("relecture devis %d", quoteID), err)
+			return
+		}
+	}
+	writeAPIJSON(w, http.StatusOK, newAPIQuote(quote))
+}
+
+// applyAPIQuoteUpdate applique les règles de la fiche devis de l'admin : motif obligatoire pour un refus,
+// au moins une ligne chiffrée pour envoyer le devis, attribution à un membre de ADMIN_STAFF.
+// Statut et attribution changent dans la même transaction
+func applyAPIQuoteUpdate(w http.ResponseWriter, r *http.Request, token *APIToken, quote *QuoteRecord, update APIQuoteUpdate) bool {
+	if update.Status == nil && update.AssignedTo == nil && update.RejectionReason == nil {
+		writeAPIError(w, http.StatusBadRequest, APIErrorInvalidRequest, "Aucun champ à modifier")
+		return false
+	}
+
+	reason := ""
+	if update.Status != nil {
+		if !isValidQuoteStatus(*update.Status) {
+			writeAPIInvalidField(w, "status", "Statut inconnu : "+*update.Status)
+			return false
+		}
+		if update.RejectionReason != nil {
+			reason = strings.TrimSpace(*update.RejectionReason)
+		}
+		if *update.Status == QuoteStatusRejected && reason == "" {
+			writeAPIInvalidField(w, "rejection_reason", "Le motif du refus est obligatoire : il est envoyé au client")
+			return false
+		}
+		if *update.Status == QuoteStatusSent {
+			lines, err := listQuoteLines(quote.ID)
+			if err != nil {
+				writeAPIInternalError(w, r, fmt.Sprintf("lignes devis %d", quote.ID), err)
+				return false
+			}
+			if len(lines) == 0 {
+				writeAPIError(w, http.StatusConflict, APIErrorConflict, "Le devis n'a aucune ligne chiffrée : il ne peut pas être envoyé")
+				return false
+			}
+		}
+	}
+	if update.RejectionReason != nil && (update.Status == nil || *update.Status != QuoteStatusRejected) {
+		writeAPIInvalidField(w, "rejection_reason", `rejection_reason n'est accepté qu'avec status "rejected"`)
+		return false
+	}
+	staff := ""
+	if update.AssignedTo != nil {
+		staff = strings.TrimSpace(*update.AssignedTo)
+		if staff != "" && !isAdminStaff(staff) {
+			writeAPIInvalidField(w, "assigned_to", "Membre de l'équipe inconnu (ADMIN_STAFF)")
+			return false
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		writeAPIInternalError(w, r, fmt.Sprintf("modification devis %d", quote.ID), err)
+		return false
+	}
+	defer tx.Rollback()
+
+	notified := false
+	if update.Status != nil {
+		if notified, err = updateQuoteStatusTx(r.Context(), tx, quote, *update.Status, reason); err != nil {
+			writeAPIInternalError(w, r, fmt.Sprintf("statut devis %d", quote.ID), err)
+			return false
+		}
+	}
+	if update.AssignedTo != nil {
+		if err := assignQuoteTx(tx, quote.ID, staff); err != nil {
+			writeAPIInternalError(w, r, fmt.Sprintf("attribution devis %d", quote.ID), err)
+			return false
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		writeAPIInternalError(w, r, fmt.Sprintf("modification devis %d", quote.ID), err)
+		return false
+	}
+	if notified {
+		notifyOutbox()
+	}
+	notifyWebhooks()
+
+	target := fmt.Sprintf("quote:%d", quote.ID)
+	if update.Status != nil {
+		after := map[string]any{"status": *update.Status, "rejection_reason": quote.RejectionReason}
+		if *update.Status == QuoteStatusRejected {
+			after["rejection_reason"] = reason
+		}
+		auditAPIAction(r, token, AuditActionQuoteStatus, target, auditDiff(map[string]any{"status": quote.Status, "rejection_reason": quote.RejectionReason}, after))
+	}
+	if update.AssignedTo != nil {
+		auditAPIAction(r, token, AuditActionQuoteAssign, target, auditDiff(map[string]any{"assigned_to": quote.AssignedTo}, map[string]any{"assigned_to": staff}))
+	}
+	return true
+}
+
+// GET /api/v1/orders?status=&limit=&cursor=
+func apiOrdersHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeAPIMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+	if _, ok := apiAuth(w, r, APIScopeOrdersRead); !ok {
+		return
+	}
+
+	query, err := parseAPIListQuery(r, func(status string) bool { _, ok := orderStatusLabels[status]; return ok })
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, APIErrorInvalidRequest, err.Error())
+		return
+	}
+	orders, err := listAPIOrders(query)
+	if err != nil {
+		writeAPIInternalError(w, r, "liste des commandes", err)
+		return
+	}
+	writeAPIJSON(w, http.StatusOK, newAPIPage(orders, query.Limit, func(order APIOrder) int { return order.ID }))
+}
+
+// GET et PATCH /api/v1/orders/{id}
+func apiOrderHandler(w http.ResponseWriter, r *http.Request) {
+	scope := APIScopeOrdersRead
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPatch:
+		scope = APIScopeOrdersWrite
+	default:
+		writeAPIMethodNotAllowed(w, http.MethodGet, http.MethodPatch)
+		return
+	}
+	token, ok := apiAuth(w, r, scope)
+	if !ok {
+		return
+	}
+
+	orderID, ok := apiPathID(w, r)
+	if !ok {
+		return
+	}
+	order, err := GetOrderByID(orderID)
+	if err != nil {
+		writeAPIInternalError(w, r, fmt.Sprintf("commande %d", orderID), err)
+		return
+	}
+	if order == nil {
+		writeAPIError(w, http.StatusNotFound, APIErrorNotFound, "Commande introuvable")
+		return
+	}
+
+	if r.Method == http.MethodPatch {
+		var update APIOrderUpdate
+		if !decodeAPIBody(w, r, &update) {
+			return
+		}
+		if !applyAPIOrderUpdate(w, r, token, order, update) {
+			return
+		}
+		if order, err = GetOrderByID(orderID); err != nil || order == nil {
+			writeAPIInternalError(w, r, fmt.Sprintf("relecture commande %d", orderID), err)
+			return
+		}
+	}
+	writeAPIJSON(w, http.StatusOK, newAPIOrder(order))
+}
+
+// applyAPIOrderUpdate fait avancer la commande d'une étape et corrige sa date de livraison prévue,
+// comme la fiche commande de l'admin
+func applyAPIOrderUpdate(w http.ResponseWriter, r *http.Request, token *APIToken, order *Order, update APIOrderUpdate) bool {
+	if update.Status == nil && update.ExpectedDeliveryAt == nil {
+		writeAPIError(w, http.StatusBadRequest, APIErrorInvalidRequest, "Aucun champ à modifier")
+		return false
+	}
+
+	var expected time.Time
+	if update.ExpectedDeliveryAt != nil {
+		var err error
+		if expected, err = time.ParseInLocation("2006-01-02", *update.ExpectedDeliveryAt, documentLocation); err != nil {
+			writeAPIInvalidField(w, "expected_delivery_at", "Date invalide, format attendu AAAA-MM-JJ")
+			return false
+		}
+	}
+	if update.Status != nil {
+		if _, ok := orderStatusLabels[*update.Status]; !ok {
+			writeAPIInvalidField(w, "status", "Statut inconnu : "+*update.Status)
+			return false
+		}
+		if next := nextOrderStatus(order.Status); *update.Status != next && *update.Status != order.Status {
+			message := fmt.Sprintf("La commande est %q : seule l'étape suivante %q est possible", order.Status, next)
+			if next == "" {
+				message = "La commande est déjà livrée"
+			}
+			writeAPIError(w, http.StatusConflict, APIErrorConflict, message)
+			return false
+		}
+	}
+
+	target := fmt.Sprintf("order:%d", order.ID)
+	if update.ExpectedDeliveryAt != nil {
+		if err := updateOrderExpectedDelivery(order.ID, expected); err != nil {
+			writeAPIInternalError(w, r, fmt.Sprintf("date de livraison commande %d", order.ID), err)
+			return false
+		}
+		auditAPIAction(r, token, AuditActionOrderDelivery, target,
+			auditDiff(map[string]any{"expected_delivery": order.ExpectedDeliveryAt.Format("2006-01-02")}, map[string]any{"expected_delivery": expected.Format("2006-01-02")}))
+	}
+	if update.Status != nil && *update.Status != order.Status {
+		status, err := advanceOrderStatus(order)
+		if errors.Is(err, errOrderStatusChanged) {
+			writeAPIError(w, http.StatusConflict, APIErrorConflict, err.Error())
+			return false
+		}
+		if err != nil {
+			writeAPIInternalError(w, r, fmt.Sprintf("avancement commande %d", order.ID), err)
+			return false
+		}
+		auditAPIAction(r, token, AuditActionOrderAdvance, target, auditDiff(map[string]any{"status": order.Status}, map[string]any{"status": status}))
+	}
+	return true
+}
+
+// GET /api/v1/products : le catalogue tient sur une page
+func apiProductsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeAPIMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+	if _, ok := apiAuth(w, r, APIScopeProductsRead); !ok {
+		return
+	}
+
+	catalog := make([]APIProduct, 0, len(products))
+	for _, product := range products {
+		catalog = append(catalog, newAPIProduct(product))
+	}
+	writeAPIJSON(w, http.StatusOK, APIPage[APIProduct]{Data: catalog})
+}
+
+// GET /api/v1/products/{slug}
+func apiProductHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeAPIMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+	if _, ok := apiAuth(w, r, APIScopeProductsRead); !ok {
+		return
+	}
+
+	for _, product := range products {
+		if product.Slug == r.PathValue("slug") {
+			writeAPIJSON(w, http.StatusOK, newAPIProduct(product))
+			return
+		}
+	}
+	writeAPIError(w, http.StatusNotFound, APIErrorNotFound, "Produit introuvable")
+}
+
+// GET /api/v1/users?limit=&cursor=
+func apiUsersHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeAPIMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+	if _, ok := apiAuth(w, r, APIScopeUsersRead); !ok {
+		return
+	}
+
+	query, err := parseAPIListQuery(r, nil)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, APIErrorInvalidRequest, err.Error())
+		return
+	}
+	users, err := listAPIUsers(query)
+	if err != nil {
+		writeAPIInternalError(w, r, "liste des comptes", err)
+		return
+	}
+	writeAPIJSON(w, http.StatusOK, newAPIPage(users, query.Limit, func(user APIUser) int { return user.ID }))
+}
+
+// GET /api/v1/users/{id}
+func apiUserHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeAPIMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+	if _, ok := apiAuth(w, r, APIScopeUsersRead); !ok {
+		return
+	}
+
+	userID, ok := apiPathID(w, r)
+	if !ok {
+		return
+	}
+	user, err := getAPIUser(userID)
+	if err != nil {
+		writeAPIInternalError(w, r, fmt.Sprintf("compte %d", userID), err)
+		return
+	}
+	if user == nil {
+		writeAPIError(w, http.StatusNotFound, APIErrorNotFound, "Compte introuvable")
+		return
+	}
+	writeAPIJSON(w, http.StatusOK, user)
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Nombre maximal de fichiers par envoi
+const attachmentMaxFiles = 5
+
+// Types acceptés, reconnus sur le contenu du fichier (http.DetectContentType) et non sur son extension
+var attachmentContentTypes = map[string]bool{
+	"image/jpeg":      true,
+	"image/png":       true,
+	"image/gif":       true,
+	"image/webp":      true,
+	"application/pdf": true,
+}
+
+var (
+	errAttachmentCount   = fmt.Errorf("%d fichiers maximum par envoi", attachmentMaxFiles)
+	errAttachmentTooBig  = errors.New("fichier trop volumineux")
+	errAttachmentType    = errors.New("type de fichier non accepté (photos JPEG, PNG, GIF, WebP ou PDF)")
+	errAttachmentStorage = errors.New("stockage des fichiers non configuré")
+)
+
+// attachmentMaxBytes est la taille maximale d'un fichier (ATTACHMENT_MAX_MB, défaut 10 Mo)
+func attachmentMaxBytes() int64 {
+	if value, err := strconv.Atoi(getEnv("ATTACHMENT_MAX_MB", "")); err == nil && value > 0 {
+		return int64(value) << 20
+	}
+	return 10 << 20
+}
+
+// Attachment est un fichier joint à une demande de devis ou à un message du fil
+type Attachment struct {
+	ID          int
+	QuoteID     int
+	MessageID   int
+	Filename    string
+	ContentType string
+	SizeBytes   int64
+	StorageKey  string
+	UploadedBy  string
+	CreatedAt   time.Time
+}
+
+// IsImage indique une photo, affichable en vignette
+func (a Attachment) IsImage() bool {
+	return strings.HasPrefix(a.ContentType, "image/")
+}
+
+// SizeLabel renvoie la taille lisible du fichier ("350 Ko", "2,4 Mo")
+func (a Attachment) SizeLabel() string {
+	if a.SizeBytes < 1<<20 {
+		return fmt.Sprintf("%d Ko", (a.SizeBytes+1023)/1024)
+	}
+	return strings.Replace(fmt.Sprintf("%.1f Mo", float64(a.SizeBytes)/(1<<20)), ".", ",", 1)
+}
+
+// storedAttachment est un fichier validé et écrit dans le BlobStore, pas encore rattaché en base
+type storedAttachment struct {
+	Filename    string
+	ContentType string
+	SizeBytes   int64
+	StorageKey  string
+}
+
+// sanitizeFilename garde le nom de base du fichier, sans caractères de contrôle ni guillemets
+func sanitizeFilename(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || r == '"' || r == '/' {
+			return -1
+		}
+		return r
+	}, name)
+	if runes := []rune(name); len(runes) > 100 {
+		extension := filepath.Ext(name)
+		name = string(runes[:100-len([]rune(extension))]) + extension
+	}
+	if name == "" || name == "." {
+		return "fichier"
+	}
+	return name
+}
+
+// parseAttachmentForm analyse un formulaire multipart en bornant la taille totale de la requête ;
+// un formulaire classique, sans fichier, est accepté tel quel
+func parseAttachmentForm(w http.ResponseWriter, r *http.Request) error {
+	limit := attachmentMaxFiles*attachmentMaxBytes() + 1<<20
+	r.Body = http.MaxBytesReader(w, r.Body, limit)
+	if err := r.ParseMultipartForm(8 << 20); err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		return err
+	}
+	return nil
+}
+
+// storeUploadedAttachments valide les fichiers du champ "attachments" (nombre, taille, type détecté sur
+// le contenu) et les écrit dans le BlobStore. En cas d'erreur, les fichiers déjà écrits sont supprimés
+func storeUploadedAttachments(form *multipart.Form) ([]storedAttachment, error) {
+	if form == nil {
+		return nil, nil
+	}
+	headers := make([]*multipart.FileHeader, 0)
+	for _, header := range form.File["attachments"] {
+		if header.Filename != "" && header.Size > 0 {
+			headers = append(headers, header)
+		}
+	}
+	if len(headers) == 0 {
+		return nil, nil
+	}
+	if len(headers) > attachmentMaxFiles {
+		return nil, errAttachmentCount
+	}
+	if blobStore == nil {
+		return nil, errAttachmentStorage
+	}
+
+	stored := make([]storedAttachment, 0, len(headers))
+	for _, header := range headers {
+		attachment, err := storeAttachment(header)
+		if err != nil {
+			discardAttachments(stored)
+			return nil, err
+		}
+		stored = append(stored, attachment)
+	}
+	return stored, nil
+}
+
+func storeAttachment(header *multipart.FileHeader) (storedAttachment, error) {
+	filename := sanitizeFilename(header.Filename)
+	if header.Size > attachmentMaxBytes() {
+		return storedAttachment{}, fmt.Errorf("%w : %s (%d Mo maximum)", errAttachmentTooBig, filename, attachmentMaxBytes()>>20)
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		return storedAttachment{}, err
+	}
+	defer file.Close()
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return storedAttachment{}, err
+	}
+	head = head[:n]
+	contentType, _, _ := mime.ParseMediaType(http.DetectContentType(head))
+	if !attachmentContentTypes[contentType] {
+		return storedAttachment{}, fmt.Errorf("%w : %s", errAttachmentType, filename)
+	}
+
+	key := newBlobKey()
+	if err := blobStore.Put(key, io.MultiReader(bytes.NewReader(head), file)); err != nil {
+		return storedAttachment{}, fmt.Errorf("erreur stockage %s: %v", filename, err)
+	}
+	return storedAttachment{Filename: filename, ContentType: contentType, SizeBytes: header.Size, StorageKey: key}, nil
+}
+
+// discardAttachments supprime du BlobStore des fichiers qui ne seront pas rattachés en base
+func discardAttachments(stored []storedAttachment) {
+	for _, attachment := range stored {
+		if err := blobStore.Delete(attachment.StorageKey); err != nil {
+			slog.Error("Erreur suppression fichier", "storage_key", attachment.StorageKey, "err", err)
+		}
+	}
+}
+
+// isAttachmentRejection distingue un fichier refusé (à signaler au client) d'une erreur serveur
+func isAttachmentRejection(err error) bool {
+	return errors.Is(err, errAttachmentCount) || errors.Is(err, errAttachmentTooBig) || errors.Is(err, errAttachmentType)
+}
+
+// insertAttachments rattache des fichiers stockés à un devis et, si messageID > 0, à un message du fil
+func insertAttachments(tx *sql.Tx, quoteID, messageID int, uploadedBy string, stored []storedAttachment) error {
+	var message any
+	if messageID > 0 {
+		message = messageID
+	}
+	for _, attachment := range stored {
+		if _, err := tx.Exec(
+			rebindQuery("INSERT INTO attachments (quote_id, message_id, filename, content_type, size_bytes, storage_key, uploaded_by, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)"),
+			quoteID, message, attachment.Filename, attachment.ContentType, attachment.SizeBytes, attachment.StorageKey, uploadedBy, time.Now(),
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+const attachmentColumns = "id, quote_id, message_id, filename, content_type, size_bytes, storage_key, uploaded_by, created_at"
+
+func scanAttachment(scanner interface{ Scan(...any) error }) (*Attachment, error) {
+	attachment := &Attachment{}
+	var messageID sql.NullInt64
+	var createdAt sql.NullTime
+	if err := scanner.Scan(&attachment.ID, &attachment.QuoteID, &messageID, &attachment.Filename, &attachment.ContentType, &attachment.SizeBytes, &attachment.StorageKey, &attachment.UploadedBy, &createdAt); err != nil {
+		return nil, err
+	}
+	attachment.MessageID = int(messageID.Int64)
+	if createdAt.Valid {
+		attachment.CreatedAt = createdAt.Time
+	}
+	return attachment, nil
+}
+
+// GetAttachmentByID récupère une pièce jointe, ou nil si elle n'existe pas
+func GetAttachmentByID(attachmentID int) (*Attachment, error) {
+	if db == nil {
+		return nil, fmt.Errorf("base de données non configurée")
+	}
+
+	attachment, err := scanAttachment(db.QueryRow(
+		rebindQuery("SELECT "+attachmentColumns+" FROM attachments WHERE id = ?"),
+		attachmentID,
+	))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return attachment, err
+}
+
+// listQuoteAttachments renvoie les pièces jointes d'un devis et de ses messages, par ordre d'envoi
+func listQuoteAttachments(quoteID int) ([]Attachment, error) {
+	if db == nil {
+		return nil, fmt.Errorf("base de données non configurée")
+	}
+
+	rows, err := db.Query(
+		rebindQuery("SELECT "+attachmentColumns+" FROM attachments WHERE quote_id = ? ORDER BY created_at, id"),
+		quoteID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attachments := make([]Attachment, 0)
+	for rows.Next() {
+		attachment, err := scanAttachment(rows)
+		if err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, *attachment)
+	}
+	return attachments, rows.Err()
+}
+
+// splitAttachments sépare les fichiers de la demande initiale de ceux des messages, rangés dans le fil
+func splitAttachments(attachments []Attachment, messages []QuoteMessage) []Attachment {
+	byMessage := make(map[int][]Attachment)
+	quoteAttachments := make([]Attachment, 0)
+	for _, attachment := range attachments {
+		if attachment.MessageID > 0 {
+			byMessage[attachment.MessageID] = append(byMessage[attachment.MessageID], attachment)
+		} else {
+			quoteAttachments = append(quoteAttachments, attachment)
+		}
+	}
+	for i := range messages {
+		messages[i].Attachments = byMessage[messages[i].ID]
+	}
+	return quoteAttachments
+}
+
+// attachmentHandler sert une pièce jointe au client propriétaire du devis ou à l'admin
+func attachmentHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	attachmentID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || attachmentID <= 0 {
+		http.Error(w, "ID fichier invalide", http.StatusBadRequest)
+		return
+	}
+
+	// Le client du devis y a accès ; sinon l'accès passe par l'authentification admin, demandée
+	// avant toute lecture quand la requête n'a pas de session client valide
+	user := GetUserFromSession(r)
+	if user == nil && !requireAdminAuth(w, r) {
+		return
+	}
+
+	attachment, err := GetAttachmentByID(attachmentID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Erreur récupération fichier", "attachment_id", attachmentID, "err", err)
+		http.Error(w, "Erreur récupération du fichier", http.StatusInternalServerError)
+		return
+	}
+	if attachment == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	if user != nil {
+		quote, err := GetQuoteByID(attachment.QuoteID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Erreur récupération devis", "quote_id", attachment.QuoteID, "err", err)
+			http.Error(w, "Erreur récupération du fichier", http.StatusInternalServerError)
+			return
+		}
+		if !quoteBelongsToUser(quote, user) && !requireAdminAuth(w, r) {
+			return
+		}
+	}
+
+	content, err := blobStore.Get(attachment.StorageKey)
+	if errors.Is(err, errBlobNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Erreur lecture fichier", "attachment_id", attachment.ID, "err", err)
+		http.Error(w, "Erreur récupération du fichier", http.StatusInternalServerError)
+		return
+	}
+	defer content.Close()
+
+	// Le type servi est celui détecté à l'envoi ; nosniff et sandbox empêchent l'exécution d'un contenu piégé
+	w.Header().Set("Content-Type", attachment.ContentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(attachment.SizeBytes, 10))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": attachment.Filename}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "sandbox")
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	if _, err := io.Copy(w, content); err != nil {
+		slog.ErrorContext(r.Context(), "Erreur envoi fichier", "attachment_id", attachment.ID, "err", err)
+	}
+}
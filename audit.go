@@ -0,0 +1,448 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Actions enregistrées dans le journal d'audit
+const (
+	AuditActionLogin              = "login"
+	AuditActionLoginFailed        = "login.failed"
+	AuditActionExport             = "export"
+	AuditActionUserDelete         = "user.delete"
+	AuditActionUserRestore        = "user.restore"
+	AuditActionImpersonationStart = "impersonation.start"
+	AuditActionImpersonationStop  = "impersonation.stop"
+	AuditActionQuoteDelete        = "quote.delete"
+	AuditActionQuoteRestore       = "quote.restore"
+	AuditActionTrashPurge         = "trash.purge"
+	AuditActionQuoteStatus        = "quote.status"
+	AuditActionQuoteAssign        = "quote.assign"
+	AuditActionQuoteLineAdd       = "quote.line.add"
+	AuditActionQuoteLineUpdate    = "quote.line.update"
+	AuditActionQuoteLineDelete    = "quote.line.delete"
+	AuditActionQuoteNote          = "quote.note"
+	AuditActionQuoteMessage       = "quote.message"
+	AuditActionQuoteConvert       = "quote.convert"
+	AuditActionOrderAdvance       = "order.advance"
+	AuditActionOrderDelivery      = "order.delivery_date"
+	AuditActionInvoiceIssue       = "invoice.issue"
+	AuditActionInvoicePayment     = "invoice.payment"
+	AuditActionPaymentRefund      = "payment.refund"
+	AuditActionEmailResend        = "email.resend"
+	AuditActionAPITokenCreate     = "api_token.create"
+	AuditActionAPITokenRevoke     = "api_token.revoke"
+	AuditActionWebhookCreate      = "webhook.create"
+	AuditActionWebhookUpdate      = "webhook.update"
+	AuditActionWebhookDelete      = "webhook.delete"
+	AuditActionWebhookTest        = "webhook.test"
+	AuditActionWebhookRedeliver   = "webhook.redeliver"
+)
+
+// auditActions liste les actions dans l'ordre du filtre de la page journal
+var auditActions = []string{
+	AuditActionLogin, AuditActionLoginFailed, AuditActionExport, AuditActionUserDelete, AuditActionUserRestore,
+	AuditActionImpersonationStart, AuditActionImpersonationStop,
+	AuditActionQuoteDelete, AuditActionQuoteRestore, AuditActionTrashPurge,
+	AuditActionQuoteStatus, AuditActionQuoteAssign, AuditActionQuoteLineAdd, AuditActionQuoteLineUpdate,
+	AuditActionQuoteLineDelete, AuditActionQuoteNote, AuditActionQuoteMessage, AuditActionQuoteConvert,
+	AuditActionOrderAdvance, AuditActionOrderDelivery, AuditActionInvoiceIssue, AuditActionInvoicePayment,
+	AuditActionPaymentRefund, AuditActionEmailResend, AuditActionAPITokenCreate, AuditActionAPITokenRevoke,
+	AuditActionWebhookCreate, AuditActionWebhookUpdate, AuditActionWebhookDelete, AuditActionWebhookTest, AuditActionWebhookRedeliver,
+}
+
+var auditActionLabels = map[string]string{
+	AuditActionLogin:              "Connexion",
+	AuditActionLoginFailed:        "Connexion refusée",
+	AuditActionExport:             "Export",
+	AuditActionUserDelete:         "Suppression utilisateur",
+	AuditActionUserRestore:        "Restauration utilisateur",
+	AuditActionImpersonationStart: "Voir comme le client (début)",
+	AuditActionImpersonationStop:  "Voir comme le client (fin)",
+	AuditActionQuoteDelete:        "Suppression devis",
+	AuditActionQuoteRestore:       "Restauration devis",
+	AuditActionTrashPurge:         "Purge de la corbeille",
+	AuditActionQuoteStatus:        "Statut du devis",
+	AuditActionQuoteAssign:        "Attribution du devis",
+	AuditActionQuoteLineAdd:       "Ajout ligne de devis",
+	AuditActionQuoteLineUpdate:    "Modification ligne de devis",
+	AuditActionQuoteLineDelete:    "Suppression ligne de devis",
+	AuditActionQuoteNote:          "Note interne",
+	AuditActionQuoteMessage:       "Message au client",
+	AuditActionQuoteConvert:       "Conversion en commande",
+	AuditActionOrderAdvance:       "Avancement commande",
+	AuditActionOrderDelivery:      "Date de livraison",
+	AuditActionInvoiceIssue:       "Émission facture",
+	AuditActionInvoicePayment:     "Règlement facture",
+	AuditActionPaymentRefund:      "Remboursement",
+	AuditActionEmailResend:        "Renvoi email",
+	AuditActionAPITokenCreate:     "Création jeton d'API",
+	AuditActionAPITokenRevoke:     "Révocation jeton d'API",
+	AuditActionWebhookCreate:      "Création webhook",
+	AuditActionWebhookUpdate:      "Modification webhook",
+	AuditActionWebhookDelete:      "Suppression webhook",
+	AuditActionWebhookTest:        "Test webhook",
+	AuditActionWebhookRedeliver:   "Renvoi webhook",
+}
+
+func auditActionLabel(action string) string {
+	if label, ok := auditActionLabels[action]; ok {
+		return label
+	}
+	return action
+}
+
+// AuditEntry est une entrée du journal. Hash scelle l'entrée et PrevHash la relie à la précédente :
+// modifier ou supprimer une entrée rompt la chaîne
+type AuditEntry struct {
+	ID        int
+	Actor     string
+	Action    string
+	Target    string
+	IP        string
+	Details   string
+	PrevHash  string
+	Hash      string
+	CreatedAt time.Time
+}
+
+// adminActor renvoie l'identité de l'admin authentifié, pour le journal d'audit
+func adminActor(r *http.Request) string {
+	if username, _, ok := r.BasicAuth(); ok && username != "" {
+		return username
+	}
+	return "admin"
+}
+
+// clientIP renvoie l'adresse du client. Derrière un reverse proxy (TRUST_PROXY=1), c'est la dernière
+// adresse de X-Forwarded-For, celle ajoutée par le proxy : les précédentes sont fournies par le client
+func clientIP(r *http.Request) string {
+	if os.Getenv("TRUST_PROXY") == "1" {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			hops := strings.Split(forwarded, ",")
+			if ip := strings.TrimSpace(hops[len(hops)-1]); net.ParseIP(ip) != nil {
+				return ip
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// auditChange décrit, dans le diff JSON d'une entrée, la modification d'un champ
+type auditChange struct {
+	From any `json:"from"`
+	To   any `json:"to"`
+}
+
+// auditDiff ne garde que les champs qui diffèrent entre before et after
+func auditDiff(before, after map[string]any) map[string]any {
+	changes := make(map[string]auditChange)
+	for key, value := range after {
+		if !reflect.DeepEqual(before[key], value) {
+			changes[key] = auditChange{From: before[key], To: value}
+		}
+	}
+	for key, value := range before {
+		if _, ok := after[key]; !ok {
+			changes[key] = auditChange{From: value, To: nil}
+		}
+	}
+	return map[string]any{"diff": changes}
+}
+
+// auditCreated et auditDeleted décrivent un objet créé ou supprimé, pour les entrées sans état antérieur ou postérieur
+func auditCreated(snapshot map[string]any) map[string]any {
+	return map[string]any{"created": snapshot}
+}
+
+func auditDeleted(snapshot map[string]any) map[string]any {
+	return map[string]any{"deleted": snapshot}
+}
+
+func userAuditSnapshot(user *User) map[string]any {
+	return map[string]any{"id": user.ID, "email": user.Email, "nom": user.Nom, "prenom": user.Prenom}
+}
+
+func quoteLineAuditSnapshot(line QuoteLine) map[string]any {
+	return map[string]any{"label": line.Label, "quantity": line.Quantity, "unit_price": formatEuros(line.UnitPriceCents), "vat_rate": formatVATRate(line.VATRateBP)}
+}
+
+// auditEntryHash scelle une entrée avec l'empreinte de la précédente. Chaque champ est préfixé par
+// sa longueur pour qu'un déplacement de texte d'un champ à l'autre change l'empreinte
+func auditEntryHash(prevHash string, createdAt time.Time, actor, action, target, ip, details string) string {
+	hash := sha256.New()
+	for _, field := range []string{prevHash, createdAt.UTC().Format(time.RFC3339), actor, action, target, ip, details} {
+		fmt.Fprintf(hash, "%d:%s\n", len(field), field)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// auditMu sérialise les ajouts de ce processus ; le verrou SQL sur la dernière entrée couvre les autres instances
+var auditMu sync.Mutex
+
+// appendAuditEntry ajoute une entrée scellée en fin de chaîne
+func appendAuditEntry(actor, action, target, ip, details string) error {
+	if db == nil {
+		return fmt.Errorf("base de données non configurée")
+	}
+
+	auditMu.Lock()
+	defer auditMu.Unlock()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var prevHash string
+	err = tx.QueryRow("SELECT hash FROM audit_log ORDER BY id DESC LIMIT 1 FOR UPDATE").Scan(&prevHash)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	// Seconde entière en UTC : c'est ce que les deux bases restituent à la vérification
+	createdAt := time.Now().UTC().Truncate(time.Second)
+	hash := auditEntryHash(prevHash, createdAt, actor, action, target, ip, details)
+	_, err = tx.Exec(
+		rebindQuery("INSERT INTO audit_log (actor, action, target, ip, details, prev_hash, hash, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)"),
+		actor, action, target, ip, details, prevHash, hash, createdAt,
+	)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// recordAudit journalise une action de l'admin authentifié par r. target désigne l'objet concerné
+// ("quote:12", "users"…) et details, encodé en JSON, le contexte ou le diff de la modification
+func recordAudit(r *http.Request, action, target string, details any) error {
+	encoded := ""
+	if details != nil {
+		data, err := json.Marshal(details)
+		if err != nil {
+			return err
+		}
+		encoded = string(data)
+	}
+	return appendAuditEntry(adminActor(r), action, target, clientIP(r), encoded)
+}
+
+// auditAdminAction journalise une action déjà effectuée : un échec d'écriture du journal est signalé sans l'annuler
+func auditAdminAction(r *http.Request, action, target string, details any) {
+	if err := recordAudit(r, action, target, details); err != nil {
+		slog.WarnContext(r.Context(), "Journal d'audit non écrit", "action", action, "target", target, "err", err)
+	}
+}
+
+// Basic auth n'a pas de session : une connexion est journalisée au premier accès d'un admin depuis
+// une adresse, puis au plus une fois par fenêtre ; les échecs au plus une fois par minute et par adresse
+const (
+	adminLoginWindow        = 12 * time.Hour
+	adminLoginFailureWindow = time.Minute
+)
+
+var (
+	auditSeenMu sync.Mutex
+	auditSeen   = make(map[string]time.Time)
+)
+
+// auditDue indique si l'événement key n'a pas été journalisé depuis window, et le note comme journalisé
+func auditDue(key string, window time.Duration) bool {
+	auditSeenMu.Lock()
+	defer auditSeenMu.Unlock()
+
+	now := time.Now()
+	if last, ok := auditSeen[key]; ok && now.Sub(last) < window {
+		return false
+	}
+	if len(auditSeen) > 10000 {
+		for seenKey, seenAt := range auditSeen {
+			if now.Sub(seenAt) > adminLoginWindow {
+				delete(auditSeen, seenKey)
+			}
+		}
+	}
+	auditSeen[key] = now
+	return true
+}
+
+func auditAdminLogin(r *http.Request) {
+	if auditDue("login|"+adminActor(r)+"|"+clientIP(r), adminLoginWindow) {
+		auditAdminAction(r, AuditActionLogin, "admin", map[string]any{"user_agent": r.UserAgent()})
+	}
+}
+
+func auditAdminLoginFailure(r *http.Request, username string) {
+	if !auditDue("failure|"+clientIP(r), adminLoginFailureWindow) {
+		return
+	}
+	details, _ := json.Marshal(map[string]any{"username": username, "user_agent": r.UserAgent()})
+	if err := appendAuditEntry("anonyme", AuditActionLoginFailed, "admin", clientIP(r), string(details)); err != nil {
+		slog.WarnContext(r.Context(), "Journal d'audit non écrit", "action", AuditActionLoginFailed, "err", err)
+	}
+}
+
+// protectAuditLog interdit UPDATE et DELETE sur audit_log au niveau de la base. Sans les droits
+// nécessaires (triggers MySQL), l'application reste en ajout seul et la chaîne d'empreintes détecte les retouches
+func protectAuditLog() {
+	if dbDriver == "postgres" {
+		for _, statement := range []string{
+			"CREATE OR REPLACE RULE audit_log_no_update AS ON UPDATE TO audit_log DO INSTEAD NOTHING",
+			"CREATE OR REPLACE RULE audit_log_no_delete AS ON DELETE TO audit_log DO INSTEAD NOTHING",
+		} {
+			if _, err := db.Exec(statement); err != nil {
+				slog.Warn("Protection du journal d'audit impossible", "err", err)
+			}
+		}
+		return
+	}
+
+	for name, event := range map[string]string{"audit_log_no_update": "UPDATE", "audit_log_no_delete": "DELETE"} {
+		var count int
+		if err := db.QueryRow("SELECT COUNT(*) FROM information_schema.triggers WHERE trigger_schema = DATABASE() AND trigger_name = ?", name).Scan(&count); err != nil {
+			slog.Warn("Protection du journal d'audit impossible", "err", err)
+			return
+		}
+		if count > 0 {
+			continue
+		}
+		statement := fmt.Sprintf("CREATE TRIGGER %s BEFORE %s ON audit_log FOR EACH ROW SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'audit_log est en ajout seul'", name, event)
+		if _, err := db.Exec(statement); err != nil {
+			slog.Warn("Protection du journal d'audit impossible", "err", err)
+		}
+	}
+}
+
+const auditEntryColumns = "id, actor, action, target, ip, details, prev_hash, hash, created_at"
+
+func scanAuditEntry(rows *sql.Rows) (AuditEntry, error) {
+	var entry AuditEntry
+	var details sql.NullString
+	var createdAt sql.NullTime
+	if err := rows.Scan(&entry.ID, &entry.Actor, &entry.Action, &entry.Target, &entry.IP, &details, &entry.PrevHash, &entry.Hash, &createdAt); err != nil {
+		return entry, err
+	}
+	entry.Details = details.String
+	if createdAt.Valid {
+		entry.CreatedAt = createdAt.Time
+	}
+	return entry, nil
+}
+
+// adminAuditConditions traduit la recherche et les filtres du journal
+func adminAuditConditions(query AdminListQuery) sqlConditions {
+	var conditions sqlConditions
+	conditions.addSearch(query.Search, "actor", "action", "target", "ip", "details")
+	if query.Action != "" {
+		conditions.add("action = ?", query.Action)
+	}
+	conditions.addDateRange("created_at", query.From, query.To)
+	return conditions
+}
+
+var adminAuditSortColumns = map[string]string{
+	"created_at": "created_at",
+	"actor":      "actor",
+	"action":     "action",
+	"target":     "target",
+}
+
+// listAuditEntries renvoie la page demandée du journal et le nombre total d'entrées filtrées
+func listAuditEntries(query AdminListQuery) ([]AuditEntry, int, error) {
+	if db == nil {
+		return nil, 0, fmt.Errorf("base de données non configurée")
+	}
+
+	conditions := adminAuditConditions(query)
+	var total int
+	if err := db.QueryRow(rebindQuery("SELECT COUNT(*) FROM audit_log"+conditions.where()), conditions.args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	args := append(conditions.args, query.PageSize, query.Offset())
+	rows, err := db.Query(rebindQuery("SELECT "+auditEntryColumns+" FROM audit_log"+conditions.where()+orderByClause(query, adminAuditSortColumns)+" LIMIT ? OFFSET ?"), args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	entries := make([]AuditEntry, 0)
+	for rows.Next() {
+		entry, err := scanAuditEntry(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, total, rows.Err()
+}
+
+// AuditVerification est le résultat du contrôle de la chaîne d'empreintes
+type AuditVerification struct {
+	Checked int
+	// Unsealed compte les entrées antérieures au scellement, en tête de journal
+	Unsealed int
+	// BrokenID est la première entrée dont l'empreinte ou le lien ne correspond pas ; zéro si la chaîne est intègre
+	BrokenID int
+	Reason   string
+}
+
+// verifyAuditChain recalcule toute la chaîne, dans l'ordre des identifiants. Une entrée modifiée ne
+// correspond plus à son empreinte ; une entrée supprimée casse le lien de la suivante
+func verifyAuditChain() (*AuditVerification, error) {
+	if db == nil {
+		return nil, fmt.Errorf("base de données non configurée")
+	}
+
+	rows, err := db.Query("SELECT " + auditEntryColumns + " FROM audit_log ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := &AuditVerification{}
+	prevHash := ""
+	sealed := false
+	for rows.Next() {
+		entry, err := scanAuditEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		if entry.Hash == "" && !sealed {
+			result.Unsealed++
+			continue
+		}
+		sealed = true
+		result.Checked++
+		switch {
+		case entry.Hash == "":
+			result.BrokenID, result.Reason = entry.ID, "empreinte effacée"
+		case entry.PrevHash != prevHash:
+			result.BrokenID, result.Reason = entry.ID, "lien rompu avec l'entrée précédente (entrée supprimée ou réordonnée)"
+		case auditEntryHash(entry.PrevHash, entry.CreatedAt, entry.Actor, entry.Action, entry.Target, entry.IP, entry.Details) != entry.Hash:
+			result.BrokenID, result.Reason = entry.ID, "contenu modifié après écriture"
+		}
+		if result.BrokenID != 0 {
+			return result, nil
+		}
+		prevHash = entry.Hash
+	}
+	return result, rows.Err()
+}
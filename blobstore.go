@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// BlobStore stocke le contenu des pièces jointes, désigné par une clé opaque
+type BlobStore interface {
+	Name() string
+	Put(key string, content io.Reader) error
+	Get(key string) (io.ReadCloser, error)
+	Delete(key string) error
+}
+
+var errBlobNotFound = errors.New("fichier introuvable")
+
+// Stockage actif, choisi au démarrage par initBlobStore
+var blobStore BlobStore
+
+// initBlobStore choisit le stockage d'après BLOB_STORE ; seul "local" (défaut) est disponible,
+// dans le dossier ATTACHMENTS_DIR (défaut var/attachments)
+func initBlobStore() {
+	switch name := getEnv("BLOB_STORE", "local"); name {
+	case "local":
+		blobStore = &localBlobStore{dir: getEnv("ATTACHMENTS_DIR", filepath.Join("var", "attachments"))}
+	default:
+		slog.Warn("Stockage de fichiers inconnu, stockage local utilisé", "blob_store", name)
+		blobStore = &localBlobStore{dir: filepath.Join("var", "attachments")}
+	}
+}
+
+// newBlobKey tire une clé aléatoire, répartie en sous-dossiers par ses deux premiers caractères
+func newBlobKey() string {
+	key := randomHex(16)
+	return key[:2] + "/" + key
+}
+
+// localBlobStore range les fichiers sur le disque local
+type localBlobStore struct {
+	dir string
+}
+
+func (s *localBlobStore) Name() string { return "local" }
+
+// path refuse toute clé qui sortirait du dossier de stockage
+func (s *localBlobStore) path(key string) (string, error) {
+	if key == "" || strings.Contains(key, "..") || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return "", fmt.Errorf("clé de fichier invalide: %q", key)
+	}
+	return filepath.Join(s.dir, filepath.FromSlash(key)), nil
+}
+
+// Put écrit le fichier dans un temporaire puis le renomme : un fichier visible est toujours complet
+func (s *localBlobStore) Put(key string, content io.Reader) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(tmp, content); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+func (s *localBlobStore) Get(key string) (io.ReadCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, errBlobNotFound
+	}
+	return file, err
+}
+
+func (s *localBlobStore) Delete(key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// bulkMaxItems borne la taille d'une sélection : une page de liste fait au plus 100 lignes
+const bulkMaxItems = 500
+
+// bulkRefusal écarte un élément d'une action groupée sans annuler le reste du lot
+type bulkRefusal string
+
+func (r bulkRefusal) Error() string {
+	return string(r)
+}
+
+// bulkOutcome est le résultat d'une action groupée pour un élément
+type bulkOutcome struct {
+	ID    int
+	Label string
+	Link  string
+	Done  bool
+	// Message explique pourquoi l'élément a été écarté, ou ce qui a été fait
+	Message string
+	// Entrée du journal d'audit, écrite une fois le lot validé
+	AuditAction  string
+	AuditDetails map[string]any
+}
+
+// bulkStep applique une action à un élément dans la transaction du lot. Elle renvoie une bulkRefusal
+// avant toute écriture pour écarter l'élément ; toute autre erreur annule le lot entier
+type bulkStep func(tx *sql.Tx, id int, outcome *bulkOutcome) error
+
+// parseBulkIDs lit les identifiants d'une sélection (paramètre ids répété), sans doublon
+func parseBulkIDs(values []string) ([]int, error) {
+	if len(values) > bulkMaxItems {
+		return nil, fmt.Errorf("sélection trop grande : %d éléments au plus", bulkMaxItems)
+	}
+	ids := make([]int, 0, len(values))
+	seen := make(map[int]bool, len(values))
+	for _, value := range values {
+		id, err := strconv.Atoi(value)
+		if err != nil || id <= 0 {
+			return nil, fmt.Errorf("identifiant invalide: %q", value)
+		}
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// runBulk applique step à chaque élément dans une seule transaction. Les éléments écartés sont
+// signalés dans leur résultat ; en cas d'erreur, rien n'est appliqué et aucun résultat n'est Done
+func runBulk(ids []int, step bulkStep) ([]bulkOutcome, error) {
+	outcomes := make([]bulkOutcome, len(ids))
+	for i, id := range ids {
+		outcomes[i].ID = id
+	}
+	if db == nil {
+		return cancelBulk(outcomes), fmt.Errorf("base de données non configurée")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return cancelBulk(outcomes), err
+	}
+	defer tx.Rollback()
+
+	for i := range outcomes {
+		err := step(tx, outcomes[i].ID, &outcomes[i])
+		var refusal bulkRefusal
+		if errors.As(err, &refusal) {
+			outcomes[i].Message = refusal.Error()
+			continue
+		}
+		if err != nil {
+			return cancelBulk(outcomes), fmt.Errorf("élément %d: %v", outcomes[i].ID, err)
+		}
+		outcomes[i].Done = true
+	}
+
+	if err := tx.Commit(); err != nil {
+		return cancelBulk(outcomes), err
+	}
+	return outcomes, nil
+}
+
+// cancelBulk marque comme annulés les éléments d'un lot dont la transaction n'a pas abouti
+func cancelBulk(outcomes []bulkOutcome) []bulkOutcome {
+	for i := range outcomes {
+		if outcomes[i].Done || outcomes[i].Message == "" {
+			outcomes[i].Done = false
+			outcomes[i].Message = "annulé : le lot n'a pas pu être appliqué"
+		}
+	}
+	return outcomes
+}
@@ -0,0 +1,501 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// PageData alimente les templates partagés header.html / footer.html
+type PageData struct {
+	Title     string
+	Username  string
+	ExtraCSS  template.HTML
+	ExtraData map[string]interface{}
+}
+
+// renderPage exécute un template de templates/ avec l'en-tête et le pied de page du site
+func renderPage(w http.ResponseWriter, name string, data PageData) {
+	tmpl, err := template.New(name).Funcs(template.FuncMap{
+		"euros":       formatEuros,
+		"statusLabel": quoteStatusLabel,
+		"le":          func(a, b int) bool { return a <= b },
+	}).ParseFiles(
+		filepath.Join("templates", "header.html"),
+		filepath.Join("templates", "footer.html"),
+		filepath.Join("templates", name),
+	)
+	if err != nil {
+		slog.Error("Erreur chargement template", "name", name, "err", err)
+		http.Error(w, "Erreur affichage page", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := tmpl.ExecuteTemplate(w, name, data); err != nil {
+		slog.Error("Erreur rendu template", "name", name, "err", err)
+	}
+}
+
+// CustomerQuoteEntry est un devis tel qu'affiché sur la page "Mes devis"
+type CustomerQuoteEntry struct {
+	ID            int
+	Number        string
+	Produit       string
+	Configuration string
+	Message       string
+	Status        string
+	// RejectionReason est le motif affiché sur un devis refusé
+	RejectionReason string
+	CreatedAt       string
+	TotalTTCCents   int64
+	HasPDF          bool
+	DepositCents    int64
+}
+
+// CustomerOrderEntry est une commande et son avancement tels qu'affichés au client
+type CustomerOrderEntry struct {
+	Reference        string
+	Produit          string
+	StatusLabel      string
+	Step             int
+	Steps            []string
+	TotalTTCCents    int64
+	ExpectedDelivery string
+	DeliveredAt      string
+	Invoices         []CustomerInvoiceEntry
+}
+
+// CustomerInvoiceEntry est une facture de commande telle qu'affichée au client
+type CustomerInvoiceEntry struct {
+	ID               int
+	Number           string
+	Label            string
+	AmountDueCents   int64
+	OutstandingCents int64
+	DueAt            string
+	StatusLabel      string
+	Paid             bool
+	CanPay           bool
+}
+
+func mesDevisHandler(w http.ResponseWriter, r *http.Request) {
+	user := GetUserFromSession(r)
+	if user == nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	quotes, err := listQuotesForUser(user)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Erreur récupération devis du client", "user_id", user.ID, "err", err)
+		http.Error(w, "Erreur récupération devis", http.StatusInternalServerError)
+		return
+	}
+
+	entries := make([]CustomerQuoteEntry, 0, len(quotes))
+	for _, quote := range quotes {
+		entry := CustomerQuoteEntry{
+			ID:              quote.ID,
+			Number:          quoteNumber(quote),
+			Produit:         quote.Produit,
+			Configuration:   quote.Configuration,
+			Message:         quote.Message,
+			Status:          quote.Status,
+			RejectionReason: quote.RejectionReason,
+			CreatedAt:       quote.CreatedAt.Format("02/01/2006"),
+		}
+		if quote.Status == QuoteStatusSent || quote.Status == QuoteStatusAccepted {
+			lines, err := listQuoteLines(quote.ID)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "Erreur récupération lignes devis", "quote_id", quote.ID, "err", err)
+			} else {
+				entry.TotalTTCCents = computeQuoteTotals(lines).TotalTTCCents
+				entry.HasPDF = quoteHasDocument(quote, lines)
+				if entry.HasPDF && quote.Status == QuoteStatusSent && paymentProvider != nil {
+					entry.DepositCents = quoteDepositCents(lines)
+				}
+			}
+		}
+		entries = append(entries, entry)
+	}
+
+	orders, err := listOrdersForUser(user)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Erreur récupération commandes du client", "user_id", user.ID, "err", err)
+		http.Error(w, "Erreur récupération commandes", http.StatusInternalServerError)
+		return
+	}
+
+	orderEntries := make([]CustomerOrderEntry, 0, len(orders))
+	for _, order := range orders {
+		entry := CustomerOrderEntry{
+			Reference:        order.Reference,
+			Produit:          order.Produit,
+			StatusLabel:      orderStatusLabel(order.Status),
+			Step:             orderStatusStep(order.Status),
+			TotalTTCCents:    order.TotalTTCCents,
+			ExpectedDelivery: order.ExpectedDeliveryAt.Format("02/01/2006"),
+		}
+		for _, status := range orderStatuses {
+			entry.Steps = append(entry.Steps, orderStatusLabel(status))
+		}
+		if order.DeliveredAt != nil {
+			entry.DeliveredAt = order.DeliveredAt.Format("02/01/2006")
+		}
+		invoices, err := listInvoicesForOrder(order.ID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Erreur récupération factures commande", "order_id", order.ID, "err", err)
+		}
+		for _, invoice := range invoices {
+			entry.Invoices = append(entry.Invoices, CustomerInvoiceEntry{
+				ID:               invoice.ID,
+				Number:           invoice.Number,
+				Label:            invoiceKindLabel(invoice.Kind),
+				AmountDueCents:   invoice.AmountDueCents,
+				OutstandingCents: invoice.OutstandingCents(),
+				DueAt:            invoice.DueAt.Format("02/01/2006"),
+				StatusLabel:      invoice.PaymentStatusLabel(),
+				Paid:             invoice.OutstandingCents() <= 0,
+				CanPay:           invoice.OutstandingCents() > 0 && paymentProvider != nil,
+			})
+		}
+		orderEntries = append(orderEntries, entry)
+	}
+
+	renderPage(w, "mes-devis.html", PageData{
+		Title:    "Mes devis",
+		Username: user.Prenom,
+		ExtraData: map[string]interface{}{
+			"Success":       r.URL.Query().Get("success") == "1",
+			"Paid":          r.URL.Query().Get("payment") == "success",
+			"PaymentFailed": r.URL.Query().Get("payment") == "failed",
+			"Quotes":        entries,
+			"Orders":        orderEntries,
+		},
+	})
+}
+
+func mesDevisPDFHandler(w http.ResponseWriter, r *http.Request) {
+	user := GetUserFromSession(r)
+	if user == nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	quoteID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || quoteID <= 0 {
+		http.Error(w, "ID devis invalide", http.StatusBadRequest)
+		return
+	}
+
+	quote, err := GetQuoteByID(quoteID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Erreur récupération devis", "quote_id", quoteID, "err", err)
+		http.Error(w, "Erreur récupération devis", http.StatusInternalServerError)
+		return
+	}
+	if !quoteBelongsToUser(quote, user) {
+		http.NotFound(w, r)
+		return
+	}
+
+	lines, err := listQuoteLines(quote.ID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Erreur récupération lignes devis", "quote_id", quote.ID, "err", err)
+		http.Error(w, "Erreur récupération devis", http.StatusInternalServerError)
+		return
+	}
+	if !quoteHasDocument(quote, lines) {
+		http.NotFound(w, r)
+		return
+	}
+
+	writeQuotePDF(w, quote, lines)
+}
+
+func mesFacturePDFHandler(w http.ResponseWriter, r *http.Request) {
+	user := GetUserFromSession(r)
+	if user == nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	invoiceID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || invoiceID <= 0 {
+		http.Error(w, "ID facture invalide", http.StatusBadRequest)
+		return
+	}
+
+	invoice, err := GetInvoiceByID(invoiceID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Erreur récupération facture", "invoice_id", invoiceID, "err", err)
+		http.Error(w, "Erreur récupération facture", http.StatusInternalServerError)
+		return
+	}
+	if invoice == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	order, err := GetOrderByID(invoice.OrderID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Erreur récupération commande", "order_id", invoice.OrderID, "err", err)
+		http.Error(w, "Erreur récupération facture", http.StatusInternalServerError)
+		return
+	}
+	if !orderBelongsToUser(order, user) {
+		http.NotFound(w, r)
+		return
+	}
+
+	writeInvoicePDF(w, r, invoice)
+}
+
+// redirectToCheckout ouvre une session de paiement et y redirige le client
+func redirectToCheckout(w http.ResponseWriter, r *http.Request, req CheckoutRequest) {
+	req.SuccessURL = "/mes-devis?payment=success"
+	req.CancelURL = "/mes-devis?payment=failed"
+
+	session, err := startCheckout(req)
+	if errors.Is(err, errPaymentsDisabled) || errors.Is(err, errNothingToPay) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Erreur ouverture paiement", "target_kind", req.TargetKind, "target_id", req.TargetID, "err", err)
+		http.Error(w, "Erreur ouverture du paiement", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, session.URL, http.StatusSeeOther)
+}
+
+func mesDevisPayHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user := GetUserFromSession(r)
+	if user == nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	quoteID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || quoteID <= 0 {
+		http.Error(w, "ID devis invalide", http.StatusBadRequest)
+		return
+	}
+
+	quote, err := GetQuoteByID(quoteID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Erreur récupération devis", "quote_id", quoteID, "err", err)
+		http.Error(w, "Erreur récupération devis", http.StatusInternalServerError)
+		return
+	}
+	if !quoteBelongsToUser(quote, user) {
+		http.NotFound(w, r)
+		return
+	}
+
+	lines, err := listQuoteLines(quote.ID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Erreur récupération lignes devis", "quote_id", quote.ID, "err", err)
+		http.Error(w, "Erreur récupération devis", http.StatusInternalServerError)
+		return
+	}
+	if quote.Status != QuoteStatusSent || !quoteHasDocument(quote, lines) {
+		http.Error(w, "Ce devis ne peut pas être réglé en ligne", http.StatusConflict)
+		return
+	}
+
+	redirectToCheckout(w, r, CheckoutRequest{
+		TargetKind:    PaymentTargetQuote,
+		TargetID:      quote.ID,
+		AmountCents:   quoteDepositCents(lines),
+		Description:   fmt.Sprintf("Acompte de %d %% - devis %s (%s)", invoiceDepositPercent(), quoteNumber(quote), quote.Produit),
+		CustomerEmail: quote.Email,
+	})
+}
+
+func mesFacturePayHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user := GetUserFromSession(r)
+	if user == nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	invoiceID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || invoiceID <= 0 {
+		http.Error(w, "ID facture invalide", http.StatusBadRequest)
+		return
+	}
+
+	invoice, err := GetInvoiceByID(invoiceID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Erreur récupération facture", "invoice_id", invoiceID, "err", err)
+		http.Error(w, "Erreur récupération facture", http.StatusInternalServerError)
+		return
+	}
+	if invoice == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	order, err := GetOrderByID(invoice.OrderID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Erreur récupération commande", "order_id", invoice.OrderID, "err", err)
+		http.Error(w, "Erreur récupération facture", http.StatusInternalServerError)
+		return
+	}
+	if !orderBelongsToUser(order, user) {
+		http.NotFound(w, r)
+		return
+	}
+
+	redirectToCheckout(w, r, CheckoutRequest{
+		TargetKind:    PaymentTargetInvoice,
+		TargetID:      invoice.ID,
+		AmountCents:   invoice.OutstandingCents(),
+		Description:   fmt.Sprintf("%s %s - commande %s", invoiceKindLabel(invoice.Kind), invoice.Number, order.Reference),
+		CustomerEmail: order.Email,
+	})
+}
+
+// loadCustomerQuote charge le devis {id} du client connecté ou écrit la réponse d'erreur
+func loadCustomerQuote(w http.ResponseWriter, r *http.Request, user *User) (*QuoteRecord, bool) {
+	quoteID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || quoteID <= 0 {
+		http.Error(w, "ID devis invalide", http.StatusBadRequest)
+		return nil, false
+	}
+
+	quote, err := GetQuoteByID(quoteID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Erreur récupération devis", "quote_id", quoteID, "err", err)
+		http.Error(w, "Erreur récupération devis", http.StatusInternalServerError)
+		return nil, false
+	}
+	if !quoteBelongsToUser(quote, user) {
+		http.NotFound(w, r)
+		return nil, false
+	}
+	return quote, true
+}
+
+// mesDevisDetailHandler affiche un devis du client et sa conversation avec l'atelier
+func mesDevisDetailHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user := GetUserFromSession(r)
+	if user == nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	quote, ok := loadCustomerQuote(w, r, user)
+	if !ok {
+		return
+	}
+
+	lines, err := listQuoteLines(quote.ID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Erreur récupération lignes devis", "quote_id", quote.ID, "err", err)
+		http.Error(w, "Erreur récupération devis", http.StatusInternalServerError)
+		return
+	}
+
+	messages, err := listQuoteMessages(quote.ID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Erreur récupération messages devis", "quote_id", quote.ID, "err", err)
+		http.Error(w, "Erreur récupération devis", http.StatusInternalServerError)
+		return
+	}
+
+	attachments, err := listQuoteAttachments(quote.ID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Erreur récupération fichiers devis", "quote_id", quote.ID, "err", err)
+		http.Error(w, "Erreur récupération devis", http.StatusInternalServerError)
+		return
+	}
+
+	renderPage(w, "mes-devis-detail.html", PageData{
+		Title:    "Devis " + quoteNumber(quote),
+		Username: user.Prenom,
+		ExtraData: map[string]interface{}{
+			"Quote":       quote,
+			"Number":      quoteNumber(quote),
+			"HasPDF":      quoteHasDocument(quote, lines),
+			"Messages":    messages,
+			"Attachments": splitAttachments(attachments, messages),
+			"Sent":        r.URL.Query().Get("sent") == "1",
+		},
+	})
+}
+
+// mesDevisMessageHandler ajoute un message du client à la conversation du devis
+func mesDevisMessageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user := GetUserFromSession(r)
+	if user == nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	quote, ok := loadCustomerQuote(w, r, user)
+	if !ok {
+		return
+	}
+
+	if err := parseAttachmentForm(w, r); err != nil {
+		http.Error(w, "Formulaire invalide ou fichiers trop volumineux", http.StatusBadRequest)
+		return
+	}
+	body := strings.TrimSpace(r.FormValue("body"))
+	if len(body) > quoteMessageMaxLength {
+		http.Error(w, fmt.Sprintf("Le message doit contenir au plus %d caractères", quoteMessageMaxLength), http.StatusBadRequest)
+		return
+	}
+	attachments, err := storeUploadedAttachments(r.MultipartForm)
+	if err != nil {
+		if isAttachmentRejection(err) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		slog.ErrorContext(r.Context(), "Erreur stockage pièces jointes devis", "quote_id", quote.ID, "err", err)
+		http.Error(w, "Erreur enregistrement des fichiers", http.StatusInternalServerError)
+		return
+	}
+	if body == "" && len(attachments) == 0 {
+		http.Error(w, "Écrivez un message ou joignez un fichier", http.StatusBadRequest)
+		return
+	}
+
+	if err := addQuoteMessage(r.Context(), quote, QuoteMessageAuthorCustomer, quoteMessageAuthorName(quote, QuoteMessageAuthorCustomer), body, QuoteMessageSourceWeb, attachments); err != nil {
+		discardAttachments(attachments)
+		slog.ErrorContext(r.Context(), "Erreur ajout message devis", "quote_id", quote.ID, "err", err)
+		http.Error(w, "Erreur envoi du message", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/mes-devis/%d?sent=1#conversation", quote.ID), http.StatusSeeOther)
+}
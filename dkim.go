@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// En-têtes couverts par la signature DKIM, quand ils sont présents
+var dkimSignedHeaders = []string{"From", "Reply-To", "To", "Subject", "Date", "Message-ID", "MIME-Version", "Content-Type", "Content-Transfer-Encoding"}
+
+// dkimSigner signe les emails en rsa-sha256, canonicalisation relaxed/relaxed (RFC 6376)
+type dkimSigner struct {
+	domain   string
+	selector string
+	key      *rsa.PrivateKey
+}
+
+// loadDKIMSigner lit DKIM_DOMAIN, DKIM_SELECTOR et la clé RSA PEM de DKIM_PRIVATE_KEY_FILE
+// (ou DKIM_PRIVATE_KEY) ; sans domaine, la signature est désactivée et le signataire est nil
+func loadDKIMSigner() (*dkimSigner, error) {
+	domain := getEnv("DKIM_DOMAIN", "")
+	if domain == "" {
+		return nil, nil
+	}
+	selector := getEnv("DKIM_SELECTOR", "")
+	if selector == "" {
+		return nil, fmt.Errorf("DKIM_SELECTOR manquant")
+	}
+
+	keyPEM := []byte(getEnv("DKIM_PRIVATE_KEY", ""))
+	if path := getEnv("DKIM_PRIVATE_KEY_FILE", ""); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("lecture clé DKIM: %v", err)
+		}
+		keyPEM = data
+	}
+	key, err := parseRSAPrivateKey(keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	return &dkimSigner{domain: domain, selector: selector, key: key}, nil
+}
+
+// parseRSAPrivateKey accepte une clé PEM PKCS#1 ("RSA PRIVATE KEY") ou PKCS#8 ("PRIVATE KEY")
+func parseRSAPrivateKey(data []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("clé DKIM absente ou non PEM")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("clé DKIM illisible: %v", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("la clé DKIM doit être une clé RSA")
+	}
+	return key, nil
+}
+
+// dkimHeader est un en-tête brut du message, avec ses éventuelles lignes de continuation
+type dkimHeader struct {
+	name string
+	raw  string
+}
+
+// splitMessage sépare les en-têtes (dépliés ligne à ligne) du corps ; le message est en CRLF
+func splitMessage(message []byte) ([]dkimHeader, []byte) {
+	head, body, found := bytes.Cut(message, []byte("\r\n\r\n"))
+	if !found {
+		head, body = message, nil
+	}
+
+	var headers []dkimHeader
+	for _, line := range strings.Split(string(head), "\r\n") {
+		if line == "" {
+			continue
+		}
+		if (line[0] == ' ' || line[0] == '\t') && len(headers) > 0 {
+			headers[len(headers)-1].raw += "\r\n" + line
+			continue
+		}
+		name, _, _ := strings.Cut(line, ":")
+		headers = append(headers, dkimHeader{name: strings.TrimSpace(name), raw: line})
+	}
+	return headers, body
+}
+
+// relaxedHeader applique la canonicalisation relaxed à un en-tête : nom en minuscules, en-tête déplié,
+// blancs consécutifs réduits à un espace et supprimés autour de la valeur
+func relaxedHeader(raw string) string {
+	name, value, _ := strings.Cut(raw, ":")
+	value = strings.ReplaceAll(value, "\r\n", "")
+	value = strings.Join(strings.FieldsFunc(value, func(r rune) bool { return r == ' ' || r == '\t' }), " ")
+	return strings.ToLower(strings.TrimSpace(name)) + ":" + value + "\r\n"
+}
+
+// relaxedBody applique la canonicalisation relaxed au corps : blancs réduits, espaces de fin de ligne
+// et lignes vides finales supprimés ; un corps non vide se termine par un seul CRLF
+func relaxedBody(body []byte) []byte {
+	lines := strings.Split(string(body), "\r\n")
+	for i, line := range lines {
+		line = strings.Join(strings.FieldsFunc(line, func(r rune) bool { return r == ' ' || r == '\t' }), " ")
+		if strings.HasPrefix(lines[i], " ") || strings.HasPrefix(lines[i], "\t") {
+			if line != "" {
+				line = " " + line
+			}
+		}
+		lines[i] = line
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
+
+// Sign renvoie le message précédé de son en-tête DKIM-Signature
+func (s *dkimSigner) Sign(message []byte) ([]byte, error) {
+	headers, body := splitMessage(message)
+
+	bodyHash := sha256.Sum256(relaxedBody(body))
+
+	// Pour un en-tête répété, seule la dernière occurrence est signée (RFC 6376 §5.4.2)
+	var signedNames []string
+	var canonical strings.Builder
+	for _, name := range dkimSignedHeaders {
+		for i := len(headers) - 1; i >= 0; i-- {
+			if strings.EqualFold(headers[i].name, name) {
+				canonical.WriteString(relaxedHeader(headers[i].raw))
+				signedNames = append(signedNames, strings.ToLower(name))
+				break
+			}
+		}
+	}
+
+	value := fmt.Sprintf("v=1; a=rsa-sha256; c=relaxed/relaxed; d=%s; s=%s; t=%d; h=%s; bh=%s; b=",
+		s.domain, s.selector, time.Now().Unix(), strings.Join(signedNames, ":"), base64.StdEncoding.EncodeToString(bodyHash[:]))
+	canonical.WriteString(strings.TrimSuffix(relaxedHeader("DKIM-Signature: "+value), "\r\n"))
+
+	digest := sha256.Sum256([]byte(canonical.String()))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return nil, err
+	}
+
+	var signed bytes.Buffer
+	signed.WriteString("DKIM-Signature: " + value + foldBase64(base64.StdEncoding.EncodeToString(signature)) + "\r\n")
+	signed.Write(message)
+	return signed.Bytes(), nil
+}
+
+// foldBase64 replie une longue valeur base64 en lignes de continuation, ignorées par la canonicalisation relaxed
+func foldBase64(value string) string {
+	var folded strings.Builder
+	for len(value) > 72 {
+		folded.WriteString(value[:72])
+		folded.WriteString("\r\n ")
+		value = value[72:]
+	}
+	folded.WriteString(value)
+	return folded.String()
+}
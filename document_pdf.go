@@ -0,0 +1,400 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// companyInfo regroupe les mentions de l'entreprise imprimées sur les documents commerciaux
+type companyInfo struct {
+	Name      string
+	Address   string
+	City      string
+	Email     string
+	Phone     string
+	LegalForm string
+	SIRET     string
+	VATNumber string
+}
+
+func loadCompanyInfo() companyInfo {
+	return companyInfo{
+		Name:      getEnv("COMPANY_NAME", "MODULSPACE"),
+		Address:   getEnv("COMPANY_ADDRESS", "19 Rue Haddock"),
+		City:      getEnv("COMPANY_CITY", "77700 Chessy"),
+		Email:     getEnv("COMPANY_EMAIL", "modulspace@outlook.fr"),
+		Phone:     getEnv("COMPANY_PHONE", ""),
+		LegalForm: getEnv("COMPANY_LEGAL_FORM", ""),
+		SIRET:     getEnv("COMPANY_SIRET", ""),
+		VATNumber: getEnv("COMPANY_VAT_NUMBER", ""),
+	}
+}
+
+// legalLine renvoie la ligne de pied de page avec les identifiants légaux renseignés
+func (c companyInfo) legalLine() string {
+	parts := []string{c.Name}
+	if c.LegalForm != "" {
+		parts = append(parts, c.LegalForm)
+	}
+	parts = append(parts, c.Address+", "+c.City)
+	if c.SIRET != "" {
+		parts = append(parts, "SIRET "+c.SIRET)
+	}
+	if c.VATNumber != "" {
+		parts = append(parts, "TVA intracom. "+c.VATNumber)
+	}
+	return strings.Join(parts, " - ")
+}
+
+// Polices et logo de la charte, lus une seule fois depuis personalisation/
+var brandAssets struct {
+	once      sync.Once
+	titleFont []byte
+	bodyFont  []byte
+	logo      []byte
+	err       error
+}
+
+func loadBrandAssets() error {
+	brandAssets.once.Do(func() {
+		read := func(parts ...string) []byte {
+			if brandAssets.err != nil {
+				return nil
+			}
+			data, err := os.ReadFile(filepath.Join(parts...))
+			if err != nil {
+				brandAssets.err = fmt.Errorf("erreur lecture ressource PDF: %v", err)
+			}
+			return data
+		}
+		brandAssets.titleFont = read("personalisation", "font", "Siberian.ttf")
+		brandAssets.bodyFont = read("personalisation", "font", "futuralight.ttf")
+		brandAssets.logo = read("personalisation", "picture", "modulespace_logo.png")
+	})
+	return brandAssets.err
+}
+
+// businessDocument décrit un document commercial (devis, facture) à mettre en page
+type businessDocument struct {
+	Title     string
+	Number    string
+	Dates     [][2]string
+	Customer  []string
+	Lines     []QuoteLine
+	Totals    QuoteTotals
+	ExtraRows [][2]string
+	Terms     []string
+	Signature bool
+}
+
+var (
+	brandBlue  = [3]float64{0.078, 0.055, 0.561}
+	brandMauve = [3]float64{0.38, 0.38, 0.67}
+	textDark   = [3]float64{0.13, 0.13, 0.13}
+	textMuted  = [3]float64{0.42, 0.45, 0.5}
+	ruleGrey   = [3]float64{0.85, 0.86, 0.88}
+)
+
+const (
+	docMargin     = 45.0
+	docRight      = pdfPageWidth - docMargin
+	docBottomStop = 760.0
+)
+
+// renderBusinessDocument met en page un document commercial avec la charte Modul-space
+func renderBusinessDocument(spec businessDocument) ([]byte, error) {
+	if err := loadBrandAssets(); err != nil {
+		return nil, err
+	}
+
+	company := loadCompanyInfo()
+	doc := newPDFDocument(spec.Title + " " + spec.Number)
+	titleFont, err := doc.addTrueTypeFont(brandAssets.titleFont)
+	if err != nil {
+		return nil, fmt.Errorf("police Siberian: %v", err)
+	}
+	bodyFont, err := doc.addTrueTypeFont(brandAssets.bodyFont)
+	if err != nil {
+		return nil, fmt.Errorf("police futuralight: %v", err)
+	}
+	logo, err := doc.addImage(brandAssets.logo)
+	if err != nil {
+		return nil, err
+	}
+
+	fill := func(page *pdfPage, c [3]float64) { page.setFillColor(c[0], c[1], c[2]) }
+
+	page := doc.addPage()
+
+	// En-tête : logo et coordonnées de l'entreprise
+	logoHeight := 60.0
+	logoWidth := logoHeight * float64(logo.width) / float64(logo.height)
+	page.image(logo, docMargin, 35, logoWidth, logoHeight)
+	fill(page, brandBlue)
+	page.textRight(titleFont, 22, docRight, 58, company.Name)
+	fill(page, textMuted)
+	y := 74.0
+	for _, line := range []string{company.Address, company.City, company.Email, company.Phone} {
+		if line == "" {
+			continue
+		}
+		page.textRight(bodyFont, 9, docRight, y, line)
+		y += 11
+	}
+	fill(page, brandBlue)
+	page.rect(docMargin, 118, docRight-docMargin, 2)
+
+	// Titre, numéro et dates
+	fill(page, brandBlue)
+	page.text(titleFont, 26, docMargin, 160, strings.ToUpper(spec.Title))
+	fill(page, textDark)
+	page.text(bodyFont, 11, docMargin, 180, "N° "+spec.Number)
+	y = 196
+	for _, date := range spec.Dates {
+		fill(page, textMuted)
+		page.text(bodyFont, 9, docMargin, y, date[0])
+		fill(page, textDark)
+		page.text(bodyFont, 9, docMargin+95, y, date[1])
+		y += 13
+	}
+
+	// Bloc client
+	boxX, boxY, boxWidth := 320.0, 140.0, docRight-320.0
+	boxHeight := 26 + 13*float64(len(spec.Customer))
+	page.setStrokeColor(brandMauve[0], brandMauve[1], brandMauve[2])
+	page.strokeRect(boxX, boxY, boxWidth, boxHeight, 0.8)
+	fill(page, brandMauve)
+	page.text(titleFont, 11, boxX+10, boxY+16, "CLIENT")
+	fill(page, textDark)
+	for i, line := range spec.Customer {
+		page.text(bodyFont, 10, boxX+10, boxY+31+13*float64(i), line)
+	}
+
+	y = boxY + boxHeight + 30
+	if y < 250 {
+		y = 250
+	}
+
+	// Tableau des lignes
+	columns := []struct {
+		label string
+		x     float64
+		right bool
+	}{
+		{"Désignation", docMargin + 8, false},
+		{"Qté", 330, true},
+		{"PU HT", 408, true},
+		{"TVA", 460, true},
+		{"Total HT", docRight - 8, true},
+	}
+	tableHeader := func(page *pdfPage, y float64) float64 {
+		fill(page, brandBlue)
+		page.rect(docMargin, y, docRight-docMargin, 22)
+		page.setFillColor(1, 1, 1)
+		for _, column := range columns {
+			if column.right {
+				page.textRight(bodyFont, 10, column.x, y+15, column.label)
+			} else {
+				page.text(bodyFont, 10, column.x, y+15, column.label)
+			}
+		}
+		return y + 22
+	}
+	y = tableHeader(page, y)
+
+	page.setStrokeColor(ruleGrey[0], ruleGrey[1], ruleGrey[2])
+	for _, line := range spec.Lines {
+		labelLines := bodyFont.wrapText(10, 250, line.Label)
+		rowHeight := 10 + 13*float64(len(labelLines))
+		if y+rowHeight > docBottomStop {
+			page = doc.addPage()
+			page.setStrokeColor(ruleGrey[0], ruleGrey[1], ruleGrey[2])
+			y = tableHeader(page, docMargin)
+		}
+		fill(page, textDark)
+		for i, labelLine := range labelLines {
+			page.text(bodyFont, 10, columns[0].x, y+15+13*float64(i), labelLine)
+		}
+		page.textRight(bodyFont, 10, columns[1].x, y+15, fmt.Sprint(line.Quantity))
+		page.textRight(bodyFont, 10, columns[2].x, y+15, formatEuros(line.UnitPriceCents))
+		page.textRight(bodyFont, 10, columns[3].x, y+15, formatVATRate(line.VATRateBP))
+		page.textRight(bodyFont, 10, columns[4].x, y+15, formatEuros(line.TotalCents()))
+		y += rowHeight
+		page.line(docMargin, y, docRight, y, 0.5)
+	}
+
+	// Totaux avec ventilation de la TVA
+	totalRows := [][2]string{{"Total HT", formatEuros(spec.Totals.TotalHTCents)}}
+	for _, vat := range spec.Totals.VAT {
+		totalRows = append(totalRows, [2]string{
+			fmt.Sprintf("TVA %s sur %s", formatVATRate(vat.RateBP), formatEuros(vat.BaseCents)),
+			formatEuros(vat.VATCents),
+		})
+	}
+	totalRows = append(totalRows, [2]string{"Total TTC", formatEuros(spec.Totals.TotalTTCCents)})
+	totalRows = append(totalRows, spec.ExtraRows...)
+
+	if y+20+16*float64(len(totalRows)) > docBottomStop {
+		page = doc.addPage()
+		y = docMargin
+	}
+	y += 20
+	for i, row := range totalRows {
+		if row[0] == "Total TTC" {
+			fill(page, brandBlue)
+			page.rect(300, y+2, docRight-300, 20)
+			page.setFillColor(1, 1, 1)
+			page.text(bodyFont, 11, 308, y+16, row[0])
+			page.textRight(bodyFont, 11, docRight-8, y+16, row[1])
+			y += 24
+			continue
+		}
+		fill(page, textDark)
+		if i > 0 && totalRows[i-1][0] == "Total TTC" {
+			y += 4
+		}
+		page.text(bodyFont, 10, 308, y+14, row[0])
+		page.textRight(bodyFont, 10, docRight-8, y+14, row[1])
+		y += 16
+	}
+
+	// Conditions
+	y += 18
+	for _, term := range spec.Terms {
+		for _, termLine := range bodyFont.wrapText(9, docRight-docMargin, term) {
+			if y > docBottomStop {
+				page = doc.addPage()
+				y = docMargin
+			}
+			fill(page, textMuted)
+			page.text(bodyFont, 9, docMargin, y, termLine)
+			y += 12
+		}
+		y += 3
+	}
+
+	if spec.Signature {
+		if y+80 > docBottomStop {
+			page = doc.addPage()
+			y = docMargin
+		}
+		y += 12
+		page.setStrokeColor(brandMauve[0], brandMauve[1], brandMauve[2])
+		page.strokeRect(300, y, docRight-300, 70, 0.8)
+		fill(page, textDark)
+		page.text(bodyFont, 9, 308, y+14, "Bon pour accord - date et signature :")
+	}
+
+	// Pied de page sur chaque page
+	for i, p := range doc.pages {
+		p.setStrokeColor(ruleGrey[0], ruleGrey[1], ruleGrey[2])
+		p.line(docMargin, 790, docRight, 790, 0.5)
+		fill(p, textMuted)
+		legal := company.legalLine()
+		p.text(bodyFont, 7.5, (pdfPageWidth-bodyFont.textWidth(7.5, legal))/2, 805, legal)
+		p.textRight(bodyFont, 7.5, docRight, 818, fmt.Sprintf("%s %s - page %d/%d", spec.Title, spec.Number, i+1, len(doc.pages)))
+	}
+
+	return doc.Bytes()
+}
+
+// quoteNumber renvoie la référence légale du devis (l'identifiant technique à défaut)
+func quoteNumber(quote *QuoteRecord) string {
+	if quote.Reference != "" {
+		return quote.Reference
+	}
+	return fmt.Sprintf("%d", quote.ID)
+}
+
+// renderQuotePDF génère le devis chiffré au format PDF
+func renderQuotePDF(quote *QuoteRecord, lines []QuoteLine) ([]byte, error) {
+	issuedAt := time.Now()
+	if quote.QuotedAt != nil {
+		issuedAt = *quote.QuotedAt
+	}
+
+	customer := []string{strings.TrimSpace(quote.Prenom + " " + quote.Nom), quote.Email}
+	if quote.Telephone != "" {
+		customer = append(customer, quote.Telephone)
+	}
+
+	validUntil := quote.ValidUntil().Format("02/01/2006")
+	return renderBusinessDocument(businessDocument{
+		Title:  "Devis",
+		Number: quoteNumber(quote),
+		Dates: [][2]string{
+			{"Date", issuedAt.Format("02/01/2006")},
+			{"Valable jusqu'au", validUntil},
+			{"Produit", quote.Produit},
+		},
+		Customer: customer,
+		Lines:    lines,
+		Totals:   computeQuoteTotals(lines),
+		Terms: []string{
+			"Devis valable jusqu'au " + validUntil + ". Passé ce délai, les prix et délais indiqués pourront être révisés.",
+			fmt.Sprintf("Conditions de règlement : acompte de %d %% à la commande, solde à la livraison. Prix exprimés en euros.", invoiceDepositPercent()),
+			"Meubles fabriqués sur mesure : le délai de fabrication est confirmé à la commande. Toute commande signée vaut acceptation des présentes conditions.",
+		},
+		Signature: true,
+	})
+}
+
+// renderInvoicePDF produit la facture avec les mentions obligatoires (art. L441-9 du Code de commerce) ;
+// la facture de solde déduit les acomptes déjà facturés
+func renderInvoicePDF(invoice *Invoice, order *Order, lines []QuoteLine, deposits []*Invoice) ([]byte, error) {
+	company := loadCompanyInfo()
+
+	customer := []string{strings.TrimSpace(order.Prenom + " " + order.Nom), order.Email}
+	if order.Telephone != "" {
+		customer = append(customer, order.Telephone)
+	}
+
+	var extraRows [][2]string
+	if invoice.Kind == InvoiceKindFinal {
+		for _, deposit := range deposits {
+			extraRows = append(extraRows, [2]string{"Acompte " + deposit.Number, "-" + formatEuros(deposit.TotalTTCCents)})
+		}
+		if len(deposits) > 0 {
+			extraRows = append(extraRows, [2]string{"Net à payer", formatEuros(invoice.AmountDueCents)})
+		}
+	}
+	if invoice.PaidCents > 0 {
+		extraRows = append(extraRows,
+			[2]string{"Déjà réglé", formatEuros(invoice.PaidCents)},
+			[2]string{"Reste à payer", formatEuros(invoice.OutstandingCents())},
+		)
+	}
+
+	dueAt := invoice.DueAt.In(documentLocation).Format("02/01/2006")
+	terms := []string{
+		"Date d'échéance : " + dueAt + ". Paiement par virement, carte ou chèque à l'ordre de " + company.Name + ".",
+		"Pas d'escompte pour paiement anticipé.",
+		"En cas de retard de paiement, des pénalités sont exigibles au taux de " + getEnv("INVOICE_LATE_PENALTY_RATE", "trois fois le taux d'intérêt légal") +
+			", ainsi qu'une indemnité forfaitaire pour frais de recouvrement de 40 € (art. L441-10 et D441-5 du Code de commerce).",
+	}
+	if company.VATNumber == "" {
+		terms = append(terms, "TVA non applicable, art. 293 B du CGI.")
+	}
+	if invoice.Kind == InvoiceKindDeposit {
+		terms = append(terms, fmt.Sprintf("Acompte de %d %% sur la commande %s, à déduire de la facture de solde.", invoiceDepositPercent(), order.Reference))
+	}
+
+	return renderBusinessDocument(businessDocument{
+		Title:  invoiceKindLabel(invoice.Kind),
+		Number: invoice.Number,
+		Dates: [][2]string{
+			{"Date", invoice.IssuedAt.In(documentLocation).Format("02/01/2006")},
+			{"Échéance", dueAt},
+			{"Commande", order.Reference + " du " + order.CreatedAt.In(documentLocation).Format("02/01/2006")},
+		},
+		Customer:  customer,
+		Lines:     lines,
+		Totals:    computeQuoteTotals(lines),
+		ExtraRows: extraRows,
+		Terms:     terms,
+	})
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"path/filepath"
+	"strings"
+	texttemplate "text/template"
+)
+
+// OutgoingEmail est un message MIME complet prêt à être remis au serveur SMTP
+type OutgoingEmail struct {
+	From       string
+	Recipients []string
+	Subject    string
+	Message    []byte
+}
+
+// Identifiant du logo inline, référencé par <img src="cid:..."> dans templates/emails/layout.html
+const emailLogoCID = "logo@modulspace"
+
+// emailView est la donnée passée aux templates d'email : .Data contient les champs propres au message
+type emailView struct {
+	Subject string
+	Company companyInfo
+	BaseURL string
+	Data    any
+}
+
+// appBaseURL est l'adresse publique du site utilisée dans les liens des emails (APP_BASE_URL)
+func appBaseURL() string {
+	if base := getEnv("APP_BASE_URL", ""); base != "" {
+		return strings.TrimRight(base, "/")
+	}
+	return "http://localhost:" + getEnv("PORT", "8080")
+}
+
+var emailFuncs = map[string]any{
+	"euros":       formatEuros,
+	"statusLabel": quoteStatusLabel,
+}
+
+// renderEmail construit un email multipart/alternative à partir de templates/emails/<name>.txt
+// (qui définit aussi le bloc "subject") et de templates/emails/<name>.html, mis en page par layout.html
+func renderEmail(name string, to []string, data any, attachments ...mimePart) (OutgoingEmail, error) {
+	return renderEmailReplyTo(name, to, mailReplyTo(), data, attachments...)
+}
+
+// renderEmailReplyTo est renderEmail avec une adresse de réponse propre au message
+func renderEmailReplyTo(name string, to []string, replyTo string, data any, attachments ...mimePart) (OutgoingEmail, error) {
+	dir := filepath.Join("templates", "emails")
+	view := emailView{Company: loadCompanyInfo(), BaseURL: appBaseURL(), Data: data}
+
+	textTmpl, err := texttemplate.New(name + ".txt").Funcs(emailFuncs).ParseFiles(filepath.Join(dir, name+".txt"))
+	if err != nil {
+		return OutgoingEmail{}, fmt.Errorf("erreur chargement template email %s: %v", name, err)
+	}
+	var subject, text bytes.Buffer
+	if err := textTmpl.ExecuteTemplate(&subject, "subject", view); err != nil {
+		return OutgoingEmail{}, fmt.Errorf("erreur rendu objet email %s: %v", name, err)
+	}
+	view.Subject = strings.TrimSpace(subject.String())
+	if err := textTmpl.Execute(&text, view); err != nil {
+		return OutgoingEmail{}, fmt.Errorf("erreur rendu texte email %s: %v", name, err)
+	}
+
+	htmlTmpl, err := htmltemplate.New("layout.html").Funcs(emailFuncs).ParseFiles(filepath.Join(dir, "layout.html"), filepath.Join(dir, name+".html"))
+	if err != nil {
+		return OutgoingEmail{}, fmt.Errorf("erreur chargement template email %s: %v", name, err)
+	}
+	var htmlBody bytes.Buffer
+	if err := htmlTmpl.ExecuteTemplate(&htmlBody, "layout", view); err != nil {
+		return OutgoingEmail{}, fmt.Errorf("erreur rendu HTML email %s: %v", name, err)
+	}
+
+	sender := mailSender(view.Company)
+	message := mimeMessage{
+		From:        sender,
+		To:          to,
+		ReplyTo:     replyTo,
+		Subject:     view.Subject,
+		Text:        text.String(),
+		HTML:        htmlBody.String(),
+		Attachments: attachments,
+	}
+	if err := loadBrandAssets(); err == nil {
+		message.Inline = append(message.Inline, mimePart{Filename: "logo.png", ContentType: "image/png", ContentID: emailLogoCID, Data: brandAssets.logo})
+	}
+
+	raw, err := message.Bytes()
+	if err != nil {
+		return OutgoingEmail{}, fmt.Errorf("erreur construction email %s: %v", name, err)
+	}
+	return OutgoingEmail{From: sender.Address, Recipients: to, Subject: view.Subject, Message: raw}, nil
+}
+
+// QuoteReceivedEmail alimente les templates quote_received (atelier) et quote_acknowledgement (client)
+type QuoteReceivedEmail struct {
+	QuoteID          int
+	Reference        string
+	Nom              string
+	Prenom           string
+	Email            string
+	Telephone        string
+	Produit          string
+	Configuration    string
+	Message          string
+	LeadTimeMinWeeks int
+	LeadTimeMaxWeeks int
+	// Attachments liste les noms des fichiers joints à la demande
+	Attachments []string
+}
+
+// quoteNotifyRecipients renvoie les destinataires internes des nouvelles demandes :
+// QUOTE_NOTIFY_EMAILS (liste séparée par des virgules), sinon le compte SMTP, sinon l'adresse de contact
+func quoteNotifyRecipients() []string {
+	recipients := make([]string, 0)
+	for _, address := range strings.Split(getEnv("QUOTE_NOTIFY_EMAILS", ""), ",") {
+		if address = strings.TrimSpace(address); address != "" {
+			recipients = append(recipients, address)
+		}
+	}
+	if len(recipients) > 0 {
+		return recipients
+	}
+	if smtpUser := getEnv("SMTP_USER", ""); smtpUser != "" {
+		return []string{smtpUser}
+	}
+	return []string{loadCompanyInfo().Email}
+}
+
+// buildQuoteEmail construit l'email de demande de devis envoyé à l'atelier
+func buildQuoteEmail(details QuoteReceivedEmail) (OutgoingEmail, error) {
+	return renderEmail("quote_received", quoteNotifyRecipients(), details)
+}
+
+// buildQuoteAcknowledgementEmail construit l'accusé de réception envoyé au demandeur, à l'adresse de son compte
+func buildQuoteAcknowledgementEmail(recipient string, details QuoteReceivedEmail) (OutgoingEmail, error) {
+	return renderEmail("quote_acknowledgement", []string{recipient}, details)
+}
+
+// QuoteStatusEmail alimente les templates quote_status_changed, quote_accepted et quote_rejected
+type QuoteStatusEmail struct {
+	QuoteID   int
+	Prenom    string
+	Reference string
+	Produit   string
+	Status    string
+	// Reason est le motif du refus
+	Reason string
+	// HasPDF indique que le devis est joint à l'email
+	HasPDF bool
+	// Optional signale un email de suivi que le client peut désactiver depuis son profil
+	Optional bool
+}
+
+// AccountLinkEmail alimente les templates password_reset et email_verification
+type AccountLinkEmail struct {
+	Prenom    string
+	Link      string
+	ExpiresIn string
+}
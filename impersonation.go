@@ -0,0 +1,218 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Cookie de la session « voir comme le client », distinct de la session du client (user_session)
+const impersonationCookie = "impersonation"
+
+// impersonationDuration borne une session : le cookie expire même si l'admin oublie d'en sortir
+const impersonationDuration = time.Hour
+
+// Message renvoyé aux actions bloquées pendant une session « voir comme le client »
+const impersonationBlockedMessage = "Action désactivée en mode « voir comme le client » : quittez ce mode pour agir sur le compte."
+
+var errInvalidImpersonation = errors.New("session « voir comme le client » invalide")
+
+var (
+	sessionSecretOnce sync.Once
+	sessionSecret     []byte
+)
+
+// sessionSigningKey signe les sessions des clients et les sessions « voir comme le client » (SESSION_SECRET).
+// Sans secret configuré, une clé aléatoire est tirée au démarrage : les sessions ouvertes prennent fin au redémarrage
+func sessionSigningKey() []byte {
+	sessionSecretOnce.Do(func() {
+		if secret := getEnv("SESSION_SECRET", ""); secret != "" {
+			sessionSecret = []byte(secret)
+			return
+		}
+		sessionSecret = []byte(randomHex(32))
+	})
+	return sessionSecret
+}
+
+// Impersonation est une session « voir comme le client » : Actor, l'admin qui l'a ouverte, voit le site
+// avec le compte du client UserID, en lecture seule
+type Impersonation struct {
+	UserID    int
+	Actor     string
+	ExpiresAt time.Time
+}
+
+// impersonationSignature signe le contenu d'un cookie ; le préfixe réserve la clé à cet usage
+func impersonationSignature(payload string) string {
+	mac := hmac.New(sha256.New, sessionSigningKey())
+	fmt.Fprintf(mac, "impersonation:%s", payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// token encode la session en "<user id>.<expiration unix>.<admin en base64>.<signature>"
+func (i *Impersonation) token() string {
+	payload := fmt.Sprintf("%d.%d.%s", i.UserID, i.ExpiresAt.Unix(), base64.RawURLEncoding.EncodeToString([]byte(i.Actor)))
+	return payload + "." + impersonationSignature(payload)
+}
+
+// parseImpersonationToken vérifie la signature et l'expiration d'un cookie de session
+func parseImpersonationToken(token string, now time.Time) (*Impersonation, error) {
+	separator := strings.LastIndex(token, ".")
+	if separator < 0 {
+		return nil, errInvalidImpersonation
+	}
+	payload, signature := token[:separator], token[separator+1:]
+	if !hmac.Equal([]byte(signature), []byte(impersonationSignature(payload))) {
+		return nil, errInvalidImpersonation
+	}
+
+	parts := strings.Split(payload, ".")
+	if len(parts) != 3 {
+		return nil, errInvalidImpersonation
+	}
+	userID, err := strconv.Atoi(parts[0])
+	if err != nil || userID <= 0 {
+		return nil, errInvalidImpersonation
+	}
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return nil, errInvalidImpersonation
+	}
+	actor, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errInvalidImpersonation
+	}
+
+	impersonation := &Impersonation{UserID: userID, Actor: string(actor), ExpiresAt: time.Unix(expires, 0)}
+	if !now.Before(impersonation.ExpiresAt) {
+		return nil, fmt.Errorf("session « voir comme le client » expirée le %s", impersonation.ExpiresAt.Format("2006-01-02 15:04"))
+	}
+	return impersonation, nil
+}
+
+// currentImpersonation renvoie la session « voir comme le client » de la requête, nil s'il n'y en a pas
+// ou si son cookie est expiré ou falsifié
+func currentImpersonation(r *http.Request) *Impersonation {
+	cookie, err := r.Cookie(impersonationCookie)
+	if err != nil {
+		return nil
+	}
+	impersonation, err := parseImpersonationToken(cookie.Value, time.Now())
+	if err != nil {
+		return nil
+	}
+	return impersonation
+}
+
+// impersonationGuard bloque toute écriture côté client pendant une session « voir comme le client » :
+// devis, messages, paiements, préférences, connexion ou inscription. L'admin et la sortie du mode restent permis
+func impersonationGuard(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead && !impersonationAllowedPath(r.URL.Path) {
+			if currentImpersonation(r) != nil {
+				http.Error(w, impersonationBlockedMessage, http.StatusForbidden)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func impersonationAllowedPath(path string) bool {
+	return path == "/admin" || strings.HasPrefix(path, "/admin/") || path == "/impersonation/stop" || path == "/logout"
+}
+
+// adminImpersonateHandler ouvre une session « voir comme le client » sur le compte d'un utilisateur. Sans trace
+// dans le journal d'audit, pas de session
+func adminImpersonateHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdminAuth(w, r) {
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || userID <= 0 {
+		http.Error(w, "ID utilisateur invalide", http.StatusBadRequest)
+		return
+	}
+	user, err := GetUserByID(userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Erreur récupération utilisateur", "user_id", userID, "err", err)
+		renderAdminErrorPage(w, "Erreur récupération utilisateur", err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	impersonation := &Impersonation{UserID: user.ID, Actor: adminActor(r), ExpiresAt: time.Now().Add(impersonationDuration).Truncate(time.Second)}
+	details := map[string]any{"email": user.Email, "expires_at": impersonation.ExpiresAt.UTC().Format(time.RFC3339)}
+	if err := recordAudit(r, AuditActionImpersonationStart, fmt.Sprintf("user:%d", user.ID), details); err != nil {
+		slog.ErrorContext(r.Context(), "Erreur journal d'audit", "action", AuditActionImpersonationStart, "user_id", user.ID, "err", err)
+		renderAdminErrorPage(w, "Mode « voir comme le client » indisponible", "Le journal d'audit n'a pas pu être écrit.", http.StatusInternalServerError)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     impersonationCookie,
+		Value:    impersonation.token(),
+		Path:     "/",
+		Expires:  impersonation.ExpiresAt,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	// Depuis la fiche d'un devis, l'admin arrive directement sur la page client de ce devis
+	next := r.FormValue("next")
+	if next != "/mes-devis" && !strings.HasPrefix(next, "/mes-devis/") {
+		next = "/mes-devis"
+	}
+	http.Redirect(w, r, next, http.StatusSeeOther)
+}
+
+// impersonationStopHandler met fin à la session « voir comme le client » et ramène l'admin à la liste des utilisateurs
+func impersonationStopHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	endImpersonation(w, r)
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+// endImpersonation efface le cookie de session « voir comme le client » et journalise la sortie du mode.
+// Elle indique si une session était en cours
+func endImpersonation(w http.ResponseWriter, r *http.Request) bool {
+	impersonation := currentImpersonation(r)
+	if _, err := r.Cookie(impersonationCookie); err == nil {
+		http.SetCookie(w, &http.Cookie{Name: impersonationCookie, Value: "", Path: "/", MaxAge: -1, HttpOnly: true})
+	}
+	if impersonation == nil {
+		return false
+	}
+
+	// La requête de sortie vient du site client, sans Basic auth : l'auteur est celui inscrit dans le cookie
+	details, _ := json.Marshal(map[string]any{"started_at": impersonation.ExpiresAt.Add(-impersonationDuration).UTC().Format(time.RFC3339)})
+	if err := appendAuditEntry(impersonation.Actor, AuditActionImpersonationStop, fmt.Sprintf("user:%d", impersonation.UserID), clientIP(r), string(details)); err != nil {
+		slog.WarnContext(r.Context(), "Journal d'audit non écrit", "action", AuditActionImpersonationStop, "user_id", impersonation.UserID, "err", err)
+	}
+	return true
+}
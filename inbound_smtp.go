@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"time"
+)
+
+// Délai d'inactivité d'une session SMTP entrante
+const inboundSMTPTimeout = 2 * time.Minute
+
+// startInboundSMTP écoute sur INBOUND_SMTP_ADDR (ex. 127.0.0.1:2525) un SMTP minimal qui remet
+// les réponses aux emails de devis à processInboundEmail. Prévu pour le développement ou derrière
+// un MTA local : pas de TLS ni d'authentification, à ne pas exposer directement sur Internet
+func startInboundSMTP() {
+	addr := getEnv("INBOUND_SMTP_ADDR", "")
+	if addr == "" {
+		return
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		slog.Warn("Serveur SMTP entrant indisponible", "addr", addr, "err", err)
+		return
+	}
+	slog.Info("Serveur SMTP entrant à l'écoute", "addr", listener.Addr().String())
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				slog.Error("Erreur SMTP entrant", "err", err)
+				return
+			}
+			go serveInboundSMTP(conn)
+		}
+	}()
+}
+
+// serveInboundSMTP traite une session : HELO/EHLO, MAIL FROM, RCPT TO, DATA, RSET, NOOP, QUIT
+func serveInboundSMTP(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	reply := func(line string) {
+		conn.SetWriteDeadline(time.Now().Add(inboundSMTPTimeout))
+		fmt.Fprintf(conn, "%s\r\n", line)
+	}
+
+	reply("220 modulspace ESMTP")
+	var sender string
+	var recipients []string
+	transaction := false
+	for {
+		conn.SetReadDeadline(time.Now().Add(inboundSMTPTimeout))
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		verb, argument, _ := strings.Cut(line, " ")
+
+		switch strings.ToUpper(verb) {
+		case "HELO":
+			reply("250 modulspace")
+		case "EHLO":
+			reply("250-modulspace")
+			reply(fmt.Sprintf("250 SIZE %d", inboundEmailMaxBytes))
+		case "MAIL":
+			sender = smtpPathArgument(argument, "FROM:")
+			recipients = nil
+			transaction = true
+			reply("250 OK")
+		case "RCPT":
+			if !transaction {
+				reply("503 MAIL FROM attendu")
+				continue
+			}
+			recipient := smtpPathArgument(argument, "TO:")
+			if _, _, ok := parseQuoteReplyAddress(recipient); !ok {
+				reply("550 Destinataire inconnu")
+				continue
+			}
+			recipients = append(recipients, recipient)
+			reply("250 OK")
+		case "DATA":
+			if len(recipients) == 0 {
+				reply("503 RCPT TO attendu")
+				continue
+			}
+			reply("354 Fin du message par <CRLF>.<CRLF>")
+			message, err := readSMTPData(reader)
+			if err != nil {
+				reply("552 " + err.Error())
+				return
+			}
+			// Chaque message reçu a son identifiant, repris par les emails qu'il déclenche
+			ctx := withRequestID(context.Background(), randomHex(8))
+			if err := processInboundEmail(ctx, message, recipients); err != nil {
+				slog.WarnContext(ctx, "Email entrant refusé", "sender", sender, "err", err)
+				if isInboundRejection(err) {
+					reply("550 " + err.Error())
+				} else {
+					reply("451 Erreur temporaire, réessayez plus tard")
+				}
+			} else {
+				reply("250 OK")
+			}
+			sender, recipients, transaction = "", nil, false
+		case "RSET":
+			sender, recipients, transaction = "", nil, false
+			reply("250 OK")
+		case "NOOP":
+			reply("250 OK")
+		case "QUIT":
+			reply("221 Au revoir")
+			return
+		default:
+			reply("502 Commande non prise en charge")
+		}
+	}
+}
+
+// smtpPathArgument extrait l'adresse de "FROM:<adresse> SIZE=…" ou "TO:<adresse>"
+func smtpPathArgument(argument, prefix string) string {
+	if len(argument) < len(prefix) || !strings.EqualFold(argument[:len(prefix)], prefix) {
+		return ""
+	}
+	path := strings.TrimSpace(argument[len(prefix):])
+	path, _, _ = strings.Cut(path, " ")
+	return strings.Trim(path, "<>")
+}
+
+// readSMTPData lit le message jusqu'à la ligne "." en retirant le point de transparence (RFC 5321 §4.5.2)
+func readSMTPData(reader *bufio.Reader) ([]byte, error) {
+	var message bytes.Buffer
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		if line == ".\r\n" || line == ".\n" {
+			return message.Bytes(), nil
+		}
+		if strings.HasPrefix(line, ".") {
+			line = line[1:]
+		}
+		if message.Len()+len(line) > inboundEmailMaxBytes {
+			return nil, errors.New("message trop volumineux")
+		}
+		message.WriteString(line)
+	}
+}
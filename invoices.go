@@ -0,0 +1,426 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Types de facture : acompte à la commande, puis facture de solde
+const (
+	InvoiceKindDeposit = "deposit"
+	InvoiceKindFinal   = "final"
+)
+
+var invoiceKindLabels = map[string]string{
+	InvoiceKindDeposit: "Facture d'acompte",
+	InvoiceKindFinal:   "Facture de solde",
+}
+
+// Moyens de paiement proposés à la saisie d'un règlement
+var paymentMethods = []string{"virement", "carte", "chèque", "espèces"}
+
+var errInvoiceExists = errors.New("cette facture a déjà été émise pour la commande")
+var errFinalInvoiceIssued = errors.New("la facture de solde a déjà été émise")
+var errPaymentTooLarge = errors.New("le règlement dépasse le reste à payer")
+
+func invoiceKindLabel(kind string) string {
+	if label, ok := invoiceKindLabels[kind]; ok {
+		return label
+	}
+	return kind
+}
+
+// invoiceDepositPercent est la part du total facturée en acompte (INVOICE_DEPOSIT_PERCENT, 30 par défaut)
+func invoiceDepositPercent() int {
+	percent, err := strconv.Atoi(getEnv("INVOICE_DEPOSIT_PERCENT", "30"))
+	if err != nil || percent <= 0 || percent >= 100 {
+		return 30
+	}
+	return percent
+}
+
+// invoiceDueDays est le délai de paiement en jours (INVOICE_DUE_DAYS, 15 par défaut)
+func invoiceDueDays() int {
+	days, err := strconv.Atoi(getEnv("INVOICE_DUE_DAYS", "15"))
+	if err != nil || days < 0 {
+		return 15
+	}
+	return days
+}
+
+// Invoice est une facture émise sur une commande ; AmountDueCents est le montant à encaisser
+// (total TTC, diminué des acomptes pour la facture de solde)
+type Invoice struct {
+	ID             int
+	Number         string
+	OrderID        int
+	Kind           string
+	TotalHTCents   int64
+	TotalVATCents  int64
+	TotalTTCCents  int64
+	AmountDueCents int64
+	PaidCents      int64
+	IssuedAt       time.Time
+	DueAt          time.Time
+}
+
+// OutstandingCents renvoie le reste à payer
+func (i *Invoice) OutstandingCents() int64 {
+	return i.AmountDueCents - i.PaidCents
+}
+
+// IsOverdue indique une facture échue non soldée
+func (i *Invoice) IsOverdue() bool {
+	return i.OutstandingCents() > 0 && time.Now().After(i.DueAt)
+}
+
+// PaymentStatusLabel résume l'état de règlement de la facture
+func (i *Invoice) PaymentStatusLabel() string {
+	switch {
+	case i.OutstandingCents() <= 0:
+		return "Payée"
+	case i.IsOverdue():
+		return "En retard"
+	case i.PaidCents > 0:
+		return "Partiellement payée"
+	default:
+		return "À payer"
+	}
+}
+
+// InvoicePayment est un règlement (partiel ou total) d'une facture
+type InvoicePayment struct {
+	ID          int
+	InvoiceID   int
+	AmountCents int64
+	Method      string
+	Reference   string
+	PaidAt      time.Time
+}
+
+const invoiceColumns = "i.id, i.number, i.order_id, i.kind, i.total_ht_cents, i.total_vat_cents, i.total_ttc_cents, i.amount_due_cents, COALESCE((SELECT SUM(p.amount_cents) FROM invoice_payments p WHERE p.invoice_id = i.id), 0), i.issued_at, i.due_at"
+
+func scanInvoice(scanner interface{ Scan(...any) error }) (*Invoice, error) {
+	invoice := &Invoice{}
+	var issuedAt, dueAt sql.NullTime
+	if err := scanner.Scan(&invoice.ID, &invoice.Number, &invoice.OrderID, &invoice.Kind, &invoice.TotalHTCents, &invoice.TotalVATCents, &invoice.TotalTTCCents,
+		&invoice.AmountDueCents, &invoice.PaidCents, &issuedAt, &dueAt); err != nil {
+		return nil, err
+	}
+	invoice.IssuedAt = issuedAt.Time
+	invoice.DueAt = dueAt.Time
+	return invoice, nil
+}
+
+func queryInvoices(query string, args ...any) ([]*Invoice, error) {
+	if db == nil {
+		return nil, fmt.Errorf("base de données non configurée")
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invoices := make([]*Invoice, 0)
+	for rows.Next() {
+		invoice, err := scanInvoice(rows)
+		if err != nil {
+			return nil, err
+		}
+		invoices = append(invoices, invoice)
+	}
+	return invoices, rows.Err()
+}
+
+// GetInvoiceByID récupère une facture, ou nil si elle n'existe pas
+func GetInvoiceByID(invoiceID int) (*Invoice, error) {
+	if db == nil {
+		return nil, fmt.Errorf("base de données non configurée")
+	}
+
+	invoice, err := scanInvoice(db.QueryRow(
+		rebindQuery("SELECT "+invoiceColumns+" FROM invoices i WHERE i.id = ?"),
+		invoiceID,
+	))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return invoice, err
+}
+
+// listInvoicesForOrder renvoie les factures d'une commande dans l'ordre d'émission
+func listInvoicesForOrder(orderID int) ([]*Invoice, error) {
+	return queryInvoices(
+		rebindQuery("SELECT "+invoiceColumns+" FROM invoices i WHERE i.order_id = ? ORDER BY i.issued_at, i.id"),
+		orderID,
+	)
+}
+
+// listInvoices renvoie toutes les factures, les plus anciennes échéances d'abord
+func listInvoices() ([]*Invoice, error) {
+	return queryInvoices("SELECT " + invoiceColumns + " FROM invoices i ORDER BY i.due_at, i.id")
+}
+
+// listInvoiceLines renvoie les lignes figées d'une facture
+func listInvoiceLines(invoiceID int) ([]QuoteLine, error) {
+	if db == nil {
+		return nil, fmt.Errorf("base de données non configurée")
+	}
+
+	rows, err := db.Query(
+		rebindQuery("SELECT id, invoice_id, label, quantity, unit_price_cents, vat_rate_bp FROM invoice_lines WHERE invoice_id = ? ORDER BY id"),
+		invoiceID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lines := make([]QuoteLine, 0)
+	for rows.Next() {
+		var line QuoteLine
+		if err := rows.Scan(&line.ID, &line.QuoteID, &line.Label, &line.Quantity, &line.UnitPriceCents, &line.VATRateBP); err != nil {
+			return nil, err
+		}
+		lines = append(lines, line)
+	}
+	return lines, rows.Err()
+}
+
+// listInvoicePayments renvoie les règlements d'une facture
+func listInvoicePayments(invoiceID int) ([]InvoicePayment, error) {
+	if db == nil {
+		return nil, fmt.Errorf("base de données non configurée")
+	}
+
+	rows, err := db.Query(
+		rebindQuery("SELECT id, invoice_id, amount_cents, method, reference, paid_at FROM invoice_payments WHERE invoice_id = ? ORDER BY paid_at, id"),
+		invoiceID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	payments := make([]InvoicePayment, 0)
+	for rows.Next() {
+		var payment InvoicePayment
+		var reference sql.NullString
+		var paidAt sql.NullTime
+		if err := rows.Scan(&payment.ID, &payment.InvoiceID, &payment.AmountCents, &payment.Method, &reference, &paidAt); err != nil {
+			return nil, err
+		}
+		payment.Reference = reference.String
+		payment.PaidAt = paidAt.Time
+		payments = append(payments, payment)
+	}
+	return payments, rows.Err()
+}
+
+// depositInvoiceLines répartit l'acompte par taux de TVA pour conserver la ventilation du devis
+func depositInvoiceLines(order *Order, orderLines []QuoteLine, percent int) []QuoteLine {
+	totals := computeQuoteTotals(orderLines)
+	lines := make([]QuoteLine, 0, len(totals.VAT))
+	for _, vat := range totals.VAT {
+		label := fmt.Sprintf("Acompte de %d %% sur la commande %s (%s)", percent, order.Reference, order.Produit)
+		if len(totals.VAT) > 1 {
+			label += " - part TVA " + formatVATRate(vat.RateBP)
+		}
+		lines = append(lines, QuoteLine{
+			Label:          label,
+			Quantity:       1,
+			UnitPriceCents: int64(math.Round(float64(vat.BaseCents) * float64(percent) / 100)),
+			VATRateBP:      vat.RateBP,
+		})
+	}
+	return lines
+}
+
+// issueInvoice émet la facture d'acompte ou de solde d'une commande, numérotée dans la même transaction
+func issueInvoice(order *Order, kind string) (int, error) {
+	if db == nil {
+		return 0, fmt.Errorf("base de données non configurée")
+	}
+	if kind != InvoiceKindDeposit && kind != InvoiceKindFinal {
+		return 0, fmt.Errorf("type de facture invalide: %s", kind)
+	}
+
+	orderLines, err := listOrderLines(order.ID)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// Verrouille la commande : une seule émission de facture à la fois
+	var locked int
+	if err := tx.QueryRow(
+		rebindQuery("SELECT id FROM orders WHERE id = ? FOR UPDATE"),
+		order.ID,
+	).Scan(&locked); err != nil {
+		return 0, err
+	}
+
+	existing := make(map[string]int)
+	var depositTTC int64
+	rows, err := tx.Query(
+		rebindQuery("SELECT kind, total_ttc_cents FROM invoices WHERE order_id = ?"),
+		order.ID,
+	)
+	if err != nil {
+		return 0, err
+	}
+	for rows.Next() {
+		var existingKind string
+		var totalTTC int64
+		if err := rows.Scan(&existingKind, &totalTTC); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		existing[existingKind]++
+		if existingKind == InvoiceKindDeposit {
+			depositTTC += totalTTC
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	if existing[InvoiceKindFinal] > 0 {
+		return 0, errFinalInvoiceIssued
+	}
+	if kind == InvoiceKindDeposit && existing[InvoiceKindDeposit] > 0 {
+		return 0, errInvoiceExists
+	}
+
+	lines := orderLines
+	if kind == InvoiceKindDeposit {
+		lines = depositInvoiceLines(order, orderLines, invoiceDepositPercent())
+	}
+	totals := computeQuoteTotals(lines)
+	amountDue := totals.TotalTTCCents
+	if kind == InvoiceKindFinal {
+		amountDue -= depositTTC
+	}
+
+	now := time.Now()
+	number, err := nextDocumentNumber(tx, DocumentPrefixInvoice, now)
+	if err != nil {
+		return 0, err
+	}
+	dueAt := now.AddDate(0, 0, invoiceDueDays())
+
+	var invoiceID int
+	args := []any{number, order.ID, kind, totals.TotalHTCents, totals.TotalVATCents, totals.TotalTTCCents, amountDue, now, dueAt}
+	if dbDriver == "postgres" {
+		err = tx.QueryRow(
+			"INSERT INTO invoices (number, order_id, kind, total_ht_cents, total_vat_cents, total_ttc_cents, amount_due_cents, issued_at, due_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id",
+			args...,
+		).Scan(&invoiceID)
+	} else {
+		var result sql.Result
+		result, err = tx.Exec(
+			"INSERT INTO invoices (number, order_id, kind, total_ht_cents, total_vat_cents, total_ttc_cents, amount_due_cents, issued_at, due_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+			args...,
+		)
+		if err == nil {
+			var lastID int64
+			lastID, err = result.LastInsertId()
+			invoiceID = int(lastID)
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	for _, line := range lines {
+		if _, err := tx.Exec(
+			rebindQuery("INSERT INTO invoice_lines (invoice_id, label, quantity, unit_price_cents, vat_rate_bp) VALUES (?, ?, ?, ?, ?)"),
+			invoiceID, line.Label, line.Quantity, line.UnitPriceCents, line.VATRateBP,
+		); err != nil {
+			return 0, err
+		}
+	}
+
+	// L'acompte réglé en ligne à l'acceptation du devis solde la première facture émise
+	if len(existing) == 0 && order.QuoteID > 0 {
+		if err := carryQuoteDeposits(tx, order.QuoteID, invoiceID, amountDue); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return invoiceID, nil
+}
+
+// recordInvoicePayment enregistre un règlement sans dépasser le reste à payer
+func recordInvoicePayment(invoiceID int, amountCents int64, method, reference string, paidAt time.Time) error {
+	if db == nil {
+		return fmt.Errorf("base de données non configurée")
+	}
+	if amountCents <= 0 {
+		return fmt.Errorf("montant de règlement invalide")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	outstanding, err := lockInvoiceOutstanding(tx, invoiceID)
+	if err != nil {
+		return err
+	}
+	if amountCents > outstanding {
+		return errPaymentTooLarge
+	}
+
+	if err := insertInvoicePayment(tx, invoiceID, amountCents, method, reference, paidAt); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// lockInvoiceOutstanding verrouille la facture et renvoie son reste à payer : deux règlements
+// concurrents sont vérifiés l'un après l'autre
+func lockInvoiceOutstanding(tx *sql.Tx, invoiceID int) (int64, error) {
+	var amountDue, paid int64
+	if err := tx.QueryRow(
+		rebindQuery("SELECT amount_due_cents FROM invoices WHERE id = ? FOR UPDATE"),
+		invoiceID,
+	).Scan(&amountDue); err != nil {
+		return 0, err
+	}
+	if err := tx.QueryRow(
+		rebindQuery("SELECT COALESCE(SUM(amount_cents), 0) FROM invoice_payments WHERE invoice_id = ?"),
+		invoiceID,
+	).Scan(&paid); err != nil {
+		return 0, err
+	}
+	return amountDue - paid, nil
+}
+
+// insertInvoicePayment ajoute un règlement dans la transaction de l'appelant (montant négatif pour un remboursement)
+func insertInvoicePayment(tx *sql.Tx, invoiceID int, amountCents int64, method, reference string, paidAt time.Time) error {
+	_, err := tx.Exec(
+		rebindQuery("INSERT INTO invoice_payments (invoice_id, amount_cents, method, reference, paid_at) VALUES (?, ?, ?, ?, ?)"),
+		invoiceID, amountCents, method, strings.TrimSpace(reference), paidAt,
+	)
+	return err
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Les journaux passent par log/slog : JSON en production (APP_ENV=production), texte lisible en développement.
+// Chaque ligne écrite pendant une requête porte son request_id, et les données personnelles sont masquées
+// avant l'écriture, quel que soit l'appelant
+
+// requestIDHeader est repris d'un proxy s'il est fourni, et renvoyé au client dans la réponse
+const requestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// initLogger installe le logger par défaut. Le paquet log standard (serveur HTTP, pilotes) y est redirigé
+func initLogger() {
+	level := slog.LevelInfo
+	switch strings.ToLower(getEnv("LOG_LEVEL", "info")) {
+	case "debug":
+		level = slog.LevelDebug
+	case "warn":
+		level = slog.LevelWarn
+	case "error":
+		level = slog.LevelError
+	}
+
+	options := &slog.HandlerOptions{Level: level, ReplaceAttr: redactLogAttr}
+	var handler slog.Handler
+	if getEnv("APP_ENV", "development") == "production" {
+		handler = slog.NewJSONHandler(os.Stderr, options)
+	} else {
+		handler = slog.NewTextHandler(os.Stderr, options)
+	}
+	slog.SetDefault(slog.New(requestIDHandler{handler}))
+}
+
+// requestIDHandler ajoute le request_id du contexte à chaque enregistrement
+type requestIDHandler struct {
+	slog.Handler
+}
+
+func (h requestIDHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id := requestIDFromContext(ctx); id != "" {
+		record.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h requestIDHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return requestIDHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h requestIDHandler) WithGroup(name string) slog.Handler {
+	return requestIDHandler{h.Handler.WithGroup(name)}
+}
+
+func withRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+func requestIDFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// statusRecorder retient le statut écrit par le handler pour la ligne de journal de la requête
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// requestIDMiddleware attribue un identifiant à chaque requête, le place dans le contexte et l'en-tête de réponse,
+// puis journalise la requête. Les fichiers statiques ne sont journalisés qu'au niveau debug
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = randomHex(8)
+		}
+		w.Header().Set(requestIDHeader, id)
+		ctx := withRequestID(r.Context(), id)
+
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+
+		level := slog.LevelInfo
+		if strings.HasPrefix(r.URL.Path, "/static/") || strings.HasPrefix(r.URL.Path, "/img/") || strings.HasPrefix(r.URL.Path, "/fonts/") {
+			level = slog.LevelDebug
+		}
+		slog.Log(ctx, level, "Requête HTTP", "method", r.Method, "path", r.URL.Path, "status", recorder.status,
+			"duration_ms", time.Since(start).Milliseconds())
+	})
+}
+
+var (
+	logURLCredentials = regexp.MustCompile(`://([^:/@\s]+):[^@/\s]+@`)
+	logEmailAddress   = regexp.MustCompile(`([A-Za-z0-9._%+-])[A-Za-z0-9._%+-]*@([A-Za-z0-9.-]+\.[A-Za-z]{2,})`)
+	logPhoneNumber    = regexp.MustCompile(`(?:\+33[\s.-]?|\b0)[1-9](?:[\s.-]?\d{2}){3}[\s.-]?(\d{2})\b`)
+)
+
+// logSecretKeys sont les attributs dont la valeur n'est jamais écrite
+var logSecretKeys = []string{"password", "secret", "token", "authorization", "cookie", "signature"}
+
+// redactLogAttr masque les données sensibles d'un attribut avant son écriture : valeur entière pour
+// les secrets, adresses email, numéros de téléphone et mots de passe d'URL dans tout texte (message compris)
+func redactLogAttr(groups []string, attr slog.Attr) slog.Attr {
+	key := strings.ToLower(attr.Key)
+	for _, secret := range logSecretKeys {
+		if strings.Contains(key, secret) {
+			return slog.String(attr.Key, "[masqué]")
+		}
+	}
+
+	switch attr.Value.Kind() {
+	case slog.KindString:
+		return slog.String(attr.Key, redactLogText(attr.Value.String()))
+	case slog.KindAny:
+		switch value := attr.Value.Any().(type) {
+		case error:
+			return slog.String(attr.Key, redactLogText(value.Error()))
+		case []string:
+			redacted := make([]string, len(value))
+			for i, text := range value {
+				redacted[i] = redactLogText(text)
+			}
+			return slog.Any(attr.Key, redacted)
+		}
+	}
+	return attr
+}
+
+// redactLogText garde de quoi reconnaître une donnée sans l'exposer : « l***@example.com », « ** ** ** ** 78 »
+func redactLogText(text string) string {
+	text = logURLCredentials.ReplaceAllString(text, "://$1:***@")
+	text = logEmailAddress.ReplaceAllString(text, "$1***@$2")
+	return logPhoneNumber.ReplaceAllString(text, "** ** ** ** $1")
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// MailTransport remet un message MIME complet à ses destinataires. ctx porte l'identifiant de la requête
+// qui a mis l'email en file, pour les journaux
+type MailTransport interface {
+	Name() string
+	Send(ctx context.Context, from string, recipients []string, message []byte) error
+}
+
+// Modes TLS de la connexion SMTP (SMTP_TLS)
+const (
+	SMTPTLSStartTLS = "starttls"
+	SMTPTLSImplicit = "tls"
+	SMTPTLSNone     = "none"
+)
+
+// Transport et signataire DKIM actifs, choisis au démarrage par initMailTransport
+var (
+	mailTransport MailTransport
+	mailSigner    *dkimSigner
+)
+
+// initMailTransport choisit le transport d'après MAIL_TRANSPORT (smtp, file ou maildir) et charge la clé DKIM.
+// Sans MAIL_TRANSPORT, le transport est smtp si SMTP_HOST est renseigné, sinon maildir (développement).
+func initMailTransport() {
+	name := getEnv("MAIL_TRANSPORT", "")
+	if name == "" {
+		name = "maildir"
+		if getEnv("SMTP_HOST", "") != "" {
+			name = "smtp"
+		}
+	}
+
+	switch name {
+	case "smtp":
+		transport, err := newSMTPTransport()
+		if err != nil {
+			slog.Warn("Transport SMTP invalide, emails écrits sur disque", "err", err, "mail_dir", mailDir())
+			mailTransport = &fileTransport{dir: mailDir(), maildir: true}
+		} else {
+			mailTransport = transport
+			slog.Info("Emails envoyés par SMTP", "host", transport.host, "port", transport.port, "tls_mode", transport.tlsMode)
+		}
+	case "file", "maildir":
+		mailTransport = &fileTransport{dir: mailDir(), maildir: name == "maildir"}
+		slog.Info("Emails écrits sur disque", "mail_dir", mailDir(), "transport", name)
+	default:
+		slog.Warn("Transport email inconnu, emails écrits sur disque", "transport", name, "mail_dir", mailDir())
+		mailTransport = &fileTransport{dir: mailDir(), maildir: true}
+	}
+
+	signer, err := loadDKIMSigner()
+	if err != nil {
+		slog.Warn("Signature DKIM désactivée", "err", err)
+	} else if signer != nil {
+		slog.Info("Emails signés DKIM", "domain", signer.domain, "selector", signer.selector)
+	}
+	mailSigner = signer
+}
+
+// mailDir est le dossier des transports file et maildir (MAIL_DIR)
+func mailDir() string {
+	return getEnv("MAIL_DIR", filepath.Join("var", "mail"))
+}
+
+// mailSender est l'expéditeur des emails : MAIL_FROM ("Nom <adresse>" ou adresse seule),
+// sinon le compte SMTP, sinon l'adresse de contact
+func mailSender(company companyInfo) mail.Address {
+	if from := getEnv("MAIL_FROM", ""); from != "" {
+		address, err := mail.ParseAddress(from)
+		if err == nil {
+			if address.Name == "" {
+				address.Name = company.Name
+			}
+			return *address
+		}
+		slog.Warn("MAIL_FROM invalide", "from", from, "err", err)
+	}
+	address := getEnv("SMTP_USER", "")
+	if address == "" {
+		address = company.Email
+	}
+	return mail.Address{Name: company.Name, Address: address}
+}
+
+// mailReplyTo est l'adresse de réponse des emails (MAIL_REPLY_TO), vide par défaut
+func mailReplyTo() string {
+	replyTo := getEnv("MAIL_REPLY_TO", "")
+	if replyTo == "" {
+		return ""
+	}
+	address, err := mail.ParseAddress(replyTo)
+	if err != nil {
+		slog.Warn("MAIL_REPLY_TO invalide", "reply_to", replyTo, "err", err)
+		return ""
+	}
+	return address.String()
+}
+
+// sendEmail signe le message (DKIM) puis le remet au transport configuré
+func sendEmail(ctx context.Context, from string, recipients []string, message []byte) error {
+	if mailTransport == nil {
+		return fmt.Errorf("transport email non configuré")
+	}
+	message = normalizeCRLF(message)
+	if mailSigner != nil {
+		signed, err := mailSigner.Sign(message)
+		if err != nil {
+			return fmt.Errorf("erreur signature DKIM: %v", err)
+		}
+		message = signed
+	}
+	return mailTransport.Send(ctx, from, recipients, message)
+}
+
+// normalizeCRLF convertit les fins de ligne en CRLF, comme l'exige SMTP
+func normalizeCRLF(message []byte) []byte {
+	message = bytes.ReplaceAll(message, []byte("\r\n"), []byte("\n"))
+	return bytes.ReplaceAll(message, []byte("\n"), []byte("\r\n"))
+}
+
+// smtpTransport envoie les emails à un serveur SMTP, en STARTTLS obligatoire, TLS implicite (port 465) ou en clair
+type smtpTransport struct {
+	host     string
+	port     string
+	username string
+	password string
+	tlsMode  string
+	timeout  time.Duration
+	tls      *tls.Config
+}
+
+// newSMTPTransport lit SMTP_HOST, SMTP_PORT, SMTP_USER, SMTP_PASS, SMTP_TLS, SMTP_TIMEOUT_SECONDS,
+// SMTP_TLS_SERVER_NAME et SMTP_TLS_PIN (empreintes SHA-256 base64 de la clé publique, séparées par des virgules)
+func newSMTPTransport() (*smtpTransport, error) {
+	transport := &smtpTransport{
+		host:     getEnv("SMTP_HOST", ""),
+		port:     getEnv("SMTP_PORT", ""),
+		username: getEnv("SMTP_USER", ""),
+		password: getEnv("SMTP_PASS", ""),
+		tlsMode:  getEnv("SMTP_TLS", ""),
+		timeout:  30 * time.Second,
+	}
+	if transport.host == "" {
+		return nil, fmt.Errorf("SMTP_HOST manquant")
+	}
+
+	if transport.tlsMode == "" {
+		transport.tlsMode = SMTPTLSStartTLS
+		if transport.port == "465" {
+			transport.tlsMode = SMTPTLSImplicit
+		}
+	}
+	switch transport.tlsMode {
+	case SMTPTLSStartTLS:
+		if transport.port == "" {
+			transport.port = "587"
+		}
+	case SMTPTLSImplicit:
+		if transport.port == "" {
+			transport.port = "465"
+		}
+	case SMTPTLSNone:
+		if transport.port == "" {
+			transport.port = "25"
+		}
+	default:
+		return nil, fmt.Errorf("SMTP_TLS inconnu: %s (starttls, tls ou none)", transport.tlsMode)
+	}
+
+	if value := getEnv("SMTP_TIMEOUT_SECONDS", ""); value != "" {
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds <= 0 {
+			return nil, fmt.Errorf("SMTP_TIMEOUT_SECONDS invalide: %s", value)
+		}
+		transport.timeout = time.Duration(seconds) * time.Second
+	}
+
+	transport.tls = &tls.Config{
+		ServerName: getEnv("SMTP_TLS_SERVER_NAME", transport.host),
+		MinVersion: tls.VersionTLS12,
+	}
+	if pins := getEnv("SMTP_TLS_PIN", ""); pins != "" {
+		allowed := make(map[string]bool)
+		for _, pin := range strings.Split(pins, ",") {
+			if pin = strings.TrimSpace(pin); pin != "" {
+				allowed[pin] = true
+			}
+		}
+		transport.tls.VerifyConnection = func(state tls.ConnectionState) error {
+			if len(state.PeerCertificates) == 0 {
+				return fmt.Errorf("aucun certificat présenté")
+			}
+			pin := publicKeyPin(state.PeerCertificates[0])
+			if !allowed[pin] {
+				return fmt.Errorf("certificat SMTP non épinglé (sha256/%s)", pin)
+			}
+			return nil
+		}
+	}
+	return transport, nil
+}
+
+// publicKeyPin est l'empreinte SHA-256 (base64) de la clé publique d'un certificat, format de SMTP_TLS_PIN
+func publicKeyPin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func (t *smtpTransport) Name() string { return "smtp" }
+
+func (t *smtpTransport) Send(ctx context.Context, from string, recipients []string, message []byte) error {
+	address := net.JoinHostPort(t.host, t.port)
+	dialer := &net.Dialer{Timeout: t.timeout}
+
+	var conn net.Conn
+	var err error
+	if t.tlsMode == SMTPTLSImplicit {
+		conn, err = tls.DialWithDialer(dialer, "tcp", address, t.tls)
+	} else {
+		conn, err = dialer.Dial("tcp", address)
+	}
+	if err != nil {
+		return fmt.Errorf("connexion SMTP %s: %v", address, err)
+	}
+	// Le délai couvre toute la session, jusqu'à la fin de l'envoi
+	conn.SetDeadline(time.Now().Add(t.timeout))
+
+	client, err := smtp.NewClient(conn, t.host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("session SMTP %s: %v", address, err)
+	}
+	defer client.Close()
+
+	if t.tlsMode == SMTPTLSStartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("le serveur %s ne propose pas STARTTLS", address)
+		}
+		if err := client.StartTLS(t.tls); err != nil {
+			return fmt.Errorf("STARTTLS %s: %v", address, err)
+		}
+	}
+
+	if t.username != "" {
+		if ok, _ := client.Extension("AUTH"); !ok {
+			return fmt.Errorf("le serveur %s ne propose pas d'authentification", address)
+		}
+		if err := client.Auth(smtp.PlainAuth("", t.username, t.password, t.host)); err != nil {
+			return fmt.Errorf("authentification SMTP: %v", err)
+		}
+	}
+
+	if err := client.Mail(from); err != nil {
+		return fmt.Errorf("MAIL FROM: %v", err)
+	}
+	for _, recipient := range recipients {
+		if err := client.Rcpt(recipient); err != nil {
+			return fmt.Errorf("RCPT TO %s: %v", recipient, err)
+		}
+	}
+	writer, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("DATA: %v", err)
+	}
+	if _, err := writer.Write(message); err != nil {
+		return fmt.Errorf("envoi du message: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("envoi du message: %v", err)
+	}
+	return client.Quit()
+}
+
+// fileTransport écrit chaque email dans un dossier : un fichier .eml par message,
+// ou une boîte Maildir (tmp/new/cur) lisible par un client mail
+type fileTransport struct {
+	dir     string
+	maildir bool
+}
+
+var fileTransportCounter atomic.Int64
+
+func (t *fileTransport) Name() string {
+	if t.maildir {
+		return "maildir"
+	}
+	return "file"
+}
+
+func (t *fileTransport) Send(ctx context.Context, from string, recipients []string, message []byte) error {
+	hostname, _ := os.Hostname()
+	name := fmt.Sprintf("%d.M%dP%dQ%d.%s", time.Now().Unix(), time.Now().Nanosecond()/1000, os.Getpid(), fileTransportCounter.Add(1), strings.ReplaceAll(hostname, "/", "_"))
+
+	if !t.maildir {
+		if err := os.MkdirAll(t.dir, 0o755); err != nil {
+			return err
+		}
+		path := filepath.Join(t.dir, name+".eml")
+		if err := os.WriteFile(path, message, 0o644); err != nil {
+			return err
+		}
+		slog.InfoContext(ctx, "Email écrit sur disque", "recipients", recipients, "path", path)
+		return nil
+	}
+
+	// Maildir : écriture dans tmp/ puis renommage atomique dans new/
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(t.dir, sub), 0o755); err != nil {
+			return err
+		}
+	}
+	tmpPath := filepath.Join(t.dir, "tmp", name)
+	if err := os.WriteFile(tmpPath, message, 0o644); err != nil {
+		return err
+	}
+	newPath := filepath.Join(t.dir, "new", name)
+	if err := os.Rename(tmpPath, newPath); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	slog.InfoContext(ctx, "Email écrit sur disque", "recipients", recipients, "path", newPath)
+	return nil
+}
@@ -1,19 +1,20 @@
 package main
 
 import (
+	"context"
 	"crypto/subtle"
 	"database/sql"
 	"encoding/json"
 	"fmt"
 	"html"
-	"log"
+	"log/slog"
 	"net/http"
-	"net/smtp"
 	"net/url"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 	_ "github.com/go-sql-driver/mysql"
@@ -26,21 +27,92 @@ var dbDriver string
 
 func main() {
 	_ = godotenv.Load()
+	initLogger()
+	initMailTransport()
+	initBlobStore()
 
 	if err := InitDB(); err != nil {
-		log.Printf("⚠️ Erreur DB: %v", err)
+		slog.Warn("Erreur DB", "err", err)
+	} else {
+		startOutboxWorker()
+		startWebhookWorker()
+		if err := migrateNotifyWebhook(); err != nil {
+			slog.Error("Erreur reprise de NOTIFY_WEBHOOK_URL en abonnement webhook", "err", err)
+		}
+		startInboundSMTP()
+		startTrashPurger()
 	}
 	defer CloseDB()
 
+	initPaymentProvider()
+	initNotifiers()
+
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", indexHandler)
 	mux.HandleFunc("/register", registerHandler)
 	mux.HandleFunc("/login", loginHandler)
 	mux.HandleFunc("/logout", logoutHandler)
-	mux.HandleFunc("/api/quote", quoteHandler)
-	mux.HandleFunc("/api/user", userHandler)
+	registerAPIRoutes(mux)
 	mux.HandleFunc("/admin", adminHandler)
 	mux.HandleFunc("/admin/delete-user", adminDeleteUserHandler)
+	mux.HandleFunc("/admin/users", adminUsersHandler)
+	mux.HandleFunc("/admin/users/{id}/impersonate", adminImpersonateHandler)
+	mux.HandleFunc("/impersonation/stop", impersonationStopHandler)
+	mux.HandleFunc("/admin/bulk", adminBulkHandler)
+	mux.HandleFunc("/admin/users/export", adminExportHandler("users"))
+	mux.HandleFunc("/admin/quotes", adminQuotesHandler)
+	mux.HandleFunc("/admin/analytics", adminAnalyticsHandler)
+	mux.HandleFunc("/admin/audit", adminAuditHandler)
+	mux.HandleFunc("/admin/trash", adminTrashHandler)
+	mux.HandleFunc("/admin/trash/restore", adminTrashRestoreHandler)
+	mux.HandleFunc("/admin/quotes/export", adminExportHandler("quotes"))
+	mux.HandleFunc("/admin/orders/export", adminExportHandler("orders"))
+	mux.HandleFunc("/admin/quotes/{id}", adminQuoteHandler)
+	mux.HandleFunc("/admin/quotes/{id}/lines", adminQuoteLinesHandler)
+	mux.HandleFunc("/admin/quotes/{id}/lines/update", adminUpdateQuoteLineHandler)
+	mux.HandleFunc("/admin/quotes/{id}/lines/delete", adminDeleteQuoteLineHandler)
+	mux.HandleFunc("/admin/quotes/{id}/assign", adminQuoteAssignHandler)
+	mux.HandleFunc("/admin/quotes/{id}/notes", adminQuoteNoteHandler)
+	mux.HandleFunc("/admin/quotes/{id}/status", adminQuoteStatusHandler)
+	mux.HandleFunc("/admin/quotes/{id}/delete", adminDeleteQuoteHandler)
+	mux.HandleFunc("/admin/quotes/{id}/messages", adminQuoteMessageHandler)
+	mux.HandleFunc("/admin/quotes/{id}/pdf", adminQuotePDFHandler)
+	mux.HandleFunc("/admin/quotes/{id}/order", adminConvertQuoteHandler)
+	mux.HandleFunc("/admin/orders/{id}", adminOrderHandler)
+	mux.HandleFunc("/admin/orders/{id}/advance", adminAdvanceOrderHandler)
+	mux.HandleFunc("/admin/orders/{id}/delivery-date", adminOrderDeliveryDateHandler)
+	mux.HandleFunc("/admin/orders/{id}/invoices", adminIssueInvoiceHandler)
+	mux.HandleFunc("/admin/invoices/{id}", adminInvoiceHandler)
+	mux.HandleFunc("/admin/invoices/{id}/payments", adminInvoicePaymentHandler)
+	mux.HandleFunc("/admin/invoices/{id}/pdf", adminInvoicePDFHandler)
+	mux.HandleFunc("/admin/receivables", adminReceivablesHandler)
+	mux.HandleFunc("/admin/payments", adminPaymentsHandler)
+	mux.HandleFunc("/admin/emails", adminEmailsHandler)
+	mux.HandleFunc("/admin/emails/{id}", adminEmailHandler)
+	mux.HandleFunc("/admin/emails/{id}/resend", adminResendEmailHandler)
+	mux.HandleFunc("/admin/payments/{id}/refund", adminRefundPaymentHandler)
+	mux.HandleFunc("/mes-devis", mesDevisHandler)
+	mux.HandleFunc("/profil", profilHandler)
+	mux.HandleFunc("/mes-devis/{id}", mesDevisDetailHandler)
+	mux.HandleFunc("/mes-devis/{id}/messages", mesDevisMessageHandler)
+	mux.HandleFunc("/mes-devis/{id}/pdf", mesDevisPDFHandler)
+	mux.HandleFunc("/mes-devis/{id}/pay", mesDevisPayHandler)
+	mux.HandleFunc("/mes-factures/{id}/pdf", mesFacturePDFHandler)
+	mux.HandleFunc("/mes-factures/{id}/pay", mesFacturePayHandler)
+	mux.HandleFunc("/api/payments/webhook", paymentWebhookHandler)
+	mux.HandleFunc("/api/inbound-email", inboundEmailHandler)
+	mux.HandleFunc("/attachments/{id}", attachmentHandler)
+	mux.HandleFunc("/payments/fake/checkout/{session}", fakeCheckoutHandler)
+	mux.HandleFunc("/webhooks/test-receiver", webhookTestReceiverHandler)
+	mux.HandleFunc("/admin/api-tokens", adminAPITokensHandler)
+	mux.HandleFunc("/admin/api-tokens/{id}/revoke", adminRevokeAPITokenHandler)
+	mux.HandleFunc("/admin/webhooks", adminWebhooksHandler)
+	mux.HandleFunc("/admin/webhooks/{id}", adminWebhookHandler)
+	mux.HandleFunc("/admin/webhooks/{id}/toggle", adminToggleWebhookHandler)
+	mux.HandleFunc("/admin/webhooks/{id}/delete", adminDeleteWebhookHandler)
+	mux.HandleFunc("/admin/webhooks/{id}/test", adminTestWebhookHandler)
+	mux.HandleFunc("/admin/webhook-deliveries/{id}", adminWebhookDeliveryHandler)
+	mux.HandleFunc("/admin/webhook-deliveries/{id}/redeliver", adminRedeliverWebhookHandler)
 	mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("static"))))
 	mux.Handle("/img/", http.StripPrefix("/img/", http.FileServer(http.Dir("static/img"))))
 	mux.Handle("/fonts/", http.StripPrefix("/fonts/", http.FileServer(http.Dir("fonts"))))
@@ -49,8 +121,10 @@ func main() {
 	if port == "" {
 		port = "8080"
 	}
-	log.Printf("Serveur Modul-space démarré sur http://localhost:%s", port)
-	log.Fatal(http.ListenAndServe(":"+port, mux))
+	slog.Info("Serveur Modul-space démarré", "url", "http://localhost:"+port)
+	err := http.ListenAndServe(":"+port, requestIDMiddleware(impersonationGuard(mux)))
+	slog.Error("Arrêt du serveur", "err", err)
+	os.Exit(1)
 }
 
 // InitDB initialise la base de données PostgreSQL (Scalingo) ou MySQL local en fallback
@@ -66,20 +140,20 @@ func InitDB() error {
 				db = postgresDB
 				migrateErr := createTablesPostgres()
 				if migrateErr == nil {
-					log.Println("✅ Connecté à PostgreSQL (DATABASE_URL)")
-					log.Println("✅ Tables users et quotes créées/vérifiées")
+					slog.Info("Connecté à PostgreSQL (DATABASE_URL)")
+					slog.Info("Tables users et quotes créées/vérifiées")
 					return nil
 				}
-				log.Printf("⚠️ Erreur DB PostgreSQL (migrations): %v", migrateErr)
+				slog.Warn("Erreur DB PostgreSQL (migrations)", "err", migrateErr)
 			} else {
-				log.Printf("⚠️ Erreur DB PostgreSQL (ping): %v", pingErr)
+				slog.Warn("Erreur DB PostgreSQL (ping)", "err", pingErr)
 			}
 			_ = postgresDB.Close()
 		} else {
-			log.Printf("⚠️ Erreur DB PostgreSQL (open): %v", openErr)
+			slog.Warn("Erreur DB PostgreSQL (open)", "err", openErr)
 		}
 
-		log.Println("ℹ️ Fallback vers MySQL local")
+		slog.Info("Fallback vers MySQL local")
 	}
 
 	dbDriver = "mysql"
@@ -108,8 +182,8 @@ func InitDB() error {
 		return err
 	}
 
-	log.Println("✅ Connecté à MySQL local")
-	log.Println("✅ Tables users et quotes créées/vérifiées")
+	slog.Info("Connecté à MySQL local")
+	slog.Info("Tables users et quotes créées/vérifiées")
 	return nil
 }
 
@@ -124,7 +198,7 @@ func normalizePostgresDSN(dsn string) string {
 	if sslMode == "prefer" {
 		query.Set("sslmode", "require")
 		parsed.RawQuery = query.Encode()
-		log.Println("ℹ️ sslmode=prefer détecté, remplacé par sslmode=require pour PostgreSQL")
+		slog.Info("sslmode=prefer détecté, remplacé par sslmode=require pour PostgreSQL")
 	}
 
 	return parsed.String()
@@ -138,6 +212,9 @@ func createTablesMySQL() error {
 		password_hash VARCHAR(255) NOT NULL,
 		nom VARCHAR(100),
 		prenom VARCHAR(100),
+		email_notifications BOOLEAN NOT NULL DEFAULT TRUE,
+		deleted_at TIMESTAMP NULL,
+		anonymized_at TIMESTAMP NULL,
 		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 	)`
 
@@ -145,56 +222,880 @@ func createTablesMySQL() error {
 		return fmt.Errorf("erreur création table users: %v", err)
 	}
 
-	queryQuotes := `
-	CREATE TABLE IF NOT EXISTS quotes (
-		id INT AUTO_INCREMENT PRIMARY KEY,
-		nom VARCHAR(100) NOT NULL,
-		prenom VARCHAR(100) NOT NULL,
-		email VARCHAR(255) NOT NULL,
-		telephone VARCHAR(20),
-		produit VARCHAR(255) NOT NULL,
-		message TEXT,
+	queryQuotes := `
+	CREATE TABLE IF NOT EXISTS quotes (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		nom VARCHAR(100) NOT NULL,
+		prenom VARCHAR(100) NOT NULL,
+		email VARCHAR(255) NOT NULL,
+		telephone VARCHAR(20),
+		produit VARCHAR(255) NOT NULL,
+		configuration VARCHAR(100) NULL,
+		message TEXT,
+		user_id INT NULL,
+		reference VARCHAR(20) NULL UNIQUE,
+		status VARCHAR(20) NOT NULL DEFAULT 'pending',
+		rejection_reason TEXT NULL,
+		assigned_to VARCHAR(100) NULL,
+		quoted_at TIMESTAMP NULL,
+		first_response_at TIMESTAMP NULL,
+		deleted_at TIMESTAMP NULL,
+		anonymized_at TIMESTAMP NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`
+
+	if _, err := db.Exec(queryQuotes); err != nil {
+		return fmt.Errorf("erreur création table quotes: %v", err)
+	}
+
+	if err := addColumnIfMissing("quotes", "user_id", "INT NULL"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing("quotes", "status", "VARCHAR(20) NOT NULL DEFAULT 'pending'"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing("quotes", "quoted_at", "TIMESTAMP NULL"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing("quotes", "reference", "VARCHAR(20) NULL UNIQUE"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing("quotes", "configuration", "VARCHAR(100) NULL"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing("quotes", "rejection_reason", "TEXT NULL"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing("quotes", "assigned_to", "VARCHAR(100) NULL"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing("quotes", "first_response_at", "TIMESTAMP NULL"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing("quotes", "deleted_at", "TIMESTAMP NULL"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing("users", "deleted_at", "TIMESTAMP NULL"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing("quotes", "anonymized_at", "TIMESTAMP NULL"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing("users", "anonymized_at", "TIMESTAMP NULL"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing("users", "email_notifications", "BOOLEAN NOT NULL DEFAULT TRUE"); err != nil {
+		return err
+	}
+
+	queryQuoteLines := `
+	CREATE TABLE IF NOT EXISTS quote_lines (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		quote_id INT NOT NULL,
+		label VARCHAR(255) NOT NULL,
+		quantity INT NOT NULL DEFAULT 1,
+		unit_price_cents BIGINT NOT NULL DEFAULT 0,
+		vat_rate_bp INT NOT NULL DEFAULT 2000,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		INDEX idx_quote_lines_quote (quote_id)
+	)`
+
+	if _, err := db.Exec(queryQuoteLines); err != nil {
+		return fmt.Errorf("erreur création table quote_lines: %v", err)
+	}
+
+	querySequences := `
+	CREATE TABLE IF NOT EXISTS document_sequences (
+		prefix VARCHAR(10) NOT NULL,
+		year INT NOT NULL,
+		last_value INT NOT NULL DEFAULT 0,
+		PRIMARY KEY (prefix, year)
+	)`
+
+	if _, err := db.Exec(querySequences); err != nil {
+		return fmt.Errorf("erreur création table document_sequences: %v", err)
+	}
+
+	queryOrders := `
+	CREATE TABLE IF NOT EXISTS orders (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		reference VARCHAR(20) NOT NULL UNIQUE,
+		quote_id INT NOT NULL UNIQUE,
+		user_id INT NULL,
+		nom VARCHAR(100) NOT NULL,
+		prenom VARCHAR(100) NOT NULL,
+		email VARCHAR(255) NOT NULL,
+		telephone VARCHAR(20),
+		produit VARCHAR(255) NOT NULL,
+		status VARCHAR(20) NOT NULL DEFAULT 'manufacturing',
+		total_ht_cents BIGINT NOT NULL,
+		total_vat_cents BIGINT NOT NULL,
+		total_ttc_cents BIGINT NOT NULL,
+		expected_ready_at TIMESTAMP NULL,
+		expected_delivery_at TIMESTAMP NULL,
+		ready_at TIMESTAMP NULL,
+		shipped_at TIMESTAMP NULL,
+		delivered_at TIMESTAMP NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`
+
+	if _, err := db.Exec(queryOrders); err != nil {
+		return fmt.Errorf("erreur création table orders: %v", err)
+	}
+
+	queryOrderLines := `
+	CREATE TABLE IF NOT EXISTS order_lines (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		order_id INT NOT NULL,
+		label VARCHAR(255) NOT NULL,
+		quantity INT NOT NULL,
+		unit_price_cents BIGINT NOT NULL,
+		vat_rate_bp INT NOT NULL,
+		INDEX idx_order_lines_order (order_id)
+	)`
+
+	if _, err := db.Exec(queryOrderLines); err != nil {
+		return fmt.Errorf("erreur création table order_lines: %v", err)
+	}
+
+	queryInvoices := `
+	CREATE TABLE IF NOT EXISTS invoices (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		number VARCHAR(20) NOT NULL UNIQUE,
+		order_id INT NOT NULL,
+		kind VARCHAR(20) NOT NULL,
+		total_ht_cents BIGINT NOT NULL,
+		total_vat_cents BIGINT NOT NULL,
+		total_ttc_cents BIGINT NOT NULL,
+		amount_due_cents BIGINT NOT NULL,
+		issued_at TIMESTAMP NULL,
+		due_at TIMESTAMP NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		INDEX idx_invoices_order (order_id)
+	)`
+
+	if _, err := db.Exec(queryInvoices); err != nil {
+		return fmt.Errorf("erreur création table invoices: %v", err)
+	}
+
+	queryInvoiceLines := `
+	CREATE TABLE IF NOT EXISTS invoice_lines (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		invoice_id INT NOT NULL,
+		label VARCHAR(255) NOT NULL,
+		quantity INT NOT NULL,
+		unit_price_cents BIGINT NOT NULL,
+		vat_rate_bp INT NOT NULL,
+		INDEX idx_invoice_lines_invoice (invoice_id)
+	)`
+
+	if _, err := db.Exec(queryInvoiceLines); err != nil {
+		return fmt.Errorf("erreur création table invoice_lines: %v", err)
+	}
+
+	queryInvoicePayments := `
+	CREATE TABLE IF NOT EXISTS invoice_payments (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		invoice_id INT NOT NULL,
+		amount_cents BIGINT NOT NULL,
+		method VARCHAR(20) NOT NULL,
+		reference VARCHAR(100),
+		paid_at TIMESTAMP NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		INDEX idx_invoice_payments_invoice (invoice_id)
+	)`
+
+	if _, err := db.Exec(queryInvoicePayments); err != nil {
+		return fmt.Errorf("erreur création table invoice_payments: %v", err)
+	}
+
+	queryPayments := `
+	CREATE TABLE IF NOT EXISTS payments (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		provider VARCHAR(20) NOT NULL,
+		session_id VARCHAR(100) NOT NULL UNIQUE,
+		target_kind VARCHAR(20) NOT NULL,
+		target_id INT NOT NULL,
+		amount_cents BIGINT NOT NULL,
+		status VARCHAR(20) NOT NULL DEFAULT 'pending',
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP NULL,
+		INDEX idx_payments_target (target_kind, target_id)
+	)`
+
+	if _, err := db.Exec(queryPayments); err != nil {
+		return fmt.Errorf("erreur création table payments: %v", err)
+	}
+
+	queryPaymentEvents := `
+	CREATE TABLE IF NOT EXISTS payment_events (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		provider VARCHAR(20) NOT NULL,
+		event_id VARCHAR(100) NOT NULL,
+		event_type VARCHAR(50) NOT NULL,
+		session_id VARCHAR(100),
+		received_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE (provider, event_id)
+	)`
+
+	if _, err := db.Exec(queryPaymentEvents); err != nil {
+		return fmt.Errorf("erreur création table payment_events: %v", err)
+	}
+
+	queryEmailOutbox := `
+	CREATE TABLE IF NOT EXISTS email_outbox (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		sender VARCHAR(255),
+		recipients TEXT NOT NULL,
+		subject VARCHAR(255) NOT NULL,
+		message LONGTEXT NOT NULL,
+		status VARCHAR(20) NOT NULL DEFAULT 'pending',
+		attempts INT NOT NULL DEFAULT 0,
+		next_attempt_at TIMESTAMP NULL,
+		locked_at TIMESTAMP NULL,
+		last_error TEXT,
+		request_id VARCHAR(64) NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		sent_at TIMESTAMP NULL,
+		INDEX idx_email_outbox_due (status, next_attempt_at)
+	)`
+
+	if _, err := db.Exec(queryEmailOutbox); err != nil {
+		return fmt.Errorf("erreur création table email_outbox: %v", err)
+	}
+	if err := addColumnIfMissing("email_outbox", "request_id", "VARCHAR(64) NULL"); err != nil {
+		return err
+	}
+
+	queryQuoteMessages := `
+	CREATE TABLE IF NOT EXISTS quote_messages (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		quote_id INT NOT NULL,
+		author VARCHAR(20) NOT NULL,
+		author_name VARCHAR(255),
+		body TEXT NOT NULL,
+		source VARCHAR(20) NOT NULL DEFAULT 'web',
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		INDEX idx_quote_messages_quote (quote_id, created_at)
+	)`
+
+	if _, err := db.Exec(queryQuoteMessages); err != nil {
+		return fmt.Errorf("erreur création table quote_messages: %v", err)
+	}
+
+	queryAttachments := `
+	CREATE TABLE IF NOT EXISTS attachments (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		quote_id INT NOT NULL,
+		message_id INT NULL,
+		filename VARCHAR(255) NOT NULL,
+		content_type VARCHAR(100) NOT NULL,
+		size_bytes BIGINT NOT NULL,
+		storage_key VARCHAR(255) NOT NULL UNIQUE,
+		uploaded_by VARCHAR(20) NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		INDEX idx_attachments_quote (quote_id, created_at)
+	)`
+
+	if _, err := db.Exec(queryAttachments); err != nil {
+		return fmt.Errorf("erreur création table attachments: %v", err)
+	}
+
+	queryQuoteNotes := `
+	CREATE TABLE IF NOT EXISTS quote_notes (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		quote_id INT NOT NULL,
+		author VARCHAR(100) NOT NULL,
+		body TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		INDEX idx_quote_notes_quote (quote_id, created_at)
+	)`
+
+	if _, err := db.Exec(queryQuoteNotes); err != nil {
+		return fmt.Errorf("erreur création table quote_notes: %v", err)
+	}
+
+	queryAuditLog := `
+	CREATE TABLE IF NOT EXISTS audit_log (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		actor VARCHAR(100) NOT NULL,
+		action VARCHAR(50) NOT NULL,
+		target VARCHAR(100) NOT NULL DEFAULT '',
+		ip VARCHAR(45) NOT NULL DEFAULT '',
+		details TEXT,
+		prev_hash VARCHAR(64) NOT NULL DEFAULT '',
+		hash VARCHAR(64) NOT NULL DEFAULT '',
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		INDEX idx_audit_log_created (created_at)
+	)`
+
+	if _, err := db.Exec(queryAuditLog); err != nil {
+		return fmt.Errorf("erreur création table audit_log: %v", err)
+	}
+
+	if err := addColumnIfMissing("audit_log", "ip", "VARCHAR(45) NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing("audit_log", "prev_hash", "VARCHAR(64) NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing("audit_log", "hash", "VARCHAR(64) NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+
+	queryAPITokens := `
+	CREATE TABLE IF NOT EXISTS api_tokens (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		name VARCHAR(100) NOT NULL,
+		owner VARCHAR(100) NOT NULL,
+		prefix VARCHAR(20) NOT NULL,
+		token_hash VARCHAR(64) NOT NULL UNIQUE,
+		scopes VARCHAR(255) NOT NULL DEFAULT '',
+		last_used_at TIMESTAMP NULL,
+		revoked_at TIMESTAMP NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`
+
+	if _, err := db.Exec(queryAPITokens); err != nil {
+		return fmt.Errorf("erreur création table api_tokens: %v", err)
+	}
+
+	queryWebhookSubscriptions := `
+	CREATE TABLE IF NOT EXISTS webhook_subscriptions (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		name VARCHAR(100) NOT NULL,
+		url VARCHAR(500) NOT NULL,
+		secret VARCHAR(100) NOT NULL,
+		events VARCHAR(255) NOT NULL,
+		format VARCHAR(20) NOT NULL DEFAULT 'json',
+		active BOOLEAN NOT NULL DEFAULT TRUE,
+		created_by VARCHAR(100) NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`
+
+	if _, err := db.Exec(queryWebhookSubscriptions); err != nil {
+		return fmt.Errorf("erreur création table webhook_subscriptions: %v", err)
+	}
+
+	queryWebhookDeliveries := `
+	CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		subscription_id INT NOT NULL,
+		event VARCHAR(50) NOT NULL,
+		event_id VARCHAR(40) NOT NULL,
+		payload LONGTEXT NOT NULL,
+		status VARCHAR(20) NOT NULL DEFAULT 'pending',
+		attempts INT NOT NULL DEFAULT 0,
+		next_attempt_at TIMESTAMP NULL,
+		locked_at TIMESTAMP NULL,
+		response_status INT NULL,
+		response_body TEXT,
+		last_error TEXT,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		delivered_at TIMESTAMP NULL,
+		INDEX idx_webhook_deliveries_due (status, next_attempt_at),
+		INDEX idx_webhook_deliveries_subscription (subscription_id, created_at)
+	)`
+
+	if _, err := db.Exec(queryWebhookDeliveries); err != nil {
+		return fmt.Errorf("erreur création table webhook_deliveries: %v", err)
+	}
+
+	protectAuditLog()
+
+	if err := backfillQuoteFirstResponses(); err != nil {
+		return err
+	}
+
+	return backfillQuoteReferences()
+}
+
+func createTablesPostgres() error {
+	queryUsers := `
+	CREATE TABLE IF NOT EXISTS users (
+		id SERIAL PRIMARY KEY,
+		email VARCHAR(255) UNIQUE NOT NULL,
+		password_hash VARCHAR(255) NOT NULL,
+		nom VARCHAR(100),
+		prenom VARCHAR(100),
+		email_notifications BOOLEAN NOT NULL DEFAULT TRUE,
+		deleted_at TIMESTAMP NULL,
+		anonymized_at TIMESTAMP NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`
+
+	if _, err := db.Exec(queryUsers); err != nil {
+		return fmt.Errorf("erreur création table users: %v", err)
+	}
+
+	queryQuotes := `
+	CREATE TABLE IF NOT EXISTS quotes (
+		id SERIAL PRIMARY KEY,
+		nom VARCHAR(100) NOT NULL,
+		prenom VARCHAR(100) NOT NULL,
+		email VARCHAR(255) NOT NULL,
+		telephone VARCHAR(20),
+		produit VARCHAR(255) NOT NULL,
+		configuration VARCHAR(100) NULL,
+		message TEXT,
+		user_id INT NULL,
+		reference VARCHAR(20) NULL UNIQUE,
+		status VARCHAR(20) NOT NULL DEFAULT 'pending',
+		rejection_reason TEXT NULL,
+		assigned_to VARCHAR(100) NULL,
+		quoted_at TIMESTAMP NULL,
+		first_response_at TIMESTAMP NULL,
+		deleted_at TIMESTAMP NULL,
+		anonymized_at TIMESTAMP NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`
+
+	if _, err := db.Exec(queryQuotes); err != nil {
+		return fmt.Errorf("erreur création table quotes: %v", err)
+	}
+
+	if err := addColumnIfMissing("quotes", "user_id", "INT NULL"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing("quotes", "status", "VARCHAR(20) NOT NULL DEFAULT 'pending'"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing("quotes", "quoted_at", "TIMESTAMP NULL"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing("quotes", "reference", "VARCHAR(20) NULL UNIQUE"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing("quotes", "configuration", "VARCHAR(100) NULL"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing("quotes", "rejection_reason", "TEXT NULL"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing("quotes", "assigned_to", "VARCHAR(100) NULL"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing("quotes", "first_response_at", "TIMESTAMP NULL"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing("quotes", "deleted_at", "TIMESTAMP NULL"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing("users", "deleted_at", "TIMESTAMP NULL"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing("quotes", "anonymized_at", "TIMESTAMP NULL"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing("users", "anonymized_at", "TIMESTAMP NULL"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing("users", "email_notifications", "BOOLEAN NOT NULL DEFAULT TRUE"); err != nil {
+		return err
+	}
+
+	queryQuoteLines := `
+	CREATE TABLE IF NOT EXISTS quote_lines (
+		id SERIAL PRIMARY KEY,
+		quote_id INT NOT NULL,
+		label VARCHAR(255) NOT NULL,
+		quantity INT NOT NULL DEFAULT 1,
+		unit_price_cents BIGINT NOT NULL DEFAULT 0,
+		vat_rate_bp INT NOT NULL DEFAULT 2000,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`
+
+	if _, err := db.Exec(queryQuoteLines); err != nil {
+		return fmt.Errorf("erreur création table quote_lines: %v", err)
+	}
+
+	if _, err := db.Exec("CREATE INDEX IF NOT EXISTS idx_quote_lines_quote ON quote_lines (quote_id)"); err != nil {
+		return fmt.Errorf("erreur création index quote_lines: %v", err)
+	}
+
+	querySequences := `
+	CREATE TABLE IF NOT EXISTS document_sequences (
+		prefix VARCHAR(10) NOT NULL,
+		year INT NOT NULL,
+		last_value INT NOT NULL DEFAULT 0,
+		PRIMARY KEY (prefix, year)
+	)`
+
+	if _, err := db.Exec(querySequences); err != nil {
+		return fmt.Errorf("erreur création table document_sequences: %v", err)
+	}
+
+	queryOrders := `
+	CREATE TABLE IF NOT EXISTS orders (
+		id SERIAL PRIMARY KEY,
+		reference VARCHAR(20) NOT NULL UNIQUE,
+		quote_id INT NOT NULL UNIQUE,
+		user_id INT NULL,
+		nom VARCHAR(100) NOT NULL,
+		prenom VARCHAR(100) NOT NULL,
+		email VARCHAR(255) NOT NULL,
+		telephone VARCHAR(20),
+		produit VARCHAR(255) NOT NULL,
+		status VARCHAR(20) NOT NULL DEFAULT 'manufacturing',
+		total_ht_cents BIGINT NOT NULL,
+		total_vat_cents BIGINT NOT NULL,
+		total_ttc_cents BIGINT NOT NULL,
+		expected_ready_at TIMESTAMP NULL,
+		expected_delivery_at TIMESTAMP NULL,
+		ready_at TIMESTAMP NULL,
+		shipped_at TIMESTAMP NULL,
+		delivered_at TIMESTAMP NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`
+
+	if _, err := db.Exec(queryOrders); err != nil {
+		return fmt.Errorf("erreur création table orders: %v", err)
+	}
+
+	queryOrderLines := `
+	CREATE TABLE IF NOT EXISTS order_lines (
+		id SERIAL PRIMARY KEY,
+		order_id INT NOT NULL,
+		label VARCHAR(255) NOT NULL,
+		quantity INT NOT NULL,
+		unit_price_cents BIGINT NOT NULL,
+		vat_rate_bp INT NOT NULL
+	)`
+
+	if _, err := db.Exec(queryOrderLines); err != nil {
+		return fmt.Errorf("erreur création table order_lines: %v", err)
+	}
+
+	if _, err := db.Exec("CREATE INDEX IF NOT EXISTS idx_order_lines_order ON order_lines (order_id)"); err != nil {
+		return fmt.Errorf("erreur création index order_lines: %v", err)
+	}
+
+	queryInvoices := `
+	CREATE TABLE IF NOT EXISTS invoices (
+		id SERIAL PRIMARY KEY,
+		number VARCHAR(20) NOT NULL UNIQUE,
+		order_id INT NOT NULL,
+		kind VARCHAR(20) NOT NULL,
+		total_ht_cents BIGINT NOT NULL,
+		total_vat_cents BIGINT NOT NULL,
+		total_ttc_cents BIGINT NOT NULL,
+		amount_due_cents BIGINT NOT NULL,
+		issued_at TIMESTAMP NULL,
+		due_at TIMESTAMP NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`
+
+	if _, err := db.Exec(queryInvoices); err != nil {
+		return fmt.Errorf("erreur création table invoices: %v", err)
+	}
+
+	queryInvoiceLines := `
+	CREATE TABLE IF NOT EXISTS invoice_lines (
+		id SERIAL PRIMARY KEY,
+		invoice_id INT NOT NULL,
+		label VARCHAR(255) NOT NULL,
+		quantity INT NOT NULL,
+		unit_price_cents BIGINT NOT NULL,
+		vat_rate_bp INT NOT NULL
+	)`
+
+	if _, err := db.Exec(queryInvoiceLines); err != nil {
+		return fmt.Errorf("erreur création table invoice_lines: %v", err)
+	}
+
+	queryInvoicePayments := `
+	CREATE TABLE IF NOT EXISTS invoice_payments (
+		id SERIAL PRIMARY KEY,
+		invoice_id INT NOT NULL,
+		amount_cents BIGINT NOT NULL,
+		method VARCHAR(20) NOT NULL,
+		reference VARCHAR(100),
+		paid_at TIMESTAMP NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`
+
+	if _, err := db.Exec(queryInvoicePayments); err != nil {
+		return fmt.Errorf("erreur création table invoice_payments: %v", err)
+	}
+
+	if _, err := db.Exec("CREATE INDEX IF NOT EXISTS idx_invoices_order ON invoices (order_id)"); err != nil {
+		return fmt.Errorf("erreur création index invoices: %v", err)
+	}
+
+	if _, err := db.Exec("CREATE INDEX IF NOT EXISTS idx_invoice_lines_invoice ON invoice_lines (invoice_id)"); err != nil {
+		return fmt.Errorf("erreur création index invoice_lines: %v", err)
+	}
+
+	if _, err := db.Exec("CREATE INDEX IF NOT EXISTS idx_invoice_payments_invoice ON invoice_payments (invoice_id)"); err != nil {
+		return fmt.Errorf("erreur création index invoice_payments: %v", err)
+	}
+
+	queryPayments := `
+	CREATE TABLE IF NOT EXISTS payments (
+		id SERIAL PRIMARY KEY,
+		provider VARCHAR(20) NOT NULL,
+		session_id VARCHAR(100) NOT NULL UNIQUE,
+		target_kind VARCHAR(20) NOT NULL,
+		target_id INT NOT NULL,
+		amount_cents BIGINT NOT NULL,
+		status VARCHAR(20) NOT NULL DEFAULT 'pending',
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP NULL
+	)`
+
+	if _, err := db.Exec(queryPayments); err != nil {
+		return fmt.Errorf("erreur création table payments: %v", err)
+	}
+
+	queryPaymentEvents := `
+	CREATE TABLE IF NOT EXISTS payment_events (
+		id SERIAL PRIMARY KEY,
+		provider VARCHAR(20) NOT NULL,
+		event_id VARCHAR(100) NOT NULL,
+		event_type VARCHAR(50) NOT NULL,
+		session_id VARCHAR(100),
+		received_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE (provider, event_id)
+	)`
+
+	if _, err := db.Exec(queryPaymentEvents); err != nil {
+		return fmt.Errorf("erreur création table payment_events: %v", err)
+	}
+
+	if _, err := db.Exec("CREATE INDEX IF NOT EXISTS idx_payments_target ON payments (target_kind, target_id)"); err != nil {
+		return fmt.Errorf("erreur création index payments: %v", err)
+	}
+
+	queryEmailOutbox := `
+	CREATE TABLE IF NOT EXISTS email_outbox (
+		id SERIAL PRIMARY KEY,
+		sender VARCHAR(255),
+		recipients TEXT NOT NULL,
+		subject VARCHAR(255) NOT NULL,
+		message TEXT NOT NULL,
+		status VARCHAR(20) NOT NULL DEFAULT 'pending',
+		attempts INT NOT NULL DEFAULT 0,
+		next_attempt_at TIMESTAMP NULL,
+		locked_at TIMESTAMP NULL,
+		last_error TEXT,
+		request_id VARCHAR(64) NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		sent_at TIMESTAMP NULL
+	)`
+
+	if _, err := db.Exec(queryEmailOutbox); err != nil {
+		return fmt.Errorf("erreur création table email_outbox: %v", err)
+	}
+	if err := addColumnIfMissing("email_outbox", "request_id", "VARCHAR(64) NULL"); err != nil {
+		return err
+	}
+
+	if _, err := db.Exec("CREATE INDEX IF NOT EXISTS idx_email_outbox_due ON email_outbox (status, next_attempt_at)"); err != nil {
+		return fmt.Errorf("erreur création index email_outbox: %v", err)
+	}
+
+	queryQuoteMessages := `
+	CREATE TABLE IF NOT EXISTS quote_messages (
+		id SERIAL PRIMARY KEY,
+		quote_id INT NOT NULL,
+		author VARCHAR(20) NOT NULL,
+		author_name VARCHAR(255),
+		body TEXT NOT NULL,
+		source VARCHAR(20) NOT NULL DEFAULT 'web',
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`
+
+	if _, err := db.Exec(queryQuoteMessages); err != nil {
+		return fmt.Errorf("erreur création table quote_messages: %v", err)
+	}
+
+	if _, err := db.Exec("CREATE INDEX IF NOT EXISTS idx_quote_messages_quote ON quote_messages (quote_id, created_at)"); err != nil {
+		return fmt.Errorf("erreur création index quote_messages: %v", err)
+	}
+
+	queryAttachments := `
+	CREATE TABLE IF NOT EXISTS attachments (
+		id SERIAL PRIMARY KEY,
+		quote_id INT NOT NULL,
+		message_id INT NULL,
+		filename VARCHAR(255) NOT NULL,
+		content_type VARCHAR(100) NOT NULL,
+		size_bytes BIGINT NOT NULL,
+		storage_key VARCHAR(255) NOT NULL UNIQUE,
+		uploaded_by VARCHAR(20) NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`
+
+	if _, err := db.Exec(queryAttachments); err != nil {
+		return fmt.Errorf("erreur création table attachments: %v", err)
+	}
+
+	if _, err := db.Exec("CREATE INDEX IF NOT EXISTS idx_attachments_quote ON attachments (quote_id, created_at)"); err != nil {
+		return fmt.Errorf("erreur création index attachments: %v", err)
+	}
+
+	queryQuoteNotes := `
+	CREATE TABLE IF NOT EXISTS quote_notes (
+		id SERIAL PRIMARY KEY,
+		quote_id INT NOT NULL,
+		author VARCHAR(100) NOT NULL,
+		body TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`
+
+	if _, err := db.Exec(queryQuoteNotes); err != nil {
+		return fmt.Errorf("erreur création table quote_notes: %v", err)
+	}
+
+	if _, err := db.Exec("CREATE INDEX IF NOT EXISTS idx_quote_notes_quote ON quote_notes (quote_id, created_at)"); err != nil {
+		return fmt.Errorf("erreur création index quote_notes: %v", err)
+	}
+
+	queryAuditLog := `
+	CREATE TABLE IF NOT EXISTS audit_log (
+		id SERIAL PRIMARY KEY,
+		actor VARCHAR(100) NOT NULL,
+		action VARCHAR(50) NOT NULL,
+		target VARCHAR(100) NOT NULL DEFAULT '',
+		ip VARCHAR(45) NOT NULL DEFAULT '',
+		details TEXT,
+		prev_hash VARCHAR(64) NOT NULL DEFAULT '',
+		hash VARCHAR(64) NOT NULL DEFAULT '',
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`
+
+	if _, err := db.Exec(queryAuditLog); err != nil {
+		return fmt.Errorf("erreur création table audit_log: %v", err)
+	}
+
+	if err := addColumnIfMissing("audit_log", "ip", "VARCHAR(45) NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing("audit_log", "prev_hash", "VARCHAR(64) NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing("audit_log", "hash", "VARCHAR(64) NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+
+	if _, err := db.Exec("CREATE INDEX IF NOT EXISTS idx_audit_log_created ON audit_log (created_at)"); err != nil {
+		return fmt.Errorf("erreur création index audit_log: %v", err)
+	}
+
+	queryAPITokens := `
+	CREATE TABLE IF NOT EXISTS api_tokens (
+		id SERIAL PRIMARY KEY,
+		name VARCHAR(100) NOT NULL,
+		owner VARCHAR(100) NOT NULL,
+		prefix VARCHAR(20) NOT NULL,
+		token_hash VARCHAR(64) NOT NULL UNIQUE,
+		scopes VARCHAR(255) NOT NULL DEFAULT '',
+		last_used_at TIMESTAMP NULL,
+		revoked_at TIMESTAMP NULL,
 		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 	)`
 
-	if _, err := db.Exec(queryQuotes); err != nil {
-		return fmt.Errorf("erreur création table quotes: %v", err)
+	if _, err := db.Exec(queryAPITokens); err != nil {
+		return fmt.Errorf("erreur création table api_tokens: %v", err)
 	}
 
-	return nil
-}
-
-func createTablesPostgres() error {
-	queryUsers := `
-	CREATE TABLE IF NOT EXISTS users (
+	queryWebhookSubscriptions := `
+	CREATE TABLE IF NOT EXISTS webhook_subscriptions (
 		id SERIAL PRIMARY KEY,
-		email VARCHAR(255) UNIQUE NOT NULL,
-		password_hash VARCHAR(255) NOT NULL,
-		nom VARCHAR(100),
-		prenom VARCHAR(100),
+		name VARCHAR(100) NOT NULL,
+		url VARCHAR(500) NOT NULL,
+		secret VARCHAR(100) NOT NULL,
+		events VARCHAR(255) NOT NULL,
+		format VARCHAR(20) NOT NULL DEFAULT 'json',
+		active BOOLEAN NOT NULL DEFAULT TRUE,
+		created_by VARCHAR(100) NOT NULL,
 		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 	)`
 
-	if _, err := db.Exec(queryUsers); err != nil {
-		return fmt.Errorf("erreur création table users: %v", err)
+	if _, err := db.Exec(queryWebhookSubscriptions); err != nil {
+		return fmt.Errorf("erreur création table webhook_subscriptions: %v", err)
 	}
 
-	queryQuotes := `
-	CREATE TABLE IF NOT EXISTS quotes (
+	queryWebhookDeliveries := `
+	CREATE TABLE IF NOT EXISTS webhook_deliveries (
 		id SERIAL PRIMARY KEY,
-		nom VARCHAR(100) NOT NULL,
-		prenom VARCHAR(100) NOT NULL,
-		email VARCHAR(255) NOT NULL,
-		telephone VARCHAR(20),
-		produit VARCHAR(255) NOT NULL,
-		message TEXT,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		subscription_id INT NOT NULL,
+		event VARCHAR(50) NOT NULL,
+		event_id VARCHAR(40) NOT NULL,
+		payload TEXT NOT NULL,
+		status VARCHAR(20) NOT NULL DEFAULT 'pending',
+		attempts INT NOT NULL DEFAULT 0,
+		next_attempt_at TIMESTAMP NULL,
+		locked_at TIMESTAMP NULL,
+		response_status INT NULL,
+		response_body TEXT,
+		last_error TEXT,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		delivered_at TIMESTAMP NULL
 	)`
 
-	if _, err := db.Exec(queryQuotes); err != nil {
-		return fmt.Errorf("erreur création table quotes: %v", err)
+	if _, err := db.Exec(queryWebhookDeliveries); err != nil {
+		return fmt.Errorf("erreur création table webhook_deliveries: %v", err)
+	}
+
+	if _, err := db.Exec("CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries (status, next_attempt_at)"); err != nil {
+		return fmt.Errorf("erreur création index webhook_deliveries: %v", err)
+	}
+	if _, err := db.Exec("CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_subscription ON webhook_deliveries (subscription_id, created_at)"); err != nil {
+		return fmt.Errorf("erreur création index webhook_deliveries: %v", err)
+	}
+
+	protectAuditLog()
+
+	if err := backfillQuoteFirstResponses(); err != nil {
+		return err
+	}
+
+	return backfillQuoteReferences()
+}
+
+// rebindQuery convertit les "?" d'une requête en $1, $2… pour PostgreSQL : chaque requête est écrite une
+// seule fois, avec les marqueurs de MySQL. Seules les requêtes dont la syntaxe diffère vraiment d'un dialecte
+// à l'autre (RETURNING, ON CONFLICT, fonctions de date) gardent deux versions
+func rebindQuery(query string) string {
+	if dbDriver != "postgres" {
+		return query
+	}
+	var builder strings.Builder
+	position := 0
+	for _, char := range query {
+		if char == '?' {
+			position++
+			builder.WriteString("$" + strconv.Itoa(position))
+			continue
+		}
+		builder.WriteRune(char)
+	}
+	return builder.String()
+}
+
+// addColumnIfMissing ajoute une colonne à une table existante (bases créées avant la colonne)
+func addColumnIfMissing(table, column, definition string) error {
+	var count int
+	err := db.QueryRow(
+		func() string {
+			if dbDriver == "postgres" {
+				return "SELECT COUNT(*) FROM information_schema.columns WHERE table_schema = current_schema() AND table_name = $1 AND column_name = $2"
+			}
+			return "SELECT COUNT(*) FROM information_schema.columns WHERE table_schema = DATABASE() AND table_name = ? AND column_name = ?"
+		}(),
+		table, column,
+	).Scan(&count)
+	if err != nil {
+		return fmt.Errorf("erreur vérification colonne %s.%s: %v", table, column, err)
+	}
+	if count > 0 {
+		return nil
 	}
 
+	if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition)); err != nil {
+		return fmt.Errorf("erreur ajout colonne %s.%s: %v", table, column, err)
+	}
 	return nil
 }
 
@@ -211,6 +1112,8 @@ type User struct {
 	PasswordHash string
 	Nom          string
 	Prenom       string
+	// EmailNotifications vaut false si le client a désactivé les emails de suivi non essentiels
+	EmailNotifications bool
 }
 
 // CreateUser crée un nouvel utilisateur
@@ -225,16 +1128,11 @@ func CreateUser(email, password, nom, prenom string) error {
 	}
 
 	_, err = db.Exec(
-		func() string {
-			if dbDriver == "postgres" {
-				return "INSERT INTO users (email, password_hash, nom, prenom) VALUES ($1, $2, $3, $4)"
-			}
-			return "INSERT INTO users (email, password_hash, nom, prenom) VALUES (?, ?, ?, ?)"
-		}(),
+		rebindQuery("INSERT INTO users (email, password_hash, nom, prenom) VALUES (?, ?, ?, ?)"),
 		email, string(hashedPassword), nom, prenom,
 	)
 	if err != nil {
-		log.Printf("Erreur insertion utilisateur %s: %v", email, err)
+		slog.Error("Erreur insertion utilisateur", "email", email, "err", err)
 	}
 	return err
 }
@@ -247,14 +1145,41 @@ func GetUserByEmail(email string) (*User, error) {
 
 	user := &User{}
 	err := db.QueryRow(
-		func() string {
-			if dbDriver == "postgres" {
-				return "SELECT id, email, password_hash, nom, prenom FROM users WHERE email = $1"
-			}
-			return "SELECT id, email, password_hash, nom, prenom FROM users WHERE email = ?"
-		}(),
+		rebindQuery("SELECT id, email, password_hash, nom, prenom, email_notifications FROM users WHERE email = ? AND deleted_at IS NULL"),
 		email,
-	).Scan(&user.ID, &user.Email, &user.PasswordHash, &user.Nom, &user.Prenom)
+	).Scan(&user.ID, &user.Email, &user.PasswordHash, &user.Nom, &user.Prenom, &user.EmailNotifications)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return user, err
+}
+
+// GetUserByID récupère un utilisateur par son identifiant
+func GetUserByID(userID int) (*User, error) {
+	if db == nil {
+		return nil, fmt.Errorf("base de données non configurée")
+	}
+
+	user := &User{}
+	err := db.QueryRow(
+		rebindQuery("SELECT id, email, password_hash, nom, prenom, email_notifications FROM users WHERE id = ? AND deleted_at IS NULL"),
+		userID,
+	).Scan(&user.ID, &user.Email, &user.PasswordHash, &user.Nom, &user.Prenom, &user.EmailNotifications)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return user, err
+}
+
+// getUserForUpdate relit un utilisateur hors corbeille en le verrouillant jusqu'à la fin de la transaction
+func getUserForUpdate(tx *sql.Tx, userID int) (*User, error) {
+	user := &User{}
+	err := tx.QueryRow(
+		rebindQuery("SELECT id, email, password_hash, nom, prenom, email_notifications FROM users WHERE id = ? AND deleted_at IS NULL FOR UPDATE"),
+		userID,
+	).Scan(&user.ID, &user.Email, &user.PasswordHash, &user.Nom, &user.Prenom, &user.EmailNotifications)
 
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -270,12 +1195,21 @@ func VerifyPassword(hashedPassword, password string) bool {
 
 // GetUserFromSession récupère l'utilisateur connecté
 func GetUserFromSession(r *http.Request) *User {
-	cookie, err := r.Cookie("user_email")
-	if err != nil {
+	// En mode « voir comme le client », l'admin voit le compte du client, quelle que soit sa propre session
+	if impersonation := currentImpersonation(r); impersonation != nil {
+		user, err := GetUserByID(impersonation.UserID)
+		if err != nil {
+			return nil
+		}
+		return user
+	}
+
+	userID := sessionUserID(r)
+	if userID == 0 {
 		return nil
 	}
 
-	user, err := GetUserByEmail(cookie.Value)
+	user, err := GetUserByID(userID)
 	if err != nil {
 		return nil
 	}
@@ -284,31 +1218,107 @@ func GetUserFromSession(r *http.Request) *User {
 
 // Quote représente une demande de devis
 type Quote struct {
-	ID        int
-	Nom       string `json:"nom"`
-	Prenom    string `json:"prenom"`
-	Email     string `json:"email"`
-	Telephone string `json:"telephone"`
-	Produit   string `json:"produit"`
+	ID        int    `json:"-"`
+	Nom       string `json:"nom" minLength:"1"`
+	Prenom    string `json:"prenom" minLength:"1"`
+	Email     string `json:"email" minLength:"1"`
+	Telephone string `json:"telephone" required:"false"`
+	Produit   string `json:"produit" minLength:"1" doc:"Nom du produit du catalogue"`
+	// Configuration choisie sur la fiche produit, vide si le produit n'en propose pas
+	Configuration string `json:"configuration" required:"false" doc:"Configuration choisie sur la fiche produit"`
+	Message       string `json:"message" required:"false"`
+}
+
+// QuoteResponse est la réponse de /api/quote ; Reference n'est renseignée qu'en cas de succès
+type QuoteResponse struct {
+	Status    string `json:"status" doc:"success ou error"`
 	Message   string `json:"message"`
+	Reference string `json:"reference,omitempty" doc:"Référence du devis, DEV-AAAA-NNNN"`
 }
 
-// CreateQuote enregistre une demande de devis
-func CreateQuote(nom, prenom, email, telephone, produit, message string) error {
+// CreateQuote enregistre une demande de devis rattachée au compte userID et lui attribue
+// sa référence légale (DEV-AAAA-NNNN) dans la même transaction, avec ses pièces jointes déjà stockées.
+// L'accusé de réception part à accountEmail, l'adresse du compte : l'email saisi dans le formulaire n'est
+// qu'un contact pour l'atelier, il ne doit pas permettre d'envoyer nos emails à un tiers
+func CreateQuote(ctx context.Context, userID int, accountEmail string, nom, prenom, email, telephone, produit, configuration, message string, attachments []storedAttachment) (int, string, error) {
 	if db == nil {
-		return fmt.Errorf("base de données non configurée")
+		return 0, "", fmt.Errorf("base de données non configurée")
 	}
 
-	_, err := db.Exec(
-		func() string {
-			if dbDriver == "postgres" {
-				return "INSERT INTO quotes (nom, prenom, email, telephone, produit, message) VALUES ($1, $2, $3, $4, $5, $6)"
-			}
-			return "INSERT INTO quotes (nom, prenom, email, telephone, produit, message) VALUES (?, ?, ?, ?, ?, ?)"
-		}(),
-		nom, prenom, email, telephone, produit, message,
-	)
-	return err
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, "", err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	reference, err := nextDocumentNumber(tx, DocumentPrefixQuote, now)
+	if err != nil {
+		return 0, "", err
+	}
+
+	var quoteID int
+	if dbDriver == "postgres" {
+		err = tx.QueryRow(
+			"INSERT INTO quotes (user_id, reference, nom, prenom, email, telephone, produit, configuration, message, status, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) RETURNING id",
+			userID, reference, nom, prenom, email, telephone, produit, configuration, message, QuoteStatusPending, now,
+		).Scan(&quoteID)
+	} else {
+		var result sql.Result
+		result, err = tx.Exec(
+			"INSERT INTO quotes (user_id, reference, nom, prenom, email, telephone, produit, configuration, message, status, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+			userID, reference, nom, prenom, email, telephone, produit, configuration, message, QuoteStatusPending, now,
+		)
+		if err == nil {
+			var lastID int64
+			lastID, err = result.LastInsertId()
+			quoteID = int(lastID)
+		}
+	}
+	if err != nil {
+		return 0, "", err
+	}
+	if err := insertAttachments(tx, quoteID, 0, QuoteMessageAuthorCustomer, attachments); err != nil {
+		return 0, "", err
+	}
+
+	// L'accusé de réception et les notifications transactionnelles (email atelier) sont enregistrés avec le devis
+	details := QuoteReceivedEmail{
+		QuoteID:       quoteID,
+		Reference:     reference,
+		Nom:           nom,
+		Prenom:        prenom,
+		Email:         email,
+		Telephone:     telephone,
+		Produit:       produit,
+		Configuration: configuration,
+		Message:       message,
+	}
+	for _, attachment := range attachments {
+		details.Attachments = append(details.Attachments, attachment.Filename)
+	}
+	details.LeadTimeMinWeeks, details.LeadTimeMaxWeeks = leadTimeWeeks(produit)
+	if accountEmail != "" {
+		acknowledgement, err := buildQuoteAcknowledgementEmail(accountEmail, details)
+		if err != nil {
+			return 0, "", err
+		}
+		if err := enqueueEmail(ctx, tx, acknowledgement); err != nil {
+			return 0, "", err
+		}
+	}
+	if err := notifyQuoteTx(ctx, tx, details); err != nil {
+		return 0, "", err
+	}
+	if err := enqueueQuoteWebhook(tx, WebhookEventQuoteCreated, quoteID, ""); err != nil {
+		return 0, "", err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, "", err
+	}
+	notifyWebhooks()
+	return quoteID, reference, nil
 }
 
 type AdminUserEntry struct {
@@ -321,23 +1331,36 @@ type AdminUserEntry struct {
 
 type AdminQuoteEntry struct {
 	ID        int
+	Reference string
 	Nom       string
 	Prenom    string
 	Email     string
 	Telephone string
 	Produit   string
 	Message   string
-	CreatedAt string
+	Status    string
+	// AssignedTo est le membre de l'équipe en charge du devis, vide si personne
+	AssignedTo string
+	CreatedAt  string
 }
 
-func listAdminUsers() ([]AdminUserEntry, error) {
+// listAdminUsers renvoie la page demandée des utilisateurs et le nombre total de résultats
+func listAdminUsers(query AdminListQuery) ([]AdminUserEntry, int, error) {
 	if db == nil {
-		return nil, fmt.Errorf("base de données non configurée")
+		return nil, 0, fmt.Errorf("base de données non configurée")
+	}
+
+	conditions := adminUserConditions(query)
+
+	var total int
+	if err := db.QueryRow(rebindQuery("SELECT COUNT(*) FROM users"+conditions.where()), conditions.args...).Scan(&total); err != nil {
+		return nil, 0, err
 	}
 
-	rows, err := db.Query("SELECT id, email, nom, prenom, created_at FROM users ORDER BY created_at DESC, id DESC")
+	args := append(conditions.args, query.PageSize, query.Offset())
+	rows, err := db.Query(rebindQuery("SELECT id, email, nom, prenom, created_at FROM users"+conditions.where()+orderByClause(query, adminUserSortColumns)+" LIMIT ? OFFSET ?"), args...)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	defer rows.Close()
 
@@ -349,7 +1372,7 @@ func listAdminUsers() ([]AdminUserEntry, error) {
 		var createdAt sql.NullTime
 
 		if err := rows.Scan(&user.ID, &user.Email, &nom, &prenom, &createdAt); err != nil {
-			return nil, err
+			return nil, 0, err
 		}
 
 		user.Nom = nom.String
@@ -364,36 +1387,49 @@ func listAdminUsers() ([]AdminUserEntry, error) {
 	}
 
 	if err := rows.Err(); err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
-	return users, nil
+	return users, total, nil
 }
 
-func listAdminQuotes() ([]AdminQuoteEntry, error) {
+// listAdminQuotes renvoie la page demandée des devis et le nombre total de résultats
+func listAdminQuotes(query AdminListQuery) ([]AdminQuoteEntry, int, error) {
 	if db == nil {
-		return nil, fmt.Errorf("base de données non configurée")
+		return nil, 0, fmt.Errorf("base de données non configurée")
+	}
+
+	conditions := adminQuoteConditions(query)
+
+	var total int
+	if err := db.QueryRow(rebindQuery("SELECT COUNT(*) FROM quotes"+conditions.where()), conditions.args...).Scan(&total); err != nil {
+		return nil, 0, err
 	}
 
-	rows, err := db.Query("SELECT id, nom, prenom, email, telephone, produit, message, created_at FROM quotes ORDER BY created_at DESC, id DESC")
+	args := append(conditions.args, query.PageSize, query.Offset())
+	rows, err := db.Query(rebindQuery("SELECT id, reference, nom, prenom, email, telephone, produit, message, status, assigned_to, created_at FROM quotes"+conditions.where()+orderByClause(query, adminQuoteSortColumns)+" LIMIT ? OFFSET ?"), args...)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	defer rows.Close()
 
 	quotes := make([]AdminQuoteEntry, 0)
 	for rows.Next() {
 		var quote AdminQuoteEntry
+		var reference sql.NullString
 		var telephone sql.NullString
 		var message sql.NullString
+		var assignedTo sql.NullString
 		var createdAt sql.NullTime
 
-		if err := rows.Scan(&quote.ID, &quote.Nom, &quote.Prenom, &quote.Email, &telephone, &quote.Produit, &message, &createdAt); err != nil {
-			return nil, err
+		if err := rows.Scan(&quote.ID, &reference, &quote.Nom, &quote.Prenom, &quote.Email, &telephone, &quote.Produit, &message, &quote.Status, &assignedTo, &createdAt); err != nil {
+			return nil, 0, err
 		}
 
+		quote.Reference = reference.String
 		quote.Telephone = telephone.String
 		quote.Message = message.String
+		quote.AssignedTo = assignedTo.String
 		if createdAt.Valid {
 			quote.CreatedAt = createdAt.Time.Format("2006-01-02 15:04")
 		} else {
@@ -404,28 +1440,33 @@ func listAdminQuotes() ([]AdminQuoteEntry, error) {
 	}
 
 	if err := rows.Err(); err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
-	return quotes, nil
+	return quotes, total, nil
 }
 
-func deleteUserByID(userID int) error {
+// listQuoteProducts renvoie les produits présents dans les devis, pour le filtre de la liste admin
+func listQuoteProducts() ([]string, error) {
 	if db == nil {
-		return fmt.Errorf("base de données non configurée")
+		return nil, fmt.Errorf("base de données non configurée")
 	}
 
-	_, err := db.Exec(
-		func() string {
-			if dbDriver == "postgres" {
-				return "DELETE FROM users WHERE id = $1"
-			}
-			return "DELETE FROM users WHERE id = ?"
-		}(),
-		userID,
-	)
+	rows, err := db.Query("SELECT DISTINCT produit FROM quotes WHERE deleted_at IS NULL ORDER BY produit")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
-	return err
+	products := make([]string, 0)
+	for rows.Next() {
+		var product string
+		if err := rows.Scan(&product); err != nil {
+			return nil, err
+		}
+		products = append(products, product)
+	}
+	return products, rows.Err()
 }
 
 func requireAdminAuth(w http.ResponseWriter, r *http.Request) bool {
@@ -442,11 +1483,16 @@ func requireAdminAuth(w http.ResponseWriter, r *http.Request) bool {
 	passwordOk := subtle.ConstantTimeCompare([]byte(password), []byte(adminPassword)) == 1
 
 	if !ok || !usernameOk || !passwordOk {
+		// Le navigateur demande d'abord sans identifiants : seuls les essais ratés sont journalisés
+		if ok {
+			auditAdminLoginFailure(r, username)
+		}
 		w.Header().Set("WWW-Authenticate", `Basic realm="Modul-space Admin"`)
 		http.Error(w, "Accès non autorisé", http.StatusUnauthorized)
 		return false
 	}
 
+	auditAdminLogin(r)
 	return true
 }
 
@@ -468,6 +1514,35 @@ func renderAdminErrorPage(w http.ResponseWriter, title, details string, status i
 	</body></html>`))
 }
 
+// writeAdminPageStart écrit l'en-tête HTML commun aux pages d'administration
+func writeAdminPageStart(builder *strings.Builder, title string) {
+	builder.WriteString(`<!DOCTYPE html><html lang="fr"><head><meta charset="UTF-8"><meta name="viewport" content="width=device-width, initial-scale=1.0"><title>` + html.EscapeString(title) + `</title><link rel="stylesheet" href="/static/css/style.css?v=31"><style>
+	body{font-family:Arial,sans-serif;background:#f7f7fb;margin:0;color:#1f2937}
+	.admin-wrap{max-width:1200px;margin:24px auto;padding:0 16px}
+	h1{margin-bottom:8px}
+	h2{margin-top:30px}
+	.card{background:#fff;border-radius:10px;padding:16px;box-shadow:0 2px 8px rgba(0,0,0,.08);margin-bottom:16px}
+	table{width:100%;border-collapse:collapse;background:#fff}
+	th,td{border:1px solid #e5e7eb;padding:10px;vertical-align:top;text-align:left;font-size:14px}
+	th{background:#f3f4f6}
+	button{background:#b91c1c;color:#fff;border:none;padding:7px 10px;border-radius:6px;cursor:pointer}
+	.meta{color:#6b7280;margin:0 0 14px}
+	.small{font-size:12px;color:#6b7280}
+	input,select,textarea{padding:6px 8px;border:1px solid #d1d5db;border-radius:6px;font-size:14px}
+	.btn-secondary{background:#6161AB}
+	.inline-form{display:inline}
+	.totals td{font-weight:700}
+	</style></head><body>
+	<header class="site-header"><div class="container"><span class="header-welcome">ADMIN</span><img src="/static/img/logo.png" alt="Logo" class="site-logo"></div></header>
+	<div class="sub-banner"><div class="container"><nav><ul><li><a href="/">Accueil</a></li><li><a href="/admin">Admin</a></li><li><a href="/admin/quotes">Devis</a></li><li><a href="/admin/users">Utilisateurs</a></li><li><a href="/admin/analytics">Statistiques</a></li><li><a href="/admin/receivables">Encours</a></li><li><a href="/admin/payments">Paiements</a></li><li><a href="/admin/emails">Emails</a></li><li><a href="/admin/audit">Journal</a></li><li><a href="/admin/trash">Corbeille</a></li><li><a href="/admin/api-tokens">API</a></li><li><a href="/admin/webhooks">Webhooks</a></li></ul></nav></div></div>
+	<div class="admin-wrap">`)
+}
+
+// writeAdminPageEnd ferme une page ouverte par writeAdminPageStart
+func writeAdminPageEnd(builder *strings.Builder) {
+	builder.WriteString(`</div></body></html>`)
+}
+
 func adminHandler(w http.ResponseWriter, r *http.Request) {
 	if r.URL.Path != "/admin" {
 		http.NotFound(w, r)
@@ -478,74 +1553,61 @@ func adminHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	users, err := listAdminUsers()
+	// Le dashboard n'affiche que les plus récents ; les listes complètes sont sur /admin/users et /admin/quotes
+	recent := AdminListQuery{Page: 1, PageSize: 10, Sort: "created_at", Desc: true}
+	users, usersTotal, err := listAdminUsers(recent)
 	if err != nil {
-		log.Printf("Erreur récupération utilisateurs (admin): %v", err)
+		slog.ErrorContext(r.Context(), "Erreur récupération utilisateurs (admin)", "err", err)
 		renderAdminErrorPage(w, "Erreur récupération utilisateurs", err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	quotes, err := listAdminQuotes()
+	quotes, quotesTotal, err := listAdminQuotes(recent)
 	if err != nil {
-		log.Printf("Erreur récupération devis (admin): %v", err)
+		slog.ErrorContext(r.Context(), "Erreur récupération devis (admin)", "err", err)
 		renderAdminErrorPage(w, "Erreur récupération devis", err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	orders, err := listOrders()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Erreur récupération commandes (admin)", "err", err)
+		renderAdminErrorPage(w, "Erreur récupération commandes", err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	var builder strings.Builder
-	builder.WriteString(`<!DOCTYPE html><html lang="fr"><head><meta charset="UTF-8"><meta name="viewport" content="width=device-width, initial-scale=1.0"><title>Admin Modul-space</title><link rel="stylesheet" href="/static/css/style.css?v=31"><style>
-	body{font-family:Arial,sans-serif;background:#f7f7fb;margin:0;color:#1f2937}
-	.admin-wrap{max-width:1200px;margin:24px auto;padding:0 16px}
-	h1{margin-bottom:8px}
-	h2{margin-top:30px}
-	.card{background:#fff;border-radius:10px;padding:16px;box-shadow:0 2px 8px rgba(0,0,0,.08);margin-bottom:16px}
-	table{width:100%;border-collapse:collapse;background:#fff}
-	th,td{border:1px solid #e5e7eb;padding:10px;vertical-align:top;text-align:left;font-size:14px}
-	th{background:#f3f4f6}
-	button{background:#b91c1c;color:#fff;border:none;padding:7px 10px;border-radius:6px;cursor:pointer}
-	.meta{color:#6b7280;margin:0 0 14px}
-	.small{font-size:12px;color:#6b7280}
-	</style></head><body>
-	<header class="site-header"><div class="container"><span class="header-welcome">ADMIN</span><img src="/static/img/logo.png" alt="Logo" class="site-logo"></div></header>
-	<div class="sub-banner"><div class="container"><nav><ul><li><a href="/">Accueil</a></li><li><a href="/admin">Admin</a></li></ul></nav></div></div>
-	<div class="admin-wrap">`)
-	builder.WriteString(fmt.Sprintf(`<div class="card"><h1>Dashboard Admin</h1><p class="meta">%d utilisateurs • %d devis</p><div class="small">Accès privé via /admin uniquement</div></div>`, len(users), len(quotes)))
+	writeAdminPageStart(&builder, "Admin Modul-space")
+	builder.WriteString(fmt.Sprintf(`<div class="card"><h1>Dashboard Admin</h1><p class="meta">%d utilisateurs • %d devis • %d commandes</p><p><a href="/admin/analytics">Statistiques : volume de devis, taux d'acceptation, délai de réponse →</a></p><div class="small">Accès privé via /admin uniquement</div></div>`, usersTotal, quotesTotal, len(orders)))
 
-	builder.WriteString(`<div class="card"><h2>Utilisateurs</h2><table><thead><tr><th>ID</th><th>Email</th><th>Nom</th><th>Prénom</th><th>Créé le</th><th>Action</th></tr></thead><tbody>`)
-	for _, user := range users {
-		builder.WriteString(`<tr>`)
-		builder.WriteString(fmt.Sprintf(`<td>%d</td>`, user.ID))
-		builder.WriteString(fmt.Sprintf(`<td>%s</td>`, html.EscapeString(user.Email)))
-		builder.WriteString(fmt.Sprintf(`<td>%s</td>`, html.EscapeString(user.Nom)))
-		builder.WriteString(fmt.Sprintf(`<td>%s</td>`, html.EscapeString(user.Prenom)))
-		builder.WriteString(fmt.Sprintf(`<td>%s</td>`, html.EscapeString(user.CreatedAt)))
-		builder.WriteString(fmt.Sprintf(`<td><form method="POST" action="/admin/delete-user" onsubmit="return confirm('Supprimer cet utilisateur ?');"><input type="hidden" name="id" value="%d"><button type="submit">Supprimer</button></form></td>`, user.ID))
-		builder.WriteString(`</tr>`)
-	}
-	if len(users) == 0 {
-		builder.WriteString(`<tr><td colspan="6" class="small">Aucun utilisateur</td></tr>`)
-	}
-	builder.WriteString(`</tbody></table></div>`)
+	builder.WriteString(fmt.Sprintf(`<div class="card"><h2>Derniers utilisateurs</h2><p><a href="/admin/users">Voir les %d utilisateurs, avec recherche et filtres →</a></p>`, usersTotal))
+	writeAdminUsersTable(&builder, users, nil, "")
+	builder.WriteString(`</div>`)
+
+	builder.WriteString(fmt.Sprintf(`<div class="card"><h2>Dernières demandes de devis</h2><p><a href="/admin/quotes">Voir les %d devis, avec recherche et filtres →</a></p>`, quotesTotal))
+	writeAdminQuotesTable(&builder, quotes, nil, "")
+	builder.WriteString(`</div>`)
 
-	builder.WriteString(`<div class="card"><h2>Demandes de devis</h2><table><thead><tr><th>ID</th><th>Nom</th><th>Prénom</th><th>Email</th><th>Téléphone</th><th>Produit</th><th>Message</th><th>Créé le</th></tr></thead><tbody>`)
-	for _, quote := range quotes {
+	builder.WriteString(`<div class="card"><h2>Commandes</h2>`)
+	writeAdminExportForm(&builder, "orders", AdminListQuery{}, true)
+	builder.WriteString(`<table><thead><tr><th>Référence</th><th>Client</th><th>Produit</th><th>Total TTC</th><th>Statut</th><th>Livraison prévue</th><th>Créée le</th></tr></thead><tbody>`)
+	for _, order := range orders {
 		builder.WriteString(`<tr>`)
-		builder.WriteString(fmt.Sprintf(`<td>%d</td>`, quote.ID))
-		builder.WriteString(fmt.Sprintf(`<td>%s</td>`, html.EscapeString(quote.Nom)))
-		builder.WriteString(fmt.Sprintf(`<td>%s</td>`, html.EscapeString(quote.Prenom)))
-		builder.WriteString(fmt.Sprintf(`<td>%s</td>`, html.EscapeString(quote.Email)))
-		builder.WriteString(fmt.Sprintf(`<td>%s</td>`, html.EscapeString(quote.Telephone)))
-		builder.WriteString(fmt.Sprintf(`<td>%s</td>`, html.EscapeString(quote.Produit)))
-		builder.WriteString(fmt.Sprintf(`<td>%s</td>`, html.EscapeString(quote.Message)))
-		builder.WriteString(fmt.Sprintf(`<td>%s</td>`, html.EscapeString(quote.CreatedAt)))
+		builder.WriteString(fmt.Sprintf(`<td><a href="/admin/orders/%d">%s</a></td>`, order.ID, html.EscapeString(order.Reference)))
+		builder.WriteString(fmt.Sprintf(`<td>%s</td>`, html.EscapeString(order.Prenom+" "+order.Nom)))
+		builder.WriteString(fmt.Sprintf(`<td>%s</td>`, html.EscapeString(order.Produit)))
+		builder.WriteString(fmt.Sprintf(`<td>%s</td>`, html.EscapeString(formatEuros(order.TotalTTCCents))))
+		builder.WriteString(fmt.Sprintf(`<td>%s</td>`, html.EscapeString(orderStatusLabel(order.Status))))
+		builder.WriteString(fmt.Sprintf(`<td>%s</td>`, order.ExpectedDeliveryAt.Format("2006-01-02")))
+		builder.WriteString(fmt.Sprintf(`<td>%s</td>`, order.CreatedAt.Format("2006-01-02 15:04")))
 		builder.WriteString(`</tr>`)
 	}
-	if len(quotes) == 0 {
-		builder.WriteString(`<tr><td colspan="8" class="small">Aucune demande de devis</td></tr>`)
+	if len(orders) == 0 {
+		builder.WriteString(`<tr><td colspan="7" class="small">Aucune commande</td></tr>`)
 	}
 	builder.WriteString(`</tbody></table></div>`)
-	builder.WriteString(`</div></body></html>`)
+	writeAdminPageEnd(&builder)
 
 	w.Write([]byte(builder.String()))
 }
@@ -566,10 +1628,23 @@ func adminDeleteUserHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := deleteUserByID(userID); err != nil {
+	user, err := GetUserByID(userID)
+	if err != nil {
+		http.Error(w, "Erreur suppression utilisateur", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	quotes, err := softDeleteUser(user)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Erreur mise à la corbeille utilisateur", "user_id", userID, "err", err)
 		http.Error(w, "Erreur suppression utilisateur", http.StatusInternalServerError)
 		return
 	}
+	auditAdminAction(r, AuditActionUserDelete, fmt.Sprintf("user:%d", user.ID), map[string]any{"deleted": userAuditSnapshot(user), "quotes": quotes})
 
 	http.Redirect(w, r, "/admin", http.StatusSeeOther)
 }
@@ -626,6 +1701,10 @@ func registerHandler(w http.ResponseWriter, r *http.Request) {
 		existing, _ := GetUserByEmail(email)
 		if existing != nil {
 			errors["email"] = "Cet email existe déjà"
+		} else if trashed, err := userEmailInTrash(email); err != nil {
+			slog.ErrorContext(r.Context(), "Erreur recherche compte supprimé", "email", email, "err", err)
+		} else if trashed {
+			errors["email"] = "Le compte associé à cet email a été supprimé : contactez-nous pour le réactiver"
 		}
 
 		// Si erreurs, afficher le formulaire avec erreurs
@@ -639,12 +1718,14 @@ func registerHandler(w http.ResponseWriter, r *http.Request) {
 			registerFormWithErrors(w, email, nom, prenom, errors)
 			return
 		}
+		user, err := GetUserByEmail(email)
+		if err != nil || user == nil {
+			errors["general"] = "Erreur création compte"
+			registerFormWithErrors(w, email, nom, prenom, errors)
+			return
+		}
 
-		http.SetCookie(w, &http.Cookie{
-			Name:  "user_email",
-			Value: email,
-			Path:  "/",
-		})
+		startUserSession(w, r, user)
 
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 	}
@@ -898,11 +1979,7 @@ func loginHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		http.SetCookie(w, &http.Cookie{
-			Name:  "user_email",
-			Value: email,
-			Path:  "/",
-		})
+		startUserSession(w, r, user)
 
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 	}
@@ -1094,12 +2171,13 @@ func loginFormWithErrors(w http.ResponseWriter, email string, errors map[string]
 }
 
 func logoutHandler(w http.ResponseWriter, r *http.Request) {
-	http.SetCookie(w, &http.Cookie{
-		Name:   "user_email",
-		Value:  "",
-		Path:   "/",
-		MaxAge: -1,
-	})
+	// En mode « voir comme le client », la déconnexion met fin au mode sans toucher à la session du client
+	if endImpersonation(w, r) {
+		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+		return
+	}
+
+	endUserSession(w)
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
@@ -1114,15 +2192,31 @@ func quoteHandler(w http.ResponseWriter, r *http.Request) {
 	if user == nil {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(map[string]string{
-			"status": "error",
-			"message": "Vous devez être connecté pour demander un devis",
+		json.NewEncoder(w).Encode(QuoteResponse{
+			Status:  "error",
+			Message: "Vous devez être connecté pour demander un devis",
 		})
 		return
 	}
 
+	// Le formulaire est envoyé en multipart lorsqu'il comporte des fichiers (plans, photos, relevés)
 	var quote Quote
-	if err := json.NewDecoder(r.Body).Decode(&quote); err != nil {
+	var attachments []storedAttachment
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		if err := parseAttachmentForm(w, r); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		quote = Quote{
+			Nom:           r.FormValue("nom"),
+			Prenom:        r.FormValue("prenom"),
+			Email:         r.FormValue("email"),
+			Telephone:     r.FormValue("telephone"),
+			Produit:       r.FormValue("produit"),
+			Configuration: r.FormValue("configuration"),
+			Message:       r.FormValue("message"),
+		}
+	} else if err := json.NewDecoder(r.Body).Decode(&quote); err != nil {
 		http.Error(w, "Invalid request", http.StatusBadRequest)
 		return
 	}
@@ -1132,68 +2226,37 @@ func quoteHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Missing required fields", http.StatusBadRequest)
 		return
 	}
-
-	// Enregistrer dans la base de données
-	if err := CreateQuote(quote.Nom, quote.Prenom, quote.Email, quote.Telephone, quote.Produit, quote.Message); err != nil {
-		log.Printf("Erreur création devis: %v", err)
-		http.Error(w, "Error saving quote", http.StatusInternalServerError)
+	if quote.Configuration != "" && !isValidProductConfiguration(quote.Produit, quote.Configuration) {
+		http.Error(w, "Unknown configuration", http.StatusBadRequest)
 		return
 	}
 
-	// Envoyer l'email
-	if err := SendQuoteEmail(quote.Nom, quote.Prenom, quote.Email, quote.Telephone, quote.Produit); err != nil {
-		log.Printf("Erreur envoi email: %v", err)
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"status": "success", "message": "Demande de devis enregistrée"})
-}
-
-// SendQuoteEmail envoie un email de demande de devis
-func SendQuoteEmail(nom, prenom, email, telephone, produit string) error {
-	// Configuration SMTP (utilise des variables d'environnement ou valeurs par défaut)
-	smtpHost := getEnv("SMTP_HOST", "smtp.gmail.com")
-	smtpPort := getEnv("SMTP_PORT", "587")
-	smtpUser := getEnv("SMTP_USER", "")
-	smtpPass := getEnv("SMTP_PASS", "")
-
-	// Destinataire
-	to := "elsachochon13@gmail.com"
-
-	// Construction du message
-	subject := fmt.Sprintf("Demande de devis - %s", produit)
-	body := fmt.Sprintf(`Bonjour,
-
-J'aimerais demander un devis pour le produit : %s
-
-Mes coordonnées :
-- Nom : %s
-- Prénom : %s
-- Email : %s
-- Téléphone : %s
-
-Merci de me renvoyer le devis pour ce produit.
-
-Cordialement,
-%s %s`, produit, nom, prenom, email, telephone, prenom, nom)
-
-	message := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n%s",
-		smtpUser, to, subject, body)
-
-	// Si pas de config SMTP, on log juste (mode dev)
-	if smtpUser == "" || smtpPass == "" {
-		fmt.Printf("MODE DEV: Email qui serait envoyé:\n%s\n", message)
-		return nil
+	// Les fichiers sont validés et stockés une fois le formulaire jugé complet
+	attachments, err := storeUploadedAttachments(r.MultipartForm)
+	if err != nil {
+		if isAttachmentRejection(err) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		slog.ErrorContext(r.Context(), "Erreur stockage pièces jointes", "err", err)
+		http.Error(w, "Error saving attachments", http.StatusInternalServerError)
+		return
 	}
 
-	// Authentification et envoi
-	auth := smtp.PlainAuth("", smtpUser, smtpPass, smtpHost)
-	err := smtp.SendMail(smtpHost+":"+smtpPort, auth, smtpUser, []string{to}, []byte(message))
+	// Enregistrer dans la base de données
+	_, reference, err := CreateQuote(r.Context(), user.ID, user.Email, quote.Nom, quote.Prenom, quote.Email, quote.Telephone, quote.Produit, quote.Configuration, quote.Message, attachments)
 	if err != nil {
-		return fmt.Errorf("erreur envoi email: %v", err)
+		discardAttachments(attachments)
+		slog.ErrorContext(r.Context(), "Erreur création devis", "err", err)
+		http.Error(w, "Error saving quote", http.StatusInternalServerError)
+		return
 	}
 
-	return nil
+	// L'email de notification est dans l'outbox : on réveille le worker pour l'envoyer sans attendre
+	notifyOutbox()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(QuoteResponse{Status: "success", Message: "Demande de devis enregistrée", Reference: reference})
 }
 
 func getEnv(key, defaultValue string) string {
@@ -1204,17 +2267,37 @@ func getEnv(key, defaultValue string) string {
 	return value
 }
 
+// UserSession est la réponse de /api/user, lue par auth.js pour l'en-tête du site
+type UserSession struct {
+	LoggedIn bool   `json:"loggedIn"`
+	Email    string `json:"email,omitempty"`
+	Prenom   string `json:"prenom,omitempty"`
+	Nom      string `json:"nom,omitempty"`
+	// Impersonation est renseignée pendant une session « voir comme le client »
+	Impersonation *UserSessionImpersonation `json:"impersonation,omitempty"`
+}
+
+type UserSessionImpersonation struct {
+	By        string    `json:"by" doc:"Admin qui consulte le compte"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
 func userHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
 	user := GetUserFromSession(r)
 	w.Header().Set("Content-Type", "application/json")
 	if user == nil {
-		w.Write([]byte(`{"loggedIn":false}`))
+		json.NewEncoder(w).Encode(UserSession{})
 		return
 	}
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"loggedIn": true,
-		"email":    user.Email,
-		"prenom":   user.Prenom,
-		"nom":      user.Nom,
-	})
+	response := UserSession{LoggedIn: true, Email: user.Email, Prenom: user.Prenom, Nom: user.Nom}
+	// Le bandeau du mode « voir comme le client » est affiché par auth.js
+	if impersonation := currentImpersonation(r); impersonation != nil {
+		response.Impersonation = &UserSessionImpersonation{By: impersonation.Actor, ExpiresAt: impersonation.ExpiresAt}
+	}
+	json.NewEncoder(w).Encode(response)
 }
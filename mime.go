@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// mimePart est une pièce jointe, ou une image inline si ContentID est renseigné
+type mimePart struct {
+	Filename    string
+	ContentType string
+	ContentID   string
+	Data        []byte
+}
+
+// mimeMessage décrit un email multipart : texte + HTML, images inline et pièces jointes
+type mimeMessage struct {
+	From        mail.Address
+	To          []string
+	ReplyTo     string
+	Subject     string
+	Text        string
+	HTML        string
+	Inline      []mimePart
+	Attachments []mimePart
+}
+
+type mimeEntity struct {
+	header textproto.MIMEHeader
+	body   []byte
+}
+
+// encodeHeader encode un en-tête accentué en Q-encoding (RFC 2047) ; un texte ASCII est laissé tel quel
+func encodeHeader(value string) string {
+	return mime.QEncoding.Encode("utf-8", value)
+}
+
+func textEntity(contentType, text string) (mimeEntity, error) {
+	var buf bytes.Buffer
+	writer := quotedprintable.NewWriter(&buf)
+	if _, err := writer.Write([]byte(strings.ReplaceAll(text, "\r\n", "\n"))); err != nil {
+		return mimeEntity{}, err
+	}
+	if err := writer.Close(); err != nil {
+		return mimeEntity{}, err
+	}
+
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Type", contentType+"; charset=UTF-8")
+	header.Set("Content-Transfer-Encoding", "quoted-printable")
+	return mimeEntity{header: header, body: buf.Bytes()}, nil
+}
+
+// binaryEntity encode une pièce en base64, lignes de 76 caractères
+func binaryEntity(part mimePart, disposition string) mimeEntity {
+	encoded := base64.StdEncoding.EncodeToString(part.Data)
+	var buf bytes.Buffer
+	for len(encoded) > 76 {
+		buf.WriteString(encoded[:76])
+		buf.WriteString("\r\n")
+		encoded = encoded[76:]
+	}
+	buf.WriteString(encoded)
+
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Type", mime.FormatMediaType(part.ContentType, map[string]string{"name": part.Filename}))
+	header.Set("Content-Transfer-Encoding", "base64")
+	header.Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": part.Filename}))
+	if part.ContentID != "" {
+		header.Set("Content-ID", "<"+part.ContentID+">")
+	}
+	return mimeEntity{header: header, body: buf.Bytes()}
+}
+
+func multipartEntity(subtype string, parts []mimeEntity) (mimeEntity, error) {
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+	for _, part := range parts {
+		partWriter, err := writer.CreatePart(part.header)
+		if err != nil {
+			return mimeEntity{}, err
+		}
+		if _, err := partWriter.Write(part.body); err != nil {
+			return mimeEntity{}, err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return mimeEntity{}, err
+	}
+
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Type", fmt.Sprintf(`multipart/%s; boundary="%s"`, subtype, writer.Boundary()))
+	return mimeEntity{header: header, body: buf.Bytes()}, nil
+}
+
+// messageIDDomain renvoie le domaine de l'expéditeur pour l'en-tête Message-ID
+func messageIDDomain(address string) string {
+	if _, domain, ok := strings.Cut(address, "@"); ok && domain != "" {
+		return domain
+	}
+	return "modulspace.local"
+}
+
+// Bytes assemble le message : mixed(related(alternative(texte, html), inline…), pièces jointes…),
+// chaque niveau n'étant ajouté que s'il est utile
+func (m mimeMessage) Bytes() ([]byte, error) {
+	textPart, err := textEntity("text/plain", m.Text)
+	if err != nil {
+		return nil, err
+	}
+	body := textPart
+	if m.HTML != "" {
+		htmlPart, err := textEntity("text/html", m.HTML)
+		if err != nil {
+			return nil, err
+		}
+		if body, err = multipartEntity("alternative", []mimeEntity{textPart, htmlPart}); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(m.Inline) > 0 {
+		parts := []mimeEntity{body}
+		for _, inline := range m.Inline {
+			parts = append(parts, binaryEntity(inline, "inline"))
+		}
+		if body, err = multipartEntity("related", parts); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(m.Attachments) > 0 {
+		parts := []mimeEntity{body}
+		for _, attachment := range m.Attachments {
+			parts = append(parts, binaryEntity(attachment, "attachment"))
+		}
+		if body, err = multipartEntity("mixed", parts); err != nil {
+			return nil, err
+		}
+	}
+
+	var buf bytes.Buffer
+	writeHeader := func(key, value string) {
+		buf.WriteString(key + ": " + value + "\r\n")
+	}
+	writeHeader("From", m.From.String())
+	writeHeader("To", strings.Join(m.To, ", "))
+	if m.ReplyTo != "" {
+		writeHeader("Reply-To", m.ReplyTo)
+	}
+	writeHeader("Subject", encodeHeader(m.Subject))
+	writeHeader("Date", time.Now().Format(time.RFC1123Z))
+	writeHeader("Message-ID", fmt.Sprintf("<%s@%s>", randomHex(16), messageIDDomain(m.From.Address)))
+	writeHeader("MIME-Version", "1.0")
+	for _, key := range []string{"Content-Type", "Content-Transfer-Encoding"} {
+		if value := body.header.Get(key); value != "" {
+			writeHeader(key, value)
+		}
+	}
+	buf.WriteString("\r\n")
+	buf.Write(body.body)
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// Notifier prévient l'atelier d'une nouvelle demande de devis. La notification est écrite dans la
+// transaction du devis : elle est enregistrée avec la demande et ne peut pas être perdue
+type Notifier interface {
+	Name() string
+	NotifyQuoteTx(ctx context.Context, tx *sql.Tx, details QuoteReceivedEmail) error
+}
+
+// Notifiers actifs, choisis au démarrage par initNotifiers
+var notifiers []Notifier
+
+// initNotifiers active les notifiers listés dans NOTIFIERS (séparés par des virgules, défaut "smtp").
+// "webhook" est remplacé par les abonnements webhook : NOTIFY_WEBHOOK_URL devient un abonnement à quote.created
+func initNotifiers() {
+	notifiers = nil
+	for _, name := range strings.Split(getEnv("NOTIFIERS", "smtp"), ",") {
+		switch name = strings.TrimSpace(name); name {
+		case "":
+		case "smtp":
+			notifiers = append(notifiers, smtpNotifier{})
+		case "webhook":
+			// Obsolète : NOTIFY_WEBHOOK_URL est repris en abonnement webhook au démarrage (migrateNotifyWebhook)
+		case "log":
+			notifiers = append(notifiers, logNotifier{})
+		default:
+			slog.Warn("Notifier inconnu", "notifier", name)
+		}
+	}
+
+	names := make([]string, 0, len(notifiers))
+	for _, notifier := range notifiers {
+		names = append(names, notifier.Name())
+	}
+	if len(names) == 0 {
+		slog.Warn("Aucun notifier actif : les nouvelles demandes de devis ne seront pas signalées")
+		return
+	}
+	slog.Info("Notifications des demandes de devis", "notifiers", names)
+}
+
+// notifyQuoteTx exécute les notifiers dans la transaction du devis
+func notifyQuoteTx(ctx context.Context, tx *sql.Tx, details QuoteReceivedEmail) error {
+	for _, notifier := range notifiers {
+		if err := notifier.NotifyQuoteTx(ctx, tx, details); err != nil {
+			return fmt.Errorf("notification %s: %v", notifier.Name(), err)
+		}
+	}
+	return nil
+}
+
+// smtpNotifier envoie l'email quote_received via l'outbox
+type smtpNotifier struct{}
+
+func (smtpNotifier) Name() string { return "smtp" }
+
+func (smtpNotifier) NotifyQuoteTx(ctx context.Context, tx *sql.Tx, details QuoteReceivedEmail) error {
+	email, err := buildQuoteEmail(details)
+	if err != nil {
+		return err
+	}
+	return enqueueEmail(ctx, tx, email)
+}
+
+// logNotifier écrit la demande dans les logs (développement)
+type logNotifier struct{}
+
+func (logNotifier) Name() string { return "log" }
+
+func (logNotifier) NotifyQuoteTx(ctx context.Context, tx *sql.Tx, details QuoteReceivedEmail) error {
+	slog.InfoContext(ctx, "Nouvelle demande de devis", "reference", details.Reference, "email", details.Email, "produit", details.Produit, "configuration", details.Configuration)
+	return nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"time"
+	_ "time/tzdata"
+)
+
+// Préfixes des numérotations légales (une séquence par préfixe et par année)
+const (
+	DocumentPrefixQuote   = "DEV"
+	DocumentPrefixInvoice = "FAC"
+)
+
+// documentLocation est le fuseau utilisé pour rattacher un document à son année
+var documentLocation = func() *time.Location {
+	location, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		return time.UTC
+	}
+	return location
+}()
+
+// formatDocumentNumber formate une référence du type DEV-2026-0001
+func formatDocumentNumber(prefix string, year, value int) string {
+	return fmt.Sprintf("%s-%d-%04d", prefix, year, value)
+}
+
+// nextDocumentNumber réserve le numéro suivant dans la transaction du document.
+// La ligne de séquence reste verrouillée jusqu'au commit : deux transactions
+// concurrentes ne peuvent pas obtenir le même numéro, et un rollback rend le
+// numéro, ce qui garantit une numérotation sans trou.
+func nextDocumentNumber(tx *sql.Tx, prefix string, at time.Time) (string, error) {
+	year := at.In(documentLocation).Year()
+
+	var value int
+	if dbDriver == "postgres" {
+		err := tx.QueryRow(
+			"INSERT INTO document_sequences (prefix, year, last_value) VALUES ($1, $2, 1) ON CONFLICT (prefix, year) DO UPDATE SET last_value = document_sequences.last_value + 1 RETURNING last_value",
+			prefix, year,
+		).Scan(&value)
+		if err != nil {
+			return "", fmt.Errorf("erreur allocation numéro %s: %v", prefix, err)
+		}
+		return formatDocumentNumber(prefix, year, value), nil
+	}
+
+	if _, err := tx.Exec(
+		"INSERT INTO document_sequences (prefix, year, last_value) VALUES (?, ?, 1) ON DUPLICATE KEY UPDATE last_value = last_value + 1",
+		prefix, year,
+	); err != nil {
+		return "", fmt.Errorf("erreur allocation numéro %s: %v", prefix, err)
+	}
+	if err := tx.QueryRow(
+		"SELECT last_value FROM document_sequences WHERE prefix = ? AND year = ?",
+		prefix, year,
+	).Scan(&value); err != nil {
+		return "", fmt.Errorf("erreur lecture numéro %s: %v", prefix, err)
+	}
+	return formatDocumentNumber(prefix, year, value), nil
+}
+
+// backfillQuoteReferences numérote, dans l'ordre chronologique, les devis créés avant la numérotation légale
+func backfillQuoteReferences() error {
+	rows, err := db.Query("SELECT id, created_at FROM quotes WHERE reference IS NULL ORDER BY created_at, id")
+	if err != nil {
+		return fmt.Errorf("erreur lecture devis sans référence: %v", err)
+	}
+
+	type pendingQuote struct {
+		id        int
+		createdAt time.Time
+	}
+	pending := make([]pendingQuote, 0)
+	for rows.Next() {
+		var quote pendingQuote
+		var createdAt sql.NullTime
+		if err := rows.Scan(&quote.id, &createdAt); err != nil {
+			rows.Close()
+			return err
+		}
+		quote.createdAt = time.Now()
+		if createdAt.Valid {
+			quote.createdAt = createdAt.Time
+		}
+		pending = append(pending, quote)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, quote := range pending {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		reference, err := nextDocumentNumber(tx, DocumentPrefixQuote, quote.createdAt)
+		if err == nil {
+			_, err = tx.Exec(
+				rebindQuery("UPDATE quotes SET reference = ? WHERE id = ? AND reference IS NULL"),
+				reference, quote.id,
+			)
+		}
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("erreur numérotation devis %d: %v", quote.id, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+
+	if len(pending) > 0 {
+		slog.Info("Devis existants numérotés", "count", len(pending))
+	}
+	return nil
+}
@@ -0,0 +1,403 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Version du format OpenAPI et version du contrat de l'API publiées dans la spécification
+const (
+	openAPIVersion = "3.0.3"
+	apiVersion     = "1.0.0"
+)
+
+// Les types Go des requêtes et des réponses sont annotés par des balises de champ, lues par schemaFor :
+//
+//	doc:"…"          description du champ
+//	enum:"nom"       valeurs autorisées, tirées de openAPIEnums
+//	format:"date"    format OpenAPI de la chaîne ; un time.Time est déjà en date-time
+//	minLength:"1"    longueur minimale d'une chaîne, maxLength pour la longueur maximale
+//	required:"false" rend facultatif un champ ; les pointeurs et les champs omitempty le sont déjà
+//
+// Un pointeur est nullable. Un struct nommé devient un schéma de components/schemas
+var openAPIEnums = map[string][]string{
+	"quote_status":   quoteStatuses,
+	"order_status":   orderStatuses,
+	"api_error_code": {APIErrorUnauthorized, APIErrorForbidden, APIErrorNotFound, APIErrorMethodNotAllowed, APIErrorInvalidRequest, APIErrorConflict, APIErrorInternal},
+}
+
+// OpenAPIDocument est la spécification OpenAPI 3 de l'API, servie sur /api/v1/openapi.json
+type OpenAPIDocument struct {
+	OpenAPI    string                                  `json:"openapi"`
+	Info       OpenAPIInfo                             `json:"info"`
+	Paths      map[string]map[string]*OpenAPIOperation `json:"paths"`
+	Components OpenAPIComponents                       `json:"components"`
+}
+
+type OpenAPIInfo struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+type OpenAPIComponents struct {
+	Schemas         map[string]*OpenAPISchema        `json:"schemas"`
+	SecuritySchemes map[string]OpenAPISecurityScheme `json:"securitySchemes"`
+}
+
+type OpenAPISecurityScheme struct {
+	Type        string `json:"type"`
+	Scheme      string `json:"scheme,omitempty"`
+	In          string `json:"in,omitempty"`
+	Name        string `json:"name,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+type OpenAPIOperation struct {
+	OperationID string                `json:"operationId"`
+	Summary     string                `json:"summary"`
+	Description string                `json:"description,omitempty"`
+	Tags        []string              `json:"tags"`
+	Security    []map[string][]string `json:"security,omitempty"`
+	// Scope est le périmètre de jeton exigé : OpenAPI 3.0 ne sait pas l'exprimer pour un jeton Bearer
+	Scope       string                     `json:"x-scope,omitempty"`
+	Parameters  []OpenAPIParameter         `json:"parameters,omitempty"`
+	RequestBody *OpenAPIRequestBody        `json:"requestBody,omitempty"`
+	Responses   map[string]OpenAPIResponse `json:"responses"`
+}
+
+type OpenAPIParameter struct {
+	Name        string         `json:"name"`
+	In          string         `json:"in"`
+	Description string         `json:"description,omitempty"`
+	Required    bool           `json:"required,omitempty"`
+	Schema      *OpenAPISchema `json:"schema"`
+}
+
+type OpenAPIRequestBody struct {
+	Required bool                        `json:"required"`
+	Content  map[string]OpenAPIMediaType `json:"content"`
+}
+
+type OpenAPIMediaType struct {
+	Schema *OpenAPISchema `json:"schema"`
+}
+
+type OpenAPIResponse struct {
+	Description string                      `json:"description"`
+	Content     map[string]OpenAPIMediaType `json:"content,omitempty"`
+}
+
+type OpenAPISchema struct {
+	Ref         string   `json:"$ref,omitempty"`
+	Type        string   `json:"type,omitempty"`
+	Format      string   `json:"format,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Nullable    bool     `json:"nullable,omitempty"`
+	Enum        []string `json:"enum,omitempty"`
+	MinLength   int      `json:"minLength,omitempty"`
+	MaxLength   int      `json:"maxLength,omitempty"`
+	Minimum     *int     `json:"minimum,omitempty"`
+	Maximum     *int     `json:"maximum,omitempty"`
+	// AllOf enveloppe une référence nullable : OpenAPI 3.0 ignore les voisins d'un $ref
+	AllOf      []*OpenAPISchema          `json:"allOf,omitempty"`
+	Items      *OpenAPISchema            `json:"items,omitempty"`
+	Properties map[string]*OpenAPISchema `json:"properties,omitempty"`
+	Required   []string                  `json:"required,omitempty"`
+	// AdditionalProperties vaut false pour un struct (champs inconnus refusés) ou le schéma des valeurs d'une map
+	AdditionalProperties any `json:"additionalProperties,omitempty"`
+}
+
+const openAPISchemaRefPrefix = "#/components/schemas/"
+
+var (
+	openAPISpecOnce sync.Once
+	openAPISpecDoc  *OpenAPIDocument
+)
+
+// openAPISpec renvoie la spécification, construite une fois à partir de la table apiRoutes
+func openAPISpec() *OpenAPIDocument {
+	openAPISpecOnce.Do(func() {
+		openAPISpecDoc = buildOpenAPISpec(apiRoutes())
+	})
+	return openAPISpecDoc
+}
+
+// GET /api/v1/openapi.json, sans authentification : le contrat est public, pas les données
+func openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeAPIMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+	writeAPIJSON(w, http.StatusOK, openAPISpec())
+}
+
+func buildOpenAPISpec(routes []apiRoute) *OpenAPIDocument {
+	builder := &openAPIBuilder{schemas: make(map[string]*OpenAPISchema)}
+	doc := &OpenAPIDocument{
+		OpenAPI: openAPIVersion,
+		Info: OpenAPIInfo{
+			Title:   "API Modul-space",
+			Version: apiVersion,
+			Description: "API JSON des devis, commandes, produits et comptes clients. Les routes /api/v1 s'authentifient par un jeton personnel " +
+				"(en-tête Authorization: Bearer), créé dans l'admin avec ses périmètres (x-scope). Les listes sont paginées par curseur et " +
+				"toutes les erreurs de /api/v1 ont l'enveloppe APIErrorEnvelope.",
+		},
+		Paths: make(map[string]map[string]*OpenAPIOperation),
+		Components: OpenAPIComponents{
+			Schemas: builder.schemas,
+			SecuritySchemes: map[string]OpenAPISecurityScheme{
+				"bearerAuth":    {Type: "http", Scheme: "bearer", Description: "Jeton d'API personnel msk_…"},
+				"sessionCookie": {Type: "apiKey", In: "cookie", Name: userSessionCookie, Description: "Session du client sur le site, cookie signé posé à la connexion"},
+			},
+		},
+	}
+
+	for _, route := range routes {
+		operations := make(map[string]*OpenAPIOperation)
+		for _, operation := range route.Operations {
+			operations[strings.ToLower(operation.Method)] = builder.operation(route, operation)
+		}
+		doc.Paths[route.Pattern] = operations
+	}
+	return doc
+}
+
+type openAPIBuilder struct {
+	schemas map[string]*OpenAPISchema
+}
+
+func (b *openAPIBuilder) operation(route apiRoute, operation apiOperation) *OpenAPIOperation {
+	spec := &OpenAPIOperation{
+		OperationID: operation.ID,
+		Summary:     operation.Summary,
+		Description: operation.Description,
+		Tags:        []string{route.Tag},
+		Scope:       operation.Scope,
+		Parameters:  append(openAPIPathParameters(route.Pattern), operation.Query...),
+		Responses:   make(map[string]OpenAPIResponse),
+	}
+	switch {
+	case operation.Scope != "":
+		spec.Security = []map[string][]string{{"bearerAuth": {}}}
+	case operation.Session:
+		spec.Security = []map[string][]string{{"sessionCookie": {}}}
+	}
+	if operation.Request != nil {
+		spec.RequestBody = &OpenAPIRequestBody{
+			Required: true,
+			Content:  map[string]OpenAPIMediaType{"application/json": {Schema: b.schemaFor(reflect.TypeOf(operation.Request))}},
+		}
+	}
+
+	success := operation.Status
+	if success == 0 {
+		success = http.StatusOK
+	}
+	response := OpenAPIResponse{Description: http.StatusText(success)}
+	if operation.Response != nil {
+		response.Content = map[string]OpenAPIMediaType{"application/json": {Schema: b.schemaFor(reflect.TypeOf(operation.Response))}}
+	}
+	spec.Responses[strconv.Itoa(success)] = response
+
+	// Les erreurs des routes à jeton se déduisent de la route ; Errors ajoute les autres (409…)
+	errorBodies := make(map[int]any)
+	if operation.Scope != "" {
+		errorBodies[http.StatusUnauthorized] = nil
+		errorBodies[http.StatusForbidden] = nil
+		errorBodies[http.StatusInternalServerError] = nil
+		if strings.Contains(route.Pattern, "{") {
+			errorBodies[http.StatusNotFound] = nil
+		}
+		if len(operation.Query) > 0 || operation.Request != nil {
+			errorBodies[http.StatusBadRequest] = nil
+		}
+	}
+	for status, body := range operation.Errors {
+		errorBodies[status] = body
+	}
+	for status, body := range errorBodies {
+		content := map[string]OpenAPIMediaType{"application/json": {Schema: b.schemaFor(reflect.TypeOf(APIErrorEnvelope{}))}}
+		switch {
+		case body != nil:
+			content = map[string]OpenAPIMediaType{"application/json": {Schema: b.schemaFor(reflect.TypeOf(body))}}
+		case route.Legacy:
+			content = map[string]OpenAPIMediaType{"text/plain": {Schema: &OpenAPISchema{Type: "string"}}}
+		}
+		spec.Responses[strconv.Itoa(status)] = OpenAPIResponse{Description: http.StatusText(status), Content: content}
+	}
+	return spec
+}
+
+// openAPIPathParameters décrit les segments {id} et {slug} du motif de la route
+func openAPIPathParameters(pattern string) []OpenAPIParameter {
+	var parameters []OpenAPIParameter
+	for _, segment := range strings.Split(pattern, "/") {
+		name, ok := strings.CutPrefix(segment, "{")
+		if !ok {
+			continue
+		}
+		name = strings.TrimSuffix(name, "}")
+		schema := &OpenAPISchema{Type: "string"}
+		if name == "id" {
+			schema = &OpenAPISchema{Type: "integer", Minimum: openAPIBound(1)}
+		}
+		parameters = append(parameters, OpenAPIParameter{Name: name, In: "path", Required: true, Schema: schema})
+	}
+	return parameters
+}
+
+// apiListParameters décrit les paramètres communs des listes (voir parseAPIListQuery) ; statusEnum est vide
+// pour les listes sans filtre de statut
+func apiListParameters(statusEnum string) []OpenAPIParameter {
+	parameters := []OpenAPIParameter{
+		{Name: "limit", In: "query", Description: fmt.Sprintf("Taille de la page, %d par défaut", apiDefaultLimit),
+			Schema: &OpenAPISchema{Type: "integer", Minimum: openAPIBound(1), Maximum: openAPIBound(apiMaxLimit)}},
+		{Name: "cursor", In: "query", Description: "Valeur next_cursor de la page précédente", Schema: &OpenAPISchema{Type: "string"}},
+	}
+	if statusEnum != "" {
+		parameters = append(parameters, OpenAPIParameter{Name: "status", In: "query", Schema: openAPIEnumSchema(statusEnum)})
+	}
+	return parameters
+}
+
+func openAPIBound(value int) *int {
+	return &value
+}
+
+func openAPIEnumSchema(name string) *OpenAPISchema {
+	values, ok := openAPIEnums[name]
+	if !ok {
+		panic(fmt.Sprintf("énumération OpenAPI inconnue: %s", name))
+	}
+	return &OpenAPISchema{Type: "string", Enum: values}
+}
+
+// openAPISchemaName nomme le schéma d'un type ; un type générique prend le nom de ses arguments :
+// APIPage[main.APIQuote] devient APIPage_APIQuote
+func openAPISchemaName(t reflect.Type) string {
+	base, arguments, generic := strings.Cut(t.Name(), "[")
+	if !generic {
+		return base
+	}
+	names := strings.Split(strings.TrimSuffix(arguments, "]"), ",")
+	for i, name := range names {
+		names[i] = name[strings.LastIndex(name, ".")+1:]
+	}
+	return base + "_" + strings.Join(names, "_")
+}
+
+func (b *openAPIBuilder) schemaFor(t reflect.Type) *OpenAPISchema {
+	if t == reflect.TypeOf(time.Time{}) {
+		return &OpenAPISchema{Type: "string", Format: "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		schema := b.schemaFor(t.Elem())
+		if schema.Ref != "" {
+			return &OpenAPISchema{AllOf: []*OpenAPISchema{schema}, Nullable: true}
+		}
+		schema.Nullable = true
+		return schema
+	case reflect.Struct:
+		if t.Name() == "" {
+			return b.structSchema(t)
+		}
+		name := openAPISchemaName(t)
+		if _, ok := b.schemas[name]; !ok {
+			// Le nom est réservé avant de décrire les champs : un type qui se contient lui-même s'y réfère
+			b.schemas[name] = nil
+			b.schemas[name] = b.structSchema(t)
+		}
+		return &OpenAPISchema{Ref: openAPISchemaRefPrefix + name}
+	case reflect.Slice, reflect.Array:
+		return &OpenAPISchema{Type: "array", Items: b.schemaFor(t.Elem())}
+	case reflect.Map:
+		return &OpenAPISchema{Type: "object", AdditionalProperties: b.schemaFor(t.Elem())}
+	case reflect.String:
+		return &OpenAPISchema{Type: "string"}
+	case reflect.Bool:
+		return &OpenAPISchema{Type: "boolean"}
+	case reflect.Int64:
+		return &OpenAPISchema{Type: "integer", Format: "int64"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &OpenAPISchema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &OpenAPISchema{Type: "number"}
+	case reflect.Interface:
+		return &OpenAPISchema{}
+	}
+	panic(fmt.Sprintf("type %s non pris en charge par la spécification OpenAPI", t))
+}
+
+// structSchema décrit les champs d'un struct comme encoding/json les écrit : nom de la balise json,
+// champs "-" et non exportés ignorés, champs embarqués mis à plat
+func (b *openAPIBuilder) structSchema(t reflect.Type) *OpenAPISchema {
+	schema := &OpenAPISchema{Type: "object", Properties: make(map[string]*OpenAPISchema), AdditionalProperties: false}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" || !field.IsExported() {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			embedded := b.structSchema(field.Type)
+			for property, propertySchema := range embedded.Properties {
+				schema.Properties[property] = propertySchema
+			}
+			schema.Required = append(schema.Required, embedded.Required...)
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		property := b.schemaFor(field.Type)
+		annotated := property
+		if annotated.Type == "array" {
+			annotated = annotated.Items
+		}
+		if enum := field.Tag.Get("enum"); enum != "" {
+			annotated.Enum = openAPIEnumSchema(enum).Enum
+		}
+		if format := field.Tag.Get("format"); format != "" {
+			annotated.Format = format
+		}
+		if minLength := field.Tag.Get("minLength"); minLength != "" {
+			annotated.MinLength = openAPITagInt(t, field, minLength)
+		}
+		if maxLength := field.Tag.Get("maxLength"); maxLength != "" {
+			annotated.MaxLength = openAPITagInt(t, field, maxLength)
+		}
+		if doc := field.Tag.Get("doc"); doc != "" {
+			if property.Ref != "" {
+				property = &OpenAPISchema{AllOf: []*OpenAPISchema{property}}
+			}
+			property.Description = doc
+		}
+		schema.Properties[name] = property
+
+		optional := field.Type.Kind() == reflect.Pointer || strings.Contains(","+options+",", ",omitempty,") || field.Tag.Get("required") == "false"
+		if !optional {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+	sort.Strings(schema.Required)
+	return schema
+}
+
+func openAPITagInt(t reflect.Type, field reflect.StructField, value string) int {
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		panic(fmt.Sprintf("annotation OpenAPI invalide sur %s.%s: %q", t.Name(), field.Name, value))
+	}
+	return parsed
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// openAPISpecFile est la spécification versionnée, à régénérer après un changement de l'API avec
+// UPDATE_OPENAPI=1 go test -run TestOpenAPISpecFile
+const openAPISpecFile = "openapi.json"
+
+func TestOpenAPISpecFile(t *testing.T) {
+	generated, err := json.MarshalIndent(buildOpenAPISpec(apiRoutes()), "", "  ")
+	if err != nil {
+		t.Fatalf("sérialisation de la spécification: %v", err)
+	}
+	generated = append(generated, '\n')
+
+	if os.Getenv("UPDATE_OPENAPI") != "" {
+		if err := os.WriteFile(openAPISpecFile, generated, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	committed, err := os.ReadFile(openAPISpecFile)
+	if err != nil {
+		t.Fatalf("lecture de %s: %v", openAPISpecFile, err)
+	}
+	if !bytes.Equal(committed, generated) {
+		t.Fatalf("%s ne correspond plus aux types et aux routes de l'API : relancez avec UPDATE_OPENAPI=1 et relisez le diff", openAPISpecFile)
+	}
+}
+
+// TestOpenAPIRoutesMatchHandlers interroge les vrais handlers, sans base de données : chaque méthode
+// documentée doit être acceptée par le handler et exiger l'authentification annoncée, les autres
+// méthodes doivent être refusées
+func TestOpenAPIRoutesMatchHandlers(t *testing.T) {
+	mux := http.NewServeMux()
+	registerAPIRoutes(mux)
+	spec := openAPISpec()
+
+	for _, route := range apiRoutes() {
+		path := strings.NewReplacer("{id}", "1", "{slug}", "test").Replace(route.Pattern)
+		documented := spec.Paths[route.Pattern]
+		if len(documented) == 0 {
+			t.Errorf("%s: aucune opération dans la spécification", route.Pattern)
+		}
+
+		for _, method := range []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete} {
+			operation := documented[strings.ToLower(method)]
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+
+			switch {
+			case operation == nil:
+				if rec.Code != http.StatusMethodNotAllowed {
+					t.Errorf("%s %s: méthode absente de la spécification mais acceptée par le handler (%d)", method, route.Pattern, rec.Code)
+				}
+			case operation.Scope != "" || len(operation.Security) > 0:
+				// Sans corps, une opération qui en attend un est refusée par la validation avant le handler
+				want := http.StatusUnauthorized
+				if operation.RequestBody != nil {
+					want = http.StatusBadRequest
+				}
+				if rec.Code != want {
+					t.Errorf("%s %s: statut %d sans authentification, %d attendu", method, route.Pattern, rec.Code, want)
+				}
+			default:
+				if rec.Code != http.StatusOK {
+					t.Errorf("%s %s: route publique en erreur (%d)", method, route.Pattern, rec.Code)
+				}
+			}
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/inconnue", nil))
+	var envelope APIErrorEnvelope
+	if rec.Code != http.StatusNotFound || json.Unmarshal(rec.Body.Bytes(), &envelope) != nil || envelope.Error.Code != APIErrorNotFound {
+		t.Errorf("route inconnue: %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+// TestOpenAPIResponsesMatchSchemas vérifie les réponses que les handlers peuvent produire sans base de données
+// contre leur schéma : la spécification elle-même et le compte de session
+func TestOpenAPIResponsesMatchSchemas(t *testing.T) {
+	mux := http.NewServeMux()
+	registerAPIRoutes(mux)
+	spec := openAPISpec()
+
+	for _, path := range []string{"/api/v1/openapi.json", "/api/user"} {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		response := spec.Paths[path]["get"].Responses["200"]
+		if media, ok := response.Content["application/json"]; ok {
+			if violation := spec.validateJSON(media.Schema, rec.Body.Bytes()); violation != nil {
+				t.Errorf("GET %s: réponse non conforme au schéma (%s: %s)", path, violation.Field, violation.Message)
+			}
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/quote", strings.NewReader(`{"nom":"Durand","prenom":"Léa","email":"lea@example.com","produit":"Table"}`)))
+	unauthorized := spec.Paths["/api/quote"]["post"].Responses["401"].Content["application/json"]
+	if rec.Code != http.StatusUnauthorized || unauthorized.Schema == nil || spec.validateJSON(unauthorized.Schema, rec.Body.Bytes()) != nil {
+		t.Errorf("POST /api/quote sans session: %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestOpenAPIRequestValidation(t *testing.T) {
+	var route apiRoute
+	for _, candidate := range apiRoutes() {
+		if candidate.Pattern == "/api/v1/quotes/{id}" {
+			route = candidate
+		}
+	}
+
+	var received string
+	handler := validateAPIRequest(route, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received = string(body)
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	cases := []struct {
+		body  string
+		code  int
+		field string
+	}{
+		{`{"status":"accepted","assigned_to":null}`, http.StatusNoContent, ""},
+		{`{"status":"rejected","rejection_reason":"Hors délai"}`, http.StatusNoContent, ""},
+		{`{"status":"archived"}`, http.StatusBadRequest, "status"},
+		{`{"status":3}`, http.StatusBadRequest, "status"},
+		{`{"statut":"sent"}`, http.StatusBadRequest, "statut"},
+		{`["sent"]`, http.StatusBadRequest, ""},
+		{`{"status":"sent"} {}`, http.StatusBadRequest, ""},
+		{`{`, http.StatusBadRequest, ""},
+	}
+	for _, c := range cases {
+		received = ""
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodPatch, "/api/v1/quotes/1", strings.NewReader(c.body)))
+		if rec.Code != c.code {
+			t.Errorf("%s: statut %d, %d attendu (%s)", c.body, rec.Code, c.code, rec.Body.String())
+			continue
+		}
+		if c.code == http.StatusNoContent {
+			if received != c.body {
+				t.Errorf("%s: le handler a reçu %q", c.body, received)
+			}
+			continue
+		}
+		var envelope APIErrorEnvelope
+		if err := json.Unmarshal(rec.Body.Bytes(), &envelope); err != nil || envelope.Error.Code != APIErrorInvalidRequest || envelope.Error.Field != c.field {
+			t.Errorf("%s: enveloppe inattendue %s", c.body, rec.Body.String())
+		}
+	}
+
+	// Une requête sans corps attendu (GET) passe sans être lue
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/api/v1/quotes/1", strings.NewReader("pas du JSON")))
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("GET: statut %d", rec.Code)
+	}
+}
+
+func TestOpenAPISchemaNames(t *testing.T) {
+	schemas := openAPISpec().Components.Schemas
+	for _, name := range []string{"APIPage_APIQuote", "APIQuote", "APIQuoteUpdate", "Quote", "UserSession", "APIErrorEnvelope"} {
+		if schemas[name] == nil {
+			t.Errorf("schéma %s absent", name)
+		}
+	}
+	for name := range schemas {
+		if strings.ContainsAny(name, "[]*/ ") {
+			t.Errorf("nom de schéma invalide: %s", name)
+		}
+	}
+	if quote := schemas["Quote"]; quote.Properties["ID"] != nil || strings.Join(quote.Required, ",") != "email,nom,prenom,produit" {
+		t.Errorf("schéma Quote: champs %v, obligatoires %v", quote.Properties, quote.Required)
+	}
+}
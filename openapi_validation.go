@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// openAPIViolation est le premier écart trouvé entre un corps de requête et son schéma
+type openAPIViolation struct {
+	Field   string
+	Message string
+}
+
+// validateAPIRequest vérifie le corps JSON d'une requête contre le schéma de son opération dans la spécification,
+// avant le handler. Les corps multipart (formulaire de devis avec pièces jointes) sont laissés au handler
+func validateAPIRequest(route apiRoute, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		spec := openAPISpec()
+		operation := spec.Paths[route.Pattern][strings.ToLower(r.Method)]
+		if operation == nil || operation.RequestBody == nil || strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+			next(w, r)
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, apiMaxBodyBytes))
+		if err != nil {
+			rejectAPIRequest(w, route, &openAPIViolation{Message: "Corps de requête illisible ou trop volumineux"})
+			return
+		}
+		if violation := spec.validateJSON(operation.RequestBody.Content["application/json"].Schema, body); violation != nil {
+			rejectAPIRequest(w, route, violation)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		next(w, r)
+	}
+}
+
+// rejectAPIRequest répond 400 dans le format d'erreur de la route : enveloppe JSON pour /api/v1,
+// texte brut pour les routes du site
+func rejectAPIRequest(w http.ResponseWriter, route apiRoute, violation *openAPIViolation) {
+	if route.Legacy {
+		message := violation.Message
+		if violation.Field != "" {
+			message = violation.Field + " : " + message
+		}
+		http.Error(w, message, http.StatusBadRequest)
+		return
+	}
+	writeAPIInvalidField(w, violation.Field, violation.Message)
+}
+
+// validateJSON vérifie qu'un corps est un unique document JSON conforme au schéma
+func (d *OpenAPIDocument) validateJSON(schema *OpenAPISchema, body []byte) *openAPIViolation {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return &openAPIViolation{Message: "Corps JSON invalide : " + err.Error()}
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return &openAPIViolation{Message: "Corps JSON invalide : un seul objet attendu"}
+	}
+	return d.validateValue(schema, value, "")
+}
+
+func (d *OpenAPIDocument) resolveSchema(schema *OpenAPISchema) *OpenAPISchema {
+	for schema.Ref != "" {
+		schema = d.Components.Schemas[strings.TrimPrefix(schema.Ref, openAPISchemaRefPrefix)]
+	}
+	return schema
+}
+
+func (d *OpenAPIDocument) validateValue(schema *OpenAPISchema, value any, field string) *openAPIViolation {
+	schema = d.resolveSchema(schema)
+	if value == nil {
+		if schema.Nullable {
+			return nil
+		}
+		return &openAPIViolation{Field: field, Message: "Valeur null non autorisée"}
+	}
+	for _, part := range schema.AllOf {
+		if violation := d.validateValue(part, value, field); violation != nil {
+			return violation
+		}
+	}
+
+	switch schema.Type {
+	case "object":
+		object, ok := value.(map[string]any)
+		if !ok {
+			return &openAPIViolation{Field: field, Message: "Objet JSON attendu"}
+		}
+		for _, name := range schema.Required {
+			if _, ok := object[name]; !ok {
+				return &openAPIViolation{Field: openAPIFieldPath(field, name), Message: "Champ obligatoire"}
+			}
+		}
+		// Les champs sont parcourus dans l'ordre alphabétique : le même corps donne toujours la même erreur
+		names := make([]string, 0, len(object))
+		for name := range object {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			property, ok := schema.Properties[name]
+			if !ok {
+				additional, isSchema := schema.AdditionalProperties.(*OpenAPISchema)
+				if !isSchema {
+					return &openAPIViolation{Field: openAPIFieldPath(field, name), Message: "Champ inconnu"}
+				}
+				property = additional
+			}
+			if violation := d.validateValue(property, object[name], openAPIFieldPath(field, name)); violation != nil {
+				return violation
+			}
+		}
+	case "array":
+		items, ok := value.([]any)
+		if !ok {
+			return &openAPIViolation{Field: field, Message: "Tableau JSON attendu"}
+		}
+		for i, item := range items {
+			if violation := d.validateValue(schema.Items, item, fmt.Sprintf("%s[%d]", field, i)); violation != nil {
+				return violation
+			}
+		}
+	case "string":
+		text, ok := value.(string)
+		if !ok {
+			return &openAPIViolation{Field: field, Message: "Chaîne attendue"}
+		}
+		return validateOpenAPIString(schema, text, field)
+	case "integer":
+		number, ok := value.(json.Number)
+		if !ok {
+			return &openAPIViolation{Field: field, Message: "Entier attendu"}
+		}
+		integer, err := number.Int64()
+		if err != nil {
+			return &openAPIViolation{Field: field, Message: "Entier attendu"}
+		}
+		if (schema.Minimum != nil && integer < int64(*schema.Minimum)) || (schema.Maximum != nil && integer > int64(*schema.Maximum)) {
+			return &openAPIViolation{Field: field, Message: "Entier hors des bornes autorisées"}
+		}
+	case "number":
+		if _, ok := value.(json.Number); !ok {
+			return &openAPIViolation{Field: field, Message: "Nombre attendu"}
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return &openAPIViolation{Field: field, Message: "Booléen attendu"}
+		}
+	}
+	return nil
+}
+
+func validateOpenAPIString(schema *OpenAPISchema, text, field string) *openAPIViolation {
+	if len(schema.Enum) > 0 {
+		allowed := false
+		for _, candidate := range schema.Enum {
+			if candidate == text {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return &openAPIViolation{Field: field, Message: fmt.Sprintf("Valeur inconnue %q, valeurs possibles : %s", text, strings.Join(schema.Enum, ", "))}
+		}
+	}
+	length := utf8.RuneCountInString(text)
+	if length < schema.MinLength {
+		return &openAPIViolation{Field: field, Message: "Champ obligatoire"}
+	}
+	if schema.MaxLength > 0 && length > schema.MaxLength {
+		return &openAPIViolation{Field: field, Message: fmt.Sprintf("%d caractères maximum", schema.MaxLength)}
+	}
+	switch schema.Format {
+	case "date":
+		if _, err := time.Parse("2006-01-02", text); err != nil {
+			return &openAPIViolation{Field: field, Message: "Date invalide, format attendu AAAA-MM-JJ"}
+		}
+	case "date-time":
+		if _, err := time.Parse(time.RFC3339, text); err != nil {
+			return &openAPIViolation{Field: field, Message: "Date et heure invalides, format attendu RFC 3339"}
+		}
+	}
+	return nil
+}
+
+func openAPIFieldPath(parent, name string) string {
+	if parent == "" {
+		return name
+	}
+	return parent + "." + name
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Statuts de suivi d'une commande, dans l'ordre de fabrication
+const (
+	OrderStatusManufacturing = "manufacturing"
+	OrderStatusReady         = "ready"
+	OrderStatusShipped       = "shipped"
+	OrderStatusDelivered     = "delivered"
+)
+
+// DocumentPrefixOrder numérote les commandes (CMD-AAAA-NNNN)
+const DocumentPrefixOrder = "CMD"
+
+var orderStatuses = []string{
+	OrderStatusManufacturing,
+	OrderStatusReady,
+	OrderStatusShipped,
+	OrderStatusDelivered,
+}
+
+var orderStatusLabels = map[string]string{
+	OrderStatusManufacturing: "En fabrication",
+	OrderStatusReady:         "Prête",
+	OrderStatusShipped:       "Expédiée",
+	OrderStatusDelivered:     "Livrée",
+}
+
+var errOrderExists = errors.New("une commande existe déjà pour ce devis")
+var errOrderStatusChanged = errors.New("la commande a changé d'étape entre-temps, rechargez-la avant de la faire avancer")
+var errQuoteNotAccepted = errors.New("seul un devis accepté peut être converti en commande")
+var errQuoteNotPriced = errors.New("le devis ne contient aucune ligne chiffrée")
+
+func orderStatusLabel(status string) string {
+	if label, ok := orderStatusLabels[status]; ok {
+		return label
+	}
+	return status
+}
+
+// nextOrderStatus renvoie l'étape suivante, ou "" si la commande est livrée
+func nextOrderStatus(status string) string {
+	for i, candidate := range orderStatuses {
+		if candidate == status && i+1 < len(orderStatuses) {
+			return orderStatuses[i+1]
+		}
+	}
+	return ""
+}
+
+// orderStatusStep renvoie la position (0..3) d'un statut dans le suivi
+func orderStatusStep(status string) int {
+	for i, candidate := range orderStatuses {
+		if candidate == status {
+			return i
+		}
+	}
+	return 0
+}
+
+// Order est une commande issue d'un devis accepté ; les montants sont figés à la conversion
+type Order struct {
+	ID                 int
+	Reference          string
+	QuoteID            int
+	UserID             int
+	Nom                string
+	Prenom             string
+	Email              string
+	Telephone          string
+	Produit            string
+	Status             string
+	TotalHTCents       int64
+	TotalVATCents      int64
+	TotalTTCCents      int64
+	ExpectedReadyAt    time.Time
+	ExpectedDeliveryAt time.Time
+	ReadyAt            *time.Time
+	ShippedAt          *time.Time
+	DeliveredAt        *time.Time
+	CreatedAt          time.Time
+}
+
+const orderColumns = "id, reference, quote_id, user_id, nom, prenom, email, telephone, produit, status, total_ht_cents, total_vat_cents, total_ttc_cents, expected_ready_at, expected_delivery_at, ready_at, shipped_at, delivered_at, created_at"
+
+func scanOrder(scanner interface{ Scan(...any) error }) (*Order, error) {
+	order := &Order{}
+	var userID sql.NullInt64
+	var telephone sql.NullString
+	var expectedReadyAt, expectedDeliveryAt, readyAt, shippedAt, deliveredAt, createdAt sql.NullTime
+
+	if err := scanner.Scan(&order.ID, &order.Reference, &order.QuoteID, &userID, &order.Nom, &order.Prenom, &order.Email, &telephone, &order.Produit, &order.Status,
+		&order.TotalHTCents, &order.TotalVATCents, &order.TotalTTCCents, &expectedReadyAt, &expectedDeliveryAt, &readyAt, &shippedAt, &deliveredAt, &createdAt); err != nil {
+		return nil, err
+	}
+
+	order.UserID = int(userID.Int64)
+	order.Telephone = telephone.String
+	order.ExpectedReadyAt = expectedReadyAt.Time
+	order.ExpectedDeliveryAt = expectedDeliveryAt.Time
+	order.CreatedAt = createdAt.Time
+	optional := func(value sql.NullTime) *time.Time {
+		if !value.Valid {
+			return nil
+		}
+		t := value.Time
+		return &t
+	}
+	order.ReadyAt = optional(readyAt)
+	order.ShippedAt = optional(shippedAt)
+	order.DeliveredAt = optional(deliveredAt)
+	return order, nil
+}
+
+// GetOrderByID récupère une commande, ou nil si elle n'existe pas
+func GetOrderByID(orderID int) (*Order, error) {
+	if db == nil {
+		return nil, fmt.Errorf("base de données non configurée")
+	}
+
+	order, err := scanOrder(db.QueryRow(
+		rebindQuery("SELECT "+orderColumns+" FROM orders WHERE id = ?"),
+		orderID,
+	))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return order, err
+}
+
+// GetOrderByQuoteID récupère la commande issue d'un devis, ou nil
+func GetOrderByQuoteID(quoteID int) (*Order, error) {
+	if db == nil {
+		return nil, fmt.Errorf("base de données non configurée")
+	}
+
+	order, err := scanOrder(db.QueryRow(
+		rebindQuery("SELECT "+orderColumns+" FROM orders WHERE quote_id = ?"),
+		quoteID,
+	))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return order, err
+}
+
+func queryOrders(query string, args ...any) ([]*Order, error) {
+	if db == nil {
+		return nil, fmt.Errorf("base de données non configurée")
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	orders := make([]*Order, 0)
+	for rows.Next() {
+		order, err := scanOrder(rows)
+		if err != nil {
+			return nil, err
+		}
+		orders = append(orders, order)
+	}
+	return orders, rows.Err()
+}
+
+// listOrders renvoie toutes les commandes, les plus récentes d'abord
+func listOrders() ([]*Order, error) {
+	return queryOrders("SELECT " + orderColumns + " FROM orders ORDER BY created_at DESC, id DESC")
+}
+
+// listOrdersForUser renvoie les commandes d'un client
+func listOrdersForUser(user *User) ([]*Order, error) {
+	return queryOrders(
+		rebindQuery("SELECT "+orderColumns+" FROM orders WHERE user_id = ? OR (user_id IS NULL AND email = ?) ORDER BY created_at DESC, id DESC"),
+		user.ID, user.Email,
+	)
+}
+
+// listOrderLines renvoie les lignes copiées du devis à la conversion
+func listOrderLines(orderID int) ([]QuoteLine, error) {
+	if db == nil {
+		return nil, fmt.Errorf("base de données non configurée")
+	}
+
+	rows, err := db.Query(
+		rebindQuery("SELECT id, order_id, label, quantity, unit_price_cents, vat_rate_bp FROM order_lines WHERE order_id = ? ORDER BY id"),
+		orderID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lines := make([]QuoteLine, 0)
+	for rows.Next() {
+		var line QuoteLine
+		if err := rows.Scan(&line.ID, &line.QuoteID, &line.Label, &line.Quantity, &line.UnitPriceCents, &line.VATRateBP); err != nil {
+			return nil, err
+		}
+		lines = append(lines, line)
+	}
+	return lines, rows.Err()
+}
+
+// convertQuoteToOrder crée la commande d'un devis accepté en copiant ses lignes et ses totaux
+func convertQuoteToOrder(quoteID int) (int, error) {
+	if db == nil {
+		return 0, fmt.Errorf("base de données non configurée")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// Verrouille le devis pour éviter deux conversions simultanées
+	quote, err := scanQuoteRecord(tx.QueryRow(
+		rebindQuery("SELECT "+quoteRecordColumns+" FROM quotes WHERE id = ? AND deleted_at IS NULL FOR UPDATE"),
+		quoteID,
+	))
+	if err != nil {
+		return 0, err
+	}
+	if quote.Status != QuoteStatusAccepted {
+		return 0, errQuoteNotAccepted
+	}
+
+	var existing int
+	if err := tx.QueryRow(
+		rebindQuery("SELECT COUNT(*) FROM orders WHERE quote_id = ?"),
+		quoteID,
+	).Scan(&existing); err != nil {
+		return 0, err
+	}
+	if existing > 0 {
+		return 0, errOrderExists
+	}
+
+	lines, err := queryQuoteLines(tx, quoteID)
+	if err != nil {
+		return 0, err
+	}
+	if len(lines) == 0 {
+		return 0, errQuoteNotPriced
+	}
+	totals := computeQuoteTotals(lines)
+
+	now := time.Now()
+	reference, err := nextDocumentNumber(tx, DocumentPrefixOrder, now)
+	if err != nil {
+		return 0, err
+	}
+	expectedReadyAt, expectedDeliveryAt := expectedOrderDates(quote.Produit, now)
+
+	var userID any
+	if quote.UserID != 0 {
+		userID = quote.UserID
+	}
+
+	var orderID int
+	args := []any{reference, quote.ID, userID, quote.Nom, quote.Prenom, quote.Email, quote.Telephone, quote.Produit, OrderStatusManufacturing,
+		totals.TotalHTCents, totals.TotalVATCents, totals.TotalTTCCents, expectedReadyAt, expectedDeliveryAt, now}
+	if dbDriver == "postgres" {
+		err = tx.QueryRow(
+			"INSERT INTO orders (reference, quote_id, user_id, nom, prenom, email, telephone, produit, status, total_ht_cents, total_vat_cents, total_ttc_cents, expected_ready_at, expected_delivery_at, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15) RETURNING id",
+			args...,
+		).Scan(&orderID)
+	} else {
+		var result sql.Result
+		result, err = tx.Exec(
+			"INSERT INTO orders (reference, quote_id, user_id, nom, prenom, email, telephone, produit, status, total_ht_cents, total_vat_cents, total_ttc_cents, expected_ready_at, expected_delivery_at, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+			args...,
+		)
+		if err == nil {
+			var lastID int64
+			lastID, err = result.LastInsertId()
+			orderID = int(lastID)
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	for _, line := range lines {
+		if _, err := tx.Exec(
+			rebindQuery("INSERT INTO order_lines (order_id, label, quantity, unit_price_cents, vat_rate_bp) VALUES (?, ?, ?, ?, ?)"),
+			orderID, line.Label, line.Quantity, line.UnitPriceCents, line.VATRateBP,
+		); err != nil {
+			return 0, err
+		}
+	}
+	if err := enqueueOrderWebhook(tx, WebhookEventOrderCreated, orderID, ""); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	notifyWebhooks()
+	return orderID, nil
+}
+
+// advanceOrderStatus fait passer la commande à l'étape suivante, date l'événement et met en file
+// le webhook order.status_changed dans la même transaction
+func advanceOrderStatus(order *Order) (string, error) {
+	if db == nil {
+		return "", fmt.Errorf("base de données non configurée")
+	}
+
+	next := nextOrderStatus(order.Status)
+	if next == "" {
+		return "", fmt.Errorf("la commande %s est déjà livrée", order.Reference)
+	}
+
+	column := map[string]string{
+		OrderStatusReady:     "ready_at",
+		OrderStatusShipped:   "shipped_at",
+		OrderStatusDelivered: "delivered_at",
+	}[next]
+
+	tx, err := db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(
+		rebindQuery("UPDATE orders SET status = ?, "+column+" = ? WHERE id = ? AND status = ?"),
+		next, time.Now(), order.ID, order.Status,
+	)
+	if err != nil {
+		return "", err
+	}
+	// Une avance concurrente a déjà fait passer l'étape : rien n'a changé ici, ni webhook ni email
+	if advanced, err := result.RowsAffected(); err != nil {
+		return "", err
+	} else if advanced == 0 {
+		return "", errOrderStatusChanged
+	}
+	if err := enqueueOrderWebhook(tx, WebhookEventOrderStatusChanged, order.ID, order.Status); err != nil {
+		return "", err
+	}
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+	notifyWebhooks()
+	return next, nil
+}
+
+// updateOrderExpectedDelivery corrige la date de livraison prévue communiquée au client
+func updateOrderExpectedDelivery(orderID int, expected time.Time) error {
+	if db == nil {
+		return fmt.Errorf("base de données non configurée")
+	}
+
+	_, err := db.Exec(
+		rebindQuery("UPDATE orders SET expected_delivery_at = ? WHERE id = ?"),
+		expected, orderID,
+	)
+	return err
+}
+
+// orderBelongsToUser indique si le client connecté peut consulter cette commande
+func orderBelongsToUser(order *Order, user *User) bool {
+	if order == nil || user == nil {
+		return false
+	}
+	if order.UserID != 0 {
+		return order.UserID == user.ID
+	}
+	return strings.EqualFold(order.Email, user.Email)
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Statuts d'un email de l'outbox
+const (
+	OutboxStatusPending = "pending"
+	OutboxStatusSending = "sending"
+	OutboxStatusSent    = "sent"
+	OutboxStatusDead    = "dead"
+)
+
+var outboxStatusLabels = map[string]string{
+	OutboxStatusPending: "En attente",
+	OutboxStatusSending: "En cours d'envoi",
+	OutboxStatusSent:    "Envoyé",
+	OutboxStatusDead:    "Échec définitif",
+}
+
+// Délai de la première relance ; il double à chaque échec, plafonné à outboxMaxBackoff
+const outboxBaseBackoff = 30 * time.Second
+const outboxMaxBackoff = 6 * time.Hour
+
+// Un envoi bloqué plus longtemps (arrêt du serveur en plein envoi) est remis en file
+const outboxStaleLock = 10 * time.Minute
+
+// outboxWake réveille le worker dès qu'un email vient d'être mis en file
+var outboxWake = make(chan struct{}, 1)
+
+// OutboxEmail est un email en file d'envoi
+type OutboxEmail struct {
+	ID            int
+	Sender        string
+	Recipients    []string
+	Subject       string
+	Message       string
+	Status        string
+	Attempts      int
+	NextAttemptAt *time.Time
+	LastError     string
+	// RequestID est l'identifiant de la requête qui a mis l'email en file, repris dans les journaux d'envoi
+	RequestID string
+	CreatedAt time.Time
+	SentAt    *time.Time
+}
+
+func outboxStatusLabel(status string) string {
+	if label, ok := outboxStatusLabels[status]; ok {
+		return label
+	}
+	return status
+}
+
+// outboxMaxAttempts est le nombre d'essais avant de classer l'email en échec définitif (OUTBOX_MAX_ATTEMPTS, 8 par défaut)
+func outboxMaxAttempts() int {
+	attempts, err := strconv.Atoi(getEnv("OUTBOX_MAX_ATTEMPTS", "8"))
+	if err != nil || attempts <= 0 {
+		return 8
+	}
+	return attempts
+}
+
+// outboxBackoff renvoie l'attente avant le prochain essai après attempts échecs
+func outboxBackoff(attempts int) time.Duration {
+	delay := outboxBaseBackoff
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= outboxMaxBackoff {
+			return outboxMaxBackoff
+		}
+	}
+	return delay
+}
+
+// enqueueEmail enregistre un email dans la transaction de l'appelant ; il sera envoyé après le commit.
+// L'identifiant de requête de ctx est conservé avec l'email pour relier son envoi à la requête d'origine
+func enqueueEmail(ctx context.Context, tx *sql.Tx, email OutgoingEmail) error {
+	_, err := tx.Exec(
+		rebindQuery("INSERT INTO email_outbox (sender, recipients, subject, message, status, attempts, next_attempt_at, request_id, created_at) VALUES (?, ?, ?, ?, ?, 0, ?, ?, ?)"),
+		email.From, strings.Join(email.Recipients, ","), email.Subject, string(email.Message), OutboxStatusPending, time.Now(), requestIDFromContext(ctx), time.Now(),
+	)
+	if err != nil {
+		return fmt.Errorf("erreur mise en file de l'email: %v", err)
+	}
+	return nil
+}
+
+// notifyOutbox réveille le worker sans bloquer si un réveil est déjà en attente
+func notifyOutbox() {
+	select {
+	case outboxWake <- struct{}{}:
+	default:
+	}
+}
+
+// startOutboxWorker lance la goroutine d'envoi (intervalle OUTBOX_POLL_SECONDS, 10 par défaut)
+func startOutboxWorker() {
+	interval, err := strconv.Atoi(getEnv("OUTBOX_POLL_SECONDS", "10"))
+	if err != nil || interval <= 0 {
+		interval = 10
+	}
+
+	go func() {
+		ticker := time.NewTicker(time.Duration(interval) * time.Second)
+		defer ticker.Stop()
+		for {
+			processOutbox()
+			select {
+			case <-ticker.C:
+			case <-outboxWake:
+			}
+		}
+	}()
+	slog.Info("Worker d'envoi des emails démarré")
+}
+
+// processOutbox envoie les emails arrivés à échéance
+func processOutbox() {
+	if db == nil {
+		return
+	}
+
+	now := time.Now()
+	if _, err := db.Exec(
+		rebindQuery("UPDATE email_outbox SET status = ? WHERE status = ? AND locked_at < ?"),
+		OutboxStatusPending, OutboxStatusSending, now.Add(-outboxStaleLock),
+	); err != nil {
+		slog.Error("Erreur remise en file des emails bloqués", "err", err)
+	}
+
+	rows, err := db.Query(
+		rebindQuery("SELECT id FROM email_outbox WHERE status = ? AND next_attempt_at <= ? ORDER BY next_attempt_at, id LIMIT 20"),
+		OutboxStatusPending, now,
+	)
+	if err != nil {
+		slog.Error("Erreur lecture de l'outbox", "err", err)
+		return
+	}
+	ids := make([]int, 0)
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			slog.Error("Erreur lecture de l'outbox", "err", err)
+			break
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	for _, id := range ids {
+		deliverOutboxEmail(id)
+	}
+}
+
+// deliverOutboxEmail réserve un email (une seule instance l'envoie), tente l'envoi et planifie la relance en cas d'échec
+func deliverOutboxEmail(id int) {
+	result, err := db.Exec(
+		rebindQuery("UPDATE email_outbox SET status = ?, locked_at = ? WHERE id = ? AND status = ?"),
+		OutboxStatusSending, time.Now(), id, OutboxStatusPending,
+	)
+	if err != nil {
+		slog.Error("Erreur réservation email", "email_id", id, "err", err)
+		return
+	}
+	if claimed, err := result.RowsAffected(); err != nil || claimed == 0 {
+		return
+	}
+
+	email, err := GetOutboxEmail(id)
+	if err != nil || email == nil {
+		slog.Error("Erreur lecture email", "email_id", id, "err", err)
+		return
+	}
+	ctx := withRequestID(context.Background(), email.RequestID)
+
+	sendErr := sendEmail(ctx, email.Sender, email.Recipients, []byte(email.Message))
+	now := time.Now()
+	if sendErr == nil {
+		if _, err := db.Exec(
+			rebindQuery("UPDATE email_outbox SET status = ?, attempts = attempts + 1, sent_at = ?, last_error = NULL WHERE id = ?"),
+			OutboxStatusSent, now, id,
+		); err != nil {
+			slog.ErrorContext(ctx, "Erreur mise à jour email envoyé", "email_id", id, "err", err)
+		}
+		return
+	}
+
+	attempts := email.Attempts + 1
+	status := OutboxStatusPending
+	if attempts >= outboxMaxAttempts() {
+		status = OutboxStatusDead
+		slog.WarnContext(ctx, "Email abandonné", "email_id", id, "subject", email.Subject, "attempts", attempts, "err", sendErr)
+	} else {
+		slog.ErrorContext(ctx, "Erreur envoi email", "email_id", id, "attempts", attempts, "err", sendErr)
+	}
+
+	if _, err := db.Exec(
+		rebindQuery("UPDATE email_outbox SET status = ?, attempts = ?, next_attempt_at = ?, last_error = ? WHERE id = ?"),
+		status, attempts, now.Add(outboxBackoff(attempts)), sendErr.Error(), id,
+	); err != nil {
+		slog.ErrorContext(ctx, "Erreur mise à jour email", "email_id", id, "err", err)
+	}
+}
+
+const outboxColumns = "id, sender, recipients, subject, message, status, attempts, next_attempt_at, last_error, request_id, created_at, sent_at"
+
+func scanOutboxEmail(scanner interface{ Scan(...any) error }) (*OutboxEmail, error) {
+	email := &OutboxEmail{}
+	var sender, lastError, requestID sql.NullString
+	var recipients string
+	var nextAttemptAt, createdAt, sentAt sql.NullTime
+	if err := scanner.Scan(&email.ID, &sender, &recipients, &email.Subject, &email.Message, &email.Status, &email.Attempts, &nextAttemptAt, &lastError, &requestID, &createdAt, &sentAt); err != nil {
+		return nil, err
+	}
+	email.Sender = sender.String
+	email.Recipients = strings.Split(recipients, ",")
+	email.LastError = lastError.String
+	email.RequestID = requestID.String
+	email.CreatedAt = createdAt.Time
+	if nextAttemptAt.Valid {
+		email.NextAttemptAt = &nextAttemptAt.Time
+	}
+	if sentAt.Valid {
+		email.SentAt = &sentAt.Time
+	}
+	return email, nil
+}
+
+// GetOutboxEmail récupère un email de l'outbox, ou nil s'il n'existe pas
+func GetOutboxEmail(id int) (*OutboxEmail, error) {
+	if db == nil {
+		return nil, fmt.Errorf("base de données non configurée")
+	}
+
+	email, err := scanOutboxEmail(db.QueryRow(
+		rebindQuery("SELECT "+outboxColumns+" FROM email_outbox WHERE id = ?"),
+		id,
+	))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return email, err
+}
+
+// listOutboxEmails renvoie les emails les plus récents, éventuellement filtrés par statut
+func listOutboxEmails(status string, limit int) ([]*OutboxEmail, error) {
+	if db == nil {
+		return nil, fmt.Errorf("base de données non configurée")
+	}
+
+	var rows *sql.Rows
+	var err error
+	if status != "" {
+		rows, err = db.Query(
+			rebindQuery("SELECT "+outboxColumns+" FROM email_outbox WHERE status = ? ORDER BY created_at DESC, id DESC LIMIT ?"),
+			status, limit,
+		)
+	} else {
+		rows, err = db.Query(
+			rebindQuery("SELECT "+outboxColumns+" FROM email_outbox ORDER BY created_at DESC, id DESC LIMIT ?"),
+			limit,
+		)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	emails := make([]*OutboxEmail, 0)
+	for rows.Next() {
+		email, err := scanOutboxEmail(rows)
+		if err != nil {
+			return nil, err
+		}
+		emails = append(emails, email)
+	}
+	return emails, rows.Err()
+}
+
+// countOutboxByStatus compte les emails par statut pour l'en-tête de la page admin
+func countOutboxByStatus() (map[string]int, error) {
+	if db == nil {
+		return nil, fmt.Errorf("base de données non configurée")
+	}
+
+	rows, err := db.Query("SELECT status, COUNT(*) FROM email_outbox GROUP BY status")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var status string
+		var count int
+		if err := rows.Scan(&status, &count); err != nil {
+			return nil, err
+		}
+		counts[status] = count
+	}
+	return counts, rows.Err()
+}
+
+// resendOutboxEmail remet un email en file pour un envoi immédiat, compteur d'essais remis à zéro
+func resendOutboxEmail(id int) error {
+	if db == nil {
+		return fmt.Errorf("base de données non configurée")
+	}
+
+	_, err := db.Exec(
+		rebindQuery("UPDATE email_outbox SET status = ?, attempts = 0, next_attempt_at = ? WHERE id = ? AND status <> ?"),
+		OutboxStatusPending, time.Now(), id, OutboxStatusSending,
+	)
+	if err != nil {
+		return err
+	}
+	notifyOutbox()
+	return nil
+}
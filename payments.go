@@ -0,0 +1,501 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"time"
+)
+
+// Objets payables en ligne
+const (
+	PaymentTargetQuote   = "quote"
+	PaymentTargetInvoice = "invoice"
+)
+
+// Statuts d'un paiement en ligne
+const (
+	PaymentStatusPending   = "pending"
+	PaymentStatusSucceeded = "succeeded"
+	PaymentStatusFailed    = "failed"
+	PaymentStatusRefunded  = "refunded"
+	// PaymentStatusOverpaid est un paiement encaissé par le prestataire mais non inscrit sur la facture,
+	// déjà réglée par une autre session : il reste à rembourser
+	PaymentStatusOverpaid = "overpaid"
+)
+
+// Types d'événements remontés par les prestataires
+const (
+	PaymentEventSucceeded = "checkout.succeeded"
+	PaymentEventFailed    = "checkout.failed"
+)
+
+var paymentStatusLabels = map[string]string{
+	PaymentStatusPending:   "En attente",
+	PaymentStatusSucceeded: "Payé",
+	PaymentStatusFailed:    "Échoué",
+	PaymentStatusRefunded:  "Remboursé",
+	PaymentStatusOverpaid:  "Trop-perçu à rembourser",
+}
+
+// Moyen de paiement inscrit sur les règlements de facture encaissés en ligne
+const paymentMethodOnline = "en ligne"
+
+var errPaymentsDisabled = errors.New("paiement en ligne non configuré")
+var errNothingToPay = errors.New("aucun montant à régler")
+var errPaymentNotRefundable = errors.New("seul un paiement encaissé peut être remboursé")
+var errPaymentOrderExists = errors.New("le devis a déjà été converti en commande : l'acompte ne peut plus être remboursé en ligne")
+
+// CheckoutRequest décrit un paiement à encaisser auprès du prestataire
+type CheckoutRequest struct {
+	TargetKind    string
+	TargetID      int
+	AmountCents   int64
+	Description   string
+	CustomerEmail string
+	SuccessURL    string
+	CancelURL     string
+}
+
+// CheckoutSession est la page de paiement ouverte chez le prestataire
+type CheckoutSession struct {
+	ID  string
+	URL string
+}
+
+// PaymentEvent est un événement de webhook dont la signature a été vérifiée
+type PaymentEvent struct {
+	ID        string `json:"id"`
+	Type      string `json:"type"`
+	SessionID string `json:"session_id"`
+}
+
+// PaymentProvider abstrait le prestataire de paiement (Stripe, PayPlug…) ; HandleWebhook doit
+// rejeter toute requête dont la signature est invalide
+type PaymentProvider interface {
+	Name() string
+	CreateCheckoutSession(req CheckoutRequest) (*CheckoutSession, error)
+	HandleWebhook(r *http.Request) (*PaymentEvent, error)
+	Refund(sessionID string, amountCents int64) error
+}
+
+// paymentProvider est le prestataire actif, nil si le paiement en ligne est désactivé
+var paymentProvider PaymentProvider
+
+// initPaymentProvider choisit le prestataire selon PAYMENT_PROVIDER. Sans valeur, le paiement en ligne est
+// désactivé ; "fake" laisse n'importe quel client simuler un paiement et n'est donc accepté qu'hors production
+func initPaymentProvider() {
+	switch name := getEnv("PAYMENT_PROVIDER", "none"); name {
+	case "none":
+		slog.Info("Paiement en ligne désactivé")
+	case "fake":
+		if getEnv("APP_ENV", "development") == "production" {
+			slog.Error("PAYMENT_PROVIDER=fake refusé en production, paiement en ligne désactivé")
+			return
+		}
+		paymentProvider = newFakePaymentProvider(getEnv("PAYMENT_WEBHOOK_SECRET", ""))
+		slog.Info("Paiement en ligne simulé (PAYMENT_PROVIDER=fake)")
+	default:
+		slog.Warn("Prestataire de paiement inconnu, paiement en ligne désactivé", "provider", name)
+	}
+}
+
+// Payment est une tentative de paiement en ligne sur un devis ou une facture
+type Payment struct {
+	ID          int
+	Provider    string
+	SessionID   string
+	TargetKind  string
+	TargetID    int
+	AmountCents int64
+	Status      string
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+func paymentStatusLabel(status string) string {
+	if label, ok := paymentStatusLabels[status]; ok {
+		return label
+	}
+	return status
+}
+
+const paymentColumns = "id, provider, session_id, target_kind, target_id, amount_cents, status, created_at, updated_at"
+
+func scanPayment(scanner interface{ Scan(...any) error }) (*Payment, error) {
+	payment := &Payment{}
+	var createdAt, updatedAt sql.NullTime
+	if err := scanner.Scan(&payment.ID, &payment.Provider, &payment.SessionID, &payment.TargetKind, &payment.TargetID, &payment.AmountCents, &payment.Status, &createdAt, &updatedAt); err != nil {
+		return nil, err
+	}
+	payment.CreatedAt = createdAt.Time
+	payment.UpdatedAt = updatedAt.Time
+	return payment, nil
+}
+
+// GetPaymentByID récupère un paiement, ou nil s'il n'existe pas
+func GetPaymentByID(paymentID int) (*Payment, error) {
+	if db == nil {
+		return nil, fmt.Errorf("base de données non configurée")
+	}
+
+	payment, err := scanPayment(db.QueryRow(
+		rebindQuery("SELECT "+paymentColumns+" FROM payments WHERE id = ?"),
+		paymentID,
+	))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return payment, err
+}
+
+// listPayments renvoie les paiements en ligne, les plus récents d'abord
+func listPayments() ([]*Payment, error) {
+	if db == nil {
+		return nil, fmt.Errorf("base de données non configurée")
+	}
+
+	rows, err := db.Query("SELECT " + paymentColumns + " FROM payments ORDER BY created_at DESC, id DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	payments := make([]*Payment, 0)
+	for rows.Next() {
+		payment, err := scanPayment(rows)
+		if err != nil {
+			return nil, err
+		}
+		payments = append(payments, payment)
+	}
+	return payments, rows.Err()
+}
+
+// quoteDepositCents est l'acompte demandé pour accepter un devis en ligne
+func quoteDepositCents(lines []QuoteLine) int64 {
+	total := computeQuoteTotals(lines).TotalTTCCents
+	return int64(math.Round(float64(total) * float64(invoiceDepositPercent()) / 100))
+}
+
+// startCheckout ouvre une session chez le prestataire et l'enregistre en attente
+func startCheckout(req CheckoutRequest) (*CheckoutSession, error) {
+	if paymentProvider == nil {
+		return nil, errPaymentsDisabled
+	}
+	if db == nil {
+		return nil, fmt.Errorf("base de données non configurée")
+	}
+	if req.AmountCents <= 0 {
+		return nil, errNothingToPay
+	}
+
+	session, err := paymentProvider.CreateCheckoutSession(req)
+	if err != nil {
+		return nil, fmt.Errorf("erreur création session de paiement: %v", err)
+	}
+
+	now := time.Now()
+	if _, err := db.Exec(
+		rebindQuery("INSERT INTO payments (provider, session_id, target_kind, target_id, amount_cents, status, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)"),
+		paymentProvider.Name(), session.ID, req.TargetKind, req.TargetID, req.AmountCents, PaymentStatusPending, now, now,
+	); err != nil {
+		return nil, fmt.Errorf("erreur enregistrement paiement: %v", err)
+	}
+
+	return session, nil
+}
+
+// applyPaymentEvent traite un événement vérifié une seule fois : l'identifiant d'événement est
+// enregistré dans la même transaction que ses effets, un rejeu renvoie duplicate=true sans rien modifier
+func applyPaymentEvent(ctx context.Context, provider string, event *PaymentEvent) (duplicate bool, err error) {
+	if db == nil {
+		return false, fmt.Errorf("base de données non configurée")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(
+		func() string {
+			if dbDriver == "postgres" {
+				return "INSERT INTO payment_events (provider, event_id, event_type, session_id) VALUES ($1, $2, $3, $4) ON CONFLICT (provider, event_id) DO NOTHING"
+			}
+			return "INSERT IGNORE INTO payment_events (provider, event_id, event_type, session_id) VALUES (?, ?, ?, ?)"
+		}(),
+		provider, event.ID, event.Type, event.SessionID,
+	)
+	if err != nil {
+		return false, err
+	}
+	if inserted, err := result.RowsAffected(); err != nil {
+		return false, err
+	} else if inserted == 0 {
+		return true, nil
+	}
+
+	payment, err := scanPayment(tx.QueryRow(
+		rebindQuery("SELECT "+paymentColumns+" FROM payments WHERE provider = ? AND session_id = ? FOR UPDATE"),
+		provider, event.SessionID,
+	))
+	if err == sql.ErrNoRows {
+		return false, fmt.Errorf("session de paiement inconnue: %s", event.SessionID)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	// Un paiement déjà finalisé n'est plus modifié par un événement tardif
+	if payment.Status != PaymentStatusPending {
+		return false, tx.Commit()
+	}
+
+	status := PaymentStatusFailed
+	if event.Type == PaymentEventSucceeded {
+		status = PaymentStatusSucceeded
+	} else if event.Type != PaymentEventFailed {
+		return false, tx.Commit()
+	}
+
+	notified := false
+
+	if status == PaymentStatusSucceeded {
+		switch payment.TargetKind {
+		case PaymentTargetInvoice:
+			// Deux sessions ouvertes sur la même facture peuvent aboutir : la seconde n'est pas inscrite
+			outstanding, err := lockInvoiceOutstanding(tx, payment.TargetID)
+			if err != nil {
+				return false, err
+			}
+			if payment.AmountCents > outstanding {
+				slog.WarnContext(ctx, "Paiement en ligne supérieur au reste à payer", "payment_id", payment.ID, "invoice_id", payment.TargetID,
+					"amount", formatEuros(payment.AmountCents), "outstanding", formatEuros(outstanding))
+				status = PaymentStatusOverpaid
+			} else if err := insertInvoicePayment(tx, payment.TargetID, payment.AmountCents, paymentMethodOnline, payment.SessionID, time.Now()); err != nil {
+				return false, err
+			}
+		case PaymentTargetQuote:
+			// L'acompte réglé vaut acceptation du devis envoyé
+			result, err := tx.Exec(
+				rebindQuery("UPDATE quotes SET status = ? WHERE id = ? AND status = ?"),
+				QuoteStatusAccepted, payment.TargetID, QuoteStatusSent,
+			)
+			if err != nil {
+				return false, err
+			}
+			if accepted, err := result.RowsAffected(); err != nil {
+				return false, err
+			} else if accepted == 0 {
+				// Devis refusé, expiré ou déjà accepté entre l'ouverture de la session et le paiement : rien ne
+				// retient la somme, elle est à rembourser
+				slog.WarnContext(ctx, "Acompte réglé sur un devis qui n'est plus en attente", "payment_id", payment.ID, "quote_id", payment.TargetID)
+				status = PaymentStatusOverpaid
+			} else {
+				quote, err := scanQuoteRecord(tx.QueryRow(
+					rebindQuery("SELECT "+quoteRecordColumns+" FROM quotes WHERE id = ?"),
+					payment.TargetID,
+				))
+				if err != nil {
+					return false, err
+				}
+				if notified, err = enqueueQuoteStatusEmail(ctx, tx, quote); err != nil {
+					return false, err
+				}
+				if err := enqueueQuoteWebhook(tx, WebhookEventQuoteStatusChanged, quote.ID, QuoteStatusSent); err != nil {
+					return false, err
+				}
+			}
+		}
+	}
+
+	if err := setPaymentStatus(tx, payment.ID, status); err != nil {
+		return false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+	if notified {
+		notifyOutbox()
+	}
+	notifyWebhooks()
+	return false, nil
+}
+
+func setPaymentStatus(tx *sql.Tx, paymentID int, status string) error {
+	_, err := tx.Exec(
+		rebindQuery("UPDATE payments SET status = ?, updated_at = ? WHERE id = ?"),
+		status, time.Now(), paymentID,
+	)
+	return err
+}
+
+// carryQuoteDeposits inscrit sur la première facture d'une commande les acomptes réglés en ligne sur son devis,
+// sans quoi ils resteraient à payer une seconde fois. Un acompte qui dépasse le reste à payer (arrondi, lignes
+// modifiées dans la commande) n'est inscrit qu'à hauteur de ce reste
+func carryQuoteDeposits(tx *sql.Tx, quoteID, invoiceID int, outstanding int64) error {
+	rows, err := tx.Query(
+		rebindQuery("SELECT "+paymentColumns+" FROM payments WHERE target_kind = ? AND target_id = ? AND status = ? ORDER BY id"),
+		PaymentTargetQuote, quoteID, PaymentStatusSucceeded,
+	)
+	if err != nil {
+		return err
+	}
+	var deposits []*Payment
+	for rows.Next() {
+		payment, err := scanPayment(rows)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		deposits = append(deposits, payment)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, payment := range deposits {
+		amount := payment.AmountCents
+		if amount > outstanding {
+			slog.Warn("Acompte en ligne supérieur au reste à payer de la facture", "payment_id", payment.ID, "invoice_id", invoiceID,
+				"amount", formatEuros(amount), "outstanding", formatEuros(outstanding))
+			amount = outstanding
+		}
+		if amount <= 0 {
+			continue
+		}
+		paidAt := payment.UpdatedAt
+		if paidAt.IsZero() {
+			paidAt = payment.CreatedAt
+		}
+		if err := insertInvoicePayment(tx, invoiceID, amount, paymentMethodOnline, payment.SessionID, paidAt); err != nil {
+			return err
+		}
+		outstanding -= amount
+	}
+	return nil
+}
+
+// refundPayment rembourse un paiement encaissé. Pour une facture, un règlement négatif est inscrit ;
+// pour un acompte, le devis accepté par ce paiement revient au statut envoyé, sauf s'il est déjà en commande.
+// Le paiement reste verrouillé pendant l'appel au prestataire pour éviter un double remboursement
+func refundPayment(payment *Payment) error {
+	if paymentProvider == nil || paymentProvider.Name() != payment.Provider {
+		return errPaymentsDisabled
+	}
+	if db == nil {
+		return fmt.Errorf("base de données non configurée")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var status string
+	if err := tx.QueryRow(
+		rebindQuery("SELECT status FROM payments WHERE id = ? FOR UPDATE"),
+		payment.ID,
+	).Scan(&status); err != nil {
+		return err
+	}
+	if status != PaymentStatusSucceeded && status != PaymentStatusOverpaid {
+		return errPaymentNotRefundable
+	}
+
+	revertQuote := false
+	if payment.TargetKind == PaymentTargetQuote && status == PaymentStatusSucceeded {
+		var quoteStatus string
+		var orders int
+		if err := tx.QueryRow(
+			rebindQuery("SELECT status FROM quotes WHERE id = ? FOR UPDATE"),
+			payment.TargetID,
+		).Scan(&quoteStatus); err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		if err := tx.QueryRow(
+			rebindQuery("SELECT COUNT(*) FROM orders WHERE quote_id = ?"),
+			payment.TargetID,
+		).Scan(&orders); err != nil {
+			return err
+		}
+		if orders > 0 {
+			return errPaymentOrderExists
+		}
+		revertQuote = quoteStatus == QuoteStatusAccepted
+	}
+
+	if err := paymentProvider.Refund(payment.SessionID, payment.AmountCents); err != nil {
+		return fmt.Errorf("erreur remboursement chez le prestataire: %v", err)
+	}
+
+	if err := setPaymentStatus(tx, payment.ID, PaymentStatusRefunded); err != nil {
+		return err
+	}
+	if payment.TargetKind == PaymentTargetInvoice && status == PaymentStatusSucceeded {
+		if err := insertInvoicePayment(tx, payment.TargetID, -payment.AmountCents, paymentMethodOnline, "Remboursement "+payment.SessionID, time.Now()); err != nil {
+			return err
+		}
+	}
+	if revertQuote {
+		// Sans acompte, l'acceptation tombe : le client peut à nouveau accepter ou refuser le devis
+		if _, err := tx.Exec(
+			rebindQuery("UPDATE quotes SET status = ? WHERE id = ? AND status = ?"),
+			QuoteStatusSent, payment.TargetID, QuoteStatusAccepted,
+		); err != nil {
+			return err
+		}
+		if err := enqueueQuoteWebhook(tx, WebhookEventQuoteStatusChanged, payment.TargetID, QuoteStatusAccepted); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	if revertQuote {
+		notifyWebhooks()
+	}
+	return nil
+}
+
+// paymentWebhookHandler reçoit les notifications du prestataire ; un événement déjà traité répond 200
+func paymentWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if paymentProvider == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	event, err := paymentProvider.HandleWebhook(r)
+	if err != nil {
+		slog.WarnContext(r.Context(), "Webhook paiement rejeté", "err", err)
+		http.Error(w, "Signature invalide", http.StatusBadRequest)
+		return
+	}
+
+	duplicate, err := applyPaymentEvent(r.Context(), paymentProvider.Name(), event)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Erreur traitement événement paiement", "event_id", event.ID, "err", err)
+		http.Error(w, "Erreur traitement événement", http.StatusInternalServerError)
+		return
+	}
+	if duplicate {
+		slog.InfoContext(r.Context(), "Événement paiement déjà traité", "event_id", event.ID)
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
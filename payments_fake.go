@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// En-tête de signature des webhooks simulés, au format "t=<unix>,v1=<hmac hex>"
+const fakeSignatureHeader = "X-Fake-Signature"
+
+// Écart maximal accepté entre l'horodatage signé et l'heure de réception
+const fakeSignatureTolerance = 5 * time.Minute
+
+// fakePaymentProvider simule un prestataire : la session ouvre une page locale où l'on choisit
+// le succès ou l'échec, puis un webhook signé est rejoué dans le circuit normal
+type fakePaymentProvider struct {
+	secret []byte
+
+	mu       sync.Mutex
+	sessions map[string]CheckoutRequest
+}
+
+func newFakePaymentProvider(secret string) *fakePaymentProvider {
+	if secret == "" {
+		secret = randomHex(32)
+	}
+	return &fakePaymentProvider{secret: []byte(secret), sessions: make(map[string]CheckoutRequest)}
+}
+
+func randomHex(size int) string {
+	buf := make([]byte, size)
+	if _, err := rand.Read(buf); err != nil {
+		panic(fmt.Sprintf("erreur génération aléatoire: %v", err))
+	}
+	return hex.EncodeToString(buf)
+}
+
+func (p *fakePaymentProvider) Name() string {
+	return "fake"
+}
+
+func (p *fakePaymentProvider) CreateCheckoutSession(req CheckoutRequest) (*CheckoutSession, error) {
+	sessionID := "fake_cs_" + randomHex(12)
+
+	p.mu.Lock()
+	p.sessions[sessionID] = req
+	p.mu.Unlock()
+
+	return &CheckoutSession{ID: sessionID, URL: "/payments/fake/checkout/" + sessionID}, nil
+}
+
+func (p *fakePaymentProvider) sign(timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, p.secret)
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (p *fakePaymentProvider) HandleWebhook(r *http.Request) (*PaymentEvent, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, 64<<10))
+	if err != nil {
+		return nil, fmt.Errorf("lecture du corps: %v", err)
+	}
+
+	var timestamp int64
+	var signature string
+	for _, part := range strings.Split(r.Header.Get(fakeSignatureHeader), ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			timestamp, _ = strconv.ParseInt(value, 10, 64)
+		case "v1":
+			signature = value
+		}
+	}
+	if timestamp == 0 || signature == "" {
+		return nil, fmt.Errorf("en-tête %s absent ou incomplet", fakeSignatureHeader)
+	}
+	if age := time.Since(time.Unix(timestamp, 0)); age > fakeSignatureTolerance || age < -fakeSignatureTolerance {
+		return nil, fmt.Errorf("horodatage hors tolérance")
+	}
+	if !hmac.Equal([]byte(signature), []byte(p.sign(timestamp, body))) {
+		return nil, fmt.Errorf("signature invalide")
+	}
+
+	var event PaymentEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		return nil, fmt.Errorf("événement illisible: %v", err)
+	}
+	if event.ID == "" || event.SessionID == "" {
+		return nil, fmt.Errorf("événement incomplet")
+	}
+	return &event, nil
+}
+
+func (p *fakePaymentProvider) Refund(sessionID string, amountCents int64) error {
+	if !strings.HasPrefix(sessionID, "fake_cs_") {
+		return fmt.Errorf("session inconnue: %s", sessionID)
+	}
+	slog.Info("Remboursement simulé", "amount", formatEuros(amountCents), "session_id", sessionID)
+	return nil
+}
+
+// signedWebhookRequest construit la requête que le prestataire enverrait sur /api/payments/webhook
+func (p *fakePaymentProvider) signedWebhookRequest(event PaymentEvent) (*http.Request, error) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, "/api/payments/webhook", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(fakeSignatureHeader, fmt.Sprintf("t=%d,v1=%s", timestamp, p.sign(timestamp, body)))
+	return req, nil
+}
+
+// fakeCheckoutHandler affiche la page de paiement simulée et rejoue le webhook choisi
+func fakeCheckoutHandler(w http.ResponseWriter, r *http.Request) {
+	provider, ok := paymentProvider.(*fakePaymentProvider)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	sessionID := r.PathValue("session")
+	provider.mu.Lock()
+	req, found := provider.sessions[sessionID]
+	provider.mu.Unlock()
+	if !found {
+		http.Error(w, "Session de paiement inconnue ou expirée", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		var builder strings.Builder
+		builder.WriteString(`<!DOCTYPE html><html lang="fr"><head><meta charset="UTF-8"><meta name="viewport" content="width=device-width, initial-scale=1.0"><title>Paiement simulé</title><style>
+	body{font-family:Arial,sans-serif;background:#f7f7fb;color:#1f2937}
+	.box{max-width:480px;margin:60px auto;background:#fff;border-radius:10px;padding:24px;box-shadow:0 2px 8px rgba(0,0,0,.08)}
+	button{border:none;padding:10px 16px;border-radius:6px;cursor:pointer;color:#fff;margin-right:8px}
+	.ok{background:#2e7d32}.ko{background:#b91c1c}
+	</style></head><body><div class="box">`)
+		builder.WriteString(`<p style="color:#b91c1c"><strong>Mode test</strong> : aucun paiement réel n'est effectué.</p>`)
+		builder.WriteString(fmt.Sprintf(`<h1>Paiement simulé</h1><p>%s</p><p>Montant : <strong>%s</strong></p>`,
+			html.EscapeString(req.Description), html.EscapeString(formatEuros(req.AmountCents))))
+		builder.WriteString(fmt.Sprintf(`<form method="POST" action="/payments/fake/checkout/%s"><button type="submit" name="outcome" value="success" class="ok">Simuler un paiement réussi</button><button type="submit" name="outcome" value="failure" class="ko">Simuler un échec</button></form>`,
+			html.EscapeString(sessionID)))
+		builder.WriteString(`</div></body></html>`)
+		w.Write([]byte(builder.String()))
+
+	case http.MethodPost:
+		event := PaymentEvent{ID: "evt_" + randomHex(12), Type: PaymentEventFailed, SessionID: sessionID}
+		redirect := req.CancelURL
+		if r.FormValue("outcome") == "success" {
+			event.Type = PaymentEventSucceeded
+			redirect = req.SuccessURL
+		}
+
+		webhook, err := provider.signedWebhookRequest(event)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Erreur construction webhook simulé", "err", err)
+			http.Error(w, "Erreur paiement simulé", http.StatusInternalServerError)
+			return
+		}
+		verified, err := provider.HandleWebhook(webhook)
+		if err != nil {
+			slog.WarnContext(r.Context(), "Webhook simulé rejeté", "err", err)
+			http.Error(w, "Erreur paiement simulé", http.StatusInternalServerError)
+			return
+		}
+		if _, err := applyPaymentEvent(r.Context(), provider.Name(), verified); err != nil {
+			slog.ErrorContext(r.Context(), "Erreur traitement paiement simulé", "session_id", sessionID, "err", err)
+			http.Error(w, "Erreur paiement simulé", http.StatusInternalServerError)
+			return
+		}
+
+		provider.mu.Lock()
+		delete(provider.sessions, sessionID)
+		provider.mu.Unlock()
+
+		http.Redirect(w, r, redirect, http.StatusSeeOther)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFakeWebhookSignature(t *testing.T) {
+	provider := newFakePaymentProvider("secret-de-test")
+	event := PaymentEvent{ID: "evt_1", Type: PaymentEventSucceeded, SessionID: "fake_cs_1"}
+	body, _ := json.Marshal(event)
+	now := time.Now().Unix()
+	signed := func(timestamp int64, body []byte) string {
+		return fmt.Sprintf("t=%d,v1=%s", timestamp, provider.sign(timestamp, body))
+	}
+
+	cases := []struct {
+		name      string
+		header    string
+		body      []byte
+		wantError string
+	}{
+		{"signature valide", signed(now, body), body, ""},
+		{"en-tête absent", "", body, "absent ou incomplet"},
+		{"signature manquante", fmt.Sprintf("t=%d", now), body, "absent ou incomplet"},
+		{"corps modifié", signed(now, body), []byte(strings.Replace(string(body), "fake_cs_1", "fake_cs_2", 1)), "signature invalide"},
+		{"autre secret", fmt.Sprintf("t=%d,v1=%s", now, newFakePaymentProvider("autre").sign(now, body)), body, "signature invalide"},
+		{"rejeu tardif", signed(now-3600, body), body, "hors tolérance"},
+		{"horodatage futur", signed(now+3600, body), body, "hors tolérance"},
+		{"événement incomplet", signed(now, []byte(`{"id":"evt_1"}`)), []byte(`{"id":"evt_1"}`), "incomplet"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/api/payments/webhook", strings.NewReader(string(c.body)))
+			if c.header != "" {
+				req.Header.Set(fakeSignatureHeader, c.header)
+			}
+			verified, err := provider.HandleWebhook(req)
+			if c.wantError == "" {
+				if err != nil {
+					t.Fatalf("webhook refusé: %v", err)
+				}
+				if *verified != event {
+					t.Fatalf("événement %+v, attendu %+v", *verified, event)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), c.wantError) {
+				t.Fatalf("erreur %v, attendu %q", err, c.wantError)
+			}
+		})
+	}
+}
+
+// openTestDB branche le paquet sur la base PostgreSQL de TEST_DATABASE_URL, à réserver aux tests :
+// les tables sont créées si besoin et chaque test y écrit ses propres lignes
+func openTestDB(t *testing.T) {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL non défini : tests sur base ignorés")
+	}
+
+	conn, err := sql.Open("postgres", normalizePostgresDSN(dsn))
+	if err != nil {
+		t.Fatal(err)
+	}
+	previousDB, previousDriver, previousProvider := db, dbDriver, paymentProvider
+	db, dbDriver = conn, "postgres"
+	paymentProvider = newFakePaymentProvider("secret-de-test")
+	t.Cleanup(func() {
+		db, dbDriver, paymentProvider = previousDB, previousDriver, previousProvider
+		conn.Close()
+	})
+	if err := createTablesPostgres(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func insertTestRow(t *testing.T, query string, args ...any) int {
+	t.Helper()
+	var id int
+	if err := db.QueryRow(rebindQuery(query+" RETURNING id"), args...).Scan(&id); err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func insertTestPayment(t *testing.T, kind string, targetID int, amountCents int64) *Payment {
+	t.Helper()
+	sessionID := "fake_cs_" + randomHex(12)
+	id := insertTestRow(t, "INSERT INTO payments (provider, session_id, target_kind, target_id, amount_cents, status, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		"fake", sessionID, kind, targetID, amountCents, PaymentStatusPending, time.Now())
+	return &Payment{ID: id, Provider: "fake", SessionID: sessionID, TargetKind: kind, TargetID: targetID, AmountCents: amountCents}
+}
+
+func testPaymentStatus(t *testing.T, paymentID int) string {
+	t.Helper()
+	var status string
+	if err := db.QueryRow(rebindQuery("SELECT status FROM payments WHERE id = ?"), paymentID).Scan(&status); err != nil {
+		t.Fatal(err)
+	}
+	return status
+}
+
+func testQuoteStatus(t *testing.T, quoteID int) string {
+	t.Helper()
+	var status string
+	if err := db.QueryRow(rebindQuery("SELECT status FROM quotes WHERE id = ?"), quoteID).Scan(&status); err != nil {
+		t.Fatal(err)
+	}
+	return status
+}
+
+func testInvoicePaid(t *testing.T, invoiceID int) int64 {
+	t.Helper()
+	var paid int64
+	if err := db.QueryRow(rebindQuery("SELECT COALESCE(SUM(amount_cents), 0) FROM invoice_payments WHERE invoice_id = ?"), invoiceID).Scan(&paid); err != nil {
+		t.Fatal(err)
+	}
+	return paid
+}
+
+func paymentEvent(payment *Payment, eventType string) *PaymentEvent {
+	return &PaymentEvent{ID: "evt_" + randomHex(12), Type: eventType, SessionID: payment.SessionID}
+}
+
+func TestApplyPaymentEvent(t *testing.T) {
+	openTestDB(t)
+	ctx := context.Background()
+
+	newQuote := func(t *testing.T, status string) int {
+		return insertTestRow(t, "INSERT INTO quotes (nom, prenom, email, produit, status) VALUES (?, ?, ?, ?, ?)",
+			"Test", "Paiement", "paiement@example.com", "Test", status)
+	}
+	newInvoice := func(t *testing.T, amountDue int64) int {
+		return insertTestRow(t, "INSERT INTO invoices (number, order_id, kind, total_ht_cents, total_vat_cents, total_ttc_cents, amount_due_cents) VALUES (?, 0, ?, ?, 0, ?, ?)",
+			"TEST-"+randomHex(6), InvoiceKindDeposit, amountDue, amountDue, amountDue)
+	}
+	apply := func(t *testing.T, event *PaymentEvent) bool {
+		t.Helper()
+		duplicate, err := applyPaymentEvent(ctx, "fake", event)
+		if err != nil {
+			t.Fatalf("applyPaymentEvent: %v", err)
+		}
+		return duplicate
+	}
+
+	cases := []struct {
+		name string
+		run  func(t *testing.T)
+	}{
+		{"acompte réglé : devis accepté", func(t *testing.T) {
+			quoteID := newQuote(t, QuoteStatusSent)
+			payment := insertTestPayment(t, PaymentTargetQuote, quoteID, 30000)
+			apply(t, paymentEvent(payment, PaymentEventSucceeded))
+			if status := testPaymentStatus(t, payment.ID); status != PaymentStatusSucceeded {
+				t.Errorf("paiement %s, attendu %s", status, PaymentStatusSucceeded)
+			}
+			if status := testQuoteStatus(t, quoteID); status != QuoteStatusAccepted {
+				t.Errorf("devis %s, attendu %s", status, QuoteStatusAccepted)
+			}
+		}},
+		{"acompte réglé sur un devis refusé : trop-perçu", func(t *testing.T) {
+			quoteID := newQuote(t, QuoteStatusRejected)
+			payment := insertTestPayment(t, PaymentTargetQuote, quoteID, 30000)
+			apply(t, paymentEvent(payment, PaymentEventSucceeded))
+			if status := testPaymentStatus(t, payment.ID); status != PaymentStatusOverpaid {
+				t.Errorf("paiement %s, attendu %s", status, PaymentStatusOverpaid)
+			}
+			if status := testQuoteStatus(t, quoteID); status != QuoteStatusRejected {
+				t.Errorf("devis %s, attendu %s", status, QuoteStatusRejected)
+			}
+		}},
+		{"acompte en ligne reporté sur la facture d'acompte", func(t *testing.T) {
+			t.Setenv("INVOICE_DEPOSIT_PERCENT", "30")
+			quoteID := newQuote(t, QuoteStatusSent)
+			payment := insertTestPayment(t, PaymentTargetQuote, quoteID, 36000)
+			apply(t, paymentEvent(payment, PaymentEventSucceeded))
+
+			orderID := insertTestRow(t, "INSERT INTO orders (reference, quote_id, user_id, nom, prenom, email, produit, total_ht_cents, total_vat_cents, total_ttc_cents, created_at) VALUES (?, ?, 0, ?, ?, ?, ?, ?, ?, ?, ?)",
+				"CMD-"+randomHex(6), quoteID, "Test", "Paiement", "paiement@example.com", "Test", 100000, 20000, 120000, time.Now())
+			insertTestRow(t, "INSERT INTO order_lines (order_id, label, quantity, unit_price_cents, vat_rate_bp) VALUES (?, ?, ?, ?, ?)",
+				orderID, "Test", 1, 100000, 2000)
+			order, err := GetOrderByID(orderID)
+			if err != nil || order == nil {
+				t.Fatalf("GetOrderByID: %v", err)
+			}
+			invoiceID, err := issueInvoice(order, InvoiceKindDeposit)
+			if err != nil {
+				t.Fatalf("issueInvoice: %v", err)
+			}
+			if paid := testInvoicePaid(t, invoiceID); paid != 36000 {
+				t.Errorf("réglé %d, attendu 36000", paid)
+			}
+		}},
+		{"paiement échoué : devis inchangé", func(t *testing.T) {
+			quoteID := newQuote(t, QuoteStatusSent)
+			payment := insertTestPayment(t, PaymentTargetQuote, quoteID, 30000)
+			apply(t, paymentEvent(payment, PaymentEventFailed))
+			if status := testPaymentStatus(t, payment.ID); status != PaymentStatusFailed {
+				t.Errorf("paiement %s, attendu %s", status, PaymentStatusFailed)
+			}
+			if status := testQuoteStatus(t, quoteID); status != QuoteStatusSent {
+				t.Errorf("devis %s, attendu %s", status, QuoteStatusSent)
+			}
+		}},
+		{"événement rejoué ignoré", func(t *testing.T) {
+			invoiceID := newInvoice(t, 50000)
+			payment := insertTestPayment(t, PaymentTargetInvoice, invoiceID, 20000)
+			event := paymentEvent(payment, PaymentEventSucceeded)
+			if apply(t, event) {
+				t.Fatal("premier passage signalé comme doublon")
+			}
+			if !apply(t, event) {
+				t.Fatal("rejeu non détecté")
+			}
+			if paid := testInvoicePaid(t, invoiceID); paid != 20000 {
+				t.Errorf("réglé %d, attendu 20000", paid)
+			}
+		}},
+		{"événement tardif sur un paiement finalisé", func(t *testing.T) {
+			quoteID := newQuote(t, QuoteStatusSent)
+			payment := insertTestPayment(t, PaymentTargetQuote, quoteID, 30000)
+			apply(t, paymentEvent(payment, PaymentEventFailed))
+			apply(t, paymentEvent(payment, PaymentEventSucceeded))
+			if status := testPaymentStatus(t, payment.ID); status != PaymentStatusFailed {
+				t.Errorf("paiement %s, attendu %s", status, PaymentStatusFailed)
+			}
+			if status := testQuoteStatus(t, quoteID); status != QuoteStatusSent {
+				t.Errorf("devis %s, attendu %s", status, QuoteStatusSent)
+			}
+		}},
+		{"deux sessions sur la même facture", func(t *testing.T) {
+			invoiceID := newInvoice(t, 50000)
+			first := insertTestPayment(t, PaymentTargetInvoice, invoiceID, 50000)
+			second := insertTestPayment(t, PaymentTargetInvoice, invoiceID, 50000)
+			apply(t, paymentEvent(first, PaymentEventSucceeded))
+			apply(t, paymentEvent(second, PaymentEventSucceeded))
+			if paid := testInvoicePaid(t, invoiceID); paid != 50000 {
+				t.Errorf("réglé %d, attendu 50000", paid)
+			}
+			if status := testPaymentStatus(t, second.ID); status != PaymentStatusOverpaid {
+				t.Errorf("second paiement %s, attendu %s", status, PaymentStatusOverpaid)
+			}
+		}},
+		{"session inconnue", func(t *testing.T) {
+			event := &PaymentEvent{ID: "evt_" + randomHex(12), Type: PaymentEventSucceeded, SessionID: "fake_cs_inconnue"}
+			if _, err := applyPaymentEvent(ctx, "fake", event); err == nil {
+				t.Fatal("session inconnue acceptée")
+			}
+		}},
+		{"remboursement de l'acompte", func(t *testing.T) {
+			quoteID := newQuote(t, QuoteStatusSent)
+			payment := insertTestPayment(t, PaymentTargetQuote, quoteID, 30000)
+			apply(t, paymentEvent(payment, PaymentEventSucceeded))
+			if err := refundPayment(payment); err != nil {
+				t.Fatalf("refundPayment: %v", err)
+			}
+			if status := testPaymentStatus(t, payment.ID); status != PaymentStatusRefunded {
+				t.Errorf("paiement %s, attendu %s", status, PaymentStatusRefunded)
+			}
+			if status := testQuoteStatus(t, quoteID); status != QuoteStatusSent {
+				t.Errorf("devis %s, attendu %s", status, QuoteStatusSent)
+			}
+		}},
+		{"remboursement d'un trop-perçu", func(t *testing.T) {
+			invoiceID := newInvoice(t, 10000)
+			first := insertTestPayment(t, PaymentTargetInvoice, invoiceID, 10000)
+			second := insertTestPayment(t, PaymentTargetInvoice, invoiceID, 10000)
+			apply(t, paymentEvent(first, PaymentEventSucceeded))
+			apply(t, paymentEvent(second, PaymentEventSucceeded))
+			if err := refundPayment(second); err != nil {
+				t.Fatalf("refundPayment: %v", err)
+			}
+			if paid := testInvoicePaid(t, invoiceID); paid != 10000 {
+				t.Errorf("réglé %d, attendu 10000", paid)
+			}
+		}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, c.run)
+	}
+}
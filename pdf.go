@@ -0,0 +1,545 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/png"
+	"strings"
+)
+
+// Générateur PDF minimal (PDF 1.4) sans dépendance externe : polices TrueType
+// embarquées en WinAnsiEncoding, images PNG et primitives de dessin simples.
+// Les coordonnées des pages sont exprimées en points depuis le coin haut gauche.
+
+const (
+	pdfPageWidth  = 595.28
+	pdfPageHeight = 841.89
+)
+
+type pdfFont struct {
+	name       string
+	baseFont   string
+	widths     [256]int
+	ascent     int
+	descent    int
+	capHeight  int
+	bbox       [4]int
+	italic     float64
+	data       []byte
+	descriptor int
+	objID      int
+}
+
+type pdfImage struct {
+	name   string
+	width  int
+	height int
+	objID  int
+}
+
+type pdfPage struct {
+	content bytes.Buffer
+}
+
+type pdfDocument struct {
+	objects [][]byte
+	pages   []*pdfPage
+	fonts   []*pdfFont
+	images  []*pdfImage
+	title   string
+}
+
+func newPDFDocument(title string) *pdfDocument {
+	return &pdfDocument{title: title}
+}
+
+func (d *pdfDocument) reserveObject() int {
+	d.objects = append(d.objects, nil)
+	return len(d.objects)
+}
+
+func (d *pdfDocument) setObject(id int, body string) {
+	d.objects[id-1] = []byte(body)
+}
+
+// addStream ajoute un flux compressé ; dict contient les entrées supplémentaires du dictionnaire
+func (d *pdfDocument) addStream(dict string, data []byte) (int, error) {
+	var compressed bytes.Buffer
+	writer := zlib.NewWriter(&compressed)
+	if _, err := writer.Write(data); err != nil {
+		return 0, err
+	}
+	if err := writer.Close(); err != nil {
+		return 0, err
+	}
+
+	id := d.reserveObject()
+	var body bytes.Buffer
+	fmt.Fprintf(&body, "<< %s /Filter /FlateDecode /Length %d >>\nstream\n", dict, compressed.Len())
+	body.Write(compressed.Bytes())
+	body.WriteString("\nendstream")
+	d.objects[id-1] = body.Bytes()
+	return id, nil
+}
+
+// addTrueTypeFont embarque une police TrueType complète
+func (d *pdfDocument) addTrueTypeFont(data []byte) (*pdfFont, error) {
+	font, err := parseTrueType(data)
+	if err != nil {
+		return nil, err
+	}
+	font.name = fmt.Sprintf("F%d", len(d.fonts)+1)
+	font.objID = d.reserveObject()
+	d.fonts = append(d.fonts, font)
+	return font, nil
+}
+
+// addImage embarque une image (PNG, ou toute image décodable) en RGB avec masque alpha
+func (d *pdfDocument) addImage(data []byte) (*pdfImage, error) {
+	decoded, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("erreur décodage image: %v", err)
+	}
+
+	bounds := decoded.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	rgb := make([]byte, 0, width*height*3)
+	alpha := make([]byte, 0, width*height)
+	opaque := true
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			pixel := color.NRGBAModel.Convert(decoded.At(x, y)).(color.NRGBA)
+			rgb = append(rgb, pixel.R, pixel.G, pixel.B)
+			alpha = append(alpha, pixel.A)
+			if pixel.A != 0xff {
+				opaque = false
+			}
+		}
+	}
+
+	dict := fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceRGB /BitsPerComponent 8", width, height)
+	if !opaque {
+		maskID, err := d.addStream(fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceGray /BitsPerComponent 8", width, height), alpha)
+		if err != nil {
+			return nil, err
+		}
+		dict += fmt.Sprintf(" /SMask %d 0 R", maskID)
+	}
+
+	id, err := d.addStream(dict, rgb)
+	if err != nil {
+		return nil, err
+	}
+
+	img := &pdfImage{name: fmt.Sprintf("Im%d", len(d.images)+1), width: width, height: height, objID: id}
+	d.images = append(d.images, img)
+	return img, nil
+}
+
+func (d *pdfDocument) addPage() *pdfPage {
+	page := &pdfPage{}
+	d.pages = append(d.pages, page)
+	return page
+}
+
+// textWidth mesure la largeur d'un texte en points
+func (f *pdfFont) textWidth(size float64, s string) float64 {
+	total := 0
+	for _, code := range encodeWinAnsi(s) {
+		total += f.widths[code]
+	}
+	return float64(total) * size / 1000
+}
+
+// wrapText découpe un texte en lignes ne dépassant pas maxWidth
+func (f *pdfFont) wrapText(size, maxWidth float64, s string) []string {
+	var lines []string
+	for _, paragraph := range strings.Split(s, "\n") {
+		words := strings.Fields(paragraph)
+		if len(words) == 0 {
+			lines = append(lines, "")
+			continue
+		}
+		current := words[0]
+		for _, word := range words[1:] {
+			candidate := current + " " + word
+			if f.textWidth(size, candidate) > maxWidth {
+				lines = append(lines, current)
+				current = word
+				continue
+			}
+			current = candidate
+		}
+		lines = append(lines, current)
+	}
+	return lines
+}
+
+func pdfNumber(value float64) string {
+	formatted := fmt.Sprintf("%.2f", value)
+	formatted = strings.TrimRight(strings.TrimRight(formatted, "0"), ".")
+	if formatted == "-0" || formatted == "" {
+		return "0"
+	}
+	return formatted
+}
+
+func (p *pdfPage) setFillColor(r, g, b float64) {
+	fmt.Fprintf(&p.content, "%s %s %s rg\n", pdfNumber(r), pdfNumber(g), pdfNumber(b))
+}
+
+func (p *pdfPage) setStrokeColor(r, g, b float64) {
+	fmt.Fprintf(&p.content, "%s %s %s RG\n", pdfNumber(r), pdfNumber(g), pdfNumber(b))
+}
+
+// text écrit un texte dont la ligne de base est à y points du haut de la page
+func (p *pdfPage) text(font *pdfFont, size, x, y float64, s string) {
+	fmt.Fprintf(&p.content, "BT /%s %s Tf %s %s Td (%s) Tj ET\n",
+		font.name, pdfNumber(size), pdfNumber(x), pdfNumber(pdfPageHeight-y), escapePDFString(encodeWinAnsi(s)))
+}
+
+// textRight écrit un texte aligné à droite sur x
+func (p *pdfPage) textRight(font *pdfFont, size, x, y float64, s string) {
+	p.text(font, size, x-font.textWidth(size, s), y, s)
+}
+
+// rect remplit un rectangle dont le coin haut gauche est (x, y)
+func (p *pdfPage) rect(x, y, width, height float64) {
+	fmt.Fprintf(&p.content, "%s %s %s %s re f\n",
+		pdfNumber(x), pdfNumber(pdfPageHeight-y-height), pdfNumber(width), pdfNumber(height))
+}
+
+// strokeRect trace le contour d'un rectangle dont le coin haut gauche est (x, y)
+func (p *pdfPage) strokeRect(x, y, width, height, lineWidth float64) {
+	fmt.Fprintf(&p.content, "%s w %s %s %s %s re S\n",
+		pdfNumber(lineWidth), pdfNumber(x), pdfNumber(pdfPageHeight-y-height), pdfNumber(width), pdfNumber(height))
+}
+
+func (p *pdfPage) line(x1, y1, x2, y2, lineWidth float64) {
+	fmt.Fprintf(&p.content, "%s w %s %s m %s %s l S\n",
+		pdfNumber(lineWidth), pdfNumber(x1), pdfNumber(pdfPageHeight-y1), pdfNumber(x2), pdfNumber(pdfPageHeight-y2))
+}
+
+// image dessine une image dont le coin haut gauche est (x, y)
+func (p *pdfPage) image(img *pdfImage, x, y, width, height float64) {
+	fmt.Fprintf(&p.content, "q %s 0 0 %s %s %s cm /%s Do Q\n",
+		pdfNumber(width), pdfNumber(height), pdfNumber(x), pdfNumber(pdfPageHeight-y-height), img.name)
+}
+
+// Bytes sérialise le document complet
+func (d *pdfDocument) Bytes() ([]byte, error) {
+	for _, font := range d.fonts {
+		fileID, err := d.addStream(fmt.Sprintf("/Length1 %d", len(font.data)), font.data)
+		if err != nil {
+			return nil, err
+		}
+		font.descriptor = d.reserveObject()
+		d.setObject(font.descriptor, fmt.Sprintf(
+			"<< /Type /FontDescriptor /FontName /%s /Flags 32 /FontBBox [%d %d %d %d] /ItalicAngle %s /Ascent %d /Descent %d /CapHeight %d /StemV 80 /FontFile2 %d 0 R >>",
+			font.baseFont, font.bbox[0], font.bbox[1], font.bbox[2], font.bbox[3], pdfNumber(font.italic), font.ascent, font.descent, font.capHeight, fileID))
+
+		var widths strings.Builder
+		for code := 32; code < 256; code++ {
+			if code > 32 {
+				widths.WriteByte(' ')
+			}
+			widths.WriteString(fmt.Sprint(font.widths[code]))
+		}
+		d.setObject(font.objID, fmt.Sprintf(
+			"<< /Type /Font /Subtype /TrueType /BaseFont /%s /FirstChar 32 /LastChar 255 /Widths [%s] /Encoding /WinAnsiEncoding /FontDescriptor %d 0 R >>",
+			font.baseFont, widths.String(), font.descriptor))
+	}
+
+	var resources strings.Builder
+	resources.WriteString("<< /Font <<")
+	for _, font := range d.fonts {
+		fmt.Fprintf(&resources, " /%s %d 0 R", font.name, font.objID)
+	}
+	resources.WriteString(" >> /XObject <<")
+	for _, img := range d.images {
+		fmt.Fprintf(&resources, " /%s %d 0 R", img.name, img.objID)
+	}
+	resources.WriteString(" >> >>")
+
+	pagesID := d.reserveObject()
+	pageIDs := make([]string, 0, len(d.pages))
+	for _, page := range d.pages {
+		contentID, err := d.addStream("", page.content.Bytes())
+		if err != nil {
+			return nil, err
+		}
+		pageID := d.reserveObject()
+		d.setObject(pageID, fmt.Sprintf("<< /Type /Page /Parent %d 0 R /MediaBox [0 0 %s %s] /Resources %s /Contents %d 0 R >>",
+			pagesID, pdfNumber(pdfPageWidth), pdfNumber(pdfPageHeight), resources.String(), contentID))
+		pageIDs = append(pageIDs, fmt.Sprintf("%d 0 R", pageID))
+	}
+	d.setObject(pagesID, fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(pageIDs, " "), len(pageIDs)))
+
+	catalogID := d.reserveObject()
+	d.setObject(catalogID, fmt.Sprintf("<< /Type /Catalog /Pages %d 0 R >>", pagesID))
+	infoID := d.reserveObject()
+	d.setObject(infoID, fmt.Sprintf("<< /Title (%s) /Producer (Modul-space) >>", escapePDFString(encodeWinAnsi(d.title))))
+
+	var out bytes.Buffer
+	out.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	offsets := make([]int, len(d.objects))
+	for i, body := range d.objects {
+		offsets[i] = out.Len()
+		fmt.Fprintf(&out, "%d 0 obj\n", i+1)
+		out.Write(body)
+		out.WriteString("\nendobj\n")
+	}
+
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(d.objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root %d 0 R /Info %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(d.objects)+1, catalogID, infoID, xref)
+	return out.Bytes(), nil
+}
+
+func escapePDFString(data []byte) string {
+	var builder strings.Builder
+	for _, b := range data {
+		switch b {
+		case '(', ')', '\\':
+			builder.WriteByte('\\')
+			builder.WriteByte(b)
+		case '\r', '\n':
+			builder.WriteByte(' ')
+		default:
+			builder.WriteByte(b)
+		}
+	}
+	return builder.String()
+}
+
+// winAnsiHigh donne les caractères Unicode des codes 0x80 à 0x9F de WinAnsiEncoding
+var winAnsiHigh = [32]rune{
+	'€', 0, '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', 0, 'Ž', 0,
+	0, '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', 0, 'ž', 'Ÿ',
+}
+
+func winAnsiRune(code int) rune {
+	if code >= 0x80 && code < 0xa0 {
+		return winAnsiHigh[code-0x80]
+	}
+	return rune(code)
+}
+
+// encodeWinAnsi convertit une chaîne UTF-8 en WinAnsiEncoding ; les caractères absents deviennent "?"
+func encodeWinAnsi(s string) []byte {
+	out := make([]byte, 0, len(s))
+	for _, r := range s {
+		switch {
+		case r == '\u00a0' || r == '\u202f':
+			out = append(out, ' ')
+		case r < 0x80 || (r >= 0xa0 && r <= 0xff):
+			out = append(out, byte(r))
+		default:
+			code := byte('?')
+			for i, candidate := range winAnsiHigh {
+				if candidate != 0 && candidate == r {
+					code = byte(0x80 + i)
+					break
+				}
+			}
+			out = append(out, code)
+		}
+	}
+	return out
+}
+
+// parseTrueType lit les métriques nécessaires à l'embarquement d'une police TrueType
+func parseTrueType(data []byte) (*pdfFont, error) {
+	if len(data) < 12 {
+		return nil, fmt.Errorf("police TrueType invalide")
+	}
+
+	tables := make(map[string][]byte)
+	numTables := int(binary.BigEndian.Uint16(data[4:6]))
+	for i := 0; i < numTables; i++ {
+		entry := 12 + 16*i
+		if entry+16 > len(data) {
+			return nil, fmt.Errorf("police TrueType tronquée")
+		}
+		tag := string(data[entry : entry+4])
+		offset := int(binary.BigEndian.Uint32(data[entry+8 : entry+12]))
+		length := int(binary.BigEndian.Uint32(data[entry+12 : entry+16]))
+		if offset+length > len(data) {
+			return nil, fmt.Errorf("table %s hors limites", tag)
+		}
+		tables[tag] = data[offset : offset+length]
+	}
+
+	for _, tag := range []string{"head", "hhea", "hmtx", "cmap"} {
+		if _, ok := tables[tag]; !ok {
+			return nil, fmt.Errorf("table TrueType %s manquante", tag)
+		}
+	}
+
+	head, hhea, hmtx := tables["head"], tables["hhea"], tables["hmtx"]
+	if len(head) < 54 || len(hhea) < 36 {
+		return nil, fmt.Errorf("tables head/hhea invalides")
+	}
+
+	unitsPerEm := int(binary.BigEndian.Uint16(head[18:20]))
+	if unitsPerEm == 0 {
+		return nil, fmt.Errorf("unitsPerEm nul")
+	}
+	scale := func(value int) int { return value * 1000 / unitsPerEm }
+	int16At := func(table []byte, offset int) int {
+		return int(int16(binary.BigEndian.Uint16(table[offset : offset+2])))
+	}
+
+	font := &pdfFont{data: data}
+	font.bbox = [4]int{scale(int16At(head, 36)), scale(int16At(head, 38)), scale(int16At(head, 40)), scale(int16At(head, 42))}
+	font.ascent = scale(int16At(hhea, 4))
+	font.descent = scale(int16At(hhea, 6))
+	font.capHeight = font.ascent
+	if os2, ok := tables["OS/2"]; ok && len(os2) >= 90 && binary.BigEndian.Uint16(os2[0:2]) >= 2 {
+		font.capHeight = scale(int16At(os2, 88))
+	}
+	if post, ok := tables["post"]; ok && len(post) >= 8 {
+		font.italic = float64(int32(binary.BigEndian.Uint32(post[4:8]))) / 65536
+	}
+	font.baseFont = trueTypePostScriptName(tables["name"])
+
+	numberOfHMetrics := int(binary.BigEndian.Uint16(hhea[34:36]))
+	advance := func(glyph int) int {
+		if numberOfHMetrics == 0 {
+			return 0
+		}
+		if glyph >= numberOfHMetrics {
+			glyph = numberOfHMetrics - 1
+		}
+		if 4*glyph+2 > len(hmtx) {
+			return 0
+		}
+		return int(binary.BigEndian.Uint16(hmtx[4*glyph : 4*glyph+2]))
+	}
+
+	lookup, err := trueTypeCmap(tables["cmap"])
+	if err != nil {
+		return nil, err
+	}
+	for code := 32; code < 256; code++ {
+		r := winAnsiRune(code)
+		if r == 0 {
+			continue
+		}
+		font.widths[code] = scale(advance(lookup(r)))
+	}
+	return font, nil
+}
+
+// trueTypeCmap renvoie la fonction caractère -> glyphe de la sous-table Unicode BMP (format 4)
+func trueTypeCmap(cmap []byte) (func(rune) int, error) {
+	if len(cmap) < 4 {
+		return nil, fmt.Errorf("table cmap invalide")
+	}
+	var subtable []byte
+	numSubtables := int(binary.BigEndian.Uint16(cmap[2:4]))
+	for i := 0; i < numSubtables; i++ {
+		entry := 4 + 8*i
+		if entry+8 > len(cmap) {
+			break
+		}
+		platform := binary.BigEndian.Uint16(cmap[entry : entry+2])
+		encoding := binary.BigEndian.Uint16(cmap[entry+2 : entry+4])
+		offset := int(binary.BigEndian.Uint32(cmap[entry+4 : entry+8]))
+		if offset+4 > len(cmap) || binary.BigEndian.Uint16(cmap[offset:offset+2]) != 4 {
+			continue
+		}
+		if (platform == 3 && encoding == 1) || platform == 0 || subtable == nil {
+			subtable = cmap[offset:]
+		}
+	}
+	if subtable == nil || len(subtable) < 14 {
+		return nil, fmt.Errorf("aucune sous-table cmap format 4")
+	}
+
+	segCount := int(binary.BigEndian.Uint16(subtable[6:8])) / 2
+	endCodes := 14
+	startCodes := endCodes + 2*segCount + 2
+	idDeltas := startCodes + 2*segCount
+	idRangeOffsets := idDeltas + 2*segCount
+	if idRangeOffsets+2*segCount > len(subtable) {
+		return nil, fmt.Errorf("sous-table cmap tronquée")
+	}
+	u16 := func(offset int) int {
+		if offset+2 > len(subtable) {
+			return 0
+		}
+		return int(binary.BigEndian.Uint16(subtable[offset : offset+2]))
+	}
+
+	return func(r rune) int {
+		code := int(r)
+		for seg := 0; seg < segCount; seg++ {
+			if code > u16(endCodes+2*seg) {
+				continue
+			}
+			if code < u16(startCodes+2*seg) {
+				return 0
+			}
+			rangeOffset := u16(idRangeOffsets + 2*seg)
+			if rangeOffset == 0 {
+				return (code + u16(idDeltas+2*seg)) & 0xffff
+			}
+			glyph := u16(idRangeOffsets + 2*seg + rangeOffset + 2*(code-u16(startCodes+2*seg)))
+			if glyph == 0 {
+				return 0
+			}
+			return (glyph + u16(idDeltas+2*seg)) & 0xffff
+		}
+		return 0
+	}, nil
+}
+
+// trueTypePostScriptName extrait le nom PostScript (nameID 6) de la table name
+func trueTypePostScriptName(name []byte) string {
+	fallback := "ModulspaceFont"
+	if len(name) < 6 {
+		return fallback
+	}
+	count := int(binary.BigEndian.Uint16(name[2:4]))
+	storage := int(binary.BigEndian.Uint16(name[4:6]))
+	for i := 0; i < count; i++ {
+		entry := 6 + 12*i
+		if entry+12 > len(name) {
+			break
+		}
+		platform := binary.BigEndian.Uint16(name[entry : entry+2])
+		nameID := binary.BigEndian.Uint16(name[entry+6 : entry+8])
+		length := int(binary.BigEndian.Uint16(name[entry+8 : entry+10]))
+		offset := storage + int(binary.BigEndian.Uint16(name[entry+10:entry+12]))
+		if nameID != 6 || offset+length > len(name) {
+			continue
+		}
+		raw := name[offset : offset+length]
+		var value strings.Builder
+		if platform == 1 {
+			value.Write(raw)
+		} else {
+			for j := 0; j+1 < len(raw); j += 2 {
+				value.WriteRune(rune(binary.BigEndian.Uint16(raw[j : j+2])))
+			}
+		}
+		cleaned := strings.Map(func(r rune) rune {
+			if r <= ' ' || r > '~' || strings.ContainsRune("()<>[]{}/%#", r) {
+				return -1
+			}
+			return r
+		}, value.String())
+		if cleaned != "" {
+			return cleaned
+		}
+	}
+	return fallback
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"strings"
+	"time"
+)
+
+// Product décrit un meuble du catalogue (pages templates/produit-*.html)
+type Product struct {
+	Slug             string
+	Name             string
+	Page             string
+	Configurations   []string
+	LeadTimeMinWeeks int
+	LeadTimeMaxWeeks int
+}
+
+// defaultLeadTime est le délai de fabrication affiché sur les fiches produit ("Livraison 4-6 semaines")
+var defaultLeadTime = [2]int{4, 6}
+
+// products reprend le catalogue publié ; Name correspond à l'attribut data-product du bouton devis
+var products = []Product{
+	{Slug: "basculette", Name: "Basculette", Page: "/produit-basculette.html", Configurations: []string{"Cheval à bascule", "Table", "Assise"}, LeadTimeMinWeeks: 4, LeadTimeMaxWeeks: 6},
+	{Slug: "novadesk", Name: "Novadesk", Page: "/produit-novadesk.html", Configurations: []string{"Configuration 1", "Configuration 2", "Configuration 3", "Configuration 4"}, LeadTimeMinWeeks: 4, LeadTimeMaxWeeks: 6},
+	{Slug: "secretaire", Name: "Secretaire", Page: "/produit-secretaire.html", Configurations: []string{"Configuration 1", "Configuration 2", "Configuration 3"}},
+	{Slug: "mic", Name: "MIC", Page: "/produit-tablebasse.html", Configurations: []string{"Configuration 1", "Configuration 2"}, LeadTimeMinWeeks: 4, LeadTimeMaxWeeks: 6},
+	{Slug: "cloison-modulaire", Name: "Cloison Modulaire", Page: "/produit-cloison-modulaire.html"},
+	{Slug: "lit-plateforme", Name: "Lit Plateforme", Page: "/produit-lit-plateforme.html"},
+	{Slug: "rangement-intelligent", Name: "Rangement Intelligent", Page: "/produit-rangement-intelligent.html"},
+	{Slug: "table-extensible", Name: "Table Extensible", Page: "/produit-table-extensible.html"},
+}
+
+// findProductByName retrouve un produit à partir du nom enregistré sur le devis
+func findProductByName(name string) *Product {
+	for i := range products {
+		if strings.EqualFold(products[i].Name, strings.TrimSpace(name)) {
+			return &products[i]
+		}
+	}
+	return nil
+}
+
+// isValidProductConfiguration vérifie qu'une configuration fait partie de celles proposées pour le produit
+func isValidProductConfiguration(productName, configuration string) bool {
+	product := findProductByName(productName)
+	if product == nil {
+		return false
+	}
+	for _, candidate := range product.Configurations {
+		if candidate == configuration {
+			return true
+		}
+	}
+	return false
+}
+
+// leadTimeWeeks renvoie le délai de fabrication (min, max) en semaines d'un produit
+func leadTimeWeeks(productName string) (int, int) {
+	if product := findProductByName(productName); product != nil && product.LeadTimeMaxWeeks > 0 {
+		return product.LeadTimeMinWeeks, product.LeadTimeMaxWeeks
+	}
+	return defaultLeadTime[0], defaultLeadTime[1]
+}
+
+// expectedOrderDates calcule les dates prévisionnelles de fin de fabrication et de livraison
+func expectedOrderDates(productName string, orderedAt time.Time) (time.Time, time.Time) {
+	minWeeks, maxWeeks := leadTimeWeeks(productName)
+	return orderedAt.AddDate(0, 0, 7*minWeeks), orderedAt.AddDate(0, 0, 7*maxWeeks)
+}
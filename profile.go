@@ -0,0 +1,36 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+)
+
+func profilHandler(w http.ResponseWriter, r *http.Request) {
+	user := GetUserFromSession(r)
+	if user == nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		renderPage(w, "profil.html", PageData{
+			Title:    "Mon profil",
+			Username: user.Prenom,
+			ExtraData: map[string]interface{}{
+				"Saved": r.URL.Query().Get("saved") == "1",
+				"User":  user,
+			},
+		})
+	case http.MethodPost:
+		enabled := r.FormValue("email_notifications") == "1"
+		if err := setUserEmailNotifications(user.ID, enabled); err != nil {
+			slog.ErrorContext(r.Context(), "Erreur préférences email du client", "user_id", user.ID, "err", err)
+			http.Error(w, "Erreur enregistrement des préférences", http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, "/profil?saved=1", http.StatusSeeOther)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
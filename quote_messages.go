@@ -0,0 +1,561 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/http"
+	"net/mail"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Auteurs d'un message de devis
+const (
+	QuoteMessageAuthorCustomer = "customer"
+	QuoteMessageAuthorStaff    = "staff"
+)
+
+// Origine d'un message : saisi sur le site ou réponse à un email
+const (
+	QuoteMessageSourceWeb   = "web"
+	QuoteMessageSourceEmail = "email"
+)
+
+// Taille maximale d'un email entrant et d'un message
+const (
+	inboundEmailMaxBytes  = 10 << 20
+	quoteMessageMaxLength = 5000
+)
+
+var (
+	errInboundAddress   = errors.New("aucune adresse de réponse valide parmi les destinataires")
+	errInboundSender    = errors.New("expéditeur non autorisé pour ce fil")
+	errInboundEmpty     = errors.New("message vide après suppression de la citation")
+	errInboundMalformed = errors.New("email illisible")
+)
+
+// QuoteMessage est un message du fil de discussion d'un devis
+type QuoteMessage struct {
+	ID         int
+	QuoteID    int
+	Author     string
+	AuthorName string
+	Body       string
+	Source     string
+	CreatedAt  time.Time
+	// Attachments est renseigné à l'affichage par splitAttachments
+	Attachments []Attachment
+}
+
+// FromStaff indique un message de l'atelier
+func (m QuoteMessage) FromStaff() bool {
+	return m.Author == QuoteMessageAuthorStaff
+}
+
+// listQuoteMessages renvoie le fil d'un devis, du plus ancien au plus récent
+func listQuoteMessages(quoteID int) ([]QuoteMessage, error) {
+	if db == nil {
+		return nil, fmt.Errorf("base de données non configurée")
+	}
+
+	rows, err := db.Query(
+		rebindQuery("SELECT id, quote_id, author, author_name, body, source, created_at FROM quote_messages WHERE quote_id = ? ORDER BY created_at, id"),
+		quoteID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := make([]QuoteMessage, 0)
+	for rows.Next() {
+		var message QuoteMessage
+		var authorName sql.NullString
+		var createdAt sql.NullTime
+		if err := rows.Scan(&message.ID, &message.QuoteID, &message.Author, &authorName, &message.Body, &message.Source, &createdAt); err != nil {
+			return nil, err
+		}
+		message.AuthorName = authorName.String
+		if createdAt.Valid {
+			message.CreatedAt = createdAt.Time
+		}
+		messages = append(messages, message)
+	}
+	return messages, rows.Err()
+}
+
+// quoteMessageAuthorName est le nom affiché dans le fil : le client du devis, ou l'atelier
+func quoteMessageAuthorName(quote *QuoteRecord, author string) string {
+	if author == QuoteMessageAuthorStaff {
+		return loadCompanyInfo().Name
+	}
+	if name := strings.TrimSpace(quote.Prenom + " " + quote.Nom); name != "" {
+		return name
+	}
+	return quote.Email
+}
+
+// QuoteMessageEmail alimente le template quote_message
+type QuoteMessageEmail struct {
+	QuoteID    int
+	Reference  string
+	Produit    string
+	AuthorName string
+	Body       string
+	// ToStaff indique une notification destinée à l'atelier (lien vers l'admin)
+	ToStaff bool
+	// CanReply indique qu'une réponse à l'email est ajoutée au fil
+	CanReply bool
+	// Attachments liste les noms des fichiers joints, consultables sur le site
+	Attachments []string
+}
+
+// addQuoteMessage ajoute un message au fil, avec ses pièces jointes déjà stockées, et met en file
+// dans la même transaction l'email qui prévient l'autre partie : le client pour un message de
+// l'atelier, l'atelier sinon. Un message peut se limiter à des pièces jointes
+func addQuoteMessage(ctx context.Context, quote *QuoteRecord, author, authorName, body, source string, attachments []storedAttachment) error {
+	if db == nil {
+		return fmt.Errorf("base de données non configurée")
+	}
+	body = strings.TrimSpace(body)
+	if body == "" && len(attachments) == 0 {
+		return fmt.Errorf("message vide")
+	}
+	if len(body) > quoteMessageMaxLength {
+		return fmt.Errorf("message trop long (%d caractères maximum)", quoteMessageMaxLength)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var messageID int
+	if dbDriver == "postgres" {
+		err = tx.QueryRow(
+			"INSERT INTO quote_messages (quote_id, author, author_name, body, source, created_at) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id",
+			quote.ID, author, authorName, body, source, time.Now(),
+		).Scan(&messageID)
+	} else {
+		var result sql.Result
+		result, err = tx.Exec(
+			"INSERT INTO quote_messages (quote_id, author, author_name, body, source, created_at) VALUES (?, ?, ?, ?, ?, ?)",
+			quote.ID, author, authorName, body, source, time.Now(),
+		)
+		if err == nil {
+			var lastID int64
+			lastID, err = result.LastInsertId()
+			messageID = int(lastID)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if err := insertAttachments(tx, quote.ID, messageID, author, attachments); err != nil {
+		return err
+	}
+	if author == QuoteMessageAuthorStaff {
+		if err := markQuoteFirstResponse(tx, quote.ID, time.Now()); err != nil {
+			return err
+		}
+	}
+
+	details := QuoteMessageEmail{
+		QuoteID:    quote.ID,
+		Reference:  quoteNumber(quote),
+		Produit:    quote.Produit,
+		AuthorName: authorName,
+		Body:       body,
+		ToStaff:    author == QuoteMessageAuthorCustomer,
+	}
+	for _, attachment := range attachments {
+		details.Attachments = append(details.Attachments, attachment.Filename)
+	}
+	recipients := quoteNotifyRecipients()
+	replyAuthor := QuoteMessageAuthorStaff
+	if author == QuoteMessageAuthorStaff {
+		customerEmail, err := quoteCustomerEmail(tx, quote)
+		if err != nil {
+			return err
+		}
+		recipients = []string{customerEmail}
+		replyAuthor = QuoteMessageAuthorCustomer
+	}
+	replyTo := quoteReplyAddress(quote.ID, replyAuthor)
+	details.CanReply = replyTo != ""
+
+	notified := false
+	if len(recipients) > 0 && recipients[0] != "" {
+		email, err := renderEmailReplyTo("quote_message", recipients, replyTo, details)
+		if err != nil {
+			return err
+		}
+		if err := enqueueEmail(ctx, tx, email); err != nil {
+			return err
+		}
+		notified = true
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	if notified {
+		notifyOutbox()
+	}
+	return nil
+}
+
+// markQuoteFirstResponse date la première réponse de l'atelier à un devis (message ou devis envoyé) ;
+// une date déjà enregistrée est conservée
+func markQuoteFirstResponse(tx *sql.Tx, quoteID int, at time.Time) error {
+	_, err := tx.Exec(
+		rebindQuery("UPDATE quotes SET first_response_at = ? WHERE id = ? AND first_response_at IS NULL"),
+		at, quoteID,
+	)
+	return err
+}
+
+// backfillQuoteFirstResponses renseigne first_response_at pour les devis antérieurs à la colonne,
+// à partir du premier message de l'atelier ou de la date d'envoi du devis
+func backfillQuoteFirstResponses() error {
+	_, err := db.Exec(
+		rebindQuery("UPDATE quotes SET first_response_at = (SELECT MIN(m.created_at) FROM quote_messages m WHERE m.quote_id = quotes.id AND m.author = ?) WHERE first_response_at IS NULL"),
+		QuoteMessageAuthorStaff,
+	)
+	if err != nil {
+		return fmt.Errorf("erreur reprise des premières réponses: %v", err)
+	}
+	if _, err := db.Exec("UPDATE quotes SET first_response_at = quoted_at WHERE quoted_at IS NOT NULL AND (first_response_at IS NULL OR first_response_at > quoted_at)"); err != nil {
+		return fmt.Errorf("erreur reprise des premières réponses: %v", err)
+	}
+	return nil
+}
+
+var (
+	inboundSecretOnce sync.Once
+	inboundSecret     []byte
+)
+
+// inboundEmailSecret signe les adresses de réponse et protège /api/inbound-email (INBOUND_EMAIL_SECRET).
+// Sans secret configuré, une clé aléatoire est tirée au démarrage : les réponses aux emails envoyés
+// avant un redémarrage sont alors refusées
+func inboundEmailSecret() []byte {
+	inboundSecretOnce.Do(func() {
+		if secret := getEnv("INBOUND_EMAIL_SECRET", ""); secret != "" {
+			inboundSecret = []byte(secret)
+			return
+		}
+		inboundSecret = []byte(randomHex(32))
+	})
+	return inboundSecret
+}
+
+// quoteReplyToken signe le couple devis / auteur de la réponse attendue
+func quoteReplyToken(quoteID int, author string) string {
+	mac := hmac.New(sha256.New, inboundEmailSecret())
+	fmt.Fprintf(mac, "quote:%d:%s", quoteID, author)
+	return hex.EncodeToString(mac.Sum(nil))[:20]
+}
+
+// quoteReplyAddress renvoie l'adresse de réponse d'un fil : INBOUND_EMAIL_ADDRESS complétée de
+// "+q<id>.<c|s>.<signature>", ou "" si la réponse par email n'est pas configurée
+func quoteReplyAddress(quoteID int, author string) string {
+	address := getEnv("INBOUND_EMAIL_ADDRESS", "")
+	local, domain, ok := strings.Cut(address, "@")
+	if !ok || local == "" || domain == "" {
+		return ""
+	}
+	role := "c"
+	if author == QuoteMessageAuthorStaff {
+		role = "s"
+	}
+	return fmt.Sprintf("%s+q%d.%s.%s@%s", local, quoteID, role, quoteReplyToken(quoteID, author), domain)
+}
+
+// parseQuoteReplyAddress vérifie une adresse de réponse et renvoie le devis et l'auteur qu'elle désigne
+func parseQuoteReplyAddress(address string) (int, string, bool) {
+	local, _, ok := strings.Cut(strings.ToLower(address), "@")
+	if !ok {
+		return 0, "", false
+	}
+	_, tag, ok := strings.Cut(local, "+")
+	if !ok {
+		return 0, "", false
+	}
+	parts := strings.Split(tag, ".")
+	if len(parts) != 3 || !strings.HasPrefix(parts[0], "q") {
+		return 0, "", false
+	}
+	quoteID, err := strconv.Atoi(parts[0][1:])
+	if err != nil || quoteID <= 0 {
+		return 0, "", false
+	}
+	author := ""
+	switch parts[1] {
+	case "c":
+		author = QuoteMessageAuthorCustomer
+	case "s":
+		author = QuoteMessageAuthorStaff
+	default:
+		return 0, "", false
+	}
+	if !hmac.Equal([]byte(parts[2]), []byte(quoteReplyToken(quoteID, author))) {
+		return 0, "", false
+	}
+	return quoteID, author, true
+}
+
+// Lignes qui introduisent le message cité dans une réponse
+var quotedReplyMarkers = []*regexp.Regexp{
+	regexp.MustCompile(`^(Le|On)\s.*(a écrit|wrote)\s*:$`),
+	regexp.MustCompile(`^-+\s*(Original Message|Message d'origine|Message original)\s*-+$`),
+	regexp.MustCompile(`^(De|From)\s*:\s.+@`),
+}
+
+// stripQuotedReply ne garde que la nouvelle partie d'une réponse : la citation (lignes "> …"
+// et ce qui suit "Le … a écrit :") et la signature ("-- ") sont retirées
+func stripQuotedReply(text string) string {
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	kept := make([]string, 0, len(lines))
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, ">") || line == "-- " || trimmed == "--" {
+			break
+		}
+		marker := false
+		for _, pattern := range quotedReplyMarkers {
+			if pattern.MatchString(trimmed) {
+				marker = true
+				break
+			}
+		}
+		if marker {
+			break
+		}
+		kept = append(kept, line)
+	}
+
+	// L'attribution "Le …, X <adresse> a écrit :" est souvent repliée sur plusieurs lignes avant la citation
+	start := len(kept)
+	for start > 0 && strings.TrimSpace(kept[start-1]) != "" {
+		start--
+	}
+	if paragraph := strings.Join(strings.Fields(strings.Join(kept[start:], " ")), " "); paragraph != "" && quotedReplyMarkers[0].MatchString(paragraph) {
+		kept = kept[:start]
+	}
+	return strings.TrimSpace(strings.Join(kept, "\n"))
+}
+
+// decodeTransferEncoding décode un corps en base64 ou quoted-printable
+func decodeTransferEncoding(body io.Reader, encoding string) ([]byte, error) {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		return io.ReadAll(base64.NewDecoder(base64.StdEncoding, &newlineStripper{r: body}))
+	case "quoted-printable":
+		return io.ReadAll(quotedprintable.NewReader(body))
+	}
+	return io.ReadAll(body)
+}
+
+// newlineStripper retire les fins de ligne d'un flux base64
+type newlineStripper struct {
+	r io.Reader
+}
+
+func (s *newlineStripper) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	kept := 0
+	for _, b := range p[:n] {
+		if b != '\r' && b != '\n' {
+			p[kept] = b
+			kept++
+		}
+	}
+	return kept, err
+}
+
+var htmlTagPattern = regexp.MustCompile(`(?s)<[^>]*>`)
+
+// inboundText extrait le texte d'un email : la partie text/plain, sinon le HTML débarrassé de ses balises
+func inboundText(contentType, encoding string, body io.Reader) (string, error) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = "text/plain"
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		reader := multipart.NewReader(body, params["boundary"])
+		fallback := ""
+		for {
+			part, err := reader.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return "", err
+			}
+			if part.FileName() != "" {
+				continue
+			}
+			// NextPart décode déjà le quoted-printable
+			text, err := inboundText(part.Header.Get("Content-Type"), part.Header.Get("Content-Transfer-Encoding"), part)
+			if err != nil {
+				return "", err
+			}
+			partType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+			if partType == "text/plain" || strings.HasPrefix(partType, "multipart/") && text != "" {
+				return text, nil
+			}
+			if fallback == "" {
+				fallback = text
+			}
+		}
+		return fallback, nil
+	}
+
+	data, err := decodeTransferEncoding(body, encoding)
+	if err != nil {
+		return "", err
+	}
+	switch mediaType {
+	case "text/plain", "":
+		return string(data), nil
+	case "text/html":
+		text := strings.NewReplacer("<br>", "\n", "<br/>", "\n", "<br />", "\n", "</p>", "\n", "</div>", "\n").Replace(string(data))
+		return html.UnescapeString(htmlTagPattern.ReplaceAllString(text, "")), nil
+	}
+	return "", nil
+}
+
+// isInboundRejection distingue un email refusé définitivement d'une erreur temporaire (base de données…)
+func isInboundRejection(err error) bool {
+	return errors.Is(err, errInboundAddress) || errors.Is(err, errInboundSender) || errors.Is(err, errInboundEmpty) || errors.Is(err, errInboundMalformed)
+}
+
+// processInboundEmail ajoute au fil du devis la réponse reçue par email. Le devis et l'auteur sont
+// lus dans l'adresse de réponse signée (destinataires de l'enveloppe, sinon To/Cc/Delivered-To)
+func processInboundEmail(ctx context.Context, raw []byte, envelopeRecipients []string) error {
+	message, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return fmt.Errorf("%w: %v", errInboundMalformed, err)
+	}
+
+	candidates := append([]string{}, envelopeRecipients...)
+	for _, header := range []string{"To", "Cc", "Delivered-To"} {
+		if addresses, err := message.Header.AddressList(header); err == nil {
+			for _, address := range addresses {
+				candidates = append(candidates, address.Address)
+			}
+		}
+	}
+	quoteID, author := 0, ""
+	for _, candidate := range candidates {
+		if id, role, ok := parseQuoteReplyAddress(strings.Trim(candidate, "<> ")); ok {
+			quoteID, author = id, role
+			break
+		}
+	}
+	if quoteID == 0 {
+		return errInboundAddress
+	}
+
+	from, err := mail.ParseAddress(message.Header.Get("From"))
+	if err != nil {
+		return fmt.Errorf("%w: expéditeur %v", errInboundMalformed, err)
+	}
+
+	quote, err := GetQuoteByID(quoteID)
+	if err != nil {
+		return err
+	}
+	if quote == nil {
+		return fmt.Errorf("%w: devis %d introuvable", errInboundAddress, quoteID)
+	}
+
+	// L'adresse signée désigne le fil ; l'expéditeur doit en plus être le client ou un destinataire interne
+	customerEmail, err := quoteCustomerEmail(db, quote)
+	if err != nil {
+		return err
+	}
+	allowed := author == QuoteMessageAuthorCustomer && customerEmail != "" && strings.EqualFold(from.Address, customerEmail)
+	if author == QuoteMessageAuthorStaff {
+		for _, recipient := range quoteNotifyRecipients() {
+			if strings.EqualFold(from.Address, recipient) {
+				allowed = true
+			}
+		}
+	}
+	if !allowed {
+		return errInboundSender
+	}
+
+	text, err := inboundText(message.Header.Get("Content-Type"), message.Header.Get("Content-Transfer-Encoding"), message.Body)
+	if err != nil {
+		return fmt.Errorf("%w: corps %v", errInboundMalformed, err)
+	}
+	body := stripQuotedReply(text)
+	if body == "" {
+		return errInboundEmpty
+	}
+
+	authorName := quoteMessageAuthorName(quote, author)
+	return addQuoteMessage(ctx, quote, author, authorName, body, QuoteMessageSourceEmail, nil)
+}
+
+// inboundEmailHandler reçoit un email brut (RFC 5322) d'un relais entrant, authentifié par
+// l'en-tête "Authorization: Bearer <INBOUND_EMAIL_SECRET>"
+func inboundEmailHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	secret := getEnv("INBOUND_EMAIL_SECRET", "")
+	if secret == "" {
+		http.Error(w, "Réception d'emails désactivée", http.StatusServiceUnavailable)
+		return
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !hmac.Equal([]byte(token), []byte(secret)) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	raw, err := io.ReadAll(http.MaxBytesReader(w, r.Body, inboundEmailMaxBytes))
+	if err != nil {
+		http.Error(w, "Email trop volumineux", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	var recipients []string
+	if recipient := r.URL.Query().Get("recipient"); recipient != "" {
+		recipients = append(recipients, recipient)
+	}
+	if err := processInboundEmail(r.Context(), raw, recipients); err != nil {
+		slog.WarnContext(r.Context(), "Email entrant refusé", "err", err)
+		if isInboundRejection(err) {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		http.Error(w, "Erreur traitement de l'email", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
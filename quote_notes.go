@@ -0,0 +1,91 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// quoteNoteMaxLength borne une note interne
+const quoteNoteMaxLength = 5000
+
+// QuoteNote est une note interne à l'équipe, jamais visible du client
+type QuoteNote struct {
+	ID        int
+	QuoteID   int
+	Author    string
+	Body      string
+	CreatedAt time.Time
+}
+
+// adminStaff renvoie les membres de l'équipe (ADMIN_STAFF, noms séparés par des virgules) à qui
+// confier un devis ; à défaut, le seul compte admin ADMIN_USERNAME
+func adminStaff() []string {
+	staff := make([]string, 0)
+	for _, name := range strings.Split(os.Getenv("ADMIN_STAFF"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			staff = append(staff, name)
+		}
+	}
+	if len(staff) == 0 {
+		if username := os.Getenv("ADMIN_USERNAME"); username != "" {
+			staff = append(staff, username)
+		}
+	}
+	return staff
+}
+
+// isAdminStaff indique si name fait partie de l'équipe
+func isAdminStaff(name string) bool {
+	for _, staff := range adminStaff() {
+		if staff == name {
+			return true
+		}
+	}
+	return false
+}
+
+// listQuoteNotes renvoie les notes internes d'un devis, de la plus récente à la plus ancienne
+func listQuoteNotes(quoteID int) ([]QuoteNote, error) {
+	if db == nil {
+		return nil, fmt.Errorf("base de données non configurée")
+	}
+
+	rows, err := db.Query(
+		rebindQuery("SELECT id, quote_id, author, body, created_at FROM quote_notes WHERE quote_id = ? ORDER BY created_at DESC, id DESC"),
+		quoteID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	notes := make([]QuoteNote, 0)
+	for rows.Next() {
+		var note QuoteNote
+		var createdAt sql.NullTime
+		if err := rows.Scan(&note.ID, &note.QuoteID, &note.Author, &note.Body, &createdAt); err != nil {
+			return nil, err
+		}
+		if createdAt.Valid {
+			note.CreatedAt = createdAt.Time
+		}
+		notes = append(notes, note)
+	}
+	return notes, rows.Err()
+}
+
+// addQuoteNote ajoute une note interne à un devis
+func addQuoteNote(quoteID int, author, body string) error {
+	if db == nil {
+		return fmt.Errorf("base de données non configurée")
+	}
+
+	_, err := db.Exec(
+		rebindQuery("INSERT INTO quote_notes (quote_id, author, body, created_at) VALUES (?, ?, ?, ?)"),
+		quoteID, author, body, time.Now(),
+	)
+	return err
+}
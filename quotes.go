@@ -0,0 +1,592 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Statuts possibles d'une demande de devis
+const (
+	QuoteStatusPending  = "pending"
+	QuoteStatusInReview = "in_review"
+	QuoteStatusSent     = "sent"
+	QuoteStatusAccepted = "accepted"
+	QuoteStatusRejected = "rejected"
+)
+
+// quoteStatuses liste les statuts dans l'ordre du cycle de vie d'un devis
+var quoteStatuses = []string{
+	QuoteStatusPending,
+	QuoteStatusInReview,
+	QuoteStatusSent,
+	QuoteStatusAccepted,
+	QuoteStatusRejected,
+}
+
+var quoteStatusLabels = map[string]string{
+	QuoteStatusPending:  "En attente",
+	QuoteStatusInReview: "En révision",
+	QuoteStatusSent:     "Devis envoyé",
+	QuoteStatusAccepted: "Accepté",
+	QuoteStatusRejected: "Refusé",
+}
+
+// defaultVATRateBP est le taux de TVA normal (20 %) en points de base
+const defaultVATRateBP = 2000
+
+// quoteValidityDays est la durée de validité d'un devis chiffré
+const quoteValidityDays = 30
+
+func quoteStatusLabel(status string) string {
+	if label, ok := quoteStatusLabels[status]; ok {
+		return label
+	}
+	return status
+}
+
+func isValidQuoteStatus(status string) bool {
+	_, ok := quoteStatusLabels[status]
+	return ok
+}
+
+// QuoteRecord représente un devis tel qu'enregistré en base
+type QuoteRecord struct {
+	ID        int
+	UserID    int
+	Reference string
+	Nom       string
+	Prenom    string
+	Email     string
+	Telephone string
+	Produit   string
+	// Configuration choisie sur la fiche produit, vide si le produit n'en propose pas
+	Configuration string
+	Message       string
+	Status        string
+	// RejectionReason est le motif communiqué au client quand le devis est refusé
+	RejectionReason string
+	// AssignedTo est le membre de l'équipe (ADMIN_STAFF) en charge du devis, vide si personne
+	AssignedTo string
+	QuotedAt   *time.Time
+	CreatedAt  time.Time
+}
+
+// ValidUntil renvoie la date de fin de validité du devis
+func (q *QuoteRecord) ValidUntil() time.Time {
+	start := time.Now()
+	if q.QuotedAt != nil {
+		start = *q.QuotedAt
+	}
+	return start.AddDate(0, 0, quoteValidityDays)
+}
+
+// QuoteLine est une ligne chiffrée d'un devis (montants en centimes)
+type QuoteLine struct {
+	ID             int
+	QuoteID        int
+	Label          string
+	Quantity       int
+	UnitPriceCents int64
+	VATRateBP      int
+}
+
+// TotalCents renvoie le montant HT de la ligne
+func (l QuoteLine) TotalCents() int64 {
+	return l.UnitPriceCents * int64(l.Quantity)
+}
+
+// VATAmount est le montant de TVA pour un taux donné
+type VATAmount struct {
+	RateBP    int
+	BaseCents int64
+	VATCents  int64
+}
+
+// QuoteTotals regroupe les totaux HT, TVA et TTC d'un devis
+type QuoteTotals struct {
+	TotalHTCents  int64
+	VAT           []VATAmount
+	TotalVATCents int64
+	TotalTTCCents int64
+}
+
+// computeQuoteTotals calcule les totaux avec une ventilation de la TVA par taux
+func computeQuoteTotals(lines []QuoteLine) QuoteTotals {
+	var totals QuoteTotals
+	bases := make(map[int]int64)
+	for _, line := range lines {
+		totals.TotalHTCents += line.TotalCents()
+		bases[line.VATRateBP] += line.TotalCents()
+	}
+
+	rates := make([]int, 0, len(bases))
+	for rate := range bases {
+		rates = append(rates, rate)
+	}
+	sort.Ints(rates)
+
+	for _, rate := range rates {
+		vat := int64(math.Round(float64(bases[rate]) * float64(rate) / 10000))
+		totals.VAT = append(totals.VAT, VATAmount{RateBP: rate, BaseCents: bases[rate], VATCents: vat})
+		totals.TotalVATCents += vat
+	}
+	totals.TotalTTCCents = totals.TotalHTCents + totals.TotalVATCents
+	return totals
+}
+
+// formatEuros formate un montant en centimes à la française : 1 234,50 €
+func formatEuros(cents int64) string {
+	sign := ""
+	if cents < 0 {
+		sign = "-"
+		cents = -cents
+	}
+	units := strconv.FormatInt(cents/100, 10)
+	var grouped strings.Builder
+	for i, digit := range units {
+		if i > 0 && (len(units)-i)%3 == 0 {
+			grouped.WriteByte(' ')
+		}
+		grouped.WriteRune(digit)
+	}
+	return fmt.Sprintf("%s%s,%02d €", sign, grouped.String(), cents%100)
+}
+
+// formatVATRate formate un taux en points de base : 2000 -> "20 %", 550 -> "5,5 %"
+func formatVATRate(rateBP int) string {
+	value := strconv.FormatFloat(float64(rateBP)/100, 'f', -1, 64)
+	return strings.Replace(value, ".", ",", 1) + " %"
+}
+
+// parseEuros convertit une saisie du type "1 234,50" en centimes
+func parseEuros(value string) (int64, error) {
+	cleaned := strings.NewReplacer(" ", "", "\u00a0", "", "€", "").Replace(strings.TrimSpace(value))
+	cleaned = strings.Replace(cleaned, ",", ".", 1)
+	amount, err := strconv.ParseFloat(cleaned, 64)
+	if err != nil || math.IsNaN(amount) || math.IsInf(amount, 0) {
+		return 0, fmt.Errorf("montant invalide: %q", value)
+	}
+	return int64(math.Round(amount * 100)), nil
+}
+
+// parseVATRate convertit un taux saisi en pourcentage ("20", "5,5") en points de base
+func parseVATRate(value string) (int, error) {
+	cleaned := strings.Replace(strings.TrimSuffix(strings.TrimSpace(value), "%"), ",", ".", 1)
+	rate, err := strconv.ParseFloat(strings.TrimSpace(cleaned), 64)
+	if err != nil || rate < 0 || rate > 100 {
+		return 0, fmt.Errorf("taux de TVA invalide: %q", value)
+	}
+	return int(math.Round(rate * 100)), nil
+}
+
+// scanQuoteRecord lit une ligne issue de quoteRecordColumns
+func scanQuoteRecord(scanner interface{ Scan(...any) error }) (*QuoteRecord, error) {
+	quote := &QuoteRecord{}
+	var userID sql.NullInt64
+	var reference sql.NullString
+	var telephone sql.NullString
+	var configuration sql.NullString
+	var message sql.NullString
+	var rejectionReason sql.NullString
+	var assignedTo sql.NullString
+	var quotedAt sql.NullTime
+	var createdAt sql.NullTime
+
+	if err := scanner.Scan(&quote.ID, &userID, &reference, &quote.Nom, &quote.Prenom, &quote.Email, &telephone, &quote.Produit, &configuration, &message, &quote.Status, &rejectionReason, &assignedTo, &quotedAt, &createdAt); err != nil {
+		return nil, err
+	}
+
+	quote.UserID = int(userID.Int64)
+	quote.Reference = reference.String
+	quote.Telephone = telephone.String
+	quote.Configuration = configuration.String
+	quote.Message = message.String
+	quote.RejectionReason = rejectionReason.String
+	quote.AssignedTo = assignedTo.String
+	if quotedAt.Valid {
+		quotedAtTime := quotedAt.Time
+		quote.QuotedAt = &quotedAtTime
+	}
+	if createdAt.Valid {
+		quote.CreatedAt = createdAt.Time
+	}
+	return quote, nil
+}
+
+const quoteRecordColumns = "id, user_id, reference, nom, prenom, email, telephone, produit, configuration, message, status, rejection_reason, assigned_to, quoted_at, created_at"
+
+// GetQuoteByID récupère un devis, ou nil s'il n'existe pas
+func GetQuoteByID(quoteID int) (*QuoteRecord, error) {
+	if db == nil {
+		return nil, fmt.Errorf("base de données non configurée")
+	}
+
+	row := db.QueryRow(
+		rebindQuery("SELECT "+quoteRecordColumns+" FROM quotes WHERE id = ? AND deleted_at IS NULL"),
+		quoteID,
+	)
+
+	quote, err := scanQuoteRecord(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return quote, err
+}
+
+// getQuoteForUpdate relit un devis hors corbeille en le verrouillant jusqu'à la fin de la transaction
+func getQuoteForUpdate(tx *sql.Tx, quoteID int) (*QuoteRecord, error) {
+	quote, err := scanQuoteRecord(tx.QueryRow(rebindQuery("SELECT "+quoteRecordColumns+" FROM quotes WHERE id = ? AND deleted_at IS NULL FOR UPDATE"), quoteID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return quote, err
+}
+
+// listQuotesForUser renvoie les devis d'un client, y compris ceux créés avant le rattachement au compte
+func listQuotesForUser(user *User) ([]*QuoteRecord, error) {
+	if db == nil {
+		return nil, fmt.Errorf("base de données non configurée")
+	}
+
+	rows, err := db.Query(
+		rebindQuery("SELECT "+quoteRecordColumns+" FROM quotes WHERE (user_id = ? OR (user_id IS NULL AND email = ?)) AND deleted_at IS NULL ORDER BY created_at DESC, id DESC"),
+		user.ID, user.Email,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	quotes := make([]*QuoteRecord, 0)
+	for rows.Next() {
+		quote, err := scanQuoteRecord(rows)
+		if err != nil {
+			return nil, err
+		}
+		quotes = append(quotes, quote)
+	}
+	return quotes, rows.Err()
+}
+
+// quoteBelongsToUser indique si le client connecté peut consulter ce devis
+func quoteBelongsToUser(quote *QuoteRecord, user *User) bool {
+	if quote == nil || user == nil {
+		return false
+	}
+	if quote.UserID != 0 {
+		return quote.UserID == user.ID
+	}
+	return strings.EqualFold(quote.Email, user.Email)
+}
+
+// listQuoteLines renvoie les lignes chiffrées d'un devis
+func listQuoteLines(quoteID int) ([]QuoteLine, error) {
+	if db == nil {
+		return nil, fmt.Errorf("base de données non configurée")
+	}
+	return queryQuoteLines(db, quoteID)
+}
+
+// quoteCustomerEmail renvoie l'adresse à laquelle écrire au client d'un devis : celle de son compte, qui a pu
+// changer depuis la demande, ou celle saisie pour un devis sans compte. Vide pour un compte anonymisé ou disparu
+func quoteCustomerEmail(query interface {
+	QueryRow(string, ...any) *sql.Row
+}, quote *QuoteRecord) (string, error) {
+	if quote.UserID == 0 {
+		return quote.Email, nil
+	}
+	var email string
+	err := query.QueryRow(
+		rebindQuery("SELECT email FROM users WHERE id = ? AND anonymized_at IS NULL"),
+		quote.UserID,
+	).Scan(&email)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return email, err
+}
+
+// queryQuoteLines lit les lignes d'un devis avec db, ou dans la transaction qui a verrouillé le devis
+func queryQuoteLines(query interface {
+	Query(string, ...any) (*sql.Rows, error)
+}, quoteID int) ([]QuoteLine, error) {
+	rows, err := query.Query(
+		rebindQuery("SELECT id, quote_id, label, quantity, unit_price_cents, vat_rate_bp FROM quote_lines WHERE quote_id = ? ORDER BY id"),
+		quoteID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lines := make([]QuoteLine, 0)
+	for rows.Next() {
+		var line QuoteLine
+		if err := rows.Scan(&line.ID, &line.QuoteID, &line.Label, &line.Quantity, &line.UnitPriceCents, &line.VATRateBP); err != nil {
+			return nil, err
+		}
+		lines = append(lines, line)
+	}
+	return lines, rows.Err()
+}
+
+// findQuoteLine renvoie la ligne lineID du devis, ou nil si elle n'en fait pas partie
+func findQuoteLine(quoteID, lineID int) (*QuoteLine, error) {
+	lines, err := listQuoteLines(quoteID)
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range lines {
+		if line.ID == lineID {
+			return &line, nil
+		}
+	}
+	return nil, nil
+}
+
+// addQuoteLine ajoute une ligne chiffrée à un devis
+func addQuoteLine(line QuoteLine) error {
+	if db == nil {
+		return fmt.Errorf("base de données non configurée")
+	}
+
+	_, err := db.Exec(
+		rebindQuery("INSERT INTO quote_lines (quote_id, label, quantity, unit_price_cents, vat_rate_bp) VALUES (?, ?, ?, ?, ?)"),
+		line.QuoteID, line.Label, line.Quantity, line.UnitPriceCents, line.VATRateBP,
+	)
+	return err
+}
+
+// deleteQuoteLine supprime une ligne d'un devis
+func deleteQuoteLine(quoteID, lineID int) error {
+	if db == nil {
+		return fmt.Errorf("base de données non configurée")
+	}
+
+	_, err := db.Exec(
+		rebindQuery("DELETE FROM quote_lines WHERE id = ? AND quote_id = ?"),
+		lineID, quoteID,
+	)
+	return err
+}
+
+// updateQuoteLine modifie la désignation, la quantité, le prix et la TVA d'une ligne d'un devis
+func updateQuoteLine(line QuoteLine) error {
+	if db == nil {
+		return fmt.Errorf("base de données non configurée")
+	}
+
+	_, err := db.Exec(
+		rebindQuery("UPDATE quote_lines SET label = ?, quantity = ?, unit_price_cents = ?, vat_rate_bp = ? WHERE id = ? AND quote_id = ?"),
+		line.Label, line.Quantity, line.UnitPriceCents, line.VATRateBP, line.ID, line.QuoteID,
+	)
+	return err
+}
+
+// assignQuote confie un devis à un membre de l'équipe ; staff vide retire l'attribution
+func assignQuote(quoteID int, staff string) error {
+	if db == nil {
+		return fmt.Errorf("base de données non configurée")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := assignQuoteTx(tx, quoteID, staff); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// assignQuoteTx attribue un devis dans une transaction existante ; staff vide retire l'attribution
+func assignQuoteTx(tx *sql.Tx, quoteID int, staff string) error {
+	var assignee any
+	if staff != "" {
+		assignee = staff
+	}
+	_, err := tx.Exec(
+		rebindQuery("UPDATE quotes SET assigned_to = ? WHERE id = ?"),
+		assignee, quoteID,
+	)
+	return err
+}
+
+// updateQuoteStatus change le statut d'un devis et, s'il change, met en file l'email
+// de notification au client dans la même transaction. reason est le motif d'un refus
+func updateQuoteStatus(ctx context.Context, quote *QuoteRecord, status, reason string) error {
+	if db == nil {
+		return fmt.Errorf("base de données non configurée")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	notified, err := updateQuoteStatusTx(ctx, tx, quote, status, reason)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	if notified {
+		notifyOutbox()
+	}
+	notifyWebhooks()
+	return nil
+}
+
+// updateQuoteStatusTx change le statut d'un devis dans une transaction existante. Il indique si un
+// email a été mis en file : l'appelant réveille l'outbox une fois la transaction validée, ainsi que
+// le worker des webhooks (quote.status_changed est mis en file dès que le statut change)
+func updateQuoteStatusTx(ctx context.Context, tx *sql.Tx, quote *QuoteRecord, status, reason string) (bool, error) {
+	if !isValidQuoteStatus(status) {
+		return false, fmt.Errorf("statut de devis invalide: %s", status)
+	}
+
+	var err error
+	updated := *quote
+	updated.Status = status
+	switch status {
+	case QuoteStatusSent:
+		now := time.Now()
+		updated.QuotedAt = &now
+		_, err = tx.Exec(
+			rebindQuery("UPDATE quotes SET status = ?, quoted_at = ? WHERE id = ?"),
+			status, now, quote.ID,
+		)
+		if err == nil {
+			err = markQuoteFirstResponse(tx, quote.ID, now)
+		}
+	case QuoteStatusRejected:
+		updated.RejectionReason = reason
+		_, err = tx.Exec(
+			rebindQuery("UPDATE quotes SET status = ?, rejection_reason = ? WHERE id = ?"),
+			status, reason, quote.ID,
+		)
+	default:
+		_, err = tx.Exec(
+			rebindQuery("UPDATE quotes SET status = ? WHERE id = ?"),
+			status, quote.ID,
+		)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if status == quote.Status {
+		return false, nil
+	}
+	if err := enqueueQuoteWebhook(tx, WebhookEventQuoteStatusChanged, quote.ID, quote.Status); err != nil {
+		return false, err
+	}
+	return enqueueQuoteStatusEmail(ctx, tx, &updated)
+}
+
+// quoteStatusEmailEssential indique si l'email d'un statut est toujours envoyé : devis envoyé, accepté ou refusé.
+// Les autres (passage en révision…) ne le sont qu'aux clients qui n'ont pas désactivé les emails de suivi
+func quoteStatusEmailEssential(status string) bool {
+	return status == QuoteStatusSent || status == QuoteStatusAccepted || status == QuoteStatusRejected
+}
+
+// enqueueQuoteStatusEmail met en file l'email correspondant au nouveau statut du devis :
+// devis joint en PDF s'il est envoyé ou accepté, motif s'il est refusé.
+// Renvoie false si aucun email n'est envoyé (pas d'adresse, ou emails de suivi désactivés)
+func enqueueQuoteStatusEmail(ctx context.Context, tx *sql.Tx, quote *QuoteRecord) (bool, error) {
+	recipient, err := quoteCustomerEmail(tx, quote)
+	if err != nil {
+		return false, err
+	}
+	if recipient == "" {
+		return false, nil
+	}
+
+	essential := quoteStatusEmailEssential(quote.Status)
+	if !essential && quote.UserID != 0 {
+		var enabled bool
+		err := tx.QueryRow(
+			rebindQuery("SELECT email_notifications FROM users WHERE id = ?"),
+			quote.UserID,
+		).Scan(&enabled)
+		if err != nil && err != sql.ErrNoRows {
+			return false, err
+		}
+		if err == nil && !enabled {
+			return false, nil
+		}
+	}
+
+	details := QuoteStatusEmail{
+		QuoteID:   quote.ID,
+		Prenom:    quote.Prenom,
+		Reference: quoteNumber(quote),
+		Produit:   quote.Produit,
+		Status:    quote.Status,
+		Reason:    quote.RejectionReason,
+		Optional:  !essential,
+	}
+
+	var attachments []mimePart
+	if quote.Status == QuoteStatusSent || quote.Status == QuoteStatusAccepted {
+		lines, err := queryQuoteLines(tx, quote.ID)
+		if err != nil {
+			return false, err
+		}
+		if quoteHasDocument(quote, lines) {
+			pdf, err := renderQuotePDF(quote, lines)
+			if err != nil {
+				return false, err
+			}
+			attachments = append(attachments, mimePart{Filename: details.Reference + ".pdf", ContentType: "application/pdf", Data: pdf})
+			details.HasPDF = true
+		}
+	}
+
+	name := "quote_status_changed"
+	switch quote.Status {
+	case QuoteStatusAccepted:
+		name = "quote_accepted"
+	case QuoteStatusRejected:
+		name = "quote_rejected"
+	}
+	email, err := renderEmail(name, []string{recipient}, details, attachments...)
+	if err != nil {
+		return false, err
+	}
+	if err := enqueueEmail(ctx, tx, email); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// setUserEmailNotifications active ou désactive les emails de suivi non essentiels d'un client
+func setUserEmailNotifications(userID int, enabled bool) error {
+	if db == nil {
+		return fmt.Errorf("base de données non configurée")
+	}
+
+	_, err := db.Exec(
+		rebindQuery("UPDATE users SET email_notifications = ? WHERE id = ?"),
+		enabled, userID,
+	)
+	return err
+}
+
+// quoteHasDocument indique si le devis peut être remis au client en PDF
+func quoteHasDocument(quote *QuoteRecord, lines []QuoteLine) bool {
+	if len(lines) == 0 {
+		return false
+	}
+	return quote.Status == QuoteStatusSent || quote.Status == QuoteStatusAccepted
+}
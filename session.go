@@ -0,0 +1,94 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cookie de session du client. Il ne porte que l'identifiant du compte, signé : un cookie écrit à la main
+// (l'email d'un autre client, un autre identifiant) est ignoré
+const userSessionCookie = "user_session"
+
+// userSessionDuration est la durée d'une connexion avant qu'il faille se reconnecter
+const userSessionDuration = 30 * 24 * time.Hour
+
+var errInvalidUserSession = errors.New("session client invalide")
+
+// userSessionSignature signe le contenu d'un cookie de session ; le préfixe le distingue des sessions
+// « voir comme le client », signées avec la même clé
+func userSessionSignature(payload string) string {
+	mac := hmac.New(sha256.New, sessionSigningKey())
+	fmt.Fprintf(mac, "session:%s", payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// userSessionToken encode la session en "<user id>.<expiration unix>.<signature>"
+func userSessionToken(userID int, expiresAt time.Time) string {
+	payload := fmt.Sprintf("%d.%d", userID, expiresAt.Unix())
+	return payload + "." + userSessionSignature(payload)
+}
+
+// parseUserSessionToken vérifie la signature et l'expiration d'un cookie de session et renvoie le compte
+func parseUserSessionToken(token string, now time.Time) (int, error) {
+	separator := strings.LastIndex(token, ".")
+	if separator < 0 {
+		return 0, errInvalidUserSession
+	}
+	payload, signature := token[:separator], token[separator+1:]
+	if !hmac.Equal([]byte(signature), []byte(userSessionSignature(payload))) {
+		return 0, errInvalidUserSession
+	}
+
+	userPart, expiresPart, found := strings.Cut(payload, ".")
+	if !found {
+		return 0, errInvalidUserSession
+	}
+	userID, err := strconv.Atoi(userPart)
+	if err != nil || userID <= 0 {
+		return 0, errInvalidUserSession
+	}
+	expires, err := strconv.ParseInt(expiresPart, 10, 64)
+	if err != nil || !now.Before(time.Unix(expires, 0)) {
+		return 0, errInvalidUserSession
+	}
+	return userID, nil
+}
+
+// startUserSession connecte le client : cookie signé, inaccessible au JavaScript
+func startUserSession(w http.ResponseWriter, r *http.Request, user *User) {
+	expiresAt := time.Now().Add(userSessionDuration)
+	http.SetCookie(w, &http.Cookie{
+		Name:     userSessionCookie,
+		Value:    userSessionToken(user.ID, expiresAt),
+		Path:     "/",
+		Expires:  expiresAt,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// endUserSession déconnecte le client
+func endUserSession(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{Name: userSessionCookie, Value: "", Path: "/", MaxAge: -1, HttpOnly: true})
+}
+
+// sessionUserID renvoie le compte de la session du client, 0 sans session valide
+func sessionUserID(r *http.Request) int {
+	cookie, err := r.Cookie(userSessionCookie)
+	if err != nil {
+		return 0
+	}
+	userID, err := parseUserSessionToken(cookie.Value, time.Now())
+	if err != nil {
+		return 0
+	}
+	return userID
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUserSessionToken(t *testing.T) {
+	now := time.Now()
+	token := userSessionToken(42, now.Add(time.Hour))
+
+	if userID, err := parseUserSessionToken(token, now); err != nil || userID != 42 {
+		t.Fatalf("session valide refusée: %d, %v", userID, err)
+	}
+
+	payload := token[:strings.LastIndex(token, ".")]
+	otherKey := hmac.New(sha256.New, []byte("autre-secret"))
+	fmt.Fprintf(otherKey, "session:%s", payload)
+	cases := []struct {
+		name  string
+		token string
+		at    time.Time
+	}{
+		{"email en clair", "client@example.com", now},
+		{"autre compte", "43" + strings.TrimPrefix(token, "42"), now},
+		{"signature retirée", payload, now},
+		{"signature d'une autre clé", payload + "." + hex.EncodeToString(otherKey.Sum(nil)), now},
+		{"session expirée", token, now.Add(2 * time.Hour)},
+		{"jeton « voir comme le client »", (&Impersonation{UserID: 42, Actor: "admin", ExpiresAt: now.Add(time.Hour)}).token(), now},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if userID, err := parseUserSessionToken(c.token, c.at); err == nil {
+				t.Fatalf("jeton accepté pour le compte %d", userID)
+			}
+		})
+	}
+}
+
+// TestForgedSessionCookie vérifie qu'un cookie écrit à la main n'ouvre aucune page réservée au client :
+// l'ancien cookie user_email en clair comme un user_session non signé mènent à la connexion ou à un refus
+func TestForgedSessionCookie(t *testing.T) {
+	t.Setenv("ADMIN_USERNAME", "admin")
+	t.Setenv("ADMIN_PASSWORD", "secret")
+	routes := map[string]http.HandlerFunc{
+		"/mes-devis/{id}":          mesDevisDetailHandler,
+		"/mes-devis/{id}/pdf":      mesDevisPDFHandler,
+		"/mes-devis/{id}/pay":      mesDevisPayHandler,
+		"/mes-factures/{id}/pdf":   mesFacturePDFHandler,
+		"/mes-factures/{id}/pay":   mesFacturePayHandler,
+		"/attachments/{id}":        attachmentHandler,
+		"/mes-devis/{id}/messages": mesDevisMessageHandler,
+	}
+	mux := http.NewServeMux()
+	for pattern, handler := range routes {
+		mux.HandleFunc(pattern, handler)
+	}
+
+	for pattern := range routes {
+		path := strings.Replace(pattern, "{id}", "1", 1)
+		for _, method := range []string{http.MethodGet, http.MethodPost} {
+			for _, cookie := range []*http.Cookie{
+				{Name: "user_email", Value: "client@example.com"},
+				{Name: userSessionCookie, Value: "client@example.com"},
+				{Name: userSessionCookie, Value: "1.9999999999.0000"},
+			} {
+				req := httptest.NewRequest(method, path, nil)
+				req.AddCookie(cookie)
+				rec := httptest.NewRecorder()
+				mux.ServeHTTP(rec, req)
+
+				status := rec.Code
+				if status != http.StatusForbidden && status != http.StatusUnauthorized && status != http.StatusMethodNotAllowed &&
+					(status < 300 || status >= 400) {
+					t.Errorf("%s %s avec %s=%s: statut %d, connexion ou refus attendu", method, path, cookie.Name, cookie.Value, status)
+				}
+			}
+		}
+	}
+}
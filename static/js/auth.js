@@ -3,13 +3,15 @@ async function checkUserStatus() {
     try {
         const response = await fetch('/api/user');
         const data = await response.json();
+
+        showImpersonationBanner(data);
         
         const authGuest = document.getElementById('auth-guest');
         const authUser = document.getElementById('auth-user');
         const userPrenomSpan = document.getElementById('user-prenom');
         
+        // Les pages rendues par le serveur (Mes devis, profil) affichent elles-mêmes l'utilisateur connecté
         if (!authGuest || !authUser || !userPrenomSpan) {
-            console.error('Éléments auth manquants');
             return;
         }
         
@@ -17,6 +19,26 @@ async function checkUserStatus() {
             authGuest.style.display = 'none';
             authUser.style.display = 'flex';
             userPrenomSpan.textContent = data.prenom || data.email;
+
+            // Lien vers le suivi des devis du client
+            if (!document.getElementById('mes-devis-link')) {
+                const link = document.createElement('a');
+                link.id = 'mes-devis-link';
+                link.href = '/mes-devis';
+                link.className = 'header-auth-btn';
+                link.textContent = 'Mes devis';
+                authUser.insertBefore(link, authUser.querySelector('a[href="/logout"]'));
+            }
+
+            // Lien vers le profil (préférences de notification)
+            if (!document.getElementById('profil-link')) {
+                const link = document.createElement('a');
+                link.id = 'profil-link';
+                link.href = '/profil';
+                link.className = 'header-auth-btn';
+                link.textContent = 'Mon profil';
+                authUser.insertBefore(link, authUser.querySelector('a[href="/logout"]'));
+            }
         } else {
             authGuest.style.display = 'flex';
             authUser.style.display = 'none';
@@ -26,5 +48,33 @@ async function checkUserStatus() {
     }
 }
 
+// Bandeau du mode « voir comme le client » : un admin consulte le site avec le compte d'un client,
+// en lecture seule. Il reste affiché en haut de chaque page jusqu'à la sortie du mode
+function showImpersonationBanner(data) {
+    if (!data.loggedIn || !data.impersonation || document.getElementById('impersonation-banner')) {
+        return;
+    }
+
+    const banner = document.createElement('div');
+    banner.id = 'impersonation-banner';
+    banner.style.cssText = 'position:sticky;top:0;z-index:1000;display:flex;gap:12px;flex-wrap:wrap;justify-content:center;align-items:center;padding:10px 16px;background:#b91c1c;color:#fff;font-weight:600;';
+
+    const text = document.createElement('span');
+    const expiresAt = new Date(data.impersonation.expiresAt);
+    text.textContent = `Mode « voir comme le client » : compte de ${data.email}, ouvert par ${data.impersonation.by} jusqu'à ${expiresAt.toLocaleTimeString('fr-FR', { hour: '2-digit', minute: '2-digit' })}. Les modifications sont désactivées.`;
+
+    const form = document.createElement('form');
+    form.method = 'POST';
+    form.action = '/impersonation/stop';
+    form.style.margin = '0';
+    const button = document.createElement('button');
+    button.type = 'submit';
+    button.textContent = 'Quitter ce mode';
+    form.appendChild(button);
+
+    banner.append(text, form);
+    document.body.prepend(banner);
+}
+
 // Lancer la vérification au chargement de la page
 document.addEventListener('DOMContentLoaded', checkUserStatus);
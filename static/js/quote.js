@@ -13,6 +13,32 @@ document.addEventListener('DOMContentLoaded', function() {
 
     console.log('Modal de devis initialisée avec', openButtons.length, 'bouton(s)');
 
+    // Ajouter au formulaire le choix de configuration (cartes de la fiche produit) et un champ de précisions
+    const configurations = Array.from(document.querySelectorAll('.product-features .feature-item h3'))
+        .map(title => title.textContent.trim());
+    const submitButton = form.querySelector('button[type="submit"]');
+    if (configurations.length > 0) {
+        const group = document.createElement('div');
+        group.className = 'quote-form-group';
+        group.innerHTML = '<label for="configuration">Configuration</label><select id="configuration" name="configuration"><option value="">Je ne sais pas encore</option></select>';
+        const select = group.querySelector('select');
+        configurations.forEach(configuration => {
+            const option = document.createElement('option');
+            option.value = configuration;
+            option.textContent = configuration;
+            select.appendChild(option);
+        });
+        form.insertBefore(group, submitButton);
+    }
+    const messageGroup = document.createElement('div');
+    messageGroup.className = 'quote-form-group';
+    messageGroup.innerHTML = '<label for="quoteMessage">Précisions (dimensions, finitions…)</label><textarea id="quoteMessage" name="message" rows="3"></textarea>';
+    form.insertBefore(messageGroup, submitButton);
+    const attachmentsGroup = document.createElement('div');
+    attachmentsGroup.className = 'quote-form-group';
+    attachmentsGroup.innerHTML = '<label for="quoteAttachments">Plans, photos, relevés de mesures (5 fichiers, 10 Mo chacun)</label><input type="file" id="quoteAttachments" name="attachments" multiple accept="image/jpeg,image/png,image/gif,image/webp,application/pdf">';
+    form.insertBefore(attachmentsGroup, submitButton);
+
     // Ouvrir la modal
     openButtons.forEach(button => {
         button.addEventListener('click', async function(e) {
@@ -105,50 +131,34 @@ document.addEventListener('DOMContentLoaded', function() {
         const email = document.getElementById('email').value;
         const telephone = document.getElementById('telephone').value;
         const produit = document.getElementById('productName').value;
+        const configurationSelect = document.getElementById('configuration');
+        const configuration = configurationSelect ? configurationSelect.value : '';
+        const message = document.getElementById('quoteMessage').value;
+        const files = Array.from(document.getElementById('quoteAttachments').files);
+
+        // Contrôle indicatif ; le serveur vérifie le nombre, la taille et le type réel des fichiers
+        if (files.length > 5 || files.some(file => file.size > 10 * 1024 * 1024)) {
+            alert('Vous pouvez joindre 5 fichiers de 10 Mo maximum chacun.');
+            submitBtn.disabled = false;
+            submitBtn.textContent = originalText;
+            return;
+        }
 
-        // Créer le message
-        const message = `Bonjour,
-
-J'aimerais demander un devis pour le produit : ${produit}
-
-Mes coordonnées :
-- Nom : ${nom}
-- Prénom : ${prenom}
-- Email : ${email}
-- Téléphone : ${telephone}
-
-Merci de me renvoyer le devis pour ce produit.
-
-Cordialement,
-${prenom} ${nom}`;
-
-        // Utiliser FormSubmit.co pour envoyer l'email directement
         const formData = new FormData();
-        formData.append('_subject', `Demande de devis - ${produit}`);
-        formData.append('_email', email);
-        formData.append('_template', 'table');
-        formData.append('_captcha', 'false');
-        formData.append('message', message);
         formData.append('nom', nom);
         formData.append('prenom', prenom);
+        formData.append('email', email);
         formData.append('telephone', telephone);
         formData.append('produit', produit);
+        formData.append('configuration', configuration);
+        formData.append('message', message);
+        files.forEach(file => formData.append('attachments', file));
 
         try {
-            // 1. Enregistrer dans la base de données
+            // Le serveur enregistre la demande et ses fichiers, et se charge de toutes les notifications
             const dbResponse = await fetch('/api/quote', {
                 method: 'POST',
-                headers: {
-                    'Content-Type': 'application/json',
-                },
-                body: JSON.stringify({
-                    nom: nom,
-                    prenom: prenom,
-                    email: email,
-                    telephone: telephone,
-                    produit: produit,
-                    message: message
-                })
+                body: formData
             });
 
             // Vérifier si l'utilisateur n'est pas connecté
@@ -162,18 +172,19 @@ ${prenom} ${nom}`;
                 return;
             }
 
+            // Fichier refusé (type, taille) ou mode « voir comme le client » : le message du serveur est affiché tel quel
+            if (dbResponse.status === 400 || dbResponse.status === 403) {
+                alert(await dbResponse.text());
+                return;
+            }
+
             if (!dbResponse.ok) {
                 throw new Error('Erreur lors de l\'enregistrement');
             }
 
-            // 2. Envoyer via FormSubmit.co
-            await fetch('https://formsubmit.co/elsachochon13@gmail.com', {
-                method: 'POST',
-                body: formData,
-                mode: 'no-cors'
-            });
+            const quoteData = await dbResponse.json();
 
-            alert('Votre demande de devis a été envoyée avec succès ! Nous vous recontacterons rapidement.');
+            alert(`Votre demande de devis ${quoteData.reference || ''} a été envoyée avec succès ! Un email de confirmation vous a été adressé.`);
             modal.style.display = 'none';
             form.reset();
         } catch (error) {
@@ -0,0 +1,419 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+)
+
+// Délai de grâce par défaut avant la suppression définitive d'un élément de la corbeille
+const trashDefaultRetentionDays = 30
+
+var (
+	errQuoteHasOrder     = errors.New("ce devis a été converti en commande : il ne peut pas être supprimé")
+	errTrashOwnerDeleted = errors.New("le compte client de ce devis est dans la corbeille : restaurez d'abord le compte")
+	errNotInTrash        = errors.New("cet élément n'est pas dans la corbeille")
+)
+
+// trashRetention renvoie le délai de grâce (TRASH_RETENTION_DAYS, 30 jours par défaut)
+func trashRetention() time.Duration {
+	days, err := strconv.Atoi(getEnv("TRASH_RETENTION_DAYS", strconv.Itoa(trashDefaultRetentionDays)))
+	if err != nil || days <= 0 {
+		days = trashDefaultRetentionDays
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// TrashedUser est un compte client dans la corbeille
+type TrashedUser struct {
+	ID        int
+	Email     string
+	Nom       string
+	Prenom    string
+	DeletedAt time.Time
+}
+
+// TrashedQuote est une demande de devis dans la corbeille
+type TrashedQuote struct {
+	ID        int
+	Reference string
+	Nom       string
+	Prenom    string
+	Email     string
+	Produit   string
+	Status    string
+	DeletedAt time.Time
+}
+
+// softDeleteUser met un compte à la corbeille avec ses devis non convertis en commande. Les devis
+// reçoivent la même date de suppression que le compte : c'est elle qui permet de les restaurer ensemble
+func softDeleteUser(user *User) (int, error) {
+	if db == nil {
+		return 0, fmt.Errorf("base de données non configurée")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	quotes, err := softDeleteUserTx(tx, user, time.Now().Truncate(time.Second))
+	if err != nil {
+		return 0, err
+	}
+	return quotes, tx.Commit()
+}
+
+// softDeleteUserTx met un compte à la corbeille dans une transaction existante ; deletedAt doit être
+// tronquée à la seconde, comme la colonne
+func softDeleteUserTx(tx *sql.Tx, user *User, deletedAt time.Time) (int, error) {
+	if _, err := tx.Exec(rebindQuery("UPDATE users SET deleted_at = ? WHERE id = ? AND deleted_at IS NULL"), deletedAt, user.ID); err != nil {
+		return 0, err
+	}
+
+	result, err := tx.Exec(
+		rebindQuery("UPDATE quotes SET deleted_at = ? WHERE (user_id = ? OR (user_id IS NULL AND email = ?)) AND deleted_at IS NULL AND id NOT IN (SELECT quote_id FROM orders)"),
+		deletedAt, user.ID, user.Email,
+	)
+	if err != nil {
+		return 0, err
+	}
+	quotes, err := result.RowsAffected()
+	return int(quotes), err
+}
+
+// userEmailInTrash indique si l'adresse est celle d'un compte de la corbeille. Elle reste prise tant que le
+// compte n'est pas anonymisé par la purge : l'inscription le signale au lieu d'échouer sur l'unicité
+func userEmailInTrash(email string) (bool, error) {
+	if db == nil {
+		return false, fmt.Errorf("base de données non configurée")
+	}
+
+	var count int
+	if err := db.QueryRow(rebindQuery("SELECT COUNT(*) FROM users WHERE email = ? AND deleted_at IS NOT NULL"), email).Scan(&count); err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// restoreUser sort un compte de la corbeille, avec les devis supprimés en même temps que lui
+func restoreUser(userID int) (int, error) {
+	if db == nil {
+		return 0, fmt.Errorf("base de données non configurée")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var email string
+	var deletedAt time.Time
+	err = tx.QueryRow(rebindQuery("SELECT email, deleted_at FROM users WHERE id = ? AND deleted_at IS NOT NULL AND anonymized_at IS NULL"), userID).Scan(&email, &deletedAt)
+	if err == sql.ErrNoRows {
+		return 0, errNotInTrash
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := tx.Exec(rebindQuery("UPDATE users SET deleted_at = NULL WHERE id = ?"), userID); err != nil {
+		return 0, err
+	}
+	result, err := tx.Exec(
+		rebindQuery("UPDATE quotes SET deleted_at = NULL WHERE (user_id = ? OR (user_id IS NULL AND email = ?)) AND deleted_at = ?"),
+		userID, email, deletedAt,
+	)
+	if err != nil {
+		return 0, err
+	}
+	quotes, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(quotes), tx.Commit()
+}
+
+// softDeleteQuote met un devis à la corbeille ; un devis converti en commande est conservé
+func softDeleteQuote(quoteID int) error {
+	if db == nil {
+		return fmt.Errorf("base de données non configurée")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := softDeleteQuoteTx(tx, quoteID, time.Now().Truncate(time.Second)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// softDeleteQuoteTx met un devis à la corbeille dans une transaction existante. errQuoteHasOrder est
+// renvoyée avant toute écriture : la transaction reste utilisable
+func softDeleteQuoteTx(tx *sql.Tx, quoteID int, deletedAt time.Time) error {
+	var orders int
+	if err := tx.QueryRow(rebindQuery("SELECT COUNT(*) FROM orders WHERE quote_id = ?"), quoteID).Scan(&orders); err != nil {
+		return err
+	}
+	if orders > 0 {
+		return errQuoteHasOrder
+	}
+
+	_, err := tx.Exec(rebindQuery("UPDATE quotes SET deleted_at = ? WHERE id = ? AND deleted_at IS NULL"), deletedAt, quoteID)
+	return err
+}
+
+// restoreQuote sort un devis de la corbeille, sauf si le compte de son client y est encore
+func restoreQuote(quoteID int) error {
+	if db == nil {
+		return fmt.Errorf("base de données non configurée")
+	}
+
+	var userID sql.NullInt64
+	err := db.QueryRow(rebindQuery("SELECT user_id FROM quotes WHERE id = ? AND deleted_at IS NOT NULL AND anonymized_at IS NULL"), quoteID).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return errNotInTrash
+	}
+	if err != nil {
+		return err
+	}
+	if userID.Valid {
+		var trashedOwner int
+		if err := db.QueryRow(rebindQuery("SELECT COUNT(*) FROM users WHERE id = ? AND deleted_at IS NOT NULL"), userID.Int64).Scan(&trashedOwner); err != nil {
+			return err
+		}
+		if trashedOwner > 0 {
+			return errTrashOwnerDeleted
+		}
+	}
+
+	_, err = db.Exec(rebindQuery("UPDATE quotes SET deleted_at = NULL WHERE id = ?"), quoteID)
+	return err
+}
+
+// listTrashedUsers renvoie les comptes de la corbeille, les plus récemment supprimés d'abord
+func listTrashedUsers() ([]TrashedUser, error) {
+	if db == nil {
+		return nil, fmt.Errorf("base de données non configurée")
+	}
+
+	rows, err := db.Query("SELECT id, email, nom, prenom, deleted_at FROM users WHERE deleted_at IS NOT NULL AND anonymized_at IS NULL ORDER BY deleted_at DESC, id DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := make([]TrashedUser, 0)
+	for rows.Next() {
+		var user TrashedUser
+		var nom, prenom sql.NullString
+		if err := rows.Scan(&user.ID, &user.Email, &nom, &prenom, &user.DeletedAt); err != nil {
+			return nil, err
+		}
+		user.Nom = nom.String
+		user.Prenom = prenom.String
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+// listTrashedQuotes renvoie les devis de la corbeille, les plus récemment supprimés d'abord
+func listTrashedQuotes() ([]TrashedQuote, error) {
+	if db == nil {
+		return nil, fmt.Errorf("base de données non configurée")
+	}
+
+	rows, err := db.Query("SELECT id, reference, nom, prenom, email, produit, status, deleted_at FROM quotes WHERE deleted_at IS NOT NULL AND anonymized_at IS NULL ORDER BY deleted_at DESC, id DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	quotes := make([]TrashedQuote, 0)
+	for rows.Next() {
+		var quote TrashedQuote
+		var reference sql.NullString
+		if err := rows.Scan(&quote.ID, &reference, &quote.Nom, &quote.Prenom, &quote.Email, &quote.Produit, &quote.Status, &quote.DeletedAt); err != nil {
+			return nil, err
+		}
+		quote.Reference = reference.String
+		quotes = append(quotes, quote)
+	}
+	return quotes, rows.Err()
+}
+
+// Tables rattachées à un devis, supprimées avec lui à la purge
+var quoteChildTables = []string{"attachments", "quote_messages", "quote_notes", "quote_lines"}
+
+// Données personnelles effacées à l'anonymisation d'un devis numéroté ; ses lignes restent, le document
+// DEV-AAAA-NNNN doit pouvoir être produit
+var quotePersonalTables = []string{"attachments", "quote_messages", "quote_notes"}
+
+// Nom inscrit à la place de celui du client sur un devis anonymisé
+const anonymizedName = "Anonyme"
+
+// purgeTrash traite les éléments mis à la corbeille avant before. Un devis sans numéro ni paiement est
+// supprimé avec ses lignes, messages, notes et pièces jointes (fichiers compris). Un devis numéroté ou payé
+// est anonymisé : la numérotation doit rester sans trou et les paiements garder leur objet. De même, un
+// compte qui a encore des devis ou des commandes est anonymisé, les autres sont supprimés
+func purgeTrash(before time.Time) (int, int, error) {
+	if db == nil {
+		return 0, 0, fmt.Errorf("base de données non configurée")
+	}
+
+	rows, err := db.Query(rebindQuery("SELECT id, reference IS NOT NULL OR EXISTS (SELECT 1 FROM payments WHERE target_kind = ? AND target_id = quotes.id) FROM quotes WHERE deleted_at IS NOT NULL AND deleted_at < ? AND anonymized_at IS NULL AND id NOT IN (SELECT quote_id FROM orders)"),
+		PaymentTargetQuote, before)
+	if err != nil {
+		return 0, 0, err
+	}
+	quoteIDs := make([]int, 0)
+	retained := make(map[int]bool)
+	for rows.Next() {
+		var id int
+		var keep bool
+		if err := rows.Scan(&id, &keep); err != nil {
+			rows.Close()
+			return 0, 0, err
+		}
+		quoteIDs = append(quoteIDs, id)
+		retained[id] = keep
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, 0, err
+	}
+
+	for _, quoteID := range quoteIDs {
+		if retained[quoteID] {
+			err = purgeQuoteData(quoteID, quotePersonalTables, true)
+		} else {
+			err = purgeQuoteData(quoteID, quoteChildTables, false)
+		}
+		if err != nil {
+			return 0, 0, fmt.Errorf("erreur purge devis %d: %v", quoteID, err)
+		}
+	}
+
+	users, err := purgeTrashedUsers(before)
+	return users, len(quoteIDs), err
+}
+
+// purgeQuoteData efface les tables rattachées au devis, puis le devis lui-même ou ses données personnelles
+// si anonymize est vrai ; les fichiers sont effacés une fois la transaction validée
+func purgeQuoteData(quoteID int, tables []string, anonymize bool) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(rebindQuery("SELECT storage_key FROM attachments WHERE quote_id = ?"), quoteID)
+	if err != nil {
+		return err
+	}
+	keys := make([]string, 0)
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			rows.Close()
+			return err
+		}
+		keys = append(keys, key)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, table := range tables {
+		if _, err := tx.Exec(rebindQuery(fmt.Sprintf("DELETE FROM %s WHERE quote_id = ?", table)), quoteID); err != nil {
+			return err
+		}
+	}
+	if anonymize {
+		_, err = tx.Exec(
+			rebindQuery("UPDATE quotes SET nom = ?, prenom = ?, email = '', telephone = NULL, message = NULL, anonymized_at = ? WHERE id = ? AND deleted_at IS NOT NULL"),
+			anonymizedName, anonymizedName, time.Now(), quoteID,
+		)
+	} else {
+		_, err = tx.Exec(rebindQuery("DELETE FROM quotes WHERE id = ? AND deleted_at IS NOT NULL"), quoteID)
+	}
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		if err := blobStore.Delete(key); err != nil && !errors.Is(err, errBlobNotFound) {
+			slog.Warn("Fichier du devis non supprimé", "storage_key", key, "quote_id", quoteID, "err", err)
+		}
+	}
+	return nil
+}
+
+// purgeTrashedUsers supprime les comptes de la corbeille sans devis ni commande, et anonymise les autres :
+// l'adresse est remplacée (elle redevient libre pour une inscription) et le compte ne peut plus se connecter
+func purgeTrashedUsers(before time.Time) (int, error) {
+	result, err := db.Exec(
+		rebindQuery("DELETE FROM users WHERE deleted_at IS NOT NULL AND deleted_at < ? AND anonymized_at IS NULL AND NOT EXISTS (SELECT 1 FROM quotes WHERE quotes.user_id = users.id) AND NOT EXISTS (SELECT 1 FROM orders WHERE orders.user_id = users.id)"),
+		before,
+	)
+	if err != nil {
+		return 0, err
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	anonymizedEmail := "CONCAT('supprime-', id, '@anonyme.invalid')"
+	if dbDriver == "postgres" {
+		anonymizedEmail = "'supprime-' || id || '@anonyme.invalid'"
+	}
+	result, err = db.Exec(
+		rebindQuery("UPDATE users SET email = "+anonymizedEmail+", password_hash = '', nom = NULL, prenom = NULL, email_notifications = FALSE, anonymized_at = ? WHERE deleted_at IS NOT NULL AND deleted_at < ? AND anonymized_at IS NULL"),
+		time.Now(), before,
+	)
+	if err != nil {
+		return int(deleted), err
+	}
+	anonymized, err := result.RowsAffected()
+	return int(deleted + anonymized), err
+}
+
+// startTrashPurger purge la corbeille au démarrage puis toutes les heures ; chaque purge est journalisée
+func startTrashPurger() {
+	retention := trashRetention()
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+		for {
+			before := time.Now().Add(-retention)
+			users, quotes, err := purgeTrash(before)
+			if err != nil {
+				slog.Error("Erreur purge de la corbeille", "err", err)
+			}
+			if users > 0 || quotes > 0 {
+				slog.Info("Corbeille purgée", "users", users, "quotes", quotes)
+				details, _ := json.Marshal(map[string]any{"users": users, "quotes": quotes, "deleted_before": before.Format(time.RFC3339)})
+				if err := appendAuditEntry("système", AuditActionTrashPurge, "trash", "", string(details)); err != nil {
+					slog.Warn("Journal d'audit non écrit", "action", AuditActionTrashPurge, "err", err)
+				}
+			}
+			<-ticker.C
+		}
+	}()
+	slog.Info("Purge de la corbeille programmée", "retention_days", int(retention/(24*time.Hour)))
+}
@@ -0,0 +1,840 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Événements envoyés aux abonnements webhook
+const (
+	WebhookEventQuoteCreated       = "quote.created"
+	WebhookEventQuoteStatusChanged = "quote.status_changed"
+	WebhookEventOrderCreated       = "order.created"
+	WebhookEventOrderStatusChanged = "order.status_changed"
+	// WebhookEventPing est envoyé par le bouton « Envoyer un test », à un seul abonnement
+	WebhookEventPing = "ping"
+)
+
+// webhookEvents liste les événements auxquels un abonnement peut s'inscrire
+var webhookEvents = []string{WebhookEventQuoteCreated, WebhookEventQuoteStatusChanged, WebhookEventOrderCreated, WebhookEventOrderStatusChanged}
+
+var webhookEventLabels = map[string]string{
+	WebhookEventQuoteCreated:       "Nouvelle demande de devis",
+	WebhookEventQuoteStatusChanged: "Changement de statut d'un devis",
+	WebhookEventOrderCreated:       "Nouvelle commande",
+	WebhookEventOrderStatusChanged: "Avancement d'une commande",
+	WebhookEventPing:               "Test",
+}
+
+// Formats de corps : JSON signé pour les automatisations, message Discord pour un salon
+const (
+	WebhookFormatJSON    = "json"
+	WebhookFormatDiscord = "discord"
+)
+
+var webhookFormatLabels = map[string]string{
+	WebhookFormatJSON:    "JSON (événement complet)",
+	WebhookFormatDiscord: "Discord (message de salon)",
+}
+
+// Statuts d'une livraison, sur le modèle de l'outbox des emails
+const (
+	WebhookDeliveryPending   = "pending"
+	WebhookDeliverySending   = "sending"
+	WebhookDeliveryDelivered = "delivered"
+	WebhookDeliveryDead      = "dead"
+)
+
+var webhookDeliveryStatusLabels = map[string]string{
+	WebhookDeliveryPending:   "En attente",
+	WebhookDeliverySending:   "En cours d'envoi",
+	WebhookDeliveryDelivered: "Livré",
+	WebhookDeliveryDead:      "Échec définitif",
+}
+
+// En-têtes des requêtes webhook ; la signature a le format "t=<unix>,v1=<hmac hex>" des webhooks de paiement
+const (
+	webhookSignatureHeader    = "X-Modulspace-Signature"
+	webhookEventHeader        = "X-Modulspace-Event"
+	webhookDeliveryHeader     = "X-Modulspace-Delivery"
+	webhookSubscriptionHeader = "X-Modulspace-Webhook"
+)
+
+// Écart maximal accepté par verifyWebhookSignature entre l'horodatage signé et la réception
+const webhookSignatureTolerance = 5 * time.Minute
+
+// webhookResponseMaxBytes borne la réponse du destinataire conservée dans le journal des livraisons
+const webhookResponseMaxBytes = 2 << 10
+
+var errWebhookSubscriptionNotFound = errors.New("abonnement webhook introuvable")
+
+// webhookWake réveille le worker dès qu'une livraison vient d'être mise en file
+var webhookWake = make(chan struct{}, 1)
+
+// webhookClient refuse, au moment de la connexion, les adresses privées et locales : une URL publique
+// qui se résout ou redirige vers le réseau interne est bloquée comme une adresse écrite en clair.
+// Il ne passe pas par un proxy, qui masquerait l'adresse réellement appelée
+var webhookClient = &http.Client{
+	Timeout: 10 * time.Second,
+	Transport: &http.Transport{
+		DialContext:         (&net.Dialer{Timeout: 5 * time.Second, Control: webhookDialControl}).DialContext,
+		TLSHandshakeTimeout: 5 * time.Second,
+	},
+}
+
+// webhookLocalClient n'appelle que le récepteur de test, sur le serveur lui-même
+var webhookLocalClient = &http.Client{Timeout: 10 * time.Second}
+
+// webhookCGNAT est l'espace partagé des opérateurs (100.64.0.0/10), non routable depuis Internet
+var webhookCGNAT = netip.MustParsePrefix("100.64.0.0/10")
+
+// webhookPrivateTargetsAllowed lève le filtrage des adresses (WEBHOOK_ALLOW_PRIVATE_TARGETS=1), pour le développement
+func webhookPrivateTargetsAllowed() bool {
+	return os.Getenv("WEBHOOK_ALLOW_PRIVATE_TARGETS") == "1"
+}
+
+// webhookAddressAllowed refuse boucle locale, réseaux privés, lien local (169.254.169.254…), CGNAT et multicast
+func webhookAddressAllowed(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsValid() && addr.IsGlobalUnicast() && !addr.IsPrivate() && !webhookCGNAT.Contains(addr)
+}
+
+func webhookDialControl(network, address string, _ syscall.RawConn) error {
+	if webhookPrivateTargetsAllowed() {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	if !webhookAddressAllowed(addr) {
+		return fmt.Errorf("adresse %s refusée : réseau privé ou local", addr)
+	}
+	return nil
+}
+
+func webhookEventLabel(event string) string {
+	if label, ok := webhookEventLabels[event]; ok {
+		return label
+	}
+	return event
+}
+
+func webhookDeliveryStatusLabel(status string) string {
+	if label, ok := webhookDeliveryStatusLabels[status]; ok {
+		return label
+	}
+	return status
+}
+
+func isValidWebhookEvent(event string) bool {
+	for _, candidate := range webhookEvents {
+		if candidate == event {
+			return true
+		}
+	}
+	return false
+}
+
+// validateWebhookURL n'accepte qu'une URL http(s) absolue. Une adresse privée ou locale écrite en clair est
+// refusée dès la saisie ; les noms d'hôte sont vérifiés à la connexion, par webhookClient
+func validateWebhookURL(raw string) error {
+	parsed, err := url.Parse(raw)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return errors.New("URL invalide : http:// ou https:// attendu")
+	}
+	if webhookPrivateTargetsAllowed() || isWebhookTestReceiverURL(raw) {
+		return nil
+	}
+	host := strings.ToLower(parsed.Hostname())
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return errors.New("URL refusée : adresse locale")
+	}
+	if addr, err := netip.ParseAddr(host); err == nil && !webhookAddressAllowed(addr) {
+		return errors.New("URL refusée : adresse privée ou locale")
+	}
+	return nil
+}
+
+// WebhookSubscription est une URL appelée à chaque événement de Events
+type WebhookSubscription struct {
+	ID     int
+	Name   string
+	URL    string
+	Secret string
+	Events []string
+	Format string
+	// Active vaut false pour un abonnement en pause : ses livraisons restent en attente
+	Active    bool
+	CreatedBy string
+	CreatedAt time.Time
+}
+
+func (s *WebhookSubscription) Subscribes(event string) bool {
+	for _, candidate := range s.Events {
+		if candidate == event {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookDelivery est l'envoi d'un événement à un abonnement, avec le résultat du dernier essai
+type WebhookDelivery struct {
+	ID             int
+	SubscriptionID int
+	Event          string
+	EventID        string
+	Payload        string
+	Status         string
+	Attempts       int
+	NextAttemptAt  *time.Time
+	ResponseStatus int
+	ResponseBody   string
+	LastError      string
+	CreatedAt      time.Time
+	DeliveredAt    *time.Time
+}
+
+// WebhookEvent est le corps JSON d'un webhook ; EventID est commun à toutes les livraisons d'un même événement
+type WebhookEvent struct {
+	ID        string           `json:"id"`
+	Event     string           `json:"event"`
+	CreatedAt time.Time        `json:"created_at"`
+	Data      WebhookEventData `json:"data"`
+}
+
+// WebhookEventData reprend les représentations de l'API (APIQuote, APIOrder)
+type WebhookEventData struct {
+	Quote          *APIQuote `json:"quote,omitempty"`
+	Order          *APIOrder `json:"order,omitempty"`
+	PreviousStatus string    `json:"previous_status,omitempty"`
+	// Message accompagne l'événement ping
+	Message string `json:"message,omitempty"`
+}
+
+// createWebhookSubscription crée un abonnement actif avec un secret de signature tiré au hasard
+func createWebhookSubscription(name, target string, events []string, format, createdBy string) (int, error) {
+	if db == nil {
+		return 0, fmt.Errorf("base de données non configurée")
+	}
+
+	args := []any{name, target, "whsec_" + randomHex(24), strings.Join(events, " "), format, true, createdBy, time.Now()}
+	if dbDriver == "postgres" {
+		var id int
+		err := db.QueryRow(
+			"INSERT INTO webhook_subscriptions (name, url, secret, events, format, active, created_by, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id",
+			args...,
+		).Scan(&id)
+		return id, err
+	}
+	result, err := db.Exec("INSERT INTO webhook_subscriptions (name, url, secret, events, format, active, created_by, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)", args...)
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	return int(id), err
+}
+
+const webhookSubscriptionColumns = "id, name, url, secret, events, format, active, created_by, created_at"
+
+func scanWebhookSubscription(scanner interface{ Scan(...any) error }) (*WebhookSubscription, error) {
+	subscription := &WebhookSubscription{}
+	var events string
+	var createdAt sql.NullTime
+	if err := scanner.Scan(&subscription.ID, &subscription.Name, &subscription.URL, &subscription.Secret, &events, &subscription.Format,
+		&subscription.Active, &subscription.CreatedBy, &createdAt); err != nil {
+		return nil, err
+	}
+	subscription.Events = strings.Fields(events)
+	subscription.CreatedAt = createdAt.Time
+	return subscription, nil
+}
+
+func queryWebhookSubscriptions(query interface {
+	Query(string, ...any) (*sql.Rows, error)
+}, where string, args ...any) ([]*WebhookSubscription, error) {
+	rows, err := query.Query(rebindQuery("SELECT "+webhookSubscriptionColumns+" FROM webhook_subscriptions"+where+" ORDER BY id"), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	subscriptions := make([]*WebhookSubscription, 0)
+	for rows.Next() {
+		subscription, err := scanWebhookSubscription(rows)
+		if err != nil {
+			return nil, err
+		}
+		subscriptions = append(subscriptions, subscription)
+	}
+	return subscriptions, rows.Err()
+}
+
+func listWebhookSubscriptions() ([]*WebhookSubscription, error) {
+	if db == nil {
+		return nil, fmt.Errorf("base de données non configurée")
+	}
+	return queryWebhookSubscriptions(db, "")
+}
+
+// GetWebhookSubscription récupère un abonnement, ou nil s'il n'existe pas
+func GetWebhookSubscription(id int) (*WebhookSubscription, error) {
+	if db == nil {
+		return nil, fmt.Errorf("base de données non configurée")
+	}
+
+	subscription, err := scanWebhookSubscription(db.QueryRow(rebindQuery("SELECT "+webhookSubscriptionColumns+" FROM webhook_subscriptions WHERE id = ?"), id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return subscription, err
+}
+
+// setWebhookSubscriptionActive met en pause ou réactive un abonnement
+func setWebhookSubscriptionActive(id int, active bool) error {
+	if db == nil {
+		return fmt.Errorf("base de données non configurée")
+	}
+
+	result, err := db.Exec(rebindQuery("UPDATE webhook_subscriptions SET active = ? WHERE id = ?"), active, id)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return errWebhookSubscriptionNotFound
+	}
+	if active {
+		notifyWebhooks()
+	}
+	return nil
+}
+
+// deleteWebhookSubscription supprime un abonnement et son journal de livraisons
+func deleteWebhookSubscription(id int) error {
+	if db == nil {
+		return fmt.Errorf("base de données non configurée")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(rebindQuery("DELETE FROM webhook_deliveries WHERE subscription_id = ?"), id); err != nil {
+		return err
+	}
+	result, err := tx.Exec(rebindQuery("DELETE FROM webhook_subscriptions WHERE id = ?"), id)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return errWebhookSubscriptionNotFound
+	}
+	return tx.Commit()
+}
+
+// enqueueWebhookEvent met en file, dans la transaction de l'appelant, une livraison par abonnement actif
+// inscrit à l'événement : elle part après le commit et disparaît avec un rollback. L'appelant réveille
+// le worker avec notifyWebhooks une fois la transaction validée
+func enqueueWebhookEvent(tx *sql.Tx, event string, data WebhookEventData) error {
+	subscriptions, err := queryWebhookSubscriptions(tx, " WHERE active = ?", true)
+	if err != nil {
+		return fmt.Errorf("lecture des abonnements webhook: %v", err)
+	}
+
+	payload := WebhookEvent{ID: "evt_" + randomHex(12), Event: event, CreatedAt: time.Now().UTC().Truncate(time.Second), Data: data}
+	for _, subscription := range subscriptions {
+		if !subscription.Subscribes(event) {
+			continue
+		}
+		if err := insertWebhookDelivery(tx, subscription, payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func insertWebhookDelivery(tx *sql.Tx, subscription *WebhookSubscription, event WebhookEvent) error {
+	body, err := renderWebhookPayload(subscription.Format, event)
+	if err != nil {
+		return fmt.Errorf("corps du webhook %s: %v", event.Event, err)
+	}
+	now := time.Now()
+	if _, err := tx.Exec(
+		rebindQuery("INSERT INTO webhook_deliveries (subscription_id, event, event_id, payload, status, attempts, next_attempt_at, created_at) VALUES (?, ?, ?, ?, ?, 0, ?, ?)"),
+		subscription.ID, event.Event, event.ID, string(body), WebhookDeliveryPending, now, now,
+	); err != nil {
+		return fmt.Errorf("erreur mise en file du webhook: %v", err)
+	}
+	return nil
+}
+
+// enqueueQuoteWebhook relit le devis dans la transaction et met en file l'événement ; previousStatus est vide
+// pour quote.created
+func enqueueQuoteWebhook(tx *sql.Tx, event string, quoteID int, previousStatus string) error {
+	quote, err := scanQuoteRecord(tx.QueryRow(rebindQuery("SELECT "+quoteRecordColumns+" FROM quotes WHERE id = ?"), quoteID))
+	if err != nil {
+		return fmt.Errorf("lecture du devis %d pour le webhook: %v", quoteID, err)
+	}
+	apiQuote := newAPIQuote(quote)
+	return enqueueWebhookEvent(tx, event, WebhookEventData{Quote: &apiQuote, PreviousStatus: previousStatus})
+}
+
+// enqueueOrderWebhook relit la commande dans la transaction et met en file l'événement
+func enqueueOrderWebhook(tx *sql.Tx, event string, orderID int, previousStatus string) error {
+	order, err := scanOrder(tx.QueryRow(rebindQuery("SELECT "+orderColumns+" FROM orders WHERE id = ?"), orderID))
+	if err != nil {
+		return fmt.Errorf("lecture de la commande %d pour le webhook: %v", orderID, err)
+	}
+	apiOrder := newAPIOrder(order)
+	return enqueueWebhookEvent(tx, event, WebhookEventData{Order: &apiOrder, PreviousStatus: previousStatus})
+}
+
+// enqueueWebhookPing envoie un événement de test au seul abonnement donné, même en pause
+func enqueueWebhookPing(subscription *WebhookSubscription, actor string) error {
+	if db == nil {
+		return fmt.Errorf("base de données non configurée")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	event := WebhookEvent{
+		ID: "evt_" + randomHex(12), Event: WebhookEventPing, CreatedAt: time.Now().UTC().Truncate(time.Second),
+		Data: WebhookEventData{Message: fmt.Sprintf("Test du webhook « %s » envoyé par %s", subscription.Name, actor)},
+	}
+	if err := insertWebhookDelivery(tx, subscription, event); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	notifyWebhooks()
+	return nil
+}
+
+// renderWebhookPayload écrit le corps d'une livraison dans le format de l'abonnement. Le corps est figé à la mise
+// en file : une relance renvoie exactement les mêmes octets
+func renderWebhookPayload(format string, event WebhookEvent) ([]byte, error) {
+	if format == WebhookFormatDiscord {
+		return json.Marshal(discordWebhookMessage(event))
+	}
+	return json.Marshal(event)
+}
+
+type discordMessage struct {
+	Username string         `json:"username"`
+	Content  string         `json:"content,omitempty"`
+	Embeds   []discordEmbed `json:"embeds,omitempty"`
+}
+
+type discordEmbed struct {
+	Title     string         `json:"title"`
+	URL       string         `json:"url,omitempty"`
+	Color     int            `json:"color"`
+	Timestamp string         `json:"timestamp"`
+	Fields    []discordField `json:"fields,omitempty"`
+}
+
+type discordField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline"`
+}
+
+// discordWebhookMessage résume l'événement en un message de salon, avec un lien vers la fiche admin
+func discordWebhookMessage(event WebhookEvent) discordMessage {
+	embed := discordEmbed{Title: webhookEventLabel(event.Event), Color: 0x6161AB, Timestamp: event.CreatedAt.Format(time.RFC3339)}
+	field := func(name, value string) {
+		// Discord refuse les champs vides
+		if value != "" {
+			embed.Fields = append(embed.Fields, discordField{Name: name, Value: value, Inline: true})
+		}
+	}
+
+	switch data := event.Data; {
+	case data.Quote != nil:
+		embed.Title = fmt.Sprintf("%s %s", webhookEventLabel(event.Event), data.Quote.Reference)
+		embed.URL = fmt.Sprintf("%s/admin/quotes/%d", appBaseURL(), data.Quote.ID)
+		field("Client", strings.TrimSpace(data.Quote.Prenom+" "+data.Quote.Nom))
+		field("Produit", strings.TrimSpace(data.Quote.Produit+" "+data.Quote.Configuration))
+		if data.PreviousStatus != "" {
+			field("Statut", quoteStatusLabel(data.PreviousStatus)+" → "+quoteStatusLabel(data.Quote.Status))
+		} else {
+			field("Statut", quoteStatusLabel(data.Quote.Status))
+		}
+		if data.Quote.Status == QuoteStatusRejected {
+			field("Motif du refus", data.Quote.RejectionReason)
+		}
+	case data.Order != nil:
+		embed.Title = fmt.Sprintf("%s %s", webhookEventLabel(event.Event), data.Order.Reference)
+		embed.URL = fmt.Sprintf("%s/admin/orders/%d", appBaseURL(), data.Order.ID)
+		field("Client", strings.TrimSpace(data.Order.Prenom+" "+data.Order.Nom))
+		field("Produit", data.Order.Produit)
+		if data.PreviousStatus != "" {
+			field("Statut", orderStatusLabel(data.PreviousStatus)+" → "+orderStatusLabel(data.Order.Status))
+		} else {
+			field("Statut", orderStatusLabel(data.Order.Status))
+		}
+		field("Total TTC", formatEuros(data.Order.TotalTTCCents))
+	default:
+		return discordMessage{Username: "Modul-space", Content: data.Message}
+	}
+	return discordMessage{Username: "Modul-space", Embeds: []discordEmbed{embed}}
+}
+
+// signWebhook calcule la signature HMAC-SHA256 de "<timestamp>.<corps>" avec le secret de l'abonnement
+func signWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifyWebhookSignature vérifie l'en-tête X-Modulspace-Signature d'un webhook reçu, comme doit le faire un destinataire
+func verifyWebhookSignature(secret, header string, body []byte, now time.Time) error {
+	var timestamp int64
+	var signature string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			timestamp, _ = strconv.ParseInt(value, 10, 64)
+		case "v1":
+			signature = value
+		}
+	}
+	if timestamp == 0 || signature == "" {
+		return fmt.Errorf("en-tête %s absent ou incomplet", webhookSignatureHeader)
+	}
+	if age := now.Sub(time.Unix(timestamp, 0)); age > webhookSignatureTolerance || age < -webhookSignatureTolerance {
+		return fmt.Errorf("horodatage de signature hors tolérance")
+	}
+	if !hmac.Equal([]byte(signature), []byte(signWebhook(secret, timestamp, body))) {
+		return fmt.Errorf("signature invalide")
+	}
+	return nil
+}
+
+// webhookMaxAttempts est le nombre d'essais avant l'échec définitif (WEBHOOK_MAX_ATTEMPTS, 8 par défaut).
+// Les relances suivent outboxBackoff : 30 s, puis le double à chaque échec, 6 h au plus
+func webhookMaxAttempts() int {
+	attempts, err := strconv.Atoi(getEnv("WEBHOOK_MAX_ATTEMPTS", "8"))
+	if err != nil || attempts <= 0 {
+		return 8
+	}
+	return attempts
+}
+
+// notifyWebhooks réveille le worker sans bloquer si un réveil est déjà en attente
+func notifyWebhooks() {
+	select {
+	case webhookWake <- struct{}{}:
+	default:
+	}
+}
+
+// migrateNotifyWebhook reprend l'ancien notifier NOTIFIERS=webhook : NOTIFY_WEBHOOK_URL devient un abonnement à
+// quote.created, s'il n'en existe pas déjà un pour cette URL. Les envois passent alors par webhook_deliveries,
+// avec relances et journal
+func migrateNotifyWebhook() error {
+	legacy := false
+	for _, name := range strings.Split(getEnv("NOTIFIERS", "smtp"), ",") {
+		legacy = legacy || strings.TrimSpace(name) == "webhook"
+	}
+	if !legacy {
+		return nil
+	}
+	url := getEnv("NOTIFY_WEBHOOK_URL", "")
+	if url == "" {
+		slog.Warn("NOTIFIERS=webhook sans NOTIFY_WEBHOOK_URL, ignoré")
+		return nil
+	}
+
+	subscriptions, err := listWebhookSubscriptions()
+	if err != nil {
+		return err
+	}
+	for _, subscription := range subscriptions {
+		if subscription.URL == url {
+			slog.Warn("NOTIFIERS=webhook est obsolète : les demandes de devis passent par l'abonnement webhook existant", "subscription_id", subscription.ID)
+			return nil
+		}
+	}
+
+	id, err := createWebhookSubscription("NOTIFY_WEBHOOK_URL", url, []string{WebhookEventQuoteCreated}, WebhookFormatJSON, "système")
+	if err != nil {
+		return err
+	}
+	details, _ := json.Marshal(auditCreated(map[string]any{"name": "NOTIFY_WEBHOOK_URL", "url": url, "events": WebhookEventQuoteCreated, "format": WebhookFormatJSON}))
+	if err := appendAuditEntry("système", AuditActionWebhookCreate, fmt.Sprintf("webhook:%d", id), "", string(details)); err != nil {
+		slog.Warn("Journal d'audit non écrit", "action", AuditActionWebhookCreate, "err", err)
+	}
+	slog.Warn("NOTIFIERS=webhook est obsolète : NOTIFY_WEBHOOK_URL a été repris dans un abonnement webhook, retirez la variable", "subscription_id", id)
+	return nil
+}
+
+// startWebhookWorker lance la goroutine d'envoi des webhooks (intervalle WEBHOOK_POLL_SECONDS, 10 par défaut)
+func startWebhookWorker() {
+	interval, err := strconv.Atoi(getEnv("WEBHOOK_POLL_SECONDS", "10"))
+	if err != nil || interval <= 0 {
+		interval = 10
+	}
+
+	go func() {
+		ticker := time.NewTicker(time.Duration(interval) * time.Second)
+		defer ticker.Stop()
+		for {
+			processWebhooks()
+			select {
+			case <-ticker.C:
+			case <-webhookWake:
+			}
+		}
+	}()
+	slog.Info("Worker d'envoi des webhooks démarré")
+}
+
+// processWebhooks envoie les livraisons arrivées à échéance des abonnements actifs
+func processWebhooks() {
+	if db == nil {
+		return
+	}
+
+	now := time.Now()
+	if _, err := db.Exec(rebindQuery("UPDATE webhook_deliveries SET status = ? WHERE status = ? AND locked_at < ?"),
+		WebhookDeliveryPending, WebhookDeliverySending, now.Add(-outboxStaleLock)); err != nil {
+		slog.Error("Erreur remise en file des webhooks bloqués", "err", err)
+	}
+
+	rows, err := db.Query(rebindQuery(`SELECT d.id FROM webhook_deliveries d JOIN webhook_subscriptions s ON s.id = d.subscription_id
+		WHERE d.status = ? AND d.next_attempt_at <= ? AND s.active = ? ORDER BY d.next_attempt_at, d.id LIMIT 20`),
+		WebhookDeliveryPending, now, true)
+	if err != nil {
+		slog.Error("Erreur lecture des webhooks en attente", "err", err)
+		return
+	}
+	ids := make([]int, 0)
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			slog.Error("Erreur lecture des webhooks en attente", "err", err)
+			break
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	for _, id := range ids {
+		deliverWebhook(id)
+	}
+}
+
+// deliverWebhook réserve une livraison (une seule instance l'envoie), l'envoie et planifie la relance en cas d'échec
+func deliverWebhook(id int) {
+	result, err := db.Exec(rebindQuery("UPDATE webhook_deliveries SET status = ?, locked_at = ? WHERE id = ? AND status = ?"),
+		WebhookDeliverySending, time.Now(), id, WebhookDeliveryPending)
+	if err != nil {
+		slog.Error("Erreur réservation webhook", "delivery_id", id, "err", err)
+		return
+	}
+	if claimed, err := result.RowsAffected(); err != nil || claimed == 0 {
+		return
+	}
+
+	delivery, err := GetWebhookDelivery(id)
+	if err != nil || delivery == nil {
+		slog.Error("Erreur lecture webhook", "delivery_id", id, "err", err)
+		return
+	}
+	subscription, err := GetWebhookSubscription(delivery.SubscriptionID)
+	if err != nil || subscription == nil {
+		slog.Error("Erreur lecture abonnement webhook", "subscription_id", delivery.SubscriptionID, "err", err)
+		return
+	}
+
+	responseStatus, responseBody, retryAfter, sendErr := sendWebhook(subscription, delivery)
+	var status any
+	if responseStatus > 0 {
+		status = responseStatus
+	}
+	now := time.Now()
+	if sendErr == nil {
+		if _, err := db.Exec(rebindQuery("UPDATE webhook_deliveries SET status = ?, attempts = attempts + 1, delivered_at = ?, response_status = ?, response_body = ?, last_error = NULL WHERE id = ?"),
+			WebhookDeliveryDelivered, now, status, responseBody, id); err != nil {
+			slog.Error("Erreur mise à jour webhook livré", "delivery_id", id, "err", err)
+		}
+		return
+	}
+
+	attempts := delivery.Attempts + 1
+	next := WebhookDeliveryPending
+	if attempts >= webhookMaxAttempts() {
+		next = WebhookDeliveryDead
+		slog.Warn("Webhook abandonné", "delivery_id", id, "event", delivery.Event, "subscription", subscription.Name, "attempts", attempts, "err", sendErr)
+	} else {
+		slog.Error("Erreur envoi webhook", "delivery_id", id, "attempts", attempts, "err", sendErr)
+	}
+	delay := outboxBackoff(attempts)
+	if retryAfter > delay {
+		delay = retryAfter
+	}
+	if _, err := db.Exec(rebindQuery("UPDATE webhook_deliveries SET status = ?, attempts = ?, next_attempt_at = ?, response_status = ?, response_body = ?, last_error = ? WHERE id = ?"),
+		next, attempts, now.Add(delay), status, responseBody, sendErr.Error(), id); err != nil {
+		slog.Error("Erreur mise à jour webhook", "delivery_id", id, "err", err)
+	}
+}
+
+// sendWebhook poste le corps signé. Une réponse 2xx vaut livraison ; retryAfter reprend l'en-tête Retry-After
+// d'une réponse 429 ou 503 (limite de débit de Discord)
+func sendWebhook(subscription *WebhookSubscription, delivery *WebhookDelivery) (status int, body string, retryAfter time.Duration, err error) {
+	request, err := http.NewRequest(http.MethodPost, subscription.URL, strings.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, "", 0, err
+	}
+	timestamp := time.Now().Unix()
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "Modul-space-Webhooks/1.0")
+	request.Header.Set(webhookEventHeader, delivery.Event)
+	request.Header.Set(webhookDeliveryHeader, strconv.Itoa(delivery.ID))
+	request.Header.Set(webhookSubscriptionHeader, strconv.Itoa(subscription.ID))
+	request.Header.Set(webhookSignatureHeader, fmt.Sprintf("t=%d,v1=%s", timestamp, signWebhook(subscription.Secret, timestamp, []byte(delivery.Payload))))
+
+	client := webhookClient
+	if isWebhookTestReceiverURL(subscription.URL) {
+		client = webhookLocalClient
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return 0, "", 0, err
+	}
+	defer response.Body.Close()
+	content, _ := io.ReadAll(io.LimitReader(response.Body, webhookResponseMaxBytes))
+	body = string(bytes.ToValidUTF8(content, []byte("?")))
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		if seconds, err := strconv.Atoi(response.Header.Get("Retry-After")); err == nil && seconds > 0 {
+			retryAfter = time.Duration(seconds) * time.Second
+		}
+		return response.StatusCode, body, retryAfter, fmt.Errorf("réponse %s", response.Status)
+	}
+	return response.StatusCode, body, 0, nil
+}
+
+const webhookDeliveryColumns = "id, subscription_id, event, event_id, payload, status, attempts, next_attempt_at, response_status, response_body, last_error, created_at, delivered_at"
+
+func scanWebhookDelivery(scanner interface{ Scan(...any) error }) (*WebhookDelivery, error) {
+	delivery := &WebhookDelivery{}
+	var responseStatus sql.NullInt64
+	var responseBody, lastError sql.NullString
+	var nextAttemptAt, createdAt, deliveredAt sql.NullTime
+	if err := scanner.Scan(&delivery.ID, &delivery.SubscriptionID, &delivery.Event, &delivery.EventID, &delivery.Payload, &delivery.Status, &delivery.Attempts,
+		&nextAttemptAt, &responseStatus, &responseBody, &lastError, &createdAt, &deliveredAt); err != nil {
+		return nil, err
+	}
+	delivery.ResponseStatus = int(responseStatus.Int64)
+	delivery.ResponseBody = responseBody.String
+	delivery.LastError = lastError.String
+	delivery.CreatedAt = createdAt.Time
+	if nextAttemptAt.Valid {
+		delivery.NextAttemptAt = &nextAttemptAt.Time
+	}
+	if deliveredAt.Valid {
+		delivery.DeliveredAt = &deliveredAt.Time
+	}
+	return delivery, nil
+}
+
+// GetWebhookDelivery récupère une livraison, ou nil si elle n'existe pas
+func GetWebhookDelivery(id int) (*WebhookDelivery, error) {
+	if db == nil {
+		return nil, fmt.Errorf("base de données non configurée")
+	}
+
+	delivery, err := scanWebhookDelivery(db.QueryRow(rebindQuery("SELECT "+webhookDeliveryColumns+" FROM webhook_deliveries WHERE id = ?"), id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return delivery, err
+}
+
+// listWebhookDeliveries renvoie les livraisons les plus récentes, filtrées par abonnement et par statut s'ils sont renseignés
+func listWebhookDeliveries(subscriptionID int, status string, limit int) ([]*WebhookDelivery, error) {
+	if db == nil {
+		return nil, fmt.Errorf("base de données non configurée")
+	}
+
+	var conditions sqlConditions
+	if subscriptionID > 0 {
+		conditions.add("subscription_id = ?", subscriptionID)
+	}
+	if status != "" {
+		conditions.add("status = ?", status)
+	}
+	rows, err := db.Query(rebindQuery(fmt.Sprintf("SELECT %s FROM webhook_deliveries%s ORDER BY created_at DESC, id DESC LIMIT %d", webhookDeliveryColumns, conditions.where(), limit)), conditions.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := make([]*WebhookDelivery, 0)
+	for rows.Next() {
+		delivery, err := scanWebhookDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, rows.Err()
+}
+
+// redeliverWebhook remet une livraison en file pour un envoi immédiat, compteur d'essais remis à zéro
+func redeliverWebhook(id int) error {
+	if db == nil {
+		return fmt.Errorf("base de données non configurée")
+	}
+
+	result, err := db.Exec(rebindQuery("UPDATE webhook_deliveries SET status = ?, attempts = 0, next_attempt_at = ? WHERE id = ? AND status <> ?"),
+		WebhookDeliveryPending, time.Now(), id, WebhookDeliverySending)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return errors.New("livraison introuvable ou en cours d'envoi")
+	}
+	notifyWebhooks()
+	return nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Récepteur de test : avec WEBHOOK_TEST_RECEIVER=1, POST /webhooks/test-receiver reçoit les webhooks comme
+// le ferait une intégration, vérifie leur signature et garde les derniers appels en mémoire pour la page
+// /admin/webhooks. ?status=500 (ou tout autre code) simule un destinataire en panne pour tester les relances
+
+// webhookReceiverCapacity est le nombre d'appels reçus conservés
+const webhookReceiverCapacity = 20
+
+// WebhookReceived est un appel reçu par le récepteur de test
+type WebhookReceived struct {
+	ReceivedAt time.Time
+	Event      string
+	DeliveryID string
+	Signature  string
+	// Verified est vide si la signature est valide, sinon la raison du refus
+	Verified string
+	Status   int
+	Body     string
+}
+
+var webhookReceiver struct {
+	mu       sync.Mutex
+	received []WebhookReceived
+}
+
+func webhookTestReceiverEnabled() bool {
+	return os.Getenv("WEBHOOK_TEST_RECEIVER") == "1"
+}
+
+// webhookTestReceiverURL est l'URL à donner à un abonnement pour l'essayer en local
+func webhookTestReceiverURL() string {
+	return appBaseURL() + "/webhooks/test-receiver"
+}
+
+// isWebhookTestReceiverURL indique si target est le récepteur de test, seule adresse locale autorisée
+// pour un abonnement ; toujours faux quand le récepteur est désactivé
+func isWebhookTestReceiverURL(target string) bool {
+	if !webhookTestReceiverEnabled() {
+		return false
+	}
+	base, _, _ := strings.Cut(target, "?")
+	return base == webhookTestReceiverURL()
+}
+
+func webhookTestReceiverHandler(w http.ResponseWriter, r *http.Request) {
+	if !webhookTestReceiverEnabled() {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, "Corps illisible", http.StatusBadRequest)
+		return
+	}
+	received := WebhookReceived{
+		ReceivedAt: time.Now(),
+		Event:      r.Header.Get(webhookEventHeader),
+		DeliveryID: r.Header.Get(webhookDeliveryHeader),
+		Signature:  r.Header.Get(webhookSignatureHeader),
+		Status:     http.StatusOK,
+		Body:       string(body),
+	}
+
+	// Le secret est celui de l'abonnement annoncé dans l'en-tête, comme chez un destinataire réel qui le connaît
+	subscriptionID, _ := strconv.Atoi(r.Header.Get(webhookSubscriptionHeader))
+	subscription, err := GetWebhookSubscription(subscriptionID)
+	switch {
+	case err != nil:
+		received.Verified = err.Error()
+	case subscription == nil:
+		received.Verified = "abonnement inconnu"
+	default:
+		if err := verifyWebhookSignature(subscription.Secret, received.Signature, body, received.ReceivedAt); err != nil {
+			received.Verified = err.Error()
+		}
+	}
+	if received.Verified != "" {
+		received.Status = http.StatusUnauthorized
+	}
+	if status, err := strconv.Atoi(r.URL.Query().Get("status")); err == nil && status >= 100 && status <= 599 {
+		received.Status = status
+	}
+
+	webhookReceiver.mu.Lock()
+	webhookReceiver.received = append([]WebhookReceived{received}, webhookReceiver.received...)
+	if len(webhookReceiver.received) > webhookReceiverCapacity {
+		webhookReceiver.received = webhookReceiver.received[:webhookReceiverCapacity]
+	}
+	webhookReceiver.mu.Unlock()
+
+	if received.Status == http.StatusTooManyRequests {
+		w.Header().Set("Retry-After", "60")
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(received.Status)
+	if received.Verified != "" {
+		fmt.Fprintf(w, "signature refusée : %s\n", received.Verified)
+		return
+	}
+	fmt.Fprintf(w, "reçu %s (livraison %s)\n", received.Event, received.DeliveryID)
+}
+
+// webhookTestReceived renvoie les derniers appels reçus, du plus récent au plus ancien
+func webhookTestReceived() []WebhookReceived {
+	webhookReceiver.mu.Lock()
+	defer webhookReceiver.mu.Unlock()
+	return append([]WebhookReceived(nil), webhookReceiver.received...)
+}